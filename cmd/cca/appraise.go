@@ -0,0 +1,244 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package cca
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/veraison/evcli/v2/common"
+	"github.com/veraison/psatoken"
+)
+
+var (
+	appraiseTokenFile   *string
+	appraiseRefValFiles *[]string
+	appraiseKeyFile     *string
+	appraiseKeysDir     *string
+	appraiseCoRIMFiles  *[]string
+	appraiseKeyFormat   *string
+
+	appraiseTrustAnchorFile *string
+	appraiseCRLFile         *string
+
+	appraiseEARKeyFile *string
+	appraiseEARFile    *string
+)
+
+var appraiseCmd = NewAppraiseCmd(common.Fs)
+
+func NewAppraiseCmd(fs afero.Fs) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "appraise",
+		Short: "compare the measurements in a CCA attestation token with reference values",
+		Long: `Verify the supplied CCA attestation token, as "check" does, then compare
+the measurements it reports with the reference values in CoRIMs, or in JSON
+files, and report whether each of them matches.  The measurements are:
+
+  * the platform software components and configuration;
+  * the realm initial measurement (RIM), extensible measurements (REMs) and
+    personalization value (RPV).
+
+Verify the CCA attestation token in my.cbor using the public IAK in
+iak-pub.jwk, and compare its measurements with the reference values in the
+platform and realm CoRIMs:
+
+	evcli cca appraise --token=my.cbor --key=iak-pub.jwk \
+	    --refvals=platform.cbor --refvals=realm.cbor
+
+JSON reference values use the names and the format of the claims, so that the
+claims saved by "check" from a known good token can be used:
+
+	{
+	  "cca-platform-token": {
+	    "cca-platform-config": "AQID",
+	    "cca-platform-sw-components": [
+	      {
+	        "measurement-value": "AwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwM=",
+	        "signer-id": "BAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQ="
+	      }
+	    ]
+	  },
+	  "cca-realm-delegated-token": {
+	    "cca-realm-initial-measurement": "Q0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0M=",
+	    "cca-realm-extensible-measurements": [ ... ],
+	    "cca-realm-personalization-value": "..."
+	  }
+	}
+
+The realm measurements are compared with the reference values of the realm
+with the same RIM.  Measurements with no reference values are reported, but
+are not a failure.  If any measurement does not match, the command fails with
+exit code 4.
+
+With --ear-key, the outcome is also saved as an EAR attestation result, with a
+submod for the platform, whose "executables" and "configuration" claims follow
+from the platform measurements, and a submod for the realm, whose "executables"
+claim follows from the realm measurements.
+	`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			earKey, err := loadEARKey(fs, *appraiseEARKeyFile)
+			if err != nil {
+				return err
+			}
+
+			e, err := verifyTokenFile(fs, *appraiseTokenFile, common.VerificationKeyFlags{
+				KeyFile:         *appraiseKeyFile,
+				KeysDir:         *appraiseKeysDir,
+				CoRIMFiles:      *appraiseCoRIMFiles,
+				TrustAnchorFile: *appraiseTrustAnchorFile,
+				CRLFile:         *appraiseCRLFile,
+			}, *appraiseKeyFormat)
+			if err != nil {
+				if earKey != nil {
+					if earErr := issueEAR(fs, *appraiseTokenFile, *appraiseEARFile, earKey, nil, err, nil); earErr != nil {
+						return earErr
+					}
+				}
+				return err
+			}
+
+			p, r := e.PlatformClaims, e.RealmClaims
+
+			implID, err := p.GetImplID()
+			if err != nil {
+				return fmt.Errorf("getting platform implementation ID: %w", err)
+			}
+
+			refvals, err := common.LoadReferenceValues(fs, *appraiseRefValFiles, implID, refValsFromJSON)
+			if err != nil {
+				return err
+			}
+
+			comps, err := p.GetSoftwareComponents()
+			if err != nil && !errors.Is(err, psatoken.ErrOptionalClaimMissing) {
+				return fmt.Errorf("getting platform software components: %w", err)
+			}
+
+			config, err := p.GetConfig()
+			if err != nil {
+				return fmt.Errorf("getting platform configuration: %w", err)
+			}
+
+			rim, err := r.GetInitialMeasurement()
+			if err != nil {
+				return fmt.Errorf("getting realm initial measurement: %w", err)
+			}
+
+			rems, err := r.GetExtensibleMeasurements()
+			if err != nil {
+				return fmt.Errorf("getting realm extensible measurements: %w", err)
+			}
+
+			// the RPV is optional
+			rpv, _ := r.GetPersonalizationValue()
+
+			var report common.MeasurementsReport
+
+			report.AppraiseSwComponents("cca-platform-sw-components", comps, refvals.SwComponents)
+			report.AppraiseValue("cca-platform-config", config, refvals.PlatformConfig)
+			report.AppraiseRealm(rim, rems, rpv, refvals.Realms)
+
+			fmt.Printf(">> %q measurements:\n", *appraiseTokenFile)
+			report.Report(os.Stdout)
+
+			if earKey != nil {
+				if err = issueEAR(fs, *appraiseTokenFile, *appraiseEARFile, earKey, e, nil, &report); err != nil {
+					return err
+				}
+			}
+
+			return report.Err()
+		},
+	}
+
+	appraiseTokenFile = cmd.Flags().StringP(
+		"token", "t", "", "CBOR file containing the CCA attestation token to be appraised",
+	)
+
+	appraiseRefValFiles = cmd.Flags().StringArrayP(
+		"refvals", "r", nil, "CoRIM, signed or unsigned, or JSON file with the reference values.  "+
+			"May be specified multiple times",
+	)
+
+	appraiseKeyFile = cmd.Flags().StringP(
+		"key", "k", "", "file with the public Initial Attestation Key used for verification",
+	)
+
+	appraiseKeysDir = cmd.Flags().String(
+		"keys-dir", "", "directory with the IAKs of a number of devices, from which the one "+
+			"matching the platform token's kid or instance ID is picked.  Use instead of --key",
+	)
+
+	appraiseCoRIMFiles = cmd.Flags().StringArray(
+		"corim", nil, "CoRIM file, signed or unsigned, with the endorsed IAK of the platform the "+
+			"token comes from.  May be specified multiple times.  Use instead of --key",
+	)
+
+	appraiseKeyFormat = cmd.Flags().String(
+		"key-format", common.KeyFormatAuto, common.KeyFormatFlagUsage,
+	)
+
+	appraiseTrustAnchorFile = cmd.Flags().StringP(
+		"trust-anchor", "T", "",
+		"PEM file with the trust anchor certificate(s) against which the platform token's "+
+			"x5chain is validated.  Use instead of --key",
+	)
+
+	appraiseCRLFile = cmd.Flags().String(
+		"crl", "", "PEM or DER file with the CRL(s) used to check the revocation "+
+			"status of the x5chain certificates",
+	)
+
+	appraiseEARKeyFile = cmd.Flags().String(
+		"ear-key", "", "file with the private key used to sign an EAR attestation result "+
+			"describing the outcome of the appraisal.  If not set, no EAR is issued",
+	)
+
+	appraiseEARFile = cmd.Flags().String(
+		"ear", "", "name of the file where the EAR is saved.  Default is the name of the token "+
+			"file, with the -ear.jwt suffix",
+	)
+
+	return cmd
+}
+
+// refValsFromJSON decodes CCA reference values in the JSON format of the
+// claims
+func refValsFromJSON(data []byte) (common.ReferenceValues, error) {
+	var j struct {
+		Platform struct {
+			SwComponents []common.SwComponentRefVal `json:"cca-platform-sw-components"`
+			Config       []byte                     `json:"cca-platform-config"`
+		} `json:"cca-platform-token"`
+		Realm *common.RealmRefVal `json:"cca-realm-delegated-token"`
+	}
+
+	if err := json.Unmarshal(data, &j); err != nil {
+		return common.ReferenceValues{}, err
+	}
+
+	rv := common.ReferenceValues{
+		SwComponents:   j.Platform.SwComponents,
+		PlatformConfig: j.Platform.Config,
+	}
+
+	if j.Realm != nil {
+		rv.Realms = []common.RealmRefVal{*j.Realm}
+	}
+
+	return rv, nil
+}
+
+func init() {
+	for _, flag := range []string{"token", "refvals"} {
+		if err := appraiseCmd.MarkFlagRequired(flag); err != nil {
+			panic(err)
+		}
+	}
+}
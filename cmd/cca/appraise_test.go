@@ -0,0 +1,150 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package cca
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veraison/evcli/v2/common"
+)
+
+func Test_AppraiseCmd_corim_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	createKeyBoundToken(t, fs, "ccatoken.cbor")
+
+	err := afero.WriteFile(fs, "iak-pub.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
+	corim := NewCorimCmd(fs)
+	corim.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--iak=iak-pub.jwk",
+			"--corim=platform.cbor",
+			"--realm-corim=realm.cbor",
+		},
+	)
+	require.NoError(t, corim.Execute())
+
+	cmd := NewAppraiseCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--corim=platform.cbor",
+			"--refvals=platform.cbor",
+			"--refvals=realm.cbor",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func Test_AppraiseCmd_ear_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "ccatoken.cbor", testValidCCAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iak-pub.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidIAK, 0600)
+	require.NoError(t, err)
+
+	corim := NewCorimCmd(fs)
+	corim.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--corim=platform.cbor",
+			"--realm-corim=realm.cbor",
+		},
+	)
+	require.NoError(t, corim.Execute())
+
+	cmd := NewAppraiseCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--key=iak-pub.jwk",
+			"--refvals=platform.cbor",
+			"--refvals=realm.cbor",
+			"--ear-key=ear.jwk",
+			"--ear=my-ear.jwt",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	ar := loadTestEAR(t, fs, "my-ear.jwt")
+
+	platform := ar.Submods[common.SubmodCCAPlatform]
+	assert.Equal(t, common.TrustTierAffirming, platform.Status)
+	assert.Equal(t, common.TrustClaim(2), platform.TrustVector.Executables)
+	assert.Equal(t, common.TrustClaim(2), platform.TrustVector.Configuration)
+
+	require.Contains(t, ar.Submods, common.SubmodCCARealm)
+	realm := ar.Submods[common.SubmodCCARealm]
+	assert.Equal(t, common.TrustTierAffirming, realm.Status)
+	assert.Equal(t, common.TrustClaim(2), realm.TrustVector.Executables)
+}
+
+func Test_AppraiseCmd_json_mismatch(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "ccatoken.cbor", testValidCCAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iak-pub.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
+	// the claims of the token are its own reference values
+	check := NewCheckCmd(fs)
+	check.SetArgs([]string{"--token=ccatoken.cbor", "--key=iak-pub.jwk", "--claims=claims.json"})
+	require.NoError(t, check.Execute())
+
+	cmd := NewAppraiseCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--key=iak-pub.jwk",
+			"--refvals=claims.json",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	// change the platform configuration
+	claims, err := afero.ReadFile(fs, "claims.json")
+	require.NoError(t, err)
+
+	claims = []byte(strings.Replace(string(claims), `"cca-platform-config": "`, `"cca-platform-config": "AAAA`, 1))
+
+	err = afero.WriteFile(fs, "claims.json", claims, 0644)
+	require.NoError(t, err)
+
+	cmd = NewAppraiseCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--key=iak-pub.jwk",
+			"--refvals=claims.json",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, "the reference values are not matched by cca-platform-config")
+
+	var exitErr *common.ExitError
+	require.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, common.ExitCodeRefValsNotMatched, exitErr.Code)
+}
@@ -4,18 +4,31 @@
 package cca
 
 import (
+	"crypto"
 	"encoding/json"
+	"errors"
 	"fmt"
 
+	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
+	"github.com/veraison/ccatoken"
 	"github.com/veraison/evcli/v2/common"
 )
 
 var (
 	checkClaimsFile *string
 	checkKeyFile    *string
+	checkKeysDir    *string
+	checkCoRIMFiles *[]string
+	checkKeyFormat  *string
 	checkTokenFile  *string
+
+	checkTrustAnchorFile *string
+	checkCRLFile         *string
+
+	checkEARKeyFile *string
+	checkEARFile    *string
 )
 
 var checkCmd = NewCheckCmd(common.Fs)
@@ -40,37 +53,54 @@ check a CCA attestation token contained in te.cbor using the public IAK in
 es256.jwk and dump the embedded claims to standard output:
 
 	evcli cca check -t te.cbor -k es256.jwk
+
+Check a CCA attestation token contained in my.cbor using the IAK certified by
+the x5chain embedded in the platform token, which is validated against the
+trust anchor in root.pem and the CRL in iak-ca.crl:
+
+	evcli cca check -t my.cbor --trust-anchor=root.pem --crl=iak-ca.crl
+
+Check a CCA attestation token contained in my.cbor using the IAK, among the
+ones in the iaks directory, whose kid matches the platform token's or from
+which the platform instance ID derives:
+
+	evcli cca check -t my.cbor --keys-dir=iaks
+
+Check a CCA attestation token contained in my.cbor using the IAK endorsed, for
+the platform implementation and instance IDs, in one of the CoRIMs
+endorsements.cbor and more-endorsements.cbor:
+
+	evcli cca check -t my.cbor --corim=endorsements.cbor --corim=more-endorsements.cbor
+
+Check a CCA attestation token contained in my.cbor using the public IAK in
+iak-pub.jwk, and save the outcome as an EAR attestation result, signed with the
+private key in ear.jwk, to my-ear.jwt:
+
+	evcli cca check -t my.cbor -k iak-pub.jwk --ear-key=ear.jwk --ear=my-ear.jwt
 	`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			key, err := afero.ReadFile(fs, *checkKeyFile)
+			earKey, err := loadEARKey(fs, *checkEARKeyFile)
 			if err != nil {
-				return fmt.Errorf(
-					"error loading verification key from %s: %w",
-					*checkKeyFile, err,
-				)
+				return err
 			}
 
-			pak, err := common.PubKeyFromJWK(key)
-			if err != nil {
-				return fmt.Errorf(
-					"error decoding verification key from %s: %w",
-					*checkKeyFile, err,
-				)
-			}
+			t, err := verifyTokenFile(fs, *checkTokenFile, common.VerificationKeyFlags{
+				KeyFile:         *checkKeyFile,
+				KeysDir:         *checkKeysDir,
+				CoRIMFiles:      *checkCoRIMFiles,
+				TrustAnchorFile: *checkTrustAnchorFile,
+				CRLFile:         *checkCRLFile,
+			}, *checkKeyFormat)
 
-			t, err := loadTokenFromFile(fs, *checkTokenFile)
-			if err != nil {
-				return fmt.Errorf(
-					"loading CCA evidence from %s: %w",
-					*checkTokenFile, err,
-				)
+			if earKey != nil {
+				earErr := issueEAR(fs, *checkTokenFile, *checkEARFile, earKey, t, err, nil)
+				if earErr != nil {
+					return earErr
+				}
 			}
 
-			if err = t.Verify(pak); err != nil {
-				return fmt.Errorf(
-					"verifying CCA evidence from %s using key from %s: %w",
-					*checkTokenFile, *checkKeyFile, err,
-				)
+			if err != nil {
+				return err
 			}
 
 			claims, err := json.MarshalIndent(t, "", "  ")
@@ -99,21 +129,260 @@ es256.jwk and dump the embedded claims to standard output:
 	)
 
 	checkKeyFile = cmd.Flags().StringP(
-		"key", "k", "", "JWK file with the public Initial Attestation Key used for verification",
+		"key", "k", "", "file with the public Initial Attestation Key used for verification",
+	)
+
+	checkKeysDir = cmd.Flags().String(
+		"keys-dir", "", "directory with the IAKs of a number of devices, from which the one "+
+			"matching the platform token's kid or instance ID is picked.  Use instead of --key",
+	)
+
+	checkCoRIMFiles = cmd.Flags().StringArray(
+		"corim", nil, "CoRIM file, signed or unsigned, with the endorsed IAK of the platform "+
+			"the token comes from.  May be specified multiple times.  Use instead of --key",
+	)
+
+	checkKeyFormat = cmd.Flags().String(
+		"key-format", common.KeyFormatAuto, common.KeyFormatFlagUsage,
 	)
 
 	checkTokenFile = cmd.Flags().StringP(
 		"token", "t", "", "CBOR file containing the CCA attestation token to be verified",
 	)
 
+	checkTrustAnchorFile = cmd.Flags().StringP(
+		"trust-anchor", "T", "",
+		"PEM file with the trust anchor certificate(s) against which the platform "+
+			"token's x5chain is validated.  Use instead of --key",
+	)
+
+	checkCRLFile = cmd.Flags().String(
+		"crl", "", "PEM or DER file with the CRL(s) used to check the revocation "+
+			"status of the x5chain certificates",
+	)
+
+	checkEARKeyFile = cmd.Flags().String(
+		"ear-key", "", "file with the private key used to sign an EAR attestation result "+
+			"describing the outcome of the verification.  If not set, no EAR is issued",
+	)
+
+	checkEARFile = cmd.Flags().String(
+		"ear", "", "name of the file where the EAR is saved.  Default is the name of the token "+
+			"file, with the -ear.jwt suffix",
+	)
+
 	return cmd
 }
 
+// verifyTokenFile verifies the CCA token in fn using the key designated by the
+// supplied flags, and returns the verified evidence
+func verifyTokenFile(
+	fs afero.Fs, fn string, kf common.VerificationKeyFlags, keyFormat string,
+) (*ccatoken.Evidence, error) {
+	if err := kf.Check(); err != nil {
+		return nil, err
+	}
+
+	var pak crypto.PublicKey
+
+	if kf.KeyFile != "" {
+		key, format, err := common.LoadKeyFile(fs, kf.KeyFile, keyFormat, "verification key")
+		if err != nil {
+			return nil, err
+		}
+
+		pak, err = common.PubKeyFromKey(key, format)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"error decoding verification key from %s: %w",
+				kf.KeyFile, err,
+			)
+		}
+	}
+
+	raw, err := afero.ReadFile(fs, fn)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"loading CCA evidence from %s: %w",
+			fn, err,
+		)
+	}
+
+	t, err := ccatoken.DecodeAndValidateEvidenceFromCBOR(raw)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"loading CCA evidence from %s: %w",
+			fn, &common.EvidenceError{Err: err},
+		)
+	}
+
+	keySource := kf.KeyFile
+
+	if kf.KeysDir != "" || len(kf.CoRIMFiles) > 0 {
+		key, keyFile, err := lookupTokenKey(fs, kf.KeysDir, kf.CoRIMFiles, raw, t)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf(">> using the key in %q\n", keyFile)
+
+		pak, err = common.PubKeyFromParsedKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding verification key from %s: %w", keyFile, err)
+		}
+
+		keySource = keyFile
+	}
+
+	if kf.TrustAnchorFile != "" {
+		pak, err = common.CertifiedKeyFromToken(
+			fs, raw, common.X5ChainFromCCAToken, kf.TrustAnchorFile, kf.CRLFile,
+		)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf(">> %q x5chain validated\n", fn)
+
+		keySource = "x5chain"
+	}
+
+	platformErr, realmErr := common.VerifyCCAEvidence(t, raw, pak)
+	if platformErr != nil {
+		return nil, &common.SignatureError{Err: fmt.Errorf(
+			"verifying CCA evidence from %s using key from %s: %w",
+			fn, keySource, platformErr,
+		)}
+	}
+
+	if realmErr != nil {
+		return nil, &common.SignatureError{Err: fmt.Errorf(
+			"verifying CCA evidence from %s using key from %s: %w",
+			fn, keySource, &common.RealmSignatureError{Err: realmErr},
+		)}
+	}
+
+	return t, nil
+}
+
+// loadEARKey loads the EAR signing key in fn, if any
+func loadEARKey(fs afero.Fs, fn string) (jwk.Key, error) {
+	if fn == "" {
+		return nil, nil
+	}
+
+	return common.LoadEARSigningKey(fs, fn, common.KeyFormatAuto)
+}
+
+// issueEAR saves to earFile an EAR, signed with key, with the outcome of the
+// verification of the CCA token in fn and, if supplied, of the comparison of
+// its measurements with the reference values.  The platform and the realm are
+// appraised in separate submods, the latter as soon as the realm token can be
+// decoded.  Malformed tokens get a contraindicated EAR.  No EAR is issued if
+// the token could not be verified for other reasons, e.g., a missing key, as
+// they say nothing about the token.
+func issueEAR(
+	fs afero.Fs, fn, earFile string, key jwk.Key, e *ccatoken.Evidence, verifyErr error,
+	report *common.MeasurementsReport,
+) error {
+	var (
+		sigErr      *common.SignatureError
+		evErr       *common.EvidenceError
+		realmSigErr *common.RealmSignatureError
+	)
+
+	if verifyErr != nil && !errors.As(verifyErr, &sigErr) && !errors.As(verifyErr, &evErr) {
+		fmt.Printf(">> no EAR issued for %q: %v\n", fn, verifyErr)
+		return nil
+	}
+
+	raw, err := afero.ReadFile(fs, fn)
+	if err != nil {
+		return fmt.Errorf("loading CCA evidence from %s: %w", fn, err)
+	}
+
+	if e == nil {
+		// the claims of a token that failed verification can still tell the
+		// nonce the EAR is about
+		e, _ = ccatoken.DecodeEvidenceFromCBOR(raw)
+	}
+
+	var nonce []byte
+	if e != nil {
+		nonce, _ = e.RealmClaims.GetChallenge()
+	}
+
+	ar := common.NewLocalAttestationResult(raw, nonce)
+
+	if evErr != nil {
+		ar.AddRejectedSubmod(common.SubmodCCAPlatform, evErr)
+		if e != nil {
+			ar.AddRejectedSubmod(common.SubmodCCARealm, evErr)
+		}
+	} else {
+		// a failure of the realm token alone does not affect the platform
+		platformErr := verifyErr
+		if errors.As(verifyErr, &realmSigErr) {
+			platformErr = nil
+		}
+
+		tv := common.SignatureTrustVector(platformErr)
+		if report != nil {
+			tv.Executables = common.ExecutablesClaim(report.Outcome("cca-platform-sw-components"))
+			tv.Configuration = common.ConfigurationClaim(report.Outcome("cca-platform-config"))
+		}
+
+		ar.AddSubmod(common.SubmodCCAPlatform, tv)
+
+		if e != nil {
+			tv = common.SignatureTrustVector(verifyErr)
+			if report != nil {
+				tv.Executables = common.ExecutablesClaim(report.Outcome("cca-realm-"))
+			}
+
+			ar.AddSubmod(common.SubmodCCARealm, tv)
+		}
+	}
+
+	if earFile == "" {
+		earFile = common.MakeFileName(".", fn, "-ear.jwt")
+	}
+
+	return common.SaveAttestationResult(fs, ar, key, earFile)
+}
+
+// lookupTokenKey picks the IAK of the CCA token either from the keys in dir,
+// using the kid in the platform token's COSE headers or, failing that, the
+// platform instance ID claim, or from the attestation verification keys in the
+// CoRIMs, using the platform implementation and instance ID claims
+func lookupTokenKey(
+	fs afero.Fs, dir string, corims []string, raw []byte, t *ccatoken.Evidence,
+) (jwk.Key, string, error) {
+	instID, _ := t.PlatformClaims.GetInstID()
+
+	if len(corims) > 0 {
+		implID, _ := t.PlatformClaims.GetImplID()
+		return common.LoadCoRIMAttestationKey(fs, corims, implID, instID)
+	}
+
+	ring, err := common.LoadKeyRing(fs, dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	platformToken, err := common.PlatformTokenFromCCAToken(raw)
+	if err != nil {
+		return nil, "", err
+	}
+
+	kid, _, err := common.PeekCOSE(platformToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("decoding CCA platform token: %w", err)
+	}
+
+	return ring.Lookup(kid, instID)
+}
+
 func init() {
 	if err := checkCmd.MarkFlagRequired("token"); err != nil {
 		panic(err)
 	}
-	if err := checkCmd.MarkFlagRequired("key"); err != nil {
-		panic(err)
-	}
 }
@@ -4,11 +4,16 @@
 package cca
 
 import (
+	"encoding/base64"
+	"errors"
 	"testing"
 
+	"github.com/fxamacker/cbor/v2"
+	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/veraison/evcli/v2/common"
 )
 
 func Test_CheckCmd_claims_to_stdout_ok(t *testing.T) {
@@ -215,3 +220,433 @@ func Test_CheckCmd_regression_bug_18(t *testing.T) {
 	err = cmd.Execute()
 	assert.EqualError(t, err, expectedErr)
 }
+
+func Test_CheckCmd_encrypted_key(t *testing.T) {
+	t.Setenv(common.KeyPassphraseEnv, "s3cr3t")
+
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "ccatoken.cbor", testValidCCAToken, 0644)
+	require.NoError(t, err)
+
+	key, err := jwk.ParseKey(testValidIAK)
+	require.NoError(t, err)
+
+	encrypted, err := common.EncryptKey(key, common.KeyFormatJWK, []byte("s3cr3t"))
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "es256.jwe", encrypted, 0600)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--key=es256.jwe",
+		},
+	)
+
+	// the key is decrypted, but, as in Test_CheckCmd_regression_bug_18, a
+	// private key is refused by the verifier
+	expectedErr := `verifying CCA evidence from ccatoken.cbor using key from es256.jwe: unable to verify platform token: unable to instantiate verifier: ES256: invalid public key`
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
+func Test_CheckCmd_trust_anchor_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "es256.jwk", testValidIAK, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "es384.jwk", testValidRAK, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "chain.pem", testIAKChain, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidCCAClaimsNoKeys, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "root.pem", testRootCA, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--iak=es256.jwk",
+			"--rak=es384.jwk",
+			"--derive-claims",
+			"--x5chain=chain.pem",
+			"--token=ccatoken.cbor",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	cmd = NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--trust-anchor=root.pem",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func Test_CheckCmd_trust_anchor_no_x5chain(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "ccatoken.cbor", testValidCCAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "root.pem", testRootCA, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--trust-anchor=root.pem",
+		},
+	)
+
+	expectedErr := `error extracting x5chain: no x5chain header found`
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
+func Test_CheckCmd_crl_without_trust_anchor(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--key=es256.jwk",
+			"--crl=iak-ca.crl",
+		},
+	)
+
+	expectedErr := `--crl can only be used with --trust-anchor`
+
+	err := cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
+func Test_CheckCmd_keys_dir_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "es256.jwk", testValidIAK, 0600)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "es384.jwk", testValidRAK, 0600)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidCCAClaimsNoKeys, 0644)
+	require.NoError(t, err)
+
+	create := NewCreateCmd(fs)
+	create.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--iak=es256.jwk",
+			"--rak=es384.jwk",
+			"--token=my.cbor",
+			"--derive-claims",
+		},
+	)
+
+	err = create.Execute()
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iaks/device-1.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=my.cbor",
+			"--keys-dir=iaks",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func Test_CheckCmd_keys_dir_and_key(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=my.cbor",
+			"--key=es256.jwk",
+			"--keys-dir=iaks",
+		},
+	)
+
+	err := cmd.Execute()
+	assert.EqualError(t, err, "--key and --keys-dir are mutually exclusive")
+}
+
+func Test_CheckCmd_corim_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "ccatoken.cbor", testValidCCAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "endorsements.cbor", testIAKCoRIM, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--corim=endorsements.cbor",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func Test_CheckCmd_corim_not_a_corim(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "ccatoken.cbor", testValidCCAToken, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--corim=ccatoken.cbor",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, "error decoding CoRIM from ccatoken.cbor: want a CoRIM (CBOR tag 501), got CBOR tag 399")
+}
+
+func loadTestEAR(t *testing.T, fs afero.Fs, fn string) *common.AttestationResult {
+	data, err := afero.ReadFile(fs, fn)
+	require.NoError(t, err)
+
+	key, err := jwk.ParseKey(testValidIAKPub)
+	require.NoError(t, err)
+
+	ar, err := common.DecodeAndVerifyAttestationResult(data, key)
+	require.NoError(t, err)
+
+	return ar
+}
+
+func Test_CheckCmd_ear_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "ccatoken.cbor", testValidCCAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "es256.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidIAK, 0600)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--key=es256.jwk",
+			"--ear-key=ear.jwk",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	ar := loadTestEAR(t, fs, "ccatoken-ear.jwt")
+
+	require.Contains(t, ar.Submods, common.SubmodCCAPlatform)
+	appraisal := ar.Submods[common.SubmodCCAPlatform]
+	assert.Equal(t, common.TrustTierAffirming, appraisal.Status)
+	assert.Equal(t, common.TrustClaim(2), appraisal.TrustVector.InstanceIdentity)
+
+	require.Contains(t, ar.Submods, common.SubmodCCARealm)
+	realm := ar.Submods[common.SubmodCCARealm]
+	assert.Equal(t, common.TrustTierAffirming, realm.Status)
+	assert.Equal(t, common.TrustClaim(2), realm.TrustVector.InstanceIdentity)
+	assert.Equal(t, common.TrustClaim(0), realm.TrustVector.Executables)
+	assert.NotEmpty(t, ar.Nonce)
+}
+
+func Test_CheckCmd_ear_key_mismatch(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "ccatoken.cbor", testValidCCAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "es256.jwk", testValidRAKPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidIAK, 0600)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--key=es256.jwk",
+			"--ear-key=ear.jwk",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.ErrorContains(t, err, "unable to verify platform token")
+
+	ar := loadTestEAR(t, fs, "ccatoken-ear.jwt")
+
+	appraisal := ar.Submods[common.SubmodCCAPlatform]
+	assert.Equal(t, common.TrustTierContraindicated, appraisal.Status)
+	assert.Equal(t, common.TrustClaim(99), appraisal.TrustVector.InstanceIdentity)
+
+	realm := ar.Submods[common.SubmodCCARealm]
+	assert.Equal(t, common.TrustTierContraindicated, realm.Status)
+	assert.Equal(t, common.TrustClaim(99), realm.TrustVector.InstanceIdentity)
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(testNonce), ar.Nonce)
+}
+
+// tamperRealmToken returns a copy of the supplied CCA token whose realm token
+// signature no longer verifies
+func tamperRealmToken(t *testing.T, token []byte) []byte {
+	var tag cbor.Tag
+	require.NoError(t, cbor.Unmarshal(token, &tag))
+
+	var collection map[uint64][]byte
+	raw, err := cbor.Marshal(tag.Content)
+	require.NoError(t, err)
+	require.NoError(t, cbor.Unmarshal(raw, &collection))
+
+	realm := collection[44241]
+	require.NotEmpty(t, realm)
+	realm[len(realm)-1] ^= 0xff
+
+	tag.Content = collection
+	tampered, err := cbor.Marshal(tag)
+	require.NoError(t, err)
+
+	return tampered
+}
+
+func Test_CheckCmd_ear_bad_realm_signature(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "ccatoken.cbor", tamperRealmToken(t, testValidCCAToken), 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "es256.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidIAK, 0600)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--key=es256.jwk",
+			"--ear-key=ear.jwk",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.ErrorContains(t, err, "unable to verify realm token")
+
+	ar := loadTestEAR(t, fs, "ccatoken-ear.jwt")
+
+	platform := ar.Submods[common.SubmodCCAPlatform]
+	assert.Equal(t, common.TrustTierAffirming, platform.Status)
+	assert.Equal(t, common.TrustClaim(2), platform.TrustVector.InstanceIdentity)
+
+	realm := ar.Submods[common.SubmodCCARealm]
+	assert.Equal(t, common.TrustTierContraindicated, realm.Status)
+	assert.Equal(t, common.TrustClaim(99), realm.TrustVector.InstanceIdentity)
+}
+
+func Test_CheckCmd_ear_bad_token(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "ccatoken.cbor", testInvalidCCAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "es256.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidIAK, 0600)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--key=es256.jwk",
+			"--ear-key=ear.jwk",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, `loading CCA evidence from ccatoken.cbor: CBOR decoding of CCA evidence failed: unexpected EOF`)
+
+	ar := loadTestEAR(t, fs, "ccatoken-ear.jwt")
+
+	appraisal := ar.Submods[common.SubmodCCAPlatform]
+	assert.Equal(t, common.TrustTierContraindicated, appraisal.Status)
+	assert.Equal(t, common.TrustClaim(1), appraisal.TrustVector.InstanceIdentity)
+	assert.Equal(t, "CBOR decoding of CCA evidence failed: unexpected EOF", appraisal.PolicyClaims["problem"])
+	assert.NotContains(t, ar.Submods, common.SubmodCCARealm)
+	assert.Empty(t, ar.Nonce)
+}
+
+func Test_CheckCmd_ear_key_not_found(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "ccatoken.cbor", testValidCCAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidIAK, 0600)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--key=es256.jwk",
+			"--ear-key=ear.jwk",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.ErrorContains(t, err, "es256.jwk")
+
+	exists, err := afero.Exists(fs, "ccatoken-ear.jwt")
+	require.NoError(t, err)
+	assert.False(t, exists, "no EAR is issued when the key cannot be loaded")
+}
+
+func Test_issueEAR_token_not_found(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	key, err := jwk.ParseKey(testValidIAK)
+	require.NoError(t, err)
+
+	err = issueEAR(fs, "ccatoken.cbor", "", key, nil, &common.SignatureError{Err: errors.New("bad signature")}, nil)
+	assert.EqualError(t, err, "loading CCA evidence from ccatoken.cbor: open ccatoken.cbor: file does not exist")
+}
@@ -9,7 +9,7 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var cmdValidArgs = []string{"create", "check", "verify-as"}
+var cmdValidArgs = []string{"create", "check", "verify-as", "serve-attester", "corim", "appraise"}
 
 var Cmd = &cobra.Command{
 	Use:   "cca",
@@ -28,4 +28,7 @@ func init() {
 	Cmd.AddCommand(checkCmd)
 	Cmd.AddCommand(verifyAsCmd)
 	Cmd.AddCommand(printCmd)
+	Cmd.AddCommand(serveAttesterCmd)
+	Cmd.AddCommand(corimCmd)
+	Cmd.AddCommand(appraiseCmd)
 }
@@ -4,15 +4,24 @@
 package cca
 
 import (
+	"crypto/ecdsa"
 	"encoding/json"
+	"errors"
 	"fmt"
 
+	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/spf13/afero"
 	"github.com/veraison/ccatoken"
 	"github.com/veraison/ccatoken/platform"
 	"github.com/veraison/ccatoken/realm"
+	"github.com/veraison/evcli/v2/common"
+	"github.com/veraison/psatoken"
 )
 
+// defaultRealmPubKeyHashAlgID is used to compute the platform challenge when
+// the realm claims do not specify a hash algorithm
+const defaultRealmPubKeyHashAlgID = "sha-256"
+
 func loadCCAClaimsFromFile(fs afero.Fs, fn string, validate bool) (*ccatoken.Evidence, error) {
 	buf, err := afero.ReadFile(fs, fn)
 	if err != nil {
@@ -61,3 +70,66 @@ func loadTokenFromFile(fs afero.Fs, fn string) (*ccatoken.Evidence, error) {
 
 	return ccatoken.DecodeAndValidateEvidenceFromCBOR(buf)
 }
+
+// deriveKeyBoundClaims sets the claims that depend on the attestation keys:
+// the platform instance ID from the IAK and the realm public key from the RAK.
+// The realm public key is encoded as a COSE_Key if the realm claims carry a
+// profile, and as a raw EC point otherwise.  The platform challenge, which
+// binds the two tokens, is computed from the realm public key when the claims
+// are set in the evidence.
+func deriveKeyBoundClaims(p platform.IClaims, r realm.IClaims, iak, rak jwk.Key) error {
+	instID, err := common.InstanceIDFromKey(iak)
+	if err != nil {
+		return fmt.Errorf("deriving instance ID: %w", err)
+	}
+
+	if err = p.SetInstID(instID); err != nil {
+		return fmt.Errorf("setting instance ID: %w", err)
+	}
+
+	_, err = r.GetProfile()
+	pubKey, err := realmPubKeyFromKey(rak, err == nil)
+	if err != nil {
+		return fmt.Errorf("deriving realm public key: %w", err)
+	}
+
+	if err = r.SetPubKey(pubKey); err != nil {
+		return fmt.Errorf("setting realm public key: %w", err)
+	}
+
+	if _, err = r.GetPubKeyHashAlgID(); errors.Is(err, psatoken.ErrMandatoryClaimMissing) {
+		if err = r.SetPubKeyHashAlgID(defaultRealmPubKeyHashAlgID); err != nil {
+			return fmt.Errorf("setting realm public key hash algorithm: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func realmPubKeyFromKey(key jwk.Key, asCOSEKey bool) ([]byte, error) {
+	pub, err := common.RawPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("the RAK must be an ECDSA key")
+	}
+
+	if !asCOSEKey {
+		ecdhPub, err := ecPub.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		return ecdhPub.Bytes(), nil
+	}
+
+	// the kid and alg members of the RAK are not part of the claim
+	bare, err := jwk.FromRaw(ecPub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key: %w", err)
+	}
+
+	return common.COSEKeyFromJWK(bare)
+}
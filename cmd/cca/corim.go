@@ -0,0 +1,356 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package cca
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/veraison/ccatoken"
+	"github.com/veraison/ccatoken/platform"
+	"github.com/veraison/ccatoken/realm"
+	"github.com/veraison/evcli/v2/common"
+	"github.com/veraison/psatoken"
+)
+
+// ccaPlatformConfigLabel identifies the platform configuration among the
+// reference values of a CCA platform
+const ccaPlatformConfigLabel = "cfg v1.0.0"
+
+var (
+	corimClaimsFile  *string
+	corimTokenFile   *string
+	corimIAKFile     *string
+	corimKeyFormat   *string
+	corimOutputFile  *string
+	corimRealmFile   *string
+	corimVendor      *string
+	corimModel       *string
+	corimSignKeyFile *string
+)
+
+var corimCmd = NewCorimCmd(common.Fs)
+
+func NewCorimCmd(fs afero.Fs) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "corim",
+		Short: "create the CoRIM endorsements of a CCA platform and realm from their claims and IAK",
+		Long: `Create two CoRIMs, using the CoRIM profiles of Veraison's CCA schemes,
+from the JSON-encoded claims of a CCA platform and realm, or from a CCA
+attestation token:
+
+  * the platform CoRIM (http://arm.com/cca/ssd/1) has the reference values of
+    the platform, i.e., the measurements of its software components and its
+    configuration, and the endorsement of its Initial Attestation Key;
+  * the realm CoRIM (http://arm.com/cca/realm/1) has the reference values of
+    the realm: its initial measurement (RIM), its extensible measurements
+    (REMs) and its personalization value (RPV).
+
+Create the CoRIMs from the claims in claims.json and the public IAK in
+iak-pub.jwk, and save them to platform.cbor and realm.cbor:
+
+	evcli cca corim --claims=claims.json --iak=iak-pub.jwk --corim=platform.cbor --realm-corim=realm.cbor
+
+Create the CoRIMs from the claims in the token my.cbor, with the vendor and
+model of the platform in the environment of the endorsements, and sign them
+with the key in corim-signer.jwk (any of the key references of "cca create"
+can be used):
+
+	evcli cca corim -t my.cbor -p iak-pub.jwk --vendor=ACME --model=RoadRunner \
+	    --sign-key=corim-signer.jwk
+
+If the platform claims carry no instance ID, it is derived from the IAK.
+	`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (*corimClaimsFile == "") == (*corimTokenFile == "") {
+				return errors.New("exactly one of --claims or --token must be supplied")
+			}
+
+			p, r, err := loadCorimClaims(fs)
+			if err != nil {
+				return err
+			}
+
+			platformCoMID, err := platformCoMID(fs, p)
+			if err != nil {
+				return err
+			}
+
+			realmCoMID, err := realmCoMID(r)
+			if err != nil {
+				return err
+			}
+
+			var signer *common.SigningKey
+			if *corimSignKeyFile != "" {
+				signer, err = common.LoadSigningKey(fs, *corimSignKeyFile, common.KeyFormatAuto, "CoRIM signing key")
+				if err != nil {
+					return err
+				}
+			}
+
+			base := *corimClaimsFile
+			if base == "" {
+				base = *corimTokenFile
+			}
+
+			for _, out := range []struct {
+				profile string
+				comid   *common.CoMID
+				fn      string
+			}{
+				{
+					common.CoRIMProfileCCAPlatform, platformCoMID,
+					corimFileName(*corimOutputFile, base, "-platform-corim.cbor"),
+				},
+				{
+					common.CoRIMProfileCCARealm, realmCoMID,
+					corimFileName(*corimRealmFile, base, "-realm-corim.cbor"),
+				},
+			} {
+				if err = saveCoRIM(fs, out.profile, out.comid, signer, out.fn); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	}
+
+	corimClaimsFile = cmd.Flags().StringP(
+		"claims", "c", "", "JSON file containing the CCA attestation claims of the platform and realm",
+	)
+
+	corimTokenFile = cmd.Flags().StringP(
+		"token", "t", "", "CCA attestation token, from which the claims are taken.  Use instead of --claims",
+	)
+
+	corimIAKFile = cmd.Flags().StringP(
+		"iak", "p", "", "file with the public Initial Attestation Key of the platform.  If not set, no key is endorsed",
+	)
+
+	corimKeyFormat = cmd.Flags().String(
+		"key-format", common.KeyFormatAuto, common.KeyFormatFlagUsage,
+	)
+
+	corimOutputFile = cmd.Flags().StringP(
+		"corim", "o", "", "name of the file where the produced platform CoRIM will be stored",
+	)
+
+	corimRealmFile = cmd.Flags().String(
+		"realm-corim", "", "name of the file where the produced realm CoRIM will be stored",
+	)
+
+	corimVendor = cmd.Flags().String(
+		"vendor", "", "vendor of the platform, set in the environment of the endorsements",
+	)
+
+	corimModel = cmd.Flags().String(
+		"model", "", "model of the platform, set in the environment of the endorsements",
+	)
+
+	corimSignKeyFile = cmd.Flags().String(
+		"sign-key", "", "file with the key used to sign the CoRIMs, or a reference to it.  If not set, the CoRIMs are unsigned",
+	)
+
+	return cmd
+}
+
+func loadCorimClaims(fs afero.Fs) (platform.IClaims, realm.IClaims, error) {
+	if *corimClaimsFile != "" {
+		return loadUnValidatedCCAClaimsFromFile(fs, *corimClaimsFile)
+	}
+
+	raw, err := afero.ReadFile(fs, *corimTokenFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the token need not be verified, as it is only a source of claims
+	e, err := ccatoken.DecodeEvidenceFromCBOR(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading CCA evidence from %s: %w", *corimTokenFile, err)
+	}
+
+	return e.PlatformClaims, e.RealmClaims, nil
+}
+
+// platformCoMID creates the CoMID with the reference values of the platform
+// described by the claims and, if a key is supplied, the endorsement of its
+// IAK
+func platformCoMID(fs afero.Fs, p platform.IClaims) (*common.CoMID, error) {
+	implID, err := p.GetImplID()
+	if err != nil {
+		return nil, fmt.Errorf("getting platform implementation ID: %w", err)
+	}
+
+	comps, err := p.GetSoftwareComponents()
+	if err != nil && !errors.Is(err, psatoken.ErrOptionalClaimMissing) {
+		return nil, fmt.Errorf("getting platform software components: %w", err)
+	}
+
+	measurements, err := common.SwComponentMeasurements(comps)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := p.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("getting platform configuration: %w", err)
+	}
+
+	measurements = append(measurements, common.Measurement{
+		Key: &cbor.Tag{Number: common.CBORTagCCAPlatformConfigID, Content: ccaPlatformConfigLabel},
+		Value: common.MeasurementValues{
+			RawValue: &cbor.Tag{Number: common.CBORTagBytes, Content: config},
+		},
+	})
+
+	env := common.NewImplEnvironment(implID, *corimVendor, *corimModel)
+
+	comid := common.CoMID{
+		TagIdentity: common.TagIdentity{TagID: "cca-platform-endorsements"},
+		Triples: common.Triples{
+			ReferenceValues: []common.RefValTriple{
+				{Environment: env, Measurements: measurements},
+			},
+		},
+	}
+
+	if *corimIAKFile != "" {
+		raw, err := afero.ReadFile(fs, *corimIAKFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading IAK from %s: %w", *corimIAKFile, err)
+		}
+
+		iak, err := common.ParseKey(raw, *corimKeyFormat)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding IAK from %s: %w", *corimIAKFile, err)
+		}
+
+		// a missing instance ID is derived from the IAK
+		claimed, err := p.GetInstID()
+		if err != nil {
+			claimed = nil
+		}
+
+		instID, err := common.CheckInstanceID(claimed, iak)
+		if err != nil {
+			return nil, err
+		}
+
+		triple, err := common.NewAttestKeyTriple(env, instID, iak)
+		if err != nil {
+			return nil, fmt.Errorf("error endorsing IAK from %s: %w", *corimIAKFile, err)
+		}
+
+		comid.Triples.AttestVerifKeys = []common.KeyTriple{triple}
+	}
+
+	return &comid, nil
+}
+
+// realmCoMID creates the CoMID with the reference values of the realm
+// described by the claims.  The realm is identified by its RIM, and its
+// measurements are the RIM and REMs, as integrity registers, and the RPV, if
+// any, as a raw value.
+func realmCoMID(r realm.IClaims) (*common.CoMID, error) {
+	alg, err := r.GetHashAlgID()
+	if err != nil {
+		return nil, fmt.Errorf("getting realm hash algorithm: %w", err)
+	}
+
+	rim, err := r.GetInitialMeasurement()
+	if err != nil {
+		return nil, fmt.Errorf("getting realm initial measurement: %w", err)
+	}
+
+	rems, err := r.GetExtensibleMeasurements()
+	if err != nil {
+		return nil, fmt.Errorf("getting realm extensible measurements: %w", err)
+	}
+
+	registers := map[string][]byte{"rim": rim}
+	for i, rem := range rems {
+		registers[fmt.Sprintf("rem%d", i)] = rem
+	}
+
+	values := common.MeasurementValues{
+		IntegrityRegisters: map[string][]common.Digest{},
+	}
+
+	for name, value := range registers {
+		digest, err := common.NewDigest(alg, value)
+		if err != nil {
+			return nil, fmt.Errorf("realm %s: %w", name, err)
+		}
+		values.IntegrityRegisters[name] = []common.Digest{digest}
+	}
+
+	if rpv, err := r.GetPersonalizationValue(); err == nil {
+		values.RawValue = &cbor.Tag{Number: common.CBORTagBytes, Content: rpv}
+	}
+
+	env := common.Environment{
+		Instance: &cbor.Tag{Number: common.CBORTagBytes, Content: rim},
+	}
+
+	if *corimVendor != "" || *corimModel != "" {
+		env.Class = &common.Class{Vendor: *corimVendor, Model: *corimModel}
+	}
+
+	return &common.CoMID{
+		TagIdentity: common.TagIdentity{TagID: "cca-realm-endorsements"},
+		Triples: common.Triples{
+			ReferenceValues: []common.RefValTriple{
+				{Environment: env, Measurements: []common.Measurement{{Value: values}}},
+			},
+		},
+	}, nil
+}
+
+func saveCoRIM(fs afero.Fs, profile string, comid *common.CoMID, signer *common.SigningKey, fn string) error {
+	corim, err := common.NewCoRIM(profile, *comid)
+	if err != nil {
+		return err
+	}
+
+	data, err := corim.Encode()
+	if err != nil {
+		return fmt.Errorf("error encoding CoRIM: %w", err)
+	}
+
+	if signer != nil {
+		if data, err = common.SignCoRIM(data, signer, corimSignerName()); err != nil {
+			return fmt.Errorf("error signing CoRIM: %w", err)
+		}
+	}
+
+	if err = afero.WriteFile(fs, fn, data, 0644); err != nil {
+		return fmt.Errorf("error saving CoRIM to file %s: %w", fn, err)
+	}
+
+	fmt.Printf(">> %q successfully created\n", fn)
+
+	return nil
+}
+
+// corimSignerName is the name of the CoRIM signer in the corim-meta header
+func corimSignerName() string {
+	if *corimVendor != "" {
+		return *corimVendor
+	}
+	return "evcli"
+}
+
+func corimFileName(fn, base, suffix string) string {
+	if fn != "" {
+		return fn
+	}
+
+	return common.MakeFileName(".", base, suffix)
+}
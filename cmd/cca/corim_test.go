@@ -0,0 +1,141 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package cca
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veraison/evcli/v2/common"
+)
+
+// createKeyBoundToken creates, in fn, a CCA token signed with testValidIAK and
+// testValidRAK whose key-bound claims are derived from them, so that it can be
+// endorsed with testValidIAKPub
+func createKeyBoundToken(t *testing.T, fs afero.Fs, fn string) {
+	err := afero.WriteFile(fs, "key-bound-claims.json", testValidCCAClaimsNoKeys, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "key-bound-iak.jwk", testValidIAK, 0600)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "key-bound-rak.jwk", testValidRAK, 0600)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=key-bound-claims.json",
+			"--iak=key-bound-iak.jwk",
+			"--rak=key-bound-rak.jwk",
+			"--derive-claims",
+			"--token=" + fn,
+		},
+	)
+	require.NoError(t, cmd.Execute())
+}
+
+func Test_CorimCmd_token_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	createKeyBoundToken(t, fs, "ccatoken.cbor")
+
+	err := afero.WriteFile(fs, "iak-pub.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCorimCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--iak=iak-pub.jwk",
+			"--corim=platform.cbor",
+			"--realm-corim=realm.cbor",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	data, err := afero.ReadFile(fs, "realm.cbor")
+	require.NoError(t, err)
+
+	corim, err := common.DecodeCoRIM(data)
+	require.NoError(t, err)
+	require.Len(t, corim.CoMIDs, 1)
+
+	refvals := corim.CoMIDs[0].Triples.ReferenceValues
+	require.Len(t, refvals, 1)
+	require.Len(t, refvals[0].Measurements, 1)
+
+	registers := refvals[0].Measurements[0].Value.IntegrityRegisters
+	assert.Len(t, registers, 5)
+	assert.Contains(t, registers, "rim")
+	assert.Contains(t, registers, "rem3")
+
+	// the endorsed IAK verifies the token
+	check := NewCheckCmd(fs)
+	check.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--corim=platform.cbor",
+		},
+	)
+
+	err = check.Execute()
+	assert.NoError(t, err)
+}
+
+func Test_CorimCmd_default_file_names(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "claims.json", testValidCCAClaims, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCorimCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	for _, fn := range []string{"claims-platform-corim.cbor", "claims-realm-corim.cbor"} {
+		exists, err := afero.Exists(fs, fn)
+		require.NoError(t, err)
+		assert.True(t, exists, fn)
+	}
+}
+
+func Test_CorimCmd_instance_id_mismatch(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	// the platform instance ID of testValidCCAToken is not derived from
+	// testValidIAKPub
+	err := afero.WriteFile(fs, "ccatoken.cbor", testValidCCAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iak-pub.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCorimCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=ccatoken.cbor",
+			"--iak=iak-pub.jwk",
+			"--corim=platform.cbor",
+			"--realm-corim=realm.cbor",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.ErrorContains(t, err, "the instance ID in the claims (010202020202020202020202020202020202020202020202020202020202020202) does not match the one derived from the IAK")
+
+	exists, err := afero.Exists(fs, "platform.cbor")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
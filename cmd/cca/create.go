@@ -4,10 +4,16 @@
 package cca
 
 import (
+	"crypto/x509"
+	"errors"
 	"fmt"
 
+	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
+	"github.com/veraison/ccatoken"
+	"github.com/veraison/ccatoken/platform"
+	"github.com/veraison/ccatoken/realm"
 	"github.com/veraison/evcli/v2/common"
 )
 
@@ -15,8 +21,14 @@ var (
 	createClaimsFile   *string
 	createRAKFile      *string
 	createIAKFile      *string
+	createKeyFormat    *string
 	createTokenFile    *string
 	allowInvalidClaims *bool
+	createDeriveClaims *bool
+	createX5ChainFile  *string
+	createEmitTBSFiles *[]string
+	createAttachSigs   *[]string
+	createStateFile    *string
 )
 
 var createCmd = NewCreateCmd(common.Fs)
@@ -32,11 +44,71 @@ Create a CCA attestation token from claims contained in claims.json, sign
 with iak.jwk and rak.jwk and save the result to my.cbor:
 
 	evcli cca create --claims=claims.json --iak=iak.jwk --rak=rak.jwk --token=my.cbor
+
+Create a CCA attestation token in which the key-bound claims are derived from
+the supplied keys rather than taken from claims.json: the instance ID from the
+IAK, and the realm public key from the RAK.  The realm public key hash
+algorithm defaults to sha-256 when not set in claims.json, and the platform
+challenge is computed from it as usual:
+
+	evcli cca create --claims=claims.json --iak=iak.jwk --rak=rak.jwk --derive-claims
+
+Create a CCA attestation token whose platform token carries the IAK
+certificate chain found in iak-chain.pem in its x5chain header:
+
+	evcli cca create -c claims.json -p iak.jwk -r rak.jwk --x5chain=iak-chain.pem
+
+Keys held in a PKCS#11 token are designated by their PKCS#11 URI:
+
+	evcli cca create -c claims.json \
+	    -p 'pkcs11:token=evcli;object=iak?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234' \
+	    -r 'pkcs11:token=evcli;object=rak?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234'
+
+and keys held in a TPM 2.0 by their TPM URI:
+
+	evcli cca create -c claims.json \
+	    -p 'tpm:/dev/tpmrm0?handle=0x81000001' -r 'tpm:/dev/tpmrm0?handle=0x81000002'
+
+Create a CCA attestation token in two steps, for keys that are only usable
+offline.  First, save the COSE Sig_structures to be signed by the RAK and by
+the IAK to realm.tbs and platform.tbs respectively, and the signing state to
+my.state; only the public parts of the keys are needed.  The platform token is
+bound to the realm token through the RAK, not through its signature, hence
+the two structures can be signed independently:
+
+	evcli cca create -c claims.json -p iak-pub.jwk -r rak-pub.jwk \
+	    --emit-tbs=realm.tbs,platform.tbs --state=my.state
+
+Then, once both have been signed, assemble the token from the signatures (in
+the same order, realm first) and save it to my.cbor:
+
+	evcli cca create --state=my.state --attach-signature=realm.sig,platform.sig -t my.cbor
 	`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			validate := !*allowInvalidClaims
 
-			evidence, err := loadCCAClaimsFromFile(fs, *createClaimsFile, validate)
+			if err := checkOfflineSigningFlags(); err != nil {
+				return err
+			}
+
+			if len(*createAttachSigs) != 0 {
+				return attachSignatures(fs)
+			}
+
+			var (
+				evidence *ccatoken.Evidence
+				pClaims  platform.IClaims
+				rClaims  realm.IClaims
+				err      error
+			)
+
+			// key-bound claims may be missing from the file: validation is
+			// deferred until they have been derived
+			if *createDeriveClaims {
+				pClaims, rClaims, err = loadUnValidatedCCAClaimsFromFile(fs, *createClaimsFile)
+			} else {
+				evidence, err = loadCCAClaimsFromFile(fs, *createClaimsFile, validate)
+			}
 			if err != nil {
 				return fmt.Errorf(
 					"error loading CCA claims from %s: %w",
@@ -44,40 +116,69 @@ with iak.jwk and rak.jwk and save the result to my.cbor:
 				)
 			}
 
-			rak, err := afero.ReadFile(fs, *createRAKFile)
-			if err != nil {
-				return fmt.Errorf(
-					"error loading RAK signing key from %s: %w",
-					*createRAKFile, err,
+			var (
+				rSigner, pSigner *common.SigningKey
+				rTBS, pTBS       *common.TBSRecorder
+			)
+
+			if len(*createEmitTBSFiles) != 0 {
+				rSigner, rTBS, err = common.LoadTBSRecorder(
+					fs, *createRAKFile, *createKeyFormat, "RAK public key",
+				)
+			} else {
+				rSigner, err = common.LoadSigningKey(
+					fs, *createRAKFile, *createKeyFormat, "RAK signing key",
 				)
 			}
-
-			rSigner, err := common.SignerFromJWK(rak)
 			if err != nil {
-				return fmt.Errorf(
-					"error decoding RAK signing key from %s: %w",
-					*createRAKFile, err,
-				)
+				return err
 			}
 
-			iak, err := afero.ReadFile(fs, *createIAKFile)
-			if err != nil {
-				return fmt.Errorf(
-					"error loading IAK signing key from %s: %w",
-					*createIAKFile, err,
+			if len(*createEmitTBSFiles) != 0 {
+				pSigner, pTBS, err = common.LoadTBSRecorder(
+					fs, *createIAKFile, *createKeyFormat, "IAK public key",
+				)
+			} else {
+				pSigner, err = common.LoadSigningKey(
+					fs, *createIAKFile, *createKeyFormat, "IAK signing key",
 				)
 			}
-
-			pSigner, err := common.SignerFromJWK(iak)
 			if err != nil {
-				return fmt.Errorf(
-					"error decoding IAK signing key from %s: %w",
-					*createIAKFile, err,
-				)
+				return err
+			}
+
+			var x5chain []*x509.Certificate
+			if *createX5ChainFile != "" {
+				x5chain, err = common.LoadX5Chain(fs, *createX5ChainFile, pSigner.Public)
+				if err != nil {
+					return err
+				}
+			}
+
+			if *createDeriveClaims {
+				if err = deriveKeyBoundClaims(pClaims, rClaims, pSigner.Public, rSigner.Public); err != nil {
+					return err
+				}
+
+				evidence = &ccatoken.Evidence{}
+				if validate {
+					if err = evidence.SetClaims(pClaims, rClaims); err != nil {
+						return err
+					}
+				} else {
+					evidence.SetUnvalidatedClaims(pClaims, rClaims)
+				}
 			}
 
 			var b []byte
-			if validate {
+			if x5chain != nil {
+				if validate {
+					err = evidence.Validate()
+				}
+				if err == nil {
+					b, err = common.SignCCATokenWithX5Chain(evidence, x5chain, pSigner, rSigner)
+				}
+			} else if validate {
 				b, err = evidence.ValidateAndSign(pSigner, rSigner)
 
 			} else {
@@ -88,6 +189,10 @@ with iak.jwk and rak.jwk and save the result to my.cbor:
 				return fmt.Errorf("error signing evidence: %w", err)
 			}
 
+			if rTBS != nil {
+				return emitTBS(fs, rTBS, pTBS, b, rSigner, pSigner)
+			}
+
 			fn := tokenFileName()
 
 			err = afero.WriteFile(fs, fn, b, 0644)
@@ -109,11 +214,15 @@ with iak.jwk and rak.jwk and save the result to my.cbor:
 	)
 
 	createRAKFile = cmd.Flags().StringP(
-		"rak", "r", "", "JWK file with the key used for signing the realm token",
+		"rak", "r", "", "file with the key used for signing the realm token, "+common.KeyRefFlagUsage,
 	)
 
 	createIAKFile = cmd.Flags().StringP(
-		"iak", "p", "", "JWK file with the key used for signing the platform token",
+		"iak", "p", "", "file with the key used for signing the platform token, "+common.KeyRefFlagUsage,
+	)
+
+	createKeyFormat = cmd.Flags().String(
+		"key-format", common.KeyFormatAuto, common.KeyFormatFlagUsage,
 	)
 
 	createTokenFile = cmd.Flags().StringP(
@@ -126,19 +235,151 @@ with iak.jwk and rak.jwk and save the result to my.cbor:
 			"This is intended for testing.",
 	)
 
+	createDeriveClaims = cmd.Flags().BoolP(
+		"derive-claims", "D", false,
+		"set the instance ID from the IAK and the realm public key from the RAK, "+
+			"overriding any value in the claims file",
+	)
+
+	createX5ChainFile = cmd.Flags().StringP(
+		"x5chain", "x", "",
+		"PEM file with the certificate chain of the IAK, leaf first, to embed in the "+
+			"x5chain header of the platform token",
+	)
+
+	createEmitTBSFiles = cmd.Flags().StringSlice(
+		"emit-tbs", nil,
+		"save the COSE Sig_structures to be signed by the RAK and by the IAK, in this order, "+
+			"to these two files, and the signing state to the --state file, rather than signing; "+
+			"--rak and --iak only need to hold the public keys",
+	)
+
+	createAttachSigs = cmd.Flags().StringSlice(
+		"attach-signature", nil,
+		"files with the signatures of the structures saved by --emit-tbs, realm first, from "+
+			"which to assemble the token kept in the --state file",
+	)
+
+	createStateFile = cmd.Flags().String(
+		"state", "", "file where --emit-tbs saves the signing state, for --attach-signature to use",
+	)
+
 	return cmd
 }
 
-func init() {
-	for _, param := range []string{"claims", "rak", "iak"} {
-		if err := createCmd.MarkFlagRequired(param); err != nil {
-			panic(err)
+// checkOfflineSigningFlags checks the combination of flags, some of which are
+// only needed when a token is not signed in two steps
+func checkOfflineSigningFlags() error {
+	emit, attach := len(*createEmitTBSFiles) != 0, len(*createAttachSigs) != 0
+
+	if emit && attach {
+		return errors.New("--emit-tbs and --attach-signature are mutually exclusive")
+	}
+
+	if emit && len(*createEmitTBSFiles) != 2 {
+		return errors.New("--emit-tbs takes two files: the realm one, then the platform one")
+	}
+
+	if attach && len(*createAttachSigs) != 2 {
+		return errors.New("--attach-signature takes two files: the realm one, then the platform one")
+	}
+
+	if (emit || attach) && *createStateFile == "" {
+		return errors.New("--state must be supplied with --emit-tbs and --attach-signature")
+	}
+
+	if attach {
+		if *createClaimsFile != "" || *createIAKFile != "" || *createRAKFile != "" {
+			return errors.New("--claims, --iak and --rak cannot be used with --attach-signature")
+		}
+		return nil
+	}
+
+	if *createClaimsFile == "" || *createIAKFile == "" || *createRAKFile == "" {
+		return errors.New("--claims, --iak and --rak must be supplied")
+	}
+
+	return nil
+}
+
+// emitTBS saves the structures to be signed and the state needed to assemble
+// the token once they have been signed
+func emitTBS(
+	fs afero.Fs, rTBS, pTBS *common.TBSRecorder, token []byte, rSigner, pSigner *common.SigningKey,
+) error {
+	state, err := common.NewOfflineSigningState(
+		common.OfflineSigningCCA, token, rSigner.Public, pSigner.Public,
+	)
+	if err != nil {
+		return err
+	}
+
+	for i, tbs := range [][]byte{rTBS.TBS, pTBS.TBS} {
+		fn := (*createEmitTBSFiles)[i]
+
+		if err = afero.WriteFile(fs, fn, tbs, 0644); err != nil {
+			return fmt.Errorf("error saving to-be-signed structure to file %s: %w", fn, err)
+		}
+
+		fmt.Printf(">> %q successfully created\n", fn)
+	}
+
+	if err = state.Save(fs, *createStateFile); err != nil {
+		return err
+	}
+
+	fmt.Printf(">> %q successfully created\n", *createStateFile)
+
+	return nil
+}
+
+// attachSignatures assembles the token kept in the signing state with the
+// supplied realm and platform signatures
+func attachSignatures(fs afero.Fs) error {
+	state, err := common.LoadOfflineSigningState(fs, *createStateFile, common.OfflineSigningCCA, 2)
+	if err != nil {
+		return err
+	}
+
+	var (
+		keys [2]jwk.Key
+		sigs [2][]byte
+	)
+
+	for i := range keys {
+		if keys[i], err = state.Key(i); err != nil {
+			return fmt.Errorf("error decoding public key from %s: %w", *createStateFile, err)
+		}
+
+		fn := (*createAttachSigs)[i]
+
+		if sigs[i], err = afero.ReadFile(fs, fn); err != nil {
+			return fmt.Errorf("error loading signature from %s: %w", fn, err)
 		}
 	}
+
+	b, err := common.AttachCCASignatures(state.Token, sigs[0], sigs[1], keys[0], keys[1])
+	if err != nil {
+		return fmt.Errorf("error attaching signatures: %w", err)
+	}
+
+	fn := tokenFileName()
+
+	if err = afero.WriteFile(fs, fn, b, 0644); err != nil {
+		return fmt.Errorf("error saving CCA attestation token to file %s: %w", fn, err)
+	}
+
+	fmt.Printf(">> %q successfully created\n", fn)
+
+	return nil
 }
 
 func tokenFileName() string {
 	if createTokenFile == nil || *createTokenFile == "" {
+		// when attaching signatures, there is no claims file
+		if *createClaimsFile == "" {
+			return common.MakeFileName(".", *createStateFile, ".cbor")
+		}
 		return common.MakeFileName(".", *createClaimsFile, ".cbor")
 	}
 
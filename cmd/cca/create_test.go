@@ -4,11 +4,14 @@
 package cca
 
 import (
+	"crypto/rand"
 	"testing"
 
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/veraison/ccatoken"
+	"github.com/veraison/evcli/v2/common"
 )
 
 func Test_CreateCmd_default_token_name_ok(t *testing.T) {
@@ -250,3 +253,246 @@ func Test_CreateCmd_claims_invalid(t *testing.T) {
 	err = cmd.Execute()
 	assert.EqualError(t, err, expectedErr)
 }
+
+func Test_CreateCmd_derive_claims_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "es256.jwk", testValidIAK, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "es384.jwk", testValidRAK, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidCCAClaimsNoKeys, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--iak=es256.jwk",
+			"--rak=es384.jwk",
+			"--derive-claims",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	token, err := afero.ReadFile(fs, "claims.cbor")
+	require.NoError(t, err)
+
+	evidence, err := ccatoken.DecodeAndValidateEvidenceFromCBOR(token)
+	require.NoError(t, err)
+
+	iakPub, err := common.PubKeyFromKey(testValidIAKPub, common.KeyFormatJWK)
+	require.NoError(t, err)
+
+	// the platform challenge is checked against the derived RAK
+	err = evidence.Verify(iakPub)
+	require.NoError(t, err)
+
+	iak, err := common.ParseKey(testValidIAK, common.KeyFormatJWK)
+	require.NoError(t, err)
+
+	expected, err := common.InstanceIDFromKey(iak)
+	require.NoError(t, err)
+
+	actual, err := evidence.PlatformClaims.GetInstID()
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	alg, err := evidence.RealmClaims.GetPubKeyHashAlgID()
+	require.NoError(t, err)
+	assert.Equal(t, "sha-256", alg)
+}
+
+func Test_CreateCmd_derive_claims_bad_rak(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "es256.jwk", testValidIAK, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "es384.jwk", testInvalidKey, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidCCAClaimsNoKeys, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--iak=es256.jwk",
+			"--rak=es384.jwk",
+			"--derive-claims",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.ErrorContains(t, err, "error decoding RAK signing key from es384.jwk")
+}
+
+func Test_CreateCmd_emit_tbs_attach_signature_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "iak-pub.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "rak-pub.jwk", testValidRAKPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidCCAClaimsNoKeys, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--iak=iak-pub.jwk",
+			"--rak=rak-pub.jwk",
+			"--derive-claims",
+			"--emit-tbs=realm.tbs,platform.tbs",
+			"--state=my.state",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	// offline signing ceremony
+	for _, tv := range []struct {
+		key      []byte
+		tbs, sig string
+	}{
+		{testValidRAK, "realm.tbs", "realm.sig"},
+		{testValidIAK, "platform.tbs", "platform.sig"},
+	} {
+		tbs, err := afero.ReadFile(fs, tv.tbs)
+		require.NoError(t, err)
+
+		signer, err := common.SignerFromJWK(tv.key)
+		require.NoError(t, err)
+
+		sig, err := signer.Sign(rand.Reader, tbs)
+		require.NoError(t, err)
+
+		err = afero.WriteFile(fs, tv.sig, sig, 0644)
+		require.NoError(t, err)
+	}
+
+	cmd = NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--state=my.state",
+			"--attach-signature=realm.sig,platform.sig",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	token, err := afero.ReadFile(fs, "my.cbor")
+	require.NoError(t, err)
+
+	evidence, err := ccatoken.DecodeAndValidateEvidenceFromCBOR(token)
+	require.NoError(t, err)
+
+	iakPub, err := common.PubKeyFromKey(testValidIAKPub, common.KeyFormatJWK)
+	require.NoError(t, err)
+
+	assert.NoError(t, evidence.Verify(iakPub))
+}
+
+func Test_CreateCmd_attach_signature_swapped(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "iak-pub.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "rak-pub.jwk", testValidRAKPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidCCAClaimsNoKeys, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--iak=iak-pub.jwk",
+			"--rak=rak-pub.jwk",
+			"--derive-claims",
+			"--emit-tbs=realm.tbs,platform.tbs",
+			"--state=my.state",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	tbs, err := afero.ReadFile(fs, "platform.tbs")
+	require.NoError(t, err)
+
+	signer, err := common.SignerFromJWK(testValidIAK)
+	require.NoError(t, err)
+
+	sig, err := signer.Sign(rand.Reader, tbs)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "platform.sig", sig, 0644)
+	require.NoError(t, err)
+
+	cmd = NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--state=my.state",
+			"--attach-signature=platform.sig,platform.sig",
+		},
+	)
+
+	// the ES256 signature does not even have the size of an ES384 one
+	err = cmd.Execute()
+	assert.EqualError(t, err,
+		"error attaching signatures: realm token: unexpected ECDSA signature size 64")
+}
+
+func Test_CreateCmd_offline_signing_bad_flags(t *testing.T) {
+	tvs := []struct {
+		args        []string
+		expectedErr string
+	}{
+		{
+			args:        []string{"--emit-tbs=r.tbs,p.tbs", "--attach-signature=r.sig,p.sig", "--state=my.state"},
+			expectedErr: "--emit-tbs and --attach-signature are mutually exclusive",
+		},
+		{
+			args:        []string{"--emit-tbs=r.tbs", "--state=my.state"},
+			expectedErr: "--emit-tbs takes two files: the realm one, then the platform one",
+		},
+		{
+			args:        []string{"--attach-signature=r.sig,p.sig,x.sig", "--state=my.state"},
+			expectedErr: "--attach-signature takes two files: the realm one, then the platform one",
+		},
+		{
+			args:        []string{"--attach-signature=r.sig,p.sig"},
+			expectedErr: "--state must be supplied with --emit-tbs and --attach-signature",
+		},
+		{
+			args:        []string{"--iak=iak.jwk", "--attach-signature=r.sig,p.sig", "--state=my.state"},
+			expectedErr: "--claims, --iak and --rak cannot be used with --attach-signature",
+		},
+		{
+			args:        []string{"--claims=claims.json", "--iak=iak.jwk"},
+			expectedErr: "--claims, --iak and --rak must be supplied",
+		},
+	}
+
+	for _, tv := range tvs {
+		cmd := NewCreateCmd(afero.NewMemMapFs())
+		cmd.SetArgs(tv.args)
+
+		err := cmd.Execute()
+		assert.EqualError(t, err, tv.expectedErr, tv.args)
+	}
+}
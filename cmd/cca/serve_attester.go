@@ -0,0 +1,122 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package cca
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/veraison/evcli/v2/common"
+)
+
+var (
+	serveAttesterClaimsFile  *string
+	serveAttesterPlatformKey *string
+	serveAttesterRealmKey    *string
+	serveAttesterKeyFormat   *string
+	serveAttesterListenAddr  *string
+)
+
+var serveAttesterCmd = NewServeAttesterCmd(common.Fs, http.ListenAndServe)
+
+// NewServeAttesterCmd creates the "serve-attester" command.  The listen
+// function is used to start the HTTP server, which allows tests to run the
+// command without binding a socket.
+func NewServeAttesterCmd(fs afero.Fs, listen func(string, http.Handler) error) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve-attester",
+		Short: "run an HTTP service that emulates a CCA attester",
+		Long: `Run an HTTP service that emulates a CCA attester for relying-party
+testing.  The relying party POSTs a JSON object carrying a base64-encoded
+challenge to the /evidence endpoint:
+
+	{ "nonce": "<64 bytes, base64 encoded>" }
+
+and receives a CCA attestation token built from the supplied claims, with the
+challenge in the realm token, the realm token signed with the RAK and the
+platform token signed with the IAK.
+
+	evcli cca serve-attester \
+	              --listen=localhost:8081 \
+	              --claims=claims.json \
+	              --iak=iak.jwk \
+	              --rak=rak.jwk
+	`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pClaims, rClaims, err := loadUnValidatedCCAClaimsFromFile(fs, *serveAttesterClaimsFile)
+			if err != nil {
+				return err
+			}
+
+			platSigner, err := common.LoadSigningKey(
+				fs, *serveAttesterPlatformKey, *serveAttesterKeyFormat, "Platform signing key",
+			)
+			if err != nil {
+				return err
+			}
+
+			realmSigner, err := common.LoadSigningKey(
+				fs, *serveAttesterRealmKey, *serveAttesterKeyFormat, "Realm signing key",
+			)
+			if err != nil {
+				return err
+			}
+
+			srv := &common.EvidenceServer{
+				Builder: attesterEvidenceBuilder{
+					Pclaims: pClaims, Rclaims: rClaims, Psigner: platSigner, Rsigner: realmSigner,
+				},
+				MediaType: CCATokenMediaType,
+				CheckNonce: func(nonce []byte) error {
+					if len(nonce) != attesterNonceSz {
+						return fmt.Errorf("wrong challenge length %d: allowed value is %d",
+							len(nonce), attesterNonceSz)
+					}
+					return nil
+				},
+			}
+
+			fmt.Printf(">> CCA attester listening on http://%s%s\n",
+				*serveAttesterListenAddr, common.EvidencePath)
+
+			return listen(*serveAttesterListenAddr, srv.Handler())
+		},
+	}
+
+	serveAttesterClaimsFile = cmd.Flags().StringP(
+		"claims", "c", "", "JSON file containing the CCA attestation claims to be signed",
+	)
+
+	serveAttesterPlatformKey = cmd.Flags().StringP(
+		"iak", "p", "", "file with the Platform Attestation Key used for signing, "+common.KeyRefFlagUsage,
+	)
+
+	serveAttesterRealmKey = cmd.Flags().StringP(
+		"rak", "r", "", "file with the Realm Attestation Key used for signing, "+common.KeyRefFlagUsage,
+	)
+
+	serveAttesterKeyFormat = cmd.Flags().String(
+		"key-format", common.KeyFormatAuto, common.KeyFormatFlagUsage,
+	)
+
+	serveAttesterListenAddr = cmd.Flags().StringP(
+		"listen", "l", "localhost:8081", "address the attester service listens on",
+	)
+
+	return cmd
+}
+
+func init() {
+	if err := serveAttesterCmd.MarkFlagRequired("claims"); err != nil {
+		panic(err)
+	}
+	if err := serveAttesterCmd.MarkFlagRequired("iak"); err != nil {
+		panic(err)
+	}
+	if err := serveAttesterCmd.MarkFlagRequired("rak"); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package cca
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veraison/ccatoken"
+	"github.com/veraison/evcli/v2/common"
+)
+
+func serveAttesterHandler(t *testing.T) http.Handler {
+	fs := afero.NewMemMapFs()
+
+	// bind the realm token to testValidRAK so that the token verifies
+	claims := strings.Replace(
+		string(testValidCCAClaims), testOtherRAKCOSEKey, testValidRAKCOSEKey, 1,
+	)
+
+	err := afero.WriteFile(fs, "claims.json", []byte(claims), 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iak.jwk", testValidIAK, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "rak.jwk", testValidRAK, 0644)
+	require.NoError(t, err)
+
+	var handler http.Handler
+
+	listen := func(addr string, h http.Handler) error {
+		assert.Equal(t, "127.0.0.1:7777", addr)
+		handler = h
+		return nil
+	}
+
+	cmd := NewServeAttesterCmd(fs, listen)
+	cmd.SetArgs([]string{
+		"--claims=claims.json",
+		"--iak=iak.jwk",
+		"--rak=rak.jwk",
+		"--listen=127.0.0.1:7777",
+	})
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+	require.NotNil(t, handler)
+
+	return handler
+}
+
+func postChallenge(h http.Handler, nonce []byte) *httptest.ResponseRecorder {
+	body := `{"nonce": "` + base64.RawURLEncoding.EncodeToString(nonce) + `"}`
+
+	req := httptest.NewRequest(http.MethodPost, common.EvidencePath, strings.NewReader(body))
+	req.Header.Set("Accept", "*/*")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	return w
+}
+
+func Test_ServeAttesterCmd_rak_not_found(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "claims.json", testValidCCAClaims, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iak.jwk", testValidIAK, 0644)
+	require.NoError(t, err)
+
+	cmd := NewServeAttesterCmd(fs, func(string, http.Handler) error {
+		return errors.New("unexpected listen")
+	})
+	cmd.SetArgs([]string{"--claims=claims.json", "--iak=iak.jwk", "--rak=rak.jwk"})
+
+	expectedErr := `error loading Realm signing key from rak.jwk: open rak.jwk: file does not exist`
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
+func Test_ServeAttesterCmd_ok(t *testing.T) {
+	h := serveAttesterHandler(t)
+
+	challenge := bytes.Repeat([]byte{0x5a}, 64)
+
+	w := postChallenge(h, challenge)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, CCATokenMediaType, w.Header().Get("Content-Type"))
+
+	token, err := io.ReadAll(w.Body)
+	require.NoError(t, err)
+
+	e, err := ccatoken.DecodeAndValidateEvidenceFromCBOR(token)
+	require.NoError(t, err)
+
+	pk, err := common.PubKeyFromJWK(testValidIAKPub)
+	require.NoError(t, err)
+
+	assert.NoError(t, e.Verify(pk))
+
+	actual, err := e.RealmClaims.GetChallenge()
+	require.NoError(t, err)
+	assert.Equal(t, challenge, actual)
+}
+
+func Test_ServeAttesterCmd_bad_challenge_size(t *testing.T) {
+	h := serveAttesterHandler(t)
+
+	w := postChallenge(h, bytes.Repeat([]byte{0x5a}, 32))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "wrong challenge length 32: allowed value is 64")
+}
@@ -1,6 +1,10 @@
 package cca
 
-import "github.com/veraison/evcli/v2/common"
+import (
+	"strings"
+
+	"github.com/veraison/evcli/v2/common"
+)
 
 var (
 	testInvalidKey = []byte(`{}`)
@@ -66,6 +70,34 @@ var (
 			"cca-realm-public-key-hash-algo-id": "sha-256"
 		}
 	}`)
+	testValidCCAClaimsNoKeys = []byte(`{
+		"cca-platform-token": {
+			"cca-platform-profile": "tag:arm.com,2023:cca_platform#1.0.0",
+			"cca-platform-implementation-id": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+			"cca-platform-config": "AQID",
+			"cca-platform-lifecycle": 12288,
+			"cca-platform-sw-components": [
+				{
+					"measurement-value": "AwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwM=",
+					"signer-id": "BAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQ="
+				}
+			],
+			"cca-platform-service-indicator": "https://veraison.example/v1/challenge-response",
+			"cca-platform-hash-algo-id": "sha-256"
+		},
+		"cca-realm-delegated-token": {
+			"cca-realm-challenge": "QUJBQkFCQUJBQkFCQUJBQkFCQUJBQkFCQUJBQkFCQUJBQkFCQUJBQkFCQUJBQkFCQUJBQkFCQUJBQkFCQUJBQg==",
+			"cca-realm-personalization-value": "QURBREFEQURBREFEQURBREFEQURBREFEQURBREFEQURBREFEQURBREFEQURBREFEQURBREFEQURBREFEQURBRA==",
+			"cca-realm-initial-measurement": "Q0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQw==",
+			"cca-realm-extensible-measurements": [
+				"Q0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQw==",
+				"Q0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQw==",
+				"Q0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQw==",
+				"Q0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQ0NDQw=="
+			],
+			"cca-realm-hash-algo-id": "sha-256"
+		}
+	}`)
 	testValidCCAClaimsNoNonce = []byte(`{
 		"cca-platform-token": {
 			"cca-platform-profile": "tag:arm.com,2023:cca_platform#1.0.0",
@@ -140,5 +172,104 @@ var (
 	41cb1012993766e31204ddfa2da2884a7a2a60e6623079dbcc16da402430
 	18eb9f4a98bfcd563773c3bfe6137c53a8404d9f97d8e22246a23a364abb
 	715cc17a6a2465798cfa4cb956d049
+`)
+	// EAR JWT signed with testValidIAK, as found in the "result" field of a
+	// challenge-response session
+	testValidEAR = []byte(`"` + strings.Join([]string{
+		`eyJhbGciOiJFUzI1NiJ9.eyJlYXRfcHJvZmlsZSI6InRhZzpnaXRodWIuY29tLDIwMjM6dmV`,
+		`yYWlzb24vZWFyIiwiaWF0IjoxNzI3NzAxMjAwLCJlYXIudmVyaWZpZXItaWQiOnsiYnVpbGQ`,
+		`iOiJjb21taXQtMTIzNDU2NyIsImRldmVsb3BlciI6IlZlcmFpc29uIFByb2plY3QifSwiZWF`,
+		`0X25vbmNlIjoiUVVKQlFrRkNRVUpCUWtGQ1FVSkJRa0ZDUVVKQlFrRkNRVUpCUWtGQ1FVSkJ`,
+		`Ra0ZDUVVKQlFrRkNRVUpCUWtGQ1FVSkJRa0ZDUVVKQlFrRkNRVUpCUWciLCJzdWJtb2RzIjp`,
+		`7IkNDQV9TU0RfUExBVEZPUk0iOnsiZWFyLnN0YXR1cyI6Indhcm5pbmciLCJlYXIudHJ1c3R`,
+		`3b3J0aGluZXNzLXZlY3RvciI6eyJpbnN0YW5jZS1pZGVudGl0eSI6MiwiY29uZmlndXJhdGl`,
+		`vbiI6MCwiZXhlY3V0YWJsZXMiOjMzLCJmaWxlLXN5c3RlbSI6MCwiaGFyZHdhcmUiOjIsInJ`,
+		`1bnRpbWUtb3BhcXVlIjoyLCJzdG9yYWdlLW9wYXF1ZSI6Miwic291cmNlZC1kYXRhIjowfSw`,
+		`iZWFyLmFwcHJhaXNhbC1wb2xpY3ktaWQiOiJwb2xpY3k6Q0NBX1NTRF9QTEFURk9STSJ9fX0`,
+		`K.QtYuXhyN5KvDS4PfsowK5BzoHEvcIFO-6kze6l5I5-8Un0SzLOMymUTu83u-88SIGN7q1b`,
+		`MY0W8ipixap1PRHA`,
+	}, "") + `"`)
+	// COSE_Key encoding of testValidRAKPub
+	testValidRAKCOSEKey = "pAECIAIhWDCC+9EyqbXDloefuxU0DZBQl45Vx51SeaK6DpWFTzfiDNL2TztytXC713Pu4s52hCUiWDDt9UXtvon/r+Dpa71G4nDyB5bESLmNr0anZNJ0Qubm7YT4zsgX5uzGpx06PefWfs0="
+	// COSE_Key of the RAK used in testValidCCAClaims, which does not match
+	// testValidRAK
+	testOtherRAKCOSEKey = "pAECIAIhWDB2+YgJG+WF7UGAGuz6uFhUjGMFfhaw5nYSC70NL5wp4FbF1BoBMOucIVF4mdwjFGsiWDAo4bBivT6ksxX9IZ8cu1KMtudMpJvhZ3NzT2GhymEDGyu/PZGPL5T/xCKOUJGVRK4="
+
+	// certificate chain of the IAK (leaf first), issued by an intermediate CA
+	// under testRootCA
+	testIAKChain = []byte(`-----BEGIN CERTIFICATE-----
+MIIBczCCARmgAwIBAgIBAzAKBggqhkjOPQQDAjApMREwDwYDVQQKEwhWZXJhaXNv
+bjEUMBIGA1UEAxMLVGVzdCBJQUsgQ0EwIBcNMjQwMTAxMDAwMDAwWhgPMjEyNDAx
+MDEwMDAwMDBaMCYxETAPBgNVBAoTCFZlcmFpc29uMREwDwYDVQQDEwhUZXN0IElB
+SzBZMBMGByqGSM49AgEGCCqGSM49AwEHA0IABDCgQkzSHClEg4otdckrN+duog2f
+AIk6O07uijwKr+w+4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyOjMzAx
+MA4GA1UdDwEB/wQEAwIHgDAfBgNVHSMEGDAWgBTSLUiH+Y807gs9ihpelWkRLuNP
+gDAKBggqhkjOPQQDAgNIADBFAiA/2HnDHmPJs4QcQYzI4Y2d7Ai+Wn8it8YufVyk
+SDjYhwIhAItgVyk4dW5ZmJ7Fi/MzfCL1OX1MDj0msItYVK9qFOMm
+-----END CERTIFICATE-----
+-----BEGIN CERTIFICATE-----
+MIIBpjCCAU2gAwIBAgIBAjAKBggqhkjOPQQDAjAqMREwDwYDVQQKEwhWZXJhaXNv
+bjEVMBMGA1UEAxMMVGVzdCBSb290IENBMCAXDTI0MDEwMTAwMDAwMFoYDzIxMjQw
+MTAxMDAwMDAwWjApMREwDwYDVQQKEwhWZXJhaXNvbjEUMBIGA1UEAxMLVGVzdCBJ
+QUsgQ0EwWTATBgcqhkjOPQIBBggqhkjOPQMBBwNCAASTusTDgvyIExi5vj93eNHT
+2khA24TUYsTJd3RRMuO9RN3lzssQNh1ZLom1CJnU7jKepPpmOVJQqUk+XzPJWJOn
+o2MwYTAOBgNVHQ8BAf8EBAMCAQYwDwYDVR0TAQH/BAUwAwEB/zAdBgNVHQ4EFgQU
+0i1Ih/mPNO4LPYoaXpVpES7jT4AwHwYDVR0jBBgwFoAUxadjQWpqOuI3WxtuGHG7
+40jUrd0wCgYIKoZIzj0EAwIDRwAwRAIgesMRGeNZEFboEhWnYDo2eNyC5UJVgV+d
+ittpxd5y0twCIBppwXeS54PNbnZFn7eG7ephd+fNs/GrGbSIRS2byRPa
+-----END CERTIFICATE-----
+`)
+	testRootCA = []byte(`-----BEGIN CERTIFICATE-----
+MIIBhjCCAS2gAwIBAgIBATAKBggqhkjOPQQDAjAqMREwDwYDVQQKEwhWZXJhaXNv
+bjEVMBMGA1UEAxMMVGVzdCBSb290IENBMCAXDTI0MDEwMTAwMDAwMFoYDzIxMjQw
+MTAxMDAwMDAwWjAqMREwDwYDVQQKEwhWZXJhaXNvbjEVMBMGA1UEAxMMVGVzdCBS
+b290IENBMFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAExjyi06nMbOXn8IBI9izp
+A35y6Xb+C3oPZ7h19qzVbbR9cTUMJikoVqUKIID1zL4wDiv9wT0q7bPjbYxuOSZD
+SKNCMEAwDgYDVR0PAQH/BAQDAgEGMA8GA1UdEwEB/wQFMAMBAf8wHQYDVR0OBBYE
+FMWnY0FqajriN1sbbhhxu+NI1K3dMAoGCCqGSM49BAMCA0cAMEQCICRzVGSJoT38
+/xvW+DdKHvWVYzi3ryAgrHmIprSNBn31AiBiv4xwebL1ZvjxJDbn0BezzVL09VEv
+uQazUFw72uZ0NQ==
+-----END CERTIFICATE-----
+`)
+	// a trust anchor that did not issue testIAKChain
+	testOtherRootCA = []byte(`-----BEGIN CERTIFICATE-----
+MIIBijCCAS+gAwIBAgIBATAKBggqhkjOPQQDAjArMREwDwYDVQQKEwhWZXJhaXNv
+bjEWMBQGA1UEAxMNT3RoZXIgUm9vdCBDQTAgFw0yNDAxMDEwMDAwMDBaGA8yMTI0
+MDEwMTAwMDAwMFowKzERMA8GA1UEChMIVmVyYWlzb24xFjAUBgNVBAMTDU90aGVy
+IFJvb3QgQ0EwWTATBgcqhkjOPQIBBggqhkjOPQMBBwNCAAQjMRajJleZiBgbbzKx
+Uv3eS+16XFGEdxCRlw6sqmS0ECrW7BoXsBadw0KaFK75Ghij8YsGoBxRyKvxIkF6
+0VnGo0IwQDAOBgNVHQ8BAf8EBAMCAQYwDwYDVR0TAQH/BAUwAwEB/zAdBgNVHQ4E
+FgQUduIPQh0Vje1s6YGVY0XOADMXjsAwCgYIKoZIzj0EAwIDSQAwRgIhAMXGYCai
+jCxxY1smVbYkafFcV3mWUyeCngZNGkyMQXYkAiEA31vwqr8qUSGpMPMJzp4mWLl9
+ck4F7LQKTC/Wjo57jkQ=
+-----END CERTIFICATE-----
+`)
+	// CRL issued by the intermediate CA, revoking the IAK certificate
+	testIAKRevokedCRL = []byte(`-----BEGIN X509 CRL-----
+MIH6MIGhAgEBMAoGCCqGSM49BAMCMCkxETAPBgNVBAoTCFZlcmFpc29uMRQwEgYD
+VQQDEwtUZXN0IElBSyBDQRcNMjQwMTAxMDAwMDAwWhgPMjEyNDAxMDEwMDAwMDBa
+MBQwEgIBAxcNMjQwMTAxMDAwMDAwWqAvMC0wHwYDVR0jBBgwFoAU0i1Ih/mPNO4L
+PYoaXpVpES7jT4AwCgYDVR0UBAMCAQEwCgYIKoZIzj0EAwIDSAAwRQIgS32qRIbY
+7GSm+DxGI0npTPD/9DjPv/7oFENfnANDqGsCIQCj3h0ErhaKyY9VFHh+fKn40dXI
+rChrbRLczAr8t69img==
+-----END X509 CRL-----
+`)
+	// CoRIM, signed with testValidIAK, endorsing testValidIAKPub as the IAK of
+	// the platform that produced testValidCCAToken
+	testIAKCoRIM = common.MustHexDecode(`
+d2845828a3012603746170706c69636174696f6e2f72696d2b63626f7208a100a1006941
+434d45204c74642ea0590168d901f5a3006a746573742d636f72696d0181d901fa590133
+a201a1006f69616b2d656e646f7273656d656e7404a1038182a200a300d9025858200000
+000000000000000000000000000000000000000000000000000000000000016441434d45
+026a526f616452756e6e657201d902265821010202020202020202020202020202020202
+02020202020202020202020202020281d9022a78b22d2d2d2d2d424547494e205055424c
+4943204b45592d2d2d2d2d0a4d466b77457759484b6f5a497a6a3043415159494b6f5a49
+7a6a304441516344516741454d4b4243544e49634b555344696931317953733335323669
+445a38410a69546f375475364b5041717637443767533258704a46625a6949745373336d
+392b39556536476e7648772f4757325a5a615674737a67675849773d3d0a2d2d2d2d2d45
+4e44205055424c4943204b45592d2d2d2d2d0a03d8207818687474703a2f2f61726d2e63
+6f6d2f6363612f7373642f3158408998ab904022ad1665c53962852993932c1258b30808
+adf2ad05c4b1bf2639e53fcead9ed6140e62f9eac1a91718197f56ab5add419b8a187772
+1ad520fe7889
 `)
 )
@@ -6,7 +6,9 @@ package cca
 import (
 	"errors"
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
@@ -33,9 +35,13 @@ var (
 	attesterClaimsFile *string
 	platformKeyFile    *string
 	realmKeyFile       *string
+	attesterKeyFormat  *string
 	attesterAPIURL     string
 	attesterIsInsecure bool
 	attesterCerts      []string
+	attesterEARKeyFile string
+	attesterExpect     *common.Expectation
+	attesterMaxAge     time.Duration
 )
 
 var (
@@ -61,7 +67,7 @@ and realm signing key (RAK).
 				   
 	`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := attesterCheckSubmitArgs(); err != nil {
+			if err := attesterCheckSubmitArgs(cmd); err != nil {
 				return err
 			}
 
@@ -70,24 +76,18 @@ and realm signing key (RAK).
 				return err
 			}
 
-			key, err := afero.ReadFile(fs, *platformKeyFile)
+			platSigner, err := common.LoadSigningKey(
+				fs, *platformKeyFile, *attesterKeyFormat, "Platform signing key",
+			)
 			if err != nil {
-				return fmt.Errorf("error loading Platform signing key from %s: %w", *platformKeyFile, err)
-			}
-
-			platSigner, err := common.SignerFromJWK(key)
-			if err != nil {
-				return fmt.Errorf("error decoding Platform signing key from %s: %w", *platformKeyFile, err)
-			}
-
-			key, err = afero.ReadFile(fs, *realmKeyFile)
-			if err != nil {
-				return fmt.Errorf("error loading Realm signing key from %s: %w", *realmKeyFile, err)
+				return err
 			}
 
-			realmSigner, err := common.SignerFromJWK(key)
+			realmSigner, err := common.LoadSigningKey(
+				fs, *realmKeyFile, *attesterKeyFormat, "Realm signing key",
+			)
 			if err != nil {
-				return fmt.Errorf("error decoding Realm signing key from %s: %w", *realmKeyFile, err)
+				return err
 			}
 
 			eb := attesterEvidenceBuilder{Pclaims: pClaims, Rclaims: rClaims, Psigner: platSigner, Rsigner: realmSigner}
@@ -113,7 +113,33 @@ and realm signing key (RAK).
 				return fmt.Errorf("error in attesterVeraisonClient Run %w", err)
 			}
 
-			fmt.Println(string(attestationResults))
+			earKey, err := common.LoadEARVerificationKey(
+				fs, attesterEARKeyFile, attesterAPIURL, attesterIsInsecure, attesterCerts,
+			)
+			if err != nil {
+				return err
+			}
+
+			ar, err := common.DecodeAndVerifyAttestationResult(attestationResults, earKey)
+			if err != nil {
+				return err
+			}
+
+			// the challenge is set by the evidence builder
+			nonce, err := rClaims.GetChallenge()
+			if err != nil {
+				return fmt.Errorf("getting the challenge of the submitted evidence: %w", err)
+			}
+
+			if err = checkResultBinding(ar, nonce, attesterMaxAge); err != nil {
+				return err
+			}
+
+			ar.Report(os.Stdout)
+
+			if attesterExpect != nil {
+				return attesterExpect.Check(ar)
+			}
 
 			return nil
 		},
@@ -124,11 +150,15 @@ and realm signing key (RAK).
 	)
 
 	platformKeyFile = cmd.Flags().StringP(
-		"iak", "p", "", "JWK file with the Platform Attestation Key used for signing",
+		"iak", "p", "", "file with the Platform Attestation Key used for signing, "+common.KeyRefFlagUsage,
 	)
 
 	realmKeyFile = cmd.Flags().StringP(
-		"rak", "r", "", "JWK file with the Realm Attestation Key used for signing",
+		"rak", "r", "", "file with the Realm Attestation Key used for signing, "+common.KeyRefFlagUsage,
+	)
+
+	attesterKeyFormat = cmd.Flags().String(
+		"key-format", common.KeyFormatAuto, common.KeyFormatFlagUsage,
 	)
 
 	cmd.Flags().StringP(
@@ -143,6 +173,29 @@ and realm signing key (RAK).
 		"ca-cert", "E", nil, "path to a CA cert that will be used in addition to system certs; may be specified multiple times",
 	)
 
+	cmd.Flags().StringP(
+		"ear-key", "K", "", "file with the public key used to verify the attestation result. "+
+			"If not set, the key is fetched from the verifier",
+	)
+
+	cmd.Flags().String(
+		"expect", "", "minimum acceptable appraisal status (affirming, warning or contraindicated), "+
+			"optionally followed by per-claim minimums, e.g. warning,executables=affirming",
+	)
+
+	cmd.Flags().Duration(
+		"max-age", common.DefaultResultMaxAge, "maximum distance between the time the attestation "+
+			"result was issued and the current time, e.g. 30s or 10m.  0 disables the freshness check",
+	)
+
+	return cmd
+}
+
+func attesterCheckSubmitArgs(cmd *cobra.Command) error {
+	// the flags are bound when the command runs, rather than when it is
+	// created, so that the commands sharing the config entries do not take
+	// them over from each other
+	var err error
 	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
 		cfgName := strings.ReplaceAll(flag.Name, "-", "_")
 		if cfgName == "claims" || cfgName == "iak" || cfgName == "rak" {
@@ -152,14 +205,14 @@ and realm signing key (RAK).
 			return
 		}
 
-		err := viper.BindPFlag(cfgName, flag)
-		cobra.CheckErr(err)
+		if bindErr := viper.BindPFlag(cfgName, flag); bindErr != nil {
+			err = bindErr
+		}
 	})
+	if err != nil {
+		return err
+	}
 
-	return cmd
-}
-
-func attesterCheckSubmitArgs() error {
 	attesterAPIURL = viper.GetString("api_server")
 	if attesterAPIURL == "" {
 		return errors.New("API server URL is not configured")
@@ -167,6 +220,14 @@ func attesterCheckSubmitArgs() error {
 
 	attesterIsInsecure = viper.GetBool("insecure")
 	attesterCerts = viper.GetStringSlice("ca_cert")
+	attesterEARKeyFile = viper.GetString("ear_key")
+	attesterMaxAge = viper.GetDuration("max_age")
+
+	expect, err := common.ParseExpectation(viper.GetString("expect"))
+	if err != nil {
+		return err
+	}
+	attesterExpect = expect
 
 	return nil
 }
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/spf13/afero"
@@ -205,18 +206,19 @@ func Test_AttesterCmd_bad_server_url(t *testing.T) {
 }
 
 func Test_AttesterCmd_ok(t *testing.T) {
+	setVerifyAsClock(t, testEARIssuedAt)
+
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	mc := mock_deps.NewMockIVeraisonClient(ctrl)
 
 	mc.EXPECT().SetSessionURI(testSessionURI)
-	mc.EXPECT().SetEvidenceBuilder(gomock.Any())
 	mc.EXPECT().SetIsInsecure(false)
 	mc.EXPECT().SetCerts([]string{})
 	mc.EXPECT().SetDeleteSession(true)
 	mc.EXPECT().SetNonceSz(uint(64))
-	mc.EXPECT().Run().Return([]byte("ok"), nil)
+	expectAttesterSession(mc, testNonce, testValidEAR)
 
 	fs := afero.NewMemMapFs()
 
@@ -229,6 +231,9 @@ func Test_AttesterCmd_ok(t *testing.T) {
 	err = afero.WriteFile(fs, "rak.jwk", testValidRAK, 0644)
 	require.NoError(t, err)
 
+	err = afero.WriteFile(fs, "ear.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
 	cmd := NewAttesterCmd(fs, mc)
 	cmd.SetArgs(
 		[]string{
@@ -236,6 +241,7 @@ func Test_AttesterCmd_ok(t *testing.T) {
 			"--claims=claims.json",
 			"--iak=iak.jwk",
 			"--rak=rak.jwk",
+			"--ear-key=ear.jwk",
 		},
 	)
 
@@ -243,6 +249,113 @@ func Test_AttesterCmd_ok(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+// runAttesterCmd runs attester over testValidCCAClaims, with the verifier
+// returning the supplied attestation result.  As in the evcli binary, the
+// other verify-as commands are built too.
+func runAttesterCmd(t *testing.T, ear []byte, args ...string) error {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mock_deps.NewMockIVeraisonClient(ctrl)
+
+	mc.EXPECT().SetSessionURI(testSessionURI)
+	mc.EXPECT().SetIsInsecure(false)
+	mc.EXPECT().SetCerts([]string{})
+	mc.EXPECT().SetDeleteSession(true)
+	mc.EXPECT().SetNonceSz(uint(64))
+	expectAttesterSession(mc, testNonce, ear)
+
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "claims.json", testValidCCAClaims, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iak.jwk", testValidIAK, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "rak.jwk", testValidRAK, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewAttesterCmd(fs, mc)
+	NewRelyingPartyCmd(fs, relyingPartyVeraisonClient)
+	buildPSAVerifyAsCmds()
+
+	cmd.SetArgs(
+		append([]string{
+			"--api-server=" + testSessionURI,
+			"--claims=claims.json",
+			"--iak=iak.jwk",
+			"--rak=rak.jwk",
+			"--ear-key=ear.jwk",
+		}, args...),
+	)
+
+	return cmd.Execute()
+}
+
+func Test_AttesterCmd_options_not_shared(t *testing.T) {
+	setVerifyAsClock(t, testEARIssuedAt)
+
+	err := runAttesterCmd(t, testValidEAR, "--ear-key=other-ear.jwk")
+	assert.EqualError(t, err, "error loading EAR verification key from other-ear.jwk: open other-ear.jwk: file does not exist")
+
+	err = runAttesterCmd(t, testValidEAR, "--expect=affirming")
+	var exitErr *common.ExitError
+	require.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, common.ExitCodeStatusNotMet, exitErr.Code)
+
+	// the result is stale, unless the freshness window is widened
+	setVerifyAsClock(t, testEARIssuedAt.Add(time.Hour))
+
+	err = runAttesterCmd(t, testValidEAR, "--max-age=2h")
+	assert.NoError(t, err)
+}
+
+func Test_AttesterCmd_ear_wrong_key(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mock_deps.NewMockIVeraisonClient(ctrl)
+
+	mc.EXPECT().SetSessionURI(testSessionURI)
+	mc.EXPECT().SetIsInsecure(false)
+	mc.EXPECT().SetCerts([]string{})
+	mc.EXPECT().SetDeleteSession(true)
+	mc.EXPECT().SetNonceSz(uint(64))
+	expectAttesterSession(mc, testNonce, testValidEAR)
+
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "claims.json", testValidCCAClaims, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iak.jwk", testValidIAK, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "rak.jwk", testValidRAK, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidRAKPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewAttesterCmd(fs, mc)
+	cmd.SetArgs(
+		[]string{
+			"--api-server=" + testSessionURI,
+			"--claims=claims.json",
+			"--iak=iak.jwk",
+			"--rak=rak.jwk",
+			"--ear-key=ear.jwk",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.ErrorContains(t, err, "attestation result signed with ES256, where ES384 is expected for the EC verification key")
+}
+
 func Test_AttesterCmd_protocol_run_failed(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -348,3 +461,101 @@ func Test_attesterEvidenceBuilder_BuildEvidence_unsupported_media_type(t *testin
 	assert.Equal(t, expectedEvidence, actualEvidence)
 	assert.Equal(t, expectedMediaType, actualMediaType)
 }
+
+func Test_AttesterCmd_expect_not_met(t *testing.T) {
+	setVerifyAsClock(t, testEARIssuedAt)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mock_deps.NewMockIVeraisonClient(ctrl)
+
+	mc.EXPECT().SetSessionURI(testSessionURI)
+	mc.EXPECT().SetIsInsecure(false)
+	mc.EXPECT().SetCerts([]string{})
+	mc.EXPECT().SetDeleteSession(true)
+	mc.EXPECT().SetNonceSz(uint(64))
+	expectAttesterSession(mc, testNonce, testValidEAR)
+
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "claims.json", testValidCCAClaims, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iak.jwk", testValidIAK, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "rak.jwk", testValidRAK, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewAttesterCmd(fs, mc)
+	cmd.SetArgs(
+		[]string{
+			"--api-server=" + testSessionURI,
+			"--claims=claims.json",
+			"--iak=iak.jwk",
+			"--rak=rak.jwk",
+			"--ear-key=ear.jwk",
+			"--expect=affirming",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, `submod "CCA_SSD_PLATFORM": status warning does not meet the expected affirming`)
+
+	var exitErr *common.ExitError
+	require.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, common.ExitCodeStatusNotMet, exitErr.Code)
+}
+
+func Test_AttesterCmd_expect_trust_vector_not_met(t *testing.T) {
+	setVerifyAsClock(t, testEARIssuedAt)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mock_deps.NewMockIVeraisonClient(ctrl)
+
+	mc.EXPECT().SetSessionURI(testSessionURI)
+	mc.EXPECT().SetIsInsecure(false)
+	mc.EXPECT().SetCerts([]string{})
+	mc.EXPECT().SetDeleteSession(true)
+	mc.EXPECT().SetNonceSz(uint(64))
+	expectAttesterSession(mc, testNonce, testValidEAR)
+
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "claims.json", testValidCCAClaims, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iak.jwk", testValidIAK, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "rak.jwk", testValidRAK, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewAttesterCmd(fs, mc)
+	cmd.SetArgs(
+		[]string{
+			"--api-server=" + testSessionURI,
+			"--claims=claims.json",
+			"--iak=iak.jwk",
+			"--rak=rak.jwk",
+			"--ear-key=ear.jwk",
+			"--expect=warning,hardware=affirming,executables=affirming",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, `submod "CCA_SSD_PLATFORM": executables claim 33 (warning) does not meet the expected affirming`)
+
+	var exitErr *common.ExitError
+	require.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, common.ExitCodeTrustVectorNotMet, exitErr.Code)
+}
@@ -7,7 +7,9 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
@@ -23,6 +25,9 @@ var (
 	relyingPartyAPIURL     string
 	relyingPartyIsInsecure bool
 	relyingPartyCerts      []string
+	relyingPartyEARKeyFile string
+	relyingPartyExpect     *common.Expectation
+	relyingPartyMaxAge     time.Duration
 )
 
 var (
@@ -46,7 +51,7 @@ previous invocation to "evcli cca create" command.
 
 	`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := relyingPartyCheckSubmitArgs(); err != nil {
+			if err := relyingPartyCheckSubmitArgs(cmd); err != nil {
 				return err
 			}
 
@@ -97,7 +102,27 @@ previous invocation to "evcli cca create" command.
 				return fmt.Errorf("Veraison API client failed: %v", err)
 			}
 
-			fmt.Println(string(attestationResults))
+			earKey, err := common.LoadEARVerificationKey(
+				fs, relyingPartyEARKeyFile, relyingPartyAPIURL, relyingPartyIsInsecure, relyingPartyCerts,
+			)
+			if err != nil {
+				return err
+			}
+
+			ar, err := common.DecodeAndVerifyAttestationResult(attestationResults, earKey)
+			if err != nil {
+				return err
+			}
+
+			if err = checkResultBinding(ar, nonce, relyingPartyMaxAge); err != nil {
+				return err
+			}
+
+			ar.Report(os.Stdout)
+
+			if relyingPartyExpect != nil {
+				return relyingPartyExpect.Check(ar)
+			}
 
 			return nil
 		},
@@ -119,6 +144,29 @@ previous invocation to "evcli cca create" command.
 		"ca-cert", "E", nil, "path to a CA cert that will be used in addition to system certs; may be specified multiple times",
 	)
 
+	cmd.Flags().StringP(
+		"ear-key", "K", "", "file with the public key used to verify the attestation result. "+
+			"If not set, the key is fetched from the verifier",
+	)
+
+	cmd.Flags().String(
+		"expect", "", "minimum acceptable appraisal status (affirming, warning or contraindicated), "+
+			"optionally followed by per-claim minimums, e.g. warning,executables=affirming",
+	)
+
+	cmd.Flags().Duration(
+		"max-age", common.DefaultResultMaxAge, "maximum distance between the time the attestation "+
+			"result was issued and the current time, e.g. 30s or 10m.  0 disables the freshness check",
+	)
+
+	return cmd
+}
+
+func relyingPartyCheckSubmitArgs(cmd *cobra.Command) error {
+	// the flags are bound when the command runs, rather than when it is
+	// created, so that the commands sharing the config entries do not take
+	// them over from each other
+	var err error
 	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
 		cfgName := strings.ReplaceAll(flag.Name, "-", "_")
 		if cfgName == "token" {
@@ -128,14 +176,14 @@ previous invocation to "evcli cca create" command.
 			return
 		}
 
-		err := viper.BindPFlag(cfgName, flag)
-		cobra.CheckErr(err)
+		if bindErr := viper.BindPFlag(cfgName, flag); bindErr != nil {
+			err = bindErr
+		}
 	})
+	if err != nil {
+		return err
+	}
 
-	return cmd
-}
-
-func relyingPartyCheckSubmitArgs() error {
 	relyingPartyAPIURL = viper.GetString("api_server")
 	if relyingPartyAPIURL == "" {
 		return errors.New("API server URL is not configured")
@@ -143,6 +191,14 @@ func relyingPartyCheckSubmitArgs() error {
 
 	relyingPartyIsInsecure = viper.GetBool("insecure")
 	relyingPartyCerts = viper.GetStringSlice("ca_cert")
+	relyingPartyEARKeyFile = viper.GetString("ear_key")
+	relyingPartyMaxAge = viper.GetDuration("max_age")
+
+	expect, err := common.ParseExpectation(viper.GetString("expect"))
+	if err != nil {
+		return err
+	}
+	relyingPartyExpect = expect
 
 	return nil
 }
@@ -8,13 +8,16 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	mock_deps "github.com/veraison/evcli/v2/cmd/mocks"
+	"github.com/veraison/evcli/v2/common"
 )
 
 func Test_RelyingPartyCmd_token_not_found(t *testing.T) {
@@ -75,6 +78,8 @@ func Test_RelyingPartyCmd_bad_server_url(t *testing.T) {
 }
 
 func Test_RelyingPartyCmd_ok(t *testing.T) {
+	setVerifyAsClock(t, testEARIssuedAt)
+
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
@@ -86,18 +91,22 @@ func Test_RelyingPartyCmd_ok(t *testing.T) {
 	mc.EXPECT().SetIsInsecure(false)
 	mc.EXPECT().SetCerts([]string{})
 	mc.EXPECT().SetDeleteSession(true)
-	mc.EXPECT().Run().Return([]byte("ok"), nil)
+	mc.EXPECT().Run().Return(testValidEAR, nil)
 
 	fs := afero.NewMemMapFs()
 
 	err := afero.WriteFile(fs, "ccatoken.cbor", testValidCCAToken, 0644)
 	require.NoError(t, err)
 
+	err = afero.WriteFile(fs, "ear.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
 	cmd := NewRelyingPartyCmd(fs, mc)
 	cmd.SetArgs(
 		[]string{
 			"--api-server=" + testSessionURI,
 			"--token=ccatoken.cbor",
+			"--ear-key=ear.jwk",
 		},
 	)
 
@@ -105,6 +114,111 @@ func Test_RelyingPartyCmd_ok(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+// runRelyingPartyCmd runs relying-party over testValidCCAToken, with the
+// verifier returning the supplied attestation result.  As in the evcli binary,
+// the other verify-as commands are built too.
+func runRelyingPartyCmd(t *testing.T, ear []byte, args ...string) error {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mock_deps.NewMockIVeraisonClient(ctrl)
+
+	mc.EXPECT().SetNonce(testNonce)
+	mc.EXPECT().SetSessionURI(testSessionURI)
+	mc.EXPECT().SetEvidenceBuilder(gomock.Any())
+	mc.EXPECT().SetIsInsecure(false)
+	mc.EXPECT().SetCerts([]string{})
+	mc.EXPECT().SetDeleteSession(true)
+	mc.EXPECT().Run().Return(ear, nil)
+
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "ccatoken.cbor", testValidCCAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewRelyingPartyCmd(fs, mc)
+	NewAttesterCmd(fs, attesterVeraisonClient)
+	buildPSAVerifyAsCmds()
+
+	cmd.SetArgs(
+		append([]string{
+			"--api-server=" + testSessionURI,
+			"--token=ccatoken.cbor",
+			"--ear-key=ear.jwk",
+		}, args...),
+	)
+
+	return cmd.Execute()
+}
+
+func Test_RelyingPartyCmd_options_not_shared(t *testing.T) {
+	setVerifyAsClock(t, testEARIssuedAt)
+
+	err := runRelyingPartyCmd(t, testValidEAR, "--ear-key=other-ear.jwk")
+	assert.EqualError(t, err, "error loading EAR verification key from other-ear.jwk: open other-ear.jwk: file does not exist")
+
+	err = runRelyingPartyCmd(t, testValidEAR, "--expect=affirming")
+	var exitErr *common.ExitError
+	require.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, common.ExitCodeStatusNotMet, exitErr.Code)
+
+	// the result is stale, unless the freshness window is widened
+	setVerifyAsClock(t, testEARIssuedAt.Add(time.Hour))
+
+	err = runRelyingPartyCmd(t, testValidEAR, "--max-age=2h")
+	assert.NoError(t, err)
+}
+
+func Test_RelyingPartyCmd_unexpected_submod(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// a PSA result for the same nonce
+	ar := common.NewLocalAttestationResult(testValidCCAToken, testNonce)
+	ar.AddSubmod(common.SubmodPSA, common.TrustVector{InstanceIdentity: 2})
+
+	key, err := jwk.ParseKey(testValidIAK)
+	require.NoError(t, err)
+
+	ear, err := ar.Sign(key)
+	require.NoError(t, err)
+
+	mc := mock_deps.NewMockIVeraisonClient(ctrl)
+
+	mc.EXPECT().SetNonce(testNonce)
+	mc.EXPECT().SetSessionURI(testSessionURI)
+	mc.EXPECT().SetEvidenceBuilder(gomock.Any())
+	mc.EXPECT().SetIsInsecure(false)
+	mc.EXPECT().SetCerts([]string{})
+	mc.EXPECT().SetDeleteSession(true)
+	mc.EXPECT().Run().Return(ear, nil)
+
+	fs := afero.NewMemMapFs()
+
+	err = afero.WriteFile(fs, "ccatoken.cbor", testValidCCAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidIAKPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewRelyingPartyCmd(fs, mc)
+	cmd.SetArgs(
+		[]string{
+			"--api-server=" + testSessionURI,
+			"--token=ccatoken.cbor",
+			"--ear-key=ear.jwk",
+		},
+	)
+
+	expectedErr := `unexpected submod "PSA_IOT" in the attestation result (expecting CCA_SSD_PLATFORM or CCA_REALM)`
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
 func Test_RelyingPartyCmd_protocol_run_failed(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -0,0 +1,53 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package cca
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/veraison/apiclient/verification"
+	mock_deps "github.com/veraison/evcli/v2/cmd/mocks"
+	"github.com/veraison/evcli/v2/cmd/psa"
+	"github.com/veraison/evcli/v2/common"
+)
+
+// testEARIssuedAt is the time testValidEAR was issued at
+var testEARIssuedAt = time.Unix(1727701200, 0)
+
+// setVerifyAsClock makes the freshness of the attestation results be checked
+// against the supplied time for the duration of the test
+func setVerifyAsClock(t *testing.T, now time.Time) {
+	verifyAsClock = func() time.Time { return now }
+	t.Cleanup(func() { verifyAsClock = time.Now })
+}
+
+// expectAttesterSession makes the mock client build the evidence for the
+// supplied nonce, as the Veraison API client does, and return the supplied
+// attestation result
+func expectAttesterSession(mc *mock_deps.MockIVeraisonClient, nonce, ear []byte) {
+	var eb verification.EvidenceBuilder
+
+	mc.EXPECT().SetEvidenceBuilder(gomock.Any()).DoAndReturn(
+		func(b verification.EvidenceBuilder) error {
+			eb = b
+			return nil
+		},
+	)
+
+	mc.EXPECT().Run().DoAndReturn(func() ([]byte, error) {
+		if _, _, err := eb.BuildEvidence(nonce, []string{CCATokenMediaType}); err != nil {
+			return nil, err
+		}
+		return ear, nil
+	})
+}
+
+// buildPSAVerifyAsCmds builds the PSA verify-as commands, as the evcli binary
+// does, so that tests can check that the CCA ones do not pick up their options
+func buildPSAVerifyAsCmds() {
+	psa.NewRelyingPartyCmd(common.Fs, &verification.ChallengeResponseConfig{})
+	psa.NewAttesterCmd(common.Fs, &verification.ChallengeResponseConfig{})
+}
@@ -0,0 +1,30 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdValidArgs = []string{"generate", "convert", "inspect"}
+
+var Cmd = &cobra.Command{
+	Use:   "keys",
+	Short: "attestation key management",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Help() // nolint: errcheck
+			os.Exit(0)
+		}
+	},
+	ValidArgs: cmdValidArgs,
+}
+
+func init() {
+	Cmd.AddCommand(generateCmd)
+	Cmd.AddCommand(convertCmd)
+	Cmd.AddCommand(inspectCmd)
+}
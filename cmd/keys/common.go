@@ -0,0 +1,64 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	"github.com/veraison/evcli/v2/common"
+)
+
+func loadKeyFromFile(fs afero.Fs, fn, format string) (jwk.Key, error) {
+	raw, format, err := common.LoadKeyFile(fs, fn, format, "key")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := common.ParseKey(raw, format)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding key from %s: %w", fn, err)
+	}
+
+	return key, nil
+}
+
+// writeKey saves key to fn in the supplied format.  If encrypt is set, the key
+// is encrypted with a passphrase obtained by common.ReadKeyPassphrase.
+func writeKey(fs afero.Fs, fn string, key jwk.Key, format string, perm os.FileMode, encrypt bool) error {
+	var (
+		data []byte
+		err  error
+	)
+
+	if encrypt {
+		passphrase, err := common.ReadKeyPassphrase("key "+fn, true)
+		if err != nil {
+			return fmt.Errorf("error encrypting key for %s: %w", fn, err)
+		}
+
+		if data, err = common.EncryptKey(key, format, passphrase); err != nil {
+			return fmt.Errorf("error encrypting key for %s: %w", fn, err)
+		}
+	} else if data, err = common.EncodeKey(key, format); err != nil {
+		return fmt.Errorf("error encoding key for %s: %w", fn, err)
+	}
+
+	if err = afero.WriteFile(fs, fn, data, perm); err != nil {
+		return fmt.Errorf("error saving key to file %s: %w", fn, err)
+	}
+
+	return nil
+}
+
+// pubKeyFileName derives the name of the public key file from that of the
+// private key, e.g., iak.jwk -> iak-pub.jwk
+func pubKeyFileName(fn string) string {
+	ext := filepath.Ext(fn)
+	return strings.TrimSuffix(fn, ext) + "-pub" + ext
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/veraison/evcli/v2/common"
+)
+
+var (
+	convertKeyFile   *string
+	convertKeyFormat *string
+	convertOutFile   *string
+	convertOutFormat *string
+	convertPublic    *bool
+	convertEncrypt   *bool
+)
+
+var convertCmd = NewConvertCmd(common.Fs)
+
+func NewConvertCmd(fs afero.Fs) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "convert",
+		Short: "convert a key between the JWK, PEM, DER and COSE_Key formats",
+		Long: `Convert a key between the JWK, PEM, DER and COSE_Key formats.  The input
+format is detected automatically unless --key-format is given.  Private keys
+are written as PKCS#8 in the PEM and DER formats, and public keys as
+SubjectPublicKeyInfo.
+
+Convert the PEM private key in iak.pem to a JWK:
+
+	evcli keys convert --key=iak.pem --out=iak.jwk --out-format=jwk
+
+Extract the public part of rak.jwk as a COSE_Key:
+
+	evcli keys convert -k rak.jwk -o rak-pub.cbor -f cose --public
+
+Encrypt iak.pem with a passphrase, read from file descriptor 3:
+
+	EVCLI_KEY_PASSPHRASE_FD=3 evcli keys convert -k iak.pem -o iak-enc.pem -f pem --encrypt 3<pass.txt
+
+Encrypted keys (JWE-encrypted JWKs, encrypted PKCS#8 private keys) are
+decrypted when read, here and wherever a key file is expected.  The passphrase
+is taken from EVCLI_KEY_PASSPHRASE, read from the file descriptor in
+EVCLI_KEY_PASSPHRASE_FD (one line per key), or prompted for on the terminal.
+	`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := loadKeyFromFile(fs, *convertKeyFile, *convertKeyFormat)
+			if err != nil {
+				return err
+			}
+
+			if *convertPublic {
+				if key, err = jwk.PublicKeyOf(key); err != nil {
+					return fmt.Errorf("public key extraction failed: %w", err)
+				}
+			}
+
+			perm := os.FileMode(0644)
+			if isPrivate, _ := jwk.IsPrivateKey(key); isPrivate {
+				perm = 0600
+			}
+
+			if err = writeKey(fs, *convertOutFile, key, *convertOutFormat, perm, *convertEncrypt); err != nil {
+				return err
+			}
+
+			fmt.Printf(">> %q successfully created\n", *convertOutFile)
+
+			return nil
+		},
+	}
+
+	convertKeyFile = cmd.Flags().StringP(
+		"key", "k", "", "file with the key to convert",
+	)
+
+	convertKeyFormat = cmd.Flags().String(
+		"key-format", common.KeyFormatAuto, common.KeyFormatFlagUsage,
+	)
+
+	convertOutFile = cmd.Flags().StringP(
+		"out", "o", "", "name of the file where the converted key will be stored",
+	)
+
+	convertOutFormat = cmd.Flags().StringP(
+		"out-format", "f", common.KeyFormatJWK, "format of the converted key: jwk, pem, der or cose",
+	)
+
+	convertPublic = cmd.Flags().BoolP(
+		"public", "P", false, "only output the public part of the key",
+	)
+
+	convertEncrypt = cmd.Flags().Bool(
+		"encrypt", false, "encrypt the private key with a passphrase: as a JWE for jwk, "+
+			"as an encrypted PKCS#8 private key for pem and der",
+	)
+
+	return cmd
+}
+
+func init() {
+	if err := convertCmd.MarkFlagRequired("key"); err != nil {
+		panic(err)
+	}
+	if err := convertCmd.MarkFlagRequired("out"); err != nil {
+		panic(err)
+	}
+}
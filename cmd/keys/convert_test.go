@@ -0,0 +1,137 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veraison/evcli/v2/common"
+)
+
+func Test_ConvertCmd_roundtrip(t *testing.T) {
+	for _, format := range []string{"pem", "der", "cose"} {
+		fs := afero.NewMemMapFs()
+
+		err := afero.WriteFile(fs, "rak.jwk", testRAK, 0644)
+		require.NoError(t, err)
+
+		cmd := NewConvertCmd(fs)
+		cmd.SetArgs([]string{"--key=rak.jwk", "--out=rak.out", "--out-format=" + format})
+		require.NoError(t, cmd.Execute(), format)
+
+		cmd = NewConvertCmd(fs)
+		cmd.SetArgs([]string{"--key=rak.out", "--out=rak.jwk", "--key-format=" + format})
+		require.NoError(t, cmd.Execute(), format)
+
+		expected, err := jwk.ParseKey(testRAK)
+		require.NoError(t, err)
+
+		actual, err := jwk.ParseKey(mustReadFile(t, fs, "rak.jwk"))
+		require.NoError(t, err, format)
+
+		isPrivate, _ := jwk.IsPrivateKey(actual)
+		assert.True(t, isPrivate, format)
+
+		expectedTP, err := expected.Thumbprint(crypto.SHA256)
+		require.NoError(t, err)
+
+		actualTP, err := actual.Thumbprint(crypto.SHA256)
+		require.NoError(t, err, format)
+
+		assert.Equal(t, expectedTP, actualTP, format)
+	}
+}
+
+func Test_ConvertCmd_public_COSE_Key(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "rak.jwk", bytes.Replace(testRAK, []byte(`"kid": "valid-rak",`), nil, 1), 0644)
+	require.NoError(t, err)
+
+	cmd := NewConvertCmd(fs)
+	cmd.SetArgs([]string{"-k", "rak.jwk", "-o", "rak-pub.cbor", "-f", "cose", "--public"})
+	require.NoError(t, cmd.Execute())
+
+	expected, err := base64.StdEncoding.DecodeString(testRAKPubCOSEKey)
+	require.NoError(t, err)
+
+	assert.Equal(t, expected, mustReadFile(t, fs, "rak-pub.cbor"))
+}
+
+func Test_ConvertCmd_bad_out_format(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "rak.jwk", testRAK, 0644)
+	require.NoError(t, err)
+
+	cmd := NewConvertCmd(fs)
+	cmd.SetArgs([]string{"-k", "rak.jwk", "-o", "rak.p12", "-f", "pkcs12"})
+
+	expectedErr := `error encoding key for rak.p12: unknown output key format "pkcs12": allowed formats are jwk, pem, der and cose`
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
+func Test_ConvertCmd_encrypt_roundtrip(t *testing.T) {
+	t.Setenv(common.KeyPassphraseEnv, "s3cr3t")
+
+	for _, format := range []string{"jwk", "pem", "der"} {
+		fs := afero.NewMemMapFs()
+
+		err := afero.WriteFile(fs, "rak.jwk", testRAK, 0644)
+		require.NoError(t, err)
+
+		cmd := NewConvertCmd(fs)
+		cmd.SetArgs([]string{"--key=rak.jwk", "--out=rak.enc", "--out-format=" + format, "--encrypt"})
+		require.NoError(t, cmd.Execute(), format)
+
+		assert.True(t, common.IsEncryptedKey(mustReadFile(t, fs, "rak.enc")), format)
+
+		// decrypted on the way in
+		cmd = NewConvertCmd(fs)
+		cmd.SetArgs([]string{"--key=rak.enc", "--out=rak-pub.cbor", "--out-format=cose", "--public"})
+		require.NoError(t, cmd.Execute(), format)
+
+		expected, err := base64.StdEncoding.DecodeString(testRAKPubCOSEKey)
+		require.NoError(t, err)
+
+		actual, err := common.ParseKey(mustReadFile(t, fs, "rak-pub.cbor"), common.KeyFormatCOSE)
+		require.NoError(t, err, format)
+
+		expectedKey, err := common.ParseKey(expected, common.KeyFormatCOSE)
+		require.NoError(t, err)
+
+		expectedTP, err := expectedKey.Thumbprint(crypto.SHA256)
+		require.NoError(t, err)
+
+		actualTP, err := actual.Thumbprint(crypto.SHA256)
+		require.NoError(t, err, format)
+
+		assert.Equal(t, expectedTP, actualTP, format)
+	}
+}
+
+func Test_ConvertCmd_encrypt_COSE_Key(t *testing.T) {
+	t.Setenv(common.KeyPassphraseEnv, "s3cr3t")
+
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "rak.jwk", testRAK, 0644)
+	require.NoError(t, err)
+
+	cmd := NewConvertCmd(fs)
+	cmd.SetArgs([]string{"-k", "rak.jwk", "-o", "rak.cbor", "-f", "cose", "--encrypt"})
+
+	err = cmd.Execute()
+	assert.EqualError(t, err,
+		`error encrypting key for rak.cbor: key format "cose" does not support encryption: use jwk, pem or der`)
+}
@@ -0,0 +1,156 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/veraison/evcli/v2/common"
+)
+
+var (
+	generateAlg     *string
+	generateKeyFile *string
+	generatePubFile *string
+	generateKid     *string
+	generateEncrypt *bool
+)
+
+var generateCmd = NewGenerateCmd(common.Fs)
+
+func NewGenerateCmd(fs afero.Fs) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "generate an attestation key pair",
+		Long: `Generate a private key for the supplied signature algorithm and save it,
+together with its public part, as a pair of JWKs.  The "alg" member of both
+JWKs is set to the chosen algorithm.
+
+Generate a P-256 key in iak.jwk, and the corresponding public key in
+iak-pub.jwk:
+
+	evcli keys generate --alg=ES256 --key=iak.jwk
+
+Generate a P-384 key with key ID "rak-1", saving the public key to rak.pub:
+
+	evcli keys generate -a ES384 -k rak.jwk -p rak.pub --kid=rak-1
+
+Generate a P-256 key in iak.jwk, encrypted with a passphrase that is prompted
+for, or taken from the EVCLI_KEY_PASSPHRASE environment variable:
+
+	evcli keys generate -k iak.jwk --encrypt
+
+Supported algorithms are ES256, ES384, ES512, EdDSA (Ed25519), PS256, PS384
+and PS512.  RSA keys are 2048, 3072 and 4096 bits long for PS256, PS384 and
+PS512 respectively.
+	`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sk, err := generateKey(*generateAlg)
+			if err != nil {
+				return err
+			}
+
+			key, err := jwk.FromRaw(sk)
+			if err != nil {
+				return fmt.Errorf("JWK creation failed: %w", err)
+			}
+
+			if err = key.Set(jwk.AlgorithmKey, jwa.SignatureAlgorithm(*generateAlg)); err != nil {
+				return err
+			}
+
+			if *generateKid != "" {
+				if err = key.Set(jwk.KeyIDKey, *generateKid); err != nil {
+					return err
+				}
+			}
+
+			pub, err := key.PublicKey()
+			if err != nil {
+				return fmt.Errorf("public key extraction failed: %w", err)
+			}
+
+			pubFile := *generatePubFile
+			if pubFile == "" {
+				pubFile = pubKeyFileName(*generateKeyFile)
+			}
+
+			if err = writeKey(fs, *generateKeyFile, key, common.KeyFormatJWK, 0600, *generateEncrypt); err != nil {
+				return err
+			}
+
+			if err = writeKey(fs, pubFile, pub, common.KeyFormatJWK, 0644, false); err != nil {
+				return err
+			}
+
+			fmt.Printf(">> %q and %q successfully created\n", *generateKeyFile, pubFile)
+
+			return nil
+		},
+	}
+
+	generateAlg = cmd.Flags().StringP(
+		"alg", "a", "ES256", "signature algorithm the key is for: ES256, ES384, ES512, EdDSA, PS256, PS384 or PS512",
+	)
+
+	generateKeyFile = cmd.Flags().StringP(
+		"key", "k", "", "name of the file where the private JWK will be stored",
+	)
+
+	generatePubFile = cmd.Flags().StringP(
+		"pub", "p", "", "name of the file where the public JWK will be stored.  "+
+			"Default is to add a -pub suffix to the private key file name",
+	)
+
+	generateKid = cmd.Flags().String(
+		"kid", "", "key ID to set in the JWKs",
+	)
+
+	generateEncrypt = cmd.Flags().Bool(
+		"encrypt", false, "encrypt the private JWK with a passphrase (JWE)",
+	)
+
+	return cmd
+}
+
+func generateKey(alg string) (crypto.Signer, error) {
+	switch jwa.SignatureAlgorithm(alg) {
+	case jwa.ES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwa.ES384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case jwa.ES512:
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case jwa.EdDSA:
+		_, sk, err := ed25519.GenerateKey(rand.Reader)
+		return sk, err
+	case jwa.PS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case jwa.PS384:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case jwa.PS512:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	}
+
+	return nil, fmt.Errorf(
+		"unsupported algorithm %s: allowed algorithms are ES256, ES384, ES512, EdDSA, PS256, PS384 and PS512",
+		alg,
+	)
+}
+
+func init() {
+	if err := generateCmd.MarkFlagRequired("key"); err != nil {
+		panic(err)
+	}
+}
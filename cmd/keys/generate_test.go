@@ -0,0 +1,103 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veraison/evcli/v2/common"
+)
+
+func Test_GenerateCmd_ok(t *testing.T) {
+	for _, alg := range []string{"ES256", "ES384", "ES512", "EdDSA", "PS256"} {
+		fs := afero.NewMemMapFs()
+
+		cmd := NewGenerateCmd(fs)
+		cmd.SetArgs(
+			[]string{
+				"--alg=" + alg,
+				"--key=key.jwk",
+				"--kid=my-key",
+			},
+		)
+
+		err := cmd.Execute()
+		require.NoError(t, err, alg)
+
+		priv, err := afero.ReadFile(fs, "key.jwk")
+		require.NoError(t, err, alg)
+
+		pub, err := afero.ReadFile(fs, "key-pub.jwk")
+		require.NoError(t, err, alg)
+
+		signer, err := common.SignerFromJWK(priv)
+		require.NoError(t, err, alg)
+		assert.Equal(t, alg, signer.Algorithm().String(), alg)
+
+		key, err := jwk.ParseKey(pub)
+		require.NoError(t, err, alg)
+
+		isPrivate, _ := jwk.IsPrivateKey(key)
+		assert.False(t, isPrivate, alg)
+		assert.Equal(t, "my-key", key.KeyID(), alg)
+	}
+}
+
+func Test_GenerateCmd_explicit_pub_file(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	cmd := NewGenerateCmd(fs)
+	cmd.SetArgs([]string{"-k", "iak.jwk", "-p", "iak.pub"})
+
+	err := cmd.Execute()
+	require.NoError(t, err)
+
+	_, err = common.PubKeyFromJWK(mustReadFile(t, fs, "iak.pub"))
+	assert.NoError(t, err)
+}
+
+func Test_GenerateCmd_unsupported_alg(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	cmd := NewGenerateCmd(fs)
+	cmd.SetArgs([]string{"--alg=RS256", "--key=key.jwk"})
+
+	expectedErr := `unsupported algorithm RS256: allowed algorithms are ES256, ES384, ES512, EdDSA, PS256, PS384 and PS512`
+
+	err := cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
+func mustReadFile(t *testing.T, fs afero.Fs, fn string) []byte {
+	data, err := afero.ReadFile(fs, fn)
+	require.NoError(t, err)
+	return data
+}
+
+func Test_GenerateCmd_encrypt(t *testing.T) {
+	t.Setenv(common.KeyPassphraseEnv, "s3cr3t")
+
+	fs := afero.NewMemMapFs()
+
+	cmd := NewGenerateCmd(fs)
+	cmd.SetArgs([]string{"-k", "iak.jwk", "-a", "ES384", "--encrypt"})
+
+	err := cmd.Execute()
+	require.NoError(t, err)
+
+	raw := mustReadFile(t, fs, "iak.jwk")
+	assert.True(t, common.IsEncryptedKey(raw))
+
+	sk, err := common.LoadSigningKey(fs, "iak.jwk", common.KeyFormatAuto, "IAK signing key")
+	require.NoError(t, err)
+	assert.Equal(t, "ES384", sk.Algorithm().String())
+
+	// the public key is not encrypted
+	_, err = common.PubKeyFromJWK(mustReadFile(t, fs, "iak-pub.jwk"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,132 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/veraison/evcli/v2/common"
+)
+
+var (
+	inspectKeyFile   *string
+	inspectKeyFormat *string
+)
+
+var inspectCmd = NewInspectCmd(common.Fs)
+
+func NewInspectCmd(fs afero.Fs) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "inspect",
+		Short: "print information about an attestation key",
+		Long: `Print the type, curve and size of the supplied key, together with the
+values derived from its public part:
+
+ * the JWK thumbprint (RFC 7638, SHA-256)
+ * the PSA / CCA instance ID, i.e., 0x01 followed by the SHA-256 hash of
+   the public key
+ * the COSE_Key encoding, as used in the CCA cca-realm-public-key claim
+
+Binary values are printed in standard base64, as expected in the claims JSON.
+
+	evcli keys inspect --key=rak.jwk
+	`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := loadKeyFromFile(fs, *inspectKeyFile, *inspectKeyFormat)
+			if err != nil {
+				return err
+			}
+
+			return inspectKey(os.Stdout, key)
+		},
+	}
+
+	inspectKeyFile = cmd.Flags().StringP(
+		"key", "k", "", "file with the key to inspect",
+	)
+
+	inspectKeyFormat = cmd.Flags().String(
+		"key-format", common.KeyFormatAuto, common.KeyFormatFlagUsage,
+	)
+
+	return cmd
+}
+
+func inspectKey(w io.Writer, key jwk.Key) error {
+	pub, err := jwk.PublicKeyOf(key)
+	if err != nil {
+		return fmt.Errorf("public key extraction failed: %w", err)
+	}
+
+	rawPub, err := common.RawPublicKey(key)
+	if err != nil {
+		return err
+	}
+
+	tp, err := pub.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("thumbprint computation failed: %w", err)
+	}
+
+	instID, err := common.InstanceIDFromKey(key)
+	if err != nil {
+		return err
+	}
+
+	// the realm public key claim carries a bare COSE_Key
+	bare, err := jwk.FromRaw(rawPub)
+	if err != nil {
+		return err
+	}
+
+	coseKey, err := common.COSEKeyFromJWK(bare)
+	if err != nil {
+		return err
+	}
+
+	isPrivate, _ := jwk.IsPrivateKey(key)
+
+	fmt.Fprintf(w, ">> key type: %s\n", key.KeyType())
+
+	switch k := rawPub.(type) {
+	case *ecdsa.PublicKey:
+		fmt.Fprintf(w, ">> curve: %s\n", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		fmt.Fprintln(w, ">> curve: Ed25519")
+	case *rsa.PublicKey:
+		fmt.Fprintf(w, ">> size: %d bits\n", k.N.BitLen())
+	}
+
+	fmt.Fprintf(w, ">> private: %t\n", isPrivate)
+
+	if kid := key.KeyID(); kid != "" {
+		fmt.Fprintf(w, ">> key ID: %s\n", kid)
+	}
+
+	if alg := key.Algorithm(); alg != nil && alg.String() != "" {
+		fmt.Fprintf(w, ">> algorithm: %s\n", alg)
+	}
+
+	fmt.Fprintf(w, ">> JWK thumbprint (SHA-256): %s\n", base64.RawURLEncoding.EncodeToString(tp))
+	fmt.Fprintf(w, ">> instance ID: %s\n", base64.StdEncoding.EncodeToString(instID))
+	fmt.Fprintf(w, ">> COSE_Key: %s\n", base64.StdEncoding.EncodeToString(coseKey))
+
+	return nil
+}
+
+func init() {
+	if err := inspectCmd.MarkFlagRequired("key"); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_inspectKey(t *testing.T) {
+	key, err := jwk.ParseKey(testRAK)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+
+	err = inspectKey(&buf, key)
+	require.NoError(t, err)
+
+	expected := `>> key type: EC
+>> curve: P-384
+>> private: true
+>> key ID: valid-rak
+>> JWK thumbprint (SHA-256): ` + testRAKThumbprint + `
+>> instance ID: ` + testRAKInstanceID + `
+>> COSE_Key: ` + testRAKPubCOSEKey + `
+`
+
+	assert.Equal(t, expected, buf.String())
+}
+
+func Test_InspectCmd_key_not_found(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	cmd := NewInspectCmd(fs)
+	cmd.SetArgs([]string{"--key=rak.jwk"})
+
+	expectedErr := `error loading key from rak.jwk: open rak.jwk: file does not exist`
+
+	err := cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
@@ -0,0 +1,20 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package keys
+
+var (
+	testRAK = []byte(`{
+		"kid": "valid-rak",
+		"kty": "EC",
+		"crv": "P-384",
+		"x": "gvvRMqm1w5aHn7sVNA2QUJeOVcedUnmiug6VhU834gzS9k87crVwu9dz7uLOdoQl",
+		"y": "7fVF7b6J_6_g6Wu9RuJw8geWxEi5ja9Gp2TSdELm5u2E-M7IF-bsxqcdOj3n1n7N",
+		"d": "ODkwMTIzNDU2Nzg5MDEyMz7deMbyLt8g4cjcxozuIoygLLlAeoQ1AfM9TSvxkFHJ"
+	}`)
+	// COSE_Key of the public part of testRAK, as found in misc/ec384-pub.diag
+	testRAKPubCOSEKey = "pAECIAIhWDCC+9EyqbXDloefuxU0DZBQl45Vx51SeaK6DpWFTzfiDNL2TztytXC713Pu4s52hCUiWDDt9UXtvon/r+Dpa71G4nDyB5bESLmNr0anZNJ0Qubm7YT4zsgX5uzGpx06PefWfs0="
+	// RFC 7638 thumbprint and PSA/CCA instance ID of testRAK
+	testRAKThumbprint = "AuAA4nCiqRdphuNkHnwf9ig7iSUUc11J4cArghmdy9w"
+	testRAKInstanceID = "AbROCAqRYNc1g9kvQIL++1iXK2xjbEfKdR7R8IBpxJYq"
+)
@@ -0,0 +1,28 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdValidArgs = []string{"test"}
+
+var Cmd = &cobra.Command{
+	Use:   "policy",
+	Short: "appraisal policy development",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.Help() // nolint: errcheck
+			os.Exit(0)
+		}
+	},
+	ValidArgs: cmdValidArgs,
+}
+
+func init() {
+	Cmd.AddCommand(testCmd)
+}
@@ -0,0 +1,84 @@
+# Copyright 2024 Contributors to the Veraison project.
+# SPDX-License-Identifier: Apache-2.0
+#
+# Preamble evaluated together with the policy under test by "evcli policy
+# test".  As in the Veraison policy engine, policies set the trustworthiness
+# vector entries and, optionally, the status; an entry left undefined (null)
+# keeps the value set by the attestation scheme.
+
+package policy
+
+# AR4SI trustworthiness claim values
+
+VERIFIER_MALFUNCTION := -1
+NO_CLAIM := 0
+UNEXPECTED_EVIDENCE := 1
+CRYPTO_VALIDATION_FAILED := 99
+
+TRUSTWORTHY_INSTANCE := 2
+UNTRUSTWORTHY_INSTANCE := 96
+UNRECOGNIZED_INSTANCE := 97
+
+APPROVED_CONFIG := 2
+NO_CONFIG_VULNS := 3
+UNSAFE_CONFIG := 32
+UNSUPPORTABLE_CONFIG := 96
+
+APPROVED_RT := 2
+APPROVED_BOOT := 3
+UNSAFE_RT := 32
+UNRECOGNIZED_RT := 33
+CONTRAINDICATED_RT := 96
+
+APPROVED_FILES := 2
+UNRECOGNIZED_FILES := 32
+CONTRAINDICATED_FILES := 96
+
+GENUINE_HARDWARE := 2
+UNSAFE_HARDWARE := 32
+CONTRAINDICATED_HARDWARE := 96
+UNRECOGNIZED_HARDWARE := 97
+
+ENCRYPTED_MEMORY_RUNTIME := 2
+ISOLATED_MEMORY_RUNTIME := 32
+VISIBLE_MEMORY_RUNTIME := 96
+
+HW_KEYS_ENCRYPTED_SECRETS := 2
+SW_KEYS_ENCRYPTED_SECRETS := 32
+UNENCRYPTED_SECRETS := 96
+
+TRUSTED_SOURCES := 2
+UNTRUSTED_SOURCES := 32
+CONTRAINDICATED_SOURCES := 96
+
+# AR4SI trust tiers
+
+NONE := "none"
+AFFIRMING := "affirming"
+WARNING := "warning"
+CONTRAINDICATED := "contraindicated"
+
+default status := null
+
+default instance_identity := null
+default configuration := null
+default executables := null
+default file_system := null
+default hardware := null
+default runtime_opaque := null
+default storage_opaque := null
+default sourced_data := null
+
+outcome := {
+	"ear.status": status,
+	"ear.trustworthiness-vector": {
+		"instance-identity": instance_identity,
+		"configuration": configuration,
+		"executables": executables,
+		"file-system": file_system,
+		"hardware": hardware,
+		"runtime-opaque": runtime_opaque,
+		"storage-opaque": storage_opaque,
+		"sourced-data": sourced_data
+	}
+}
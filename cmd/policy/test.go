@@ -0,0 +1,372 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/veraison/ccatoken"
+	"github.com/veraison/evcli/v2/common"
+	"github.com/veraison/psatoken"
+)
+
+// preamble defines the AR4SI constants and the "outcome" rule the policy under
+// test contributes to
+//
+//go:embed preamble.rego
+var preamble []byte
+
+// the rule evaluated by opa
+const outcomeQuery = "data.policy.outcome"
+
+var (
+	testPolicyFile *string
+	testTokenFile  *string
+	testScheme     *string
+	testOPA        *string
+	testOPAArgs    *[]string
+)
+
+var testCmd = NewTestCmd(common.Fs)
+
+func NewTestCmd(fs afero.Fs) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "test",
+		Short: "evaluate an appraisal policy against a PSA or CCA attestation token",
+		Long: `Evaluate an OPA Rego appraisal policy against the claims in the supplied
+attestation token, and print the changes the policy makes to the appraisal.
+
+	evcli policy test --policy=my.rego --token=psatoken.cbor --scheme=psa
+
+The policy is evaluated with the "opa" executable, which must be in PATH or
+supplied with --opa.  As with the Veraison policy engine, the policy is in
+package "policy", sets the trustworthiness vector entries (instance_identity,
+configuration, executables, file_system, hardware, runtime_opaque,
+storage_opaque, sourced_data) and, optionally, the status.  The AR4SI claim values are available as constants,
+e.g., APPROVED_RT or UNRECOGNIZED_RT.  The input document is:
+
+	{
+	  "scheme": "PSA_IOT",
+	  "evidence": { <the claims, as printed by "evcli psa print"> },
+	  "endorsements": [],
+	  "result": { <the appraisal, as found in an EAR submod> }
+	}
+
+The token signature is not verified: the appraisal the policy starts from is
+that of a token whose signature has been verified.  The claims of CCA tokens are
+evaluated twice, once as CCA_SSD_PLATFORM with the platform claims, and once as
+CCA_REALM with the realm claims.
+
+Policies in the pre-1.0 Rego syntax can be evaluated with OPA 1.0 or later by
+adding --opa-arg=--v0-compatible.
+	`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			policy, err := afero.ReadFile(fs, *testPolicyFile)
+			if err != nil {
+				return fmt.Errorf("error loading policy from %s: %w", *testPolicyFile, err)
+			}
+
+			token, err := afero.ReadFile(fs, *testTokenFile)
+			if err != nil {
+				return fmt.Errorf("error loading token from %s: %w", *testTokenFile, err)
+			}
+
+			inputs, err := policyInputs(*testScheme, token)
+			if err != nil {
+				return err
+			}
+
+			dir, err := writePolicyModules(fs, policy)
+			if err != nil {
+				return err
+			}
+			defer fs.RemoveAll(dir) // nolint: errcheck
+
+			for _, in := range inputs {
+				outcome, err := evalPolicy(*testOPA, *testOPAArgs, dir, in)
+				if err != nil {
+					return fmt.Errorf("evaluating %s against %s: %w", *testPolicyFile, in.Scheme, err)
+				}
+
+				if err := reportPolicyOutcome(os.Stdout, in, outcome); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	}
+
+	testPolicyFile = cmd.Flags().StringP(
+		"policy", "p", "", "file with the Rego appraisal policy",
+	)
+
+	testTokenFile = cmd.Flags().StringP(
+		"token", "t", "", "CBOR file containing the attestation token",
+	)
+
+	testScheme = cmd.Flags().StringP(
+		"scheme", "s", "", "attestation scheme of the token: psa or cca",
+	)
+
+	testOPA = cmd.Flags().String(
+		"opa", "opa", "the opa executable, looked up in PATH unless a path is given",
+	)
+
+	testOPAArgs = cmd.Flags().StringArray(
+		"opa-arg", nil, `additional argument to "opa eval", may be repeated`,
+	)
+
+	return cmd
+}
+
+// policyInput is the input document of the policy, as built by the Veraison
+// policy engine
+type policyInput struct {
+	Scheme       string           `json:"scheme"`
+	Evidence     map[string]any   `json:"evidence"`
+	Endorsements []any            `json:"endorsements"`
+	Result       common.Appraisal `json:"result"`
+}
+
+// policyInputs decodes the token and returns one input document per submod of
+// the scheme
+func policyInputs(scheme string, token []byte) ([]policyInput, error) {
+	switch scheme {
+	case "psa":
+		e, err := psatoken.DecodeAndValidateEvidenceFromCOSE(token)
+		if err != nil {
+			return nil, fmt.Errorf("decoding PSA token: %w", err)
+		}
+
+		in, err := newPolicyInput(common.SubmodPSA, e.Claims)
+		if err != nil {
+			return nil, err
+		}
+
+		return []policyInput{in}, nil
+	case "cca":
+		e, err := ccatoken.DecodeAndValidateEvidenceFromCBOR(token)
+		if err != nil {
+			return nil, fmt.Errorf("decoding CCA token: %w", err)
+		}
+
+		p, err := newPolicyInput(common.SubmodCCAPlatform, e.PlatformClaims)
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := newPolicyInput(common.SubmodCCARealm, e.RealmClaims)
+		if err != nil {
+			return nil, err
+		}
+
+		return []policyInput{p, r}, nil
+	}
+
+	return nil, fmt.Errorf("unknown scheme %q: allowed values are psa and cca", scheme)
+}
+
+func newPolicyInput(submod string, claims any) (policyInput, error) {
+	in := policyInput{Scheme: submod, Endorsements: []any{}}
+
+	j, err := json.Marshal(claims)
+	if err != nil {
+		return in, fmt.Errorf("serializing %s claims: %w", submod, err)
+	}
+
+	if err := json.Unmarshal(j, &in.Evidence); err != nil {
+		return in, fmt.Errorf("serializing %s claims: %w", submod, err)
+	}
+
+	ar := common.NewLocalAttestationResult(nil, nil)
+	ar.AddSubmod(submod, common.SignatureTrustVector(nil))
+	in.Result = ar.Submods[submod]
+
+	return in, nil
+}
+
+// writePolicyModules saves the preamble and the policy to a fresh temporary
+// directory, for opa to load
+func writePolicyModules(fs afero.Fs, policy []byte) (string, error) {
+	dir, err := afero.TempDir(fs, "", "evcli-policy-")
+	if err != nil {
+		return "", fmt.Errorf("error creating policy directory: %w", err)
+	}
+
+	for fn, data := range map[string][]byte{
+		"preamble.rego": preamble,
+		"policy.rego":   policy,
+	} {
+		if err := afero.WriteFile(fs, filepath.Join(dir, fn), data, 0600); err != nil {
+			fs.RemoveAll(dir) // nolint: errcheck
+			return "", fmt.Errorf("error saving policy module %s: %w", fn, err)
+		}
+	}
+
+	return dir, nil
+}
+
+// opaOutput is the part of the "opa eval --format=json" output we need
+type opaOutput struct {
+	Result []struct {
+		Expressions []struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"expressions"`
+	} `json:"result"`
+	Errors []struct {
+		Message  string `json:"message"`
+		Location *struct {
+			File string `json:"file"`
+			Row  int    `json:"row"`
+		} `json:"location"`
+	} `json:"errors"`
+}
+
+func (o opaOutput) errorMessages() string {
+	msgs := make([]string, 0, len(o.Errors))
+
+	for _, e := range o.Errors {
+		if e.Location != nil {
+			msgs = append(msgs, fmt.Sprintf(
+				"%s:%d: %s", filepath.Base(e.Location.File), e.Location.Row, e.Message,
+			))
+		} else {
+			msgs = append(msgs, e.Message)
+		}
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// policyOutcome is the value of the "outcome" rule of the preamble.  Null
+// entries are left unchanged by the policy.
+type policyOutcome struct {
+	Status      *common.TrustTier             `json:"ear.status"`
+	TrustVector map[string]*common.TrustClaim `json:"ear.trustworthiness-vector"`
+}
+
+func evalPolicy(opa string, opaArgs []string, dir string, in policyInput) (*policyOutcome, error) {
+	input, err := json.Marshal(in)
+	if err != nil {
+		return nil, fmt.Errorf("serializing policy input: %w", err)
+	}
+
+	args := []string{
+		"eval", "--format=json", "--stdin-input",
+		"--data=" + filepath.Join(dir, "preamble.rego"),
+		"--data=" + filepath.Join(dir, "policy.rego"),
+	}
+	args = append(args, opaArgs...)
+	args = append(args, outcomeQuery)
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(opa, args...) // nolint: gosec
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	runErr := cmd.Run()
+
+	var out opaOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		if runErr != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return nil, fmt.Errorf("%s: %w: %s", opa, runErr, msg)
+			}
+			return nil, fmt.Errorf("%s: %w", opa, runErr)
+		}
+		return nil, fmt.Errorf("decoding %s output: %w", opa, err)
+	}
+
+	if len(out.Errors) > 0 {
+		return nil, errors.New(out.errorMessages())
+	}
+
+	if runErr != nil {
+		return nil, fmt.Errorf("%s: %w", opa, runErr)
+	}
+
+	if len(out.Result) == 0 || len(out.Result[0].Expressions) == 0 {
+		return nil, fmt.Errorf(`%s is undefined, is the policy in package "policy"?`, outcomeQuery)
+	}
+
+	var outcome policyOutcome
+	if err := json.Unmarshal(out.Result[0].Expressions[0].Value, &outcome); err != nil {
+		return nil, fmt.Errorf("decoding policy outcome: %w", err)
+	}
+
+	return &outcome, nil
+}
+
+// reportPolicyOutcome applies the outcome to the appraisal in the input and
+// writes the resulting changes to w.  Unless set by the policy, the status is
+// the worst tier of the resulting trustworthiness vector.
+func reportPolicyOutcome(w io.Writer, in policyInput, outcome *policyOutcome) error {
+	before := *in.Result.TrustVector
+	after := before
+
+	for cn, c := range outcome.TrustVector {
+		if c == nil {
+			continue
+		}
+
+		p := after.Claim(cn)
+		if p == nil {
+			return fmt.Errorf("unknown trustworthiness vector entry %q in policy outcome", cn)
+		}
+		*p = *c
+	}
+
+	status := after.Status()
+	if outcome.Status != nil {
+		status = *outcome.Status
+	}
+
+	fmt.Fprintf(w, ">> submod %q\n", in.Scheme)
+
+	if status == in.Result.Status {
+		fmt.Fprintf(w, "   status: %s (unchanged)\n", status)
+	} else {
+		fmt.Fprintf(w, "   status: %s -> %s\n", in.Result.Status, status)
+	}
+
+	if after == before {
+		fmt.Fprintln(w, "   trust vector: unchanged")
+		return nil
+	}
+
+	fmt.Fprintln(w, "   trust vector:")
+	for _, cn := range common.TrustVectorClaimNames {
+		b, a := *before.Claim(cn), *after.Claim(cn)
+		if a == b {
+			continue
+		}
+
+		fmt.Fprintf(w, "     %-18s %s (%d) -> %s (%d): %s\n",
+			cn+":", b.Tier(), b, a.Tier(), a, a.Meaning(cn))
+	}
+
+	return nil
+}
+
+func init() {
+	for _, flag := range []string{"policy", "token", "scheme"} {
+		if err := testCmd.MarkFlagRequired(flag); err != nil {
+			panic(err)
+		}
+	}
+}
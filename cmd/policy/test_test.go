@@ -0,0 +1,436 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veraison/evcli/v2/common"
+)
+
+// When testOPAOutputEnv is set, the test binary acts as the opa executable: it
+// prints the content of that file, appends its arguments and input to the file
+// in testOPARecordEnv, and exits with the status in testOPAExitEnv, if any.
+const (
+	testOPAOutputEnv = "EVCLI_TEST_OPA_OUTPUT"
+	testOPARecordEnv = "EVCLI_TEST_OPA_RECORD"
+	testOPAExitEnv   = "EVCLI_TEST_OPA_EXIT"
+)
+
+func TestMain(m *testing.M) {
+	if fn := os.Getenv(testOPAOutputEnv); fn != "" {
+		if err := testOPAHelper(fn); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		if os.Getenv(testOPAExitEnv) != "" {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// testOPARecord is what the fake opa was invoked with
+type testOPARecord struct {
+	Args  []string    `json:"args"`
+	Input policyInput `json:"input"`
+}
+
+func testOPAHelper(fn string) error {
+	out, err := os.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	rec := struct {
+		Args  []string        `json:"args"`
+		Input json.RawMessage `json:"input"`
+	}{os.Args[1:], input}
+
+	j, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(os.Getenv(testOPARecordEnv), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintf(f, "%s\n", j); err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(out)
+
+	return err
+}
+
+// newTestOPA returns the path to the test binary acting as opa, printing the
+// supplied output, and a function returning the invocations recorded so far
+func newTestOPA(t *testing.T, output string, fail bool) (string, func() []testOPARecord) {
+	dir := t.TempDir()
+
+	out := filepath.Join(dir, "output.json")
+	require.NoError(t, os.WriteFile(out, []byte(output), 0600))
+
+	record := filepath.Join(dir, "record.json")
+
+	t.Setenv(testOPAOutputEnv, out)
+	t.Setenv(testOPARecordEnv, record)
+	if fail {
+		t.Setenv(testOPAExitEnv, "1")
+	} else {
+		t.Setenv(testOPAExitEnv, "")
+	}
+
+	exe, err := os.Executable()
+	require.NoError(t, err)
+
+	return exe, func() []testOPARecord {
+		f, err := os.Open(record)
+		require.NoError(t, err)
+		defer f.Close()
+
+		var recs []testOPARecord
+
+		s := bufio.NewScanner(f)
+		s.Buffer(nil, 1<<20)
+		for s.Scan() {
+			var rec testOPARecord
+			require.NoError(t, json.Unmarshal(s.Bytes(), &rec))
+			recs = append(recs, rec)
+		}
+		require.NoError(t, s.Err())
+
+		return recs
+	}
+}
+
+const (
+	testPolicy = `package policy
+
+executables := UNRECOGNIZED_RT
+`
+	testOPAOutcome = `{"result": [{"expressions": [{"value": {
+		"ear.status": null,
+		"ear.trustworthiness-vector": {
+			"instance-identity": null,
+			"configuration": null,
+			"executables": 33,
+			"file-system": null,
+			"hardware": null,
+			"runtime-opaque": null,
+			"storage-opaque": null,
+			"sourced-data": null
+		}
+	}, "text": "data.policy.outcome"}]}]}`
+)
+
+func writeTestFiles(t *testing.T, token []byte) afero.Fs {
+	fs := afero.NewMemMapFs()
+
+	require.NoError(t, afero.WriteFile(fs, "my.rego", []byte(testPolicy), 0644))
+	require.NoError(t, afero.WriteFile(fs, "token.cbor", token, 0644))
+
+	return fs
+}
+
+func Test_TestCmd_psa_ok(t *testing.T) {
+	opa, records := newTestOPA(t, testOPAOutcome, false)
+	fs := writeTestFiles(t, testValidP2PSAToken)
+
+	cmd := NewTestCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--policy=my.rego",
+			"--token=token.cbor",
+			"--scheme=psa",
+			"--opa=" + opa,
+			"--opa-arg=--v0-compatible",
+		},
+	)
+
+	err := cmd.Execute()
+	require.NoError(t, err)
+
+	recs := records()
+	require.Len(t, recs, 1)
+
+	args := recs[0].Args
+	require.Len(t, args, 7)
+	assert.Equal(t, []string{"eval", "--format=json", "--stdin-input"}, args[:3])
+	assert.Equal(t, []string{"--v0-compatible", "data.policy.outcome"}, args[5:])
+
+	dir := filepath.Dir(strings.TrimPrefix(args[3], "--data="))
+	assert.Equal(t, "--data="+filepath.Join(dir, "preamble.rego"), args[3])
+	assert.Equal(t, "--data="+filepath.Join(dir, "policy.rego"), args[4])
+	exists, err := afero.DirExists(fs, dir)
+	require.NoError(t, err)
+	assert.False(t, exists, "policy modules should be removed")
+
+	in := recs[0].Input
+	assert.Equal(t, common.SubmodPSA, in.Scheme)
+	assert.Equal(t, "http://arm.com/psa/2.0.0", in.Evidence["eat-profile"])
+	assert.Equal(t, "https://psa-verifier.org", in.Evidence["psa-verification-service-indicator"])
+	assert.Equal(t, []any{}, in.Endorsements)
+	assert.Equal(t, common.TrustTierAffirming, in.Result.Status)
+	assert.Equal(t, &common.TrustVector{InstanceIdentity: 2, Hardware: 2}, in.Result.TrustVector)
+}
+
+func Test_TestCmd_cca_ok(t *testing.T) {
+	opa, records := newTestOPA(t, testOPAOutcome, false)
+	fs := writeTestFiles(t, testValidCCAToken)
+
+	cmd := NewTestCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--policy=my.rego",
+			"--token=token.cbor",
+			"--scheme=cca",
+			"--opa=" + opa,
+		},
+	)
+
+	err := cmd.Execute()
+	require.NoError(t, err)
+
+	recs := records()
+	require.Len(t, recs, 2)
+
+	assert.Equal(t, common.SubmodCCAPlatform, recs[0].Input.Scheme)
+	assert.Contains(t, recs[0].Input.Evidence, "cca-platform-sw-components")
+	assert.Equal(t, common.SubmodCCARealm, recs[1].Input.Scheme)
+	assert.Contains(t, recs[1].Input.Evidence, "cca-realm-initial-measurement")
+}
+
+func Test_TestCmd_policy_error(t *testing.T) {
+	opa, _ := newTestOPA(t, `{"errors": [{
+		"message": "unexpected eof token",
+		"code": "rego_parse_error",
+		"location": {"file": "/tmp/evcli-policy-1/policy.rego", "row": 3, "col": 1}
+	}]}`, true)
+	fs := writeTestFiles(t, testValidP2PSAToken)
+
+	cmd := NewTestCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--policy=my.rego",
+			"--token=token.cbor",
+			"--scheme=psa",
+			"--opa=" + opa,
+		},
+	)
+
+	err := cmd.Execute()
+	assert.EqualError(t, err, "evaluating my.rego against PSA_IOT: policy.rego:3: unexpected eof token")
+}
+
+func Test_TestCmd_outcome_undefined(t *testing.T) {
+	opa, _ := newTestOPA(t, `{}`, false)
+	fs := writeTestFiles(t, testValidP2PSAToken)
+
+	cmd := NewTestCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--policy=my.rego",
+			"--token=token.cbor",
+			"--scheme=psa",
+			"--opa=" + opa,
+		},
+	)
+
+	err := cmd.Execute()
+	assert.EqualError(t, err, `evaluating my.rego against PSA_IOT: data.policy.outcome is undefined, is the policy in package "policy"?`)
+}
+
+func Test_TestCmd_opa_not_found(t *testing.T) {
+	fs := writeTestFiles(t, testValidP2PSAToken)
+
+	cmd := NewTestCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--policy=my.rego",
+			"--token=token.cbor",
+			"--scheme=psa",
+			"--opa=/nonexistent/opa",
+		},
+	)
+
+	err := cmd.Execute()
+	assert.EqualError(t, err, "evaluating my.rego against PSA_IOT: /nonexistent/opa: fork/exec /nonexistent/opa: no such file or directory")
+}
+
+func Test_TestCmd_unknown_scheme(t *testing.T) {
+	fs := writeTestFiles(t, testValidP2PSAToken)
+
+	cmd := NewTestCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--policy=my.rego",
+			"--token=token.cbor",
+			"--scheme=tpm",
+		},
+	)
+
+	err := cmd.Execute()
+	assert.EqualError(t, err, `unknown scheme "tpm": allowed values are psa and cca`)
+}
+
+func Test_TestCmd_policy_not_found(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	cmd := NewTestCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--policy=my.rego",
+			"--token=token.cbor",
+			"--scheme=psa",
+		},
+	)
+
+	err := cmd.Execute()
+	assert.EqualError(t, err, "error loading policy from my.rego: open my.rego: file does not exist")
+}
+
+func Test_writePolicyModules(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	dir, err := writePolicyModules(fs, []byte(testPolicy))
+	require.NoError(t, err)
+
+	data, err := afero.ReadFile(fs, filepath.Join(dir, "preamble.rego"))
+	require.NoError(t, err)
+	assert.Equal(t, preamble, data)
+
+	data, err = afero.ReadFile(fs, filepath.Join(dir, "policy.rego"))
+	require.NoError(t, err)
+	assert.Equal(t, testPolicy, string(data))
+}
+
+func Test_writePolicyModules_fail(t *testing.T) {
+	_, err := writePolicyModules(afero.NewReadOnlyFs(afero.NewMemMapFs()), []byte(testPolicy))
+	assert.ErrorContains(t, err, "error creating policy directory")
+}
+
+// Test_evalPolicy_opa evaluates the test policy with the real opa, if found
+func Test_evalPolicy_opa(t *testing.T) {
+	opa, err := exec.LookPath("opa")
+	if err != nil {
+		t.Skip("opa not found in PATH")
+	}
+
+	fs := afero.NewOsFs()
+
+	dir, err := writePolicyModules(fs, []byte(testPolicy))
+	require.NoError(t, err)
+	defer fs.RemoveAll(dir) // nolint: errcheck
+
+	outcome, err := evalPolicy(opa, []string{"--v0-compatible"}, dir, testPSAPolicyInput(t))
+	require.NoError(t, err)
+
+	assert.Nil(t, outcome.Status)
+	require.NotNil(t, outcome.TrustVector["executables"])
+	assert.Equal(t, common.TrustClaim(33), *outcome.TrustVector["executables"])
+	assert.Nil(t, outcome.TrustVector["hardware"])
+}
+
+func testPSAPolicyInput(t *testing.T) policyInput {
+	inputs, err := policyInputs("psa", testValidP2PSAToken)
+	require.NoError(t, err)
+	require.Len(t, inputs, 1)
+
+	return inputs[0]
+}
+
+func Test_reportPolicyOutcome_changes(t *testing.T) {
+	var outcome policyOutcome
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"ear.status": null,
+		"ear.trustworthiness-vector": {
+			"instance-identity": null,
+			"configuration": 2,
+			"executables": 33,
+			"hardware": 2
+		}
+	}`), &outcome))
+
+	var buf bytes.Buffer
+
+	err := reportPolicyOutcome(&buf, testPSAPolicyInput(t), &outcome)
+	require.NoError(t, err)
+
+	expected := `>> submod "PSA_IOT"
+   status: affirming -> warning
+   trust vector:
+     configuration:     none (0) -> affirming (2): the configuration is a known and approved config
+     executables:       none (0) -> warning (33): runtime memory includes executables, scripts, files, and/or objects which are not recognized
+`
+
+	assert.Equal(t, expected, buf.String())
+}
+
+func Test_reportPolicyOutcome_status_only(t *testing.T) {
+	var outcome policyOutcome
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"ear.status": "contraindicated",
+		"ear.trustworthiness-vector": {"executables": null}
+	}`), &outcome))
+
+	var buf bytes.Buffer
+
+	err := reportPolicyOutcome(&buf, testPSAPolicyInput(t), &outcome)
+	require.NoError(t, err)
+
+	expected := `>> submod "PSA_IOT"
+   status: affirming -> contraindicated
+   trust vector: unchanged
+`
+
+	assert.Equal(t, expected, buf.String())
+}
+
+func Test_reportPolicyOutcome_unchanged(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := reportPolicyOutcome(&buf, testPSAPolicyInput(t), &policyOutcome{})
+	require.NoError(t, err)
+
+	expected := `>> submod "PSA_IOT"
+   status: affirming (unchanged)
+   trust vector: unchanged
+`
+
+	assert.Equal(t, expected, buf.String())
+}
+
+func Test_reportPolicyOutcome_unknown_claim(t *testing.T) {
+	c := common.TrustClaim(2)
+
+	err := reportPolicyOutcome(io.Discard, testPSAPolicyInput(t), &policyOutcome{
+		TrustVector: map[string]*common.TrustClaim{"firmware": &c},
+	})
+	assert.EqualError(t, err, `unknown trustworthiness vector entry "firmware" in policy outcome`)
+}
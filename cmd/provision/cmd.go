@@ -0,0 +1,155 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/veraison/apiclient/provisioning"
+	"github.com/veraison/evcli/v2/common"
+)
+
+var (
+	provisionCoRIMFiles *[]string
+	provisionMediaType  *string
+	provisionAPIURL     string
+	provisionIsInsecure bool
+	provisionCerts      []string
+)
+
+var (
+	provisionVeraisonClient common.IVeraisonProvisioner = &provisioning.SubmitConfig{}
+	Cmd                                                 = NewProvisionCmd(common.Fs, provisionVeraisonClient)
+)
+
+func NewProvisionCmd(fs afero.Fs, veraisonClient common.IVeraisonProvisioner) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "provision",
+		Short: "use Veraison REST API to provision CoRIM endorsements",
+		Long: `This command submits CoRIMs carrying reference values and trust anchors,
+such as those produced by "evcli psa corim" or "evcli cca corim", to the
+Veraison endorsement provisioning API.
+
+	evcli provision \
+	              --api-server=https://veraison.example/endorsement-provisioning/v1/submit \
+	              --corim=platform.cbor --corim=realm.cbor
+
+The media type of each CoRIM is inferred from its profile and from whether it
+is signed, e.g.:
+
+	application/corim-unsigned+cbor; profile="http://arm.com/psa/iot/1"
+	application/rim+cose; profile="http://arm.com/cca/ssd/1"
+
+Use --media-type to set it explicitly, e.g., for CoRIMs with no profile.
+
+The API server URL can also be set with "provisioning_api_server" in the
+configuration file, as the "api_server" entry is used by verify-as.
+	`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := provisionCheckSubmitArgs(cmd); err != nil {
+				return err
+			}
+
+			if err := veraisonClient.SetSubmitURI(provisionAPIURL); err != nil {
+				return err
+			}
+
+			veraisonClient.SetDeleteSession(true)
+			veraisonClient.SetIsInsecure(provisionIsInsecure)
+			veraisonClient.SetCerts(provisionCerts)
+
+			for _, fn := range *provisionCoRIMFiles {
+				data, err := afero.ReadFile(fs, fn)
+				if err != nil {
+					return fmt.Errorf("error loading CoRIM from %s: %w", fn, err)
+				}
+
+				mediaType := *provisionMediaType
+				if mediaType == "" {
+					if mediaType, err = common.CoRIMMediaType(data); err != nil {
+						return fmt.Errorf("%s: %w (use --media-type to set the media type)", fn, err)
+					}
+				}
+
+				if err = veraisonClient.Run(data, mediaType); err != nil {
+					return fmt.Errorf("provisioning %s: %w", fn, err)
+				}
+
+				fmt.Printf(">> %q successfully provisioned as %s\n", fn, mediaType)
+			}
+
+			return nil
+		},
+	}
+
+	provisionCoRIMFiles = cmd.Flags().StringArrayP(
+		"corim", "c", nil, "file containing a CoRIM, signed or unsigned; may be specified multiple times",
+	)
+
+	provisionMediaType = cmd.Flags().StringP(
+		"media-type", "m", "", "media type of the CoRIMs. If not set, it is inferred from each CoRIM",
+	)
+
+	cmd.Flags().StringP(
+		"api-server", "s", "", "URL of the Veraison endorsement provisioning API",
+	)
+
+	cmd.Flags().BoolP(
+		"insecure", "i", false, "allow insecure connections (e.g. do not verify TLS certs)",
+	)
+
+	cmd.Flags().StringArrayP(
+		"ca-cert", "E", nil, "path to a CA cert that will be used in addition to system certs; may be specified multiple times",
+	)
+
+	return cmd
+}
+
+func provisionCheckSubmitArgs(cmd *cobra.Command) error {
+	// the flags are bound when the command runs, rather than when it is
+	// created, so that they do not take over the config entries shared with
+	// the verify-as commands
+	var err error
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		cfgName := strings.ReplaceAll(flag.Name, "-", "_")
+		switch cfgName {
+		case "corim", "media_type":
+			// these are likely to be different on each invocation
+			return
+		case "api_server":
+			cfgName = "provisioning_api_server"
+		}
+
+		if bindErr := viper.BindPFlag(cfgName, flag); bindErr != nil {
+			err = bindErr
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	provisionAPIURL = viper.GetString("provisioning_api_server")
+	if provisionAPIURL == "" {
+		return errors.New("API server URL is not configured")
+	}
+
+	provisionIsInsecure = viper.GetBool("insecure")
+	provisionCerts = viper.GetStringSlice("ca_cert")
+
+	return nil
+}
+
+func init() {
+	for _, flag := range []string{"corim"} {
+		if err := Cmd.MarkFlagRequired(flag); err != nil {
+			panic(err)
+		}
+	}
+}
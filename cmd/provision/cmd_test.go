@@ -0,0 +1,216 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package provision
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mock_deps "github.com/veraison/evcli/v2/cmd/mocks"
+	"github.com/veraison/evcli/v2/common"
+	cose "github.com/veraison/go-cose"
+)
+
+func newTestCoRIM(t *testing.T, profile string) []byte {
+	comid := common.CoMID{
+		TagIdentity: common.TagIdentity{TagID: "test-comid"},
+		Triples: common.Triples{
+			ReferenceValues: []common.RefValTriple{
+				{
+					Environment: common.NewImplEnvironment(make([]byte, 32), "ACME", ""),
+					Measurements: []common.Measurement{
+						{Value: common.MeasurementValues{Digests: []common.Digest{{Alg: 1, Value: make([]byte, 32)}}}},
+					},
+				},
+			},
+		},
+	}
+
+	corim, err := common.NewCoRIM(profile, comid)
+	require.NoError(t, err)
+
+	data, err := corim.Encode()
+	require.NoError(t, err)
+
+	return data
+}
+
+func newTestSignedCoRIM(t *testing.T, profile string) []byte {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := cose.NewSigner(cose.AlgorithmES256, priv)
+	require.NoError(t, err)
+
+	data, err := common.SignCoRIM(newTestCoRIM(t, profile), signer, "ACME")
+	require.NoError(t, err)
+
+	return data
+}
+
+func Test_ProvisionCmd_ok(t *testing.T) {
+	psa := newTestCoRIM(t, common.CoRIMProfilePSA)
+	cca := newTestSignedCoRIM(t, common.CoRIMProfileCCAPlatform)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mock_deps.NewMockIVeraisonProvisioner(ctrl)
+
+	mc.EXPECT().SetSubmitURI(testSubmitURI)
+	mc.EXPECT().SetIsInsecure(false)
+	mc.EXPECT().SetCerts([]string{})
+	mc.EXPECT().SetDeleteSession(true)
+	gomock.InOrder(
+		mc.EXPECT().Run(psa, testPSAMediaType),
+		mc.EXPECT().Run(cca, testCCAMediaType),
+	)
+
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psa.cbor", psa, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "cca.cbor", cca, 0644)
+	require.NoError(t, err)
+
+	cmd := NewProvisionCmd(fs, mc)
+	cmd.SetArgs(
+		[]string{
+			"--api-server=" + testSubmitURI,
+			"--corim=psa.cbor",
+			"--corim=cca.cbor",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func Test_ProvisionCmd_media_type(t *testing.T) {
+	data := []byte{0xa0}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mock_deps.NewMockIVeraisonProvisioner(ctrl)
+
+	mc.EXPECT().SetSubmitURI(testSubmitURI)
+	mc.EXPECT().SetIsInsecure(true)
+	mc.EXPECT().SetCerts([]string{"ca.pem"})
+	mc.EXPECT().SetDeleteSession(true)
+	mc.EXPECT().Run(data, "application/vnd.example+cbor")
+
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "endorsements.cbor", data, 0644)
+	require.NoError(t, err)
+
+	cmd := NewProvisionCmd(fs, mc)
+	cmd.SetArgs(
+		[]string{
+			"--api-server=" + testSubmitURI,
+			"--corim=endorsements.cbor",
+			"--media-type=application/vnd.example+cbor",
+			"--insecure",
+			"--ca-cert=ca.pem",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func Test_ProvisionCmd_submit_failed(t *testing.T) {
+	data := newTestCoRIM(t, common.CoRIMProfileCCARealm)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mock_deps.NewMockIVeraisonProvisioner(ctrl)
+
+	mc.EXPECT().SetSubmitURI(testSubmitURI)
+	mc.EXPECT().SetIsInsecure(false)
+	mc.EXPECT().SetCerts([]string{})
+	mc.EXPECT().SetDeleteSession(true)
+	mc.EXPECT().Run(data, `application/corim-unsigned+cbor; profile="http://arm.com/cca/realm/1"`).
+		Return(errors.New("submission failed: no handler"))
+
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "realm.cbor", data, 0644)
+	require.NoError(t, err)
+
+	cmd := NewProvisionCmd(fs, mc)
+	cmd.SetArgs(
+		[]string{
+			"--api-server=" + testSubmitURI,
+			"--corim=realm.cbor",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, "provisioning realm.cbor: submission failed: no handler")
+}
+
+func Test_ProvisionCmd_not_a_corim(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mock_deps.NewMockIVeraisonProvisioner(ctrl)
+
+	mc.EXPECT().SetSubmitURI(testSubmitURI)
+	mc.EXPECT().SetIsInsecure(false)
+	mc.EXPECT().SetCerts([]string{})
+	mc.EXPECT().SetDeleteSession(true)
+
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "endorsements.cbor", []byte{0xa0}, 0644)
+	require.NoError(t, err)
+
+	cmd := NewProvisionCmd(fs, mc)
+	cmd.SetArgs(
+		[]string{
+			"--api-server=" + testSubmitURI,
+			"--corim=endorsements.cbor",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.ErrorContains(t, err, "endorsements.cbor: decoding CoRIM")
+	assert.ErrorContains(t, err, "(use --media-type to set the media type)")
+}
+
+func Test_ProvisionCmd_no_server(t *testing.T) {
+	cmd := NewProvisionCmd(afero.NewMemMapFs(), provisionVeraisonClient)
+	cmd.SetArgs(
+		[]string{
+			"--corim=endorsements.cbor",
+		},
+	)
+
+	err := cmd.Execute()
+	assert.EqualError(t, err, "API server URL is not configured")
+}
+
+func Test_ProvisionCmd_bad_server_url(t *testing.T) {
+	cmd := NewProvisionCmd(afero.NewMemMapFs(), provisionVeraisonClient)
+	cmd.SetArgs(
+		[]string{
+			"--api-server=veraison.example/submit",
+			"--corim=endorsements.cbor",
+		},
+	)
+
+	err := cmd.Execute()
+	assert.EqualError(t, err, "uri is not absolute")
+}
@@ -0,0 +1,10 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package provision
+
+const (
+	testSubmitURI    = "http://veraison.example/endorsement-provisioning/v1/submit"
+	testPSAMediaType = `application/corim-unsigned+cbor; profile="http://arm.com/psa/iot/1"`
+	testCCAMediaType = `application/rim+cose; profile="http://arm.com/cca/ssd/1"`
+)
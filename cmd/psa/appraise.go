@@ -0,0 +1,202 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package psa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/veraison/evcli/v2/common"
+	"github.com/veraison/psatoken"
+)
+
+var (
+	appraiseTokenFile   *string
+	appraiseRefValFiles *[]string
+	appraiseKeyFile     *string
+	appraiseKeysDir     *string
+	appraiseCoRIMFiles  *[]string
+	appraiseKeyFormat   *string
+
+	appraiseTrustAnchorFile *string
+	appraiseCRLFile         *string
+
+	appraiseEARKeyFile *string
+	appraiseEARFile    *string
+)
+
+var appraiseCmd = NewAppraiseCmd(common.Fs)
+
+func NewAppraiseCmd(fs afero.Fs) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "appraise",
+		Short: "compare the measurements in a PSA attestation token with reference values",
+		Long: `Verify the supplied PSA attestation token, as "check" does, then compare
+the software components it reports with the reference values in a CoRIM, or in
+a JSON file, and report whether each of them matches.
+
+Verify the PSA attestation token in my.cbor using the public IAK in
+es256.jwk, and compare its measurements with the reference values in the
+CoRIM endorsements.cbor:
+
+	evcli psa appraise --token=my.cbor --key=es256.jwk --refvals=endorsements.cbor
+
+The key can be designated in any of the ways supported by "check", e.g., the
+CoRIM can supply both the IAK and the reference values:
+
+	evcli psa appraise -t my.cbor --corim=endorsements.cbor -r endorsements.cbor
+
+JSON reference values use the names and the format of the claims, so that the
+claims saved by "check" from a known good token can be used:
+
+	{
+	  "psa-software-components": [
+	    {
+	      "measurement-type": "BL",
+	      "measurement-value": "AAECBAABAgQAAQIEAAECBAABAgQAAQIEAAECBAABAgQ=",
+	      "signer-id": "UZIA/1GSAP9RkgD/UZIA/1GSAP9RkgD/UZIA/1GSAP8="
+	    }
+	  ]
+	}
+
+A software component matches if there is a reference value with the same
+measurement value and, where the reference value has them, the same
+measurement type, signer ID and version.  If any of them does not, the command
+fails with exit code 4.
+
+With --ear-key, the outcome is also saved as an EAR attestation result, where
+the "executables" claim of the trustworthiness vector is set according to the
+software components matching their reference values.
+	`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			earKey, err := loadEARKey(fs, *appraiseEARKeyFile)
+			if err != nil {
+				return err
+			}
+
+			claims, err := verifyTokenFile(fs, *appraiseTokenFile, common.VerificationKeyFlags{
+				KeyFile:         *appraiseKeyFile,
+				KeysDir:         *appraiseKeysDir,
+				CoRIMFiles:      *appraiseCoRIMFiles,
+				TrustAnchorFile: *appraiseTrustAnchorFile,
+				CRLFile:         *appraiseCRLFile,
+			}, *appraiseKeyFormat)
+			if err != nil {
+				if earKey != nil {
+					if earErr := issueEAR(fs, *appraiseTokenFile, *appraiseEARFile, earKey, nil, err, nil); earErr != nil {
+						return earErr
+					}
+				}
+				return err
+			}
+
+			implID, err := claims.GetImplID()
+			if err != nil {
+				return fmt.Errorf("getting implementation ID: %w", err)
+			}
+
+			refvals, err := common.LoadReferenceValues(fs, *appraiseRefValFiles, implID, refValsFromJSON)
+			if err != nil {
+				return err
+			}
+
+			comps, err := claims.GetSoftwareComponents()
+			if err != nil && !errors.Is(err, psatoken.ErrOptionalClaimMissing) {
+				return fmt.Errorf("getting software components: %w", err)
+			}
+
+			var report common.MeasurementsReport
+
+			report.AppraiseSwComponents("psa-software-components", comps, refvals.SwComponents)
+
+			fmt.Printf(">> %q measurements:\n", *appraiseTokenFile)
+			report.Report(os.Stdout)
+
+			if earKey != nil {
+				if err = issueEAR(fs, *appraiseTokenFile, *appraiseEARFile, earKey, claims, nil, &report); err != nil {
+					return err
+				}
+			}
+
+			return report.Err()
+		},
+	}
+
+	appraiseTokenFile = cmd.Flags().StringP(
+		"token", "t", "", "CBOR file containing the PSA attestation token to be appraised",
+	)
+
+	appraiseRefValFiles = cmd.Flags().StringArrayP(
+		"refvals", "r", nil, "CoRIM, signed or unsigned, or JSON file with the reference values.  "+
+			"May be specified multiple times",
+	)
+
+	appraiseKeyFile = cmd.Flags().StringP(
+		"key", "k", "", "file with the public Initial Attestation Key used for verification, "+
+			"or with the symmetric IAK for COSE_Mac0 tokens",
+	)
+
+	appraiseKeysDir = cmd.Flags().String(
+		"keys-dir", "", "directory with the verification keys of a number of devices, from which "+
+			"the one matching the token's kid or instance ID is picked.  Use instead of --key",
+	)
+
+	appraiseCoRIMFiles = cmd.Flags().StringArray(
+		"corim", nil, "CoRIM file, signed or unsigned, with the endorsed IAK of the device the "+
+			"token comes from.  May be specified multiple times.  Use instead of --key",
+	)
+
+	appraiseKeyFormat = cmd.Flags().String(
+		"key-format", common.KeyFormatAuto, common.KeyFormatFlagUsage,
+	)
+
+	appraiseTrustAnchorFile = cmd.Flags().StringP(
+		"trust-anchor", "T", "",
+		"PEM file with the trust anchor certificate(s) against which the token's "+
+			"x5chain is validated.  Use instead of --key",
+	)
+
+	appraiseCRLFile = cmd.Flags().String(
+		"crl", "", "PEM or DER file with the CRL(s) used to check the revocation "+
+			"status of the x5chain certificates",
+	)
+
+	appraiseEARKeyFile = cmd.Flags().String(
+		"ear-key", "", "file with the private key used to sign an EAR attestation result "+
+			"describing the outcome of the appraisal.  If not set, no EAR is issued",
+	)
+
+	appraiseEARFile = cmd.Flags().String(
+		"ear", "", "name of the file where the EAR is saved.  Default is the name of the token "+
+			"file, with the -ear.jwt suffix",
+	)
+
+	return cmd
+}
+
+// refValsFromJSON decodes PSA reference values in the JSON format of the
+// claims
+func refValsFromJSON(data []byte) (common.ReferenceValues, error) {
+	var j struct {
+		SwComponents []common.SwComponentRefVal `json:"psa-software-components"`
+	}
+
+	if err := json.Unmarshal(data, &j); err != nil {
+		return common.ReferenceValues{}, err
+	}
+
+	return common.ReferenceValues{SwComponents: j.SwComponents}, nil
+}
+
+func init() {
+	for _, flag := range []string{"token", "refvals"} {
+		if err := appraiseCmd.MarkFlagRequired(flag); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package psa
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veraison/evcli/v2/common"
+)
+
+var testRefValsBadBL = []byte(`{
+	"psa-software-components": [
+		{
+			"measurement-type": "BL",
+			"measurement-value": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+		},
+		{
+			"measurement-type": "PRoT",
+			"measurement-value": "BQYHCAUGBwgFBgcIBQYHCAUGBwgFBgcIBQYHCAUGBwg=",
+			"signer-id": "UZIA/1GSAP9RkgD/UZIA/1GSAP9RkgD/UZIA/1GSAP8="
+		}
+	]
+}`)
+
+func Test_AppraiseCmd_corim_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	createKeyBoundToken(t, fs, "psatoken.cbor")
+
+	err := afero.WriteFile(fs, "iak-pub.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	corim := NewCorimCmd(fs)
+	corim.SetArgs([]string{"--token=psatoken.cbor", "--key=iak-pub.jwk", "--corim=endorsements.cbor"})
+	require.NoError(t, corim.Execute())
+
+	cmd := NewAppraiseCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--corim=endorsements.cbor",
+			"--refvals=endorsements.cbor",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func Test_AppraiseCmd_json_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iak-pub.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	// the claims of the token are its own reference values
+	check := NewCheckCmd(fs)
+	check.SetArgs([]string{"--token=psatoken.cbor", "--key=iak-pub.jwk", "--claims=claims.json"})
+	require.NoError(t, check.Execute())
+
+	cmd := NewAppraiseCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--key=iak-pub.jwk",
+			"--refvals=claims.json",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func Test_AppraiseCmd_mismatch(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iak-pub.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "refvals.json", testRefValsBadBL, 0644)
+	require.NoError(t, err)
+
+	cmd := NewAppraiseCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--key=iak-pub.jwk",
+			"--refvals=refvals.json",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, "the reference values are not matched by psa-software-components[0] (BL)")
+
+	var exitErr *common.ExitError
+	require.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, common.ExitCodeRefValsNotMatched, exitErr.Code)
+}
+
+func Test_AppraiseCmd_ear_mismatch(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iak-pub.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidKey, 0600)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "refvals.json", testRefValsBadBL, 0644)
+	require.NoError(t, err)
+
+	cmd := NewAppraiseCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--key=iak-pub.jwk",
+			"--refvals=refvals.json",
+			"--ear-key=ear.jwk",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.Error(t, err)
+
+	ar := loadTestEAR(t, fs, "psatoken-ear.jwt")
+
+	appraisal := ar.Submods[common.SubmodPSA]
+	assert.Equal(t, common.TrustTierWarning, appraisal.Status)
+	assert.Equal(t, common.TrustClaim(2), appraisal.TrustVector.InstanceIdentity)
+	assert.Equal(t, common.TrustClaim(33), appraisal.TrustVector.Executables)
+}
+
+func Test_AppraiseCmd_bad_refvals(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iak-pub.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "refvals.cbor", []byte{0xa0}, 0644)
+	require.NoError(t, err)
+
+	cmd := NewAppraiseCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--key=iak-pub.jwk",
+			"--refvals=refvals.cbor",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.ErrorContains(t, err, "error decoding reference values from refvals.cbor")
+}
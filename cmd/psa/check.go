@@ -4,18 +4,31 @@
 package psa
 
 import (
+	"crypto"
 	"encoding/json"
+	"errors"
 	"fmt"
 
+	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 	"github.com/veraison/evcli/v2/common"
+	"github.com/veraison/psatoken"
 )
 
 var (
 	checkClaimsFile *string
 	checkKeyFile    *string
+	checkKeysDir    *string
+	checkCoRIMFiles *[]string
+	checkKeyFormat  *string
 	checkTokenFile  *string
+
+	checkTrustAnchorFile *string
+	checkCRLFile         *string
+
+	checkEARKeyFile *string
+	checkEARFile    *string
 )
 
 var checkCmd = NewCheckCmd(common.Fs)
@@ -40,35 +53,62 @@ check a PSA attestation token contained in te.cbor using the public IAK in
 es256.jwk and dump the embedded claims to standard output:
 
 	evcli psa check -t te.cbor -k es256.jwk
+
+Check a PSA attestation token contained in my.cbor using the IAK certified by
+the x5chain embedded in the token, which is validated against the trust anchor
+in root.pem and the CRL in iak-ca.crl:
+
+	evcli psa check -t my.cbor --trust-anchor=root.pem --crl=iak-ca.crl
+
+Check a PSA attestation token contained in my.cbor, authenticated with
+COSE_Mac0 using the symmetric IAK in hmac.jwk:
+
+	evcli psa check -t my.cbor -k hmac.jwk
+
+Check a PSA attestation token contained in my.cbor using the IAK, among the
+ones in the iaks directory, whose kid matches the token's or from which the
+token's instance ID derives:
+
+	evcli psa check -t my.cbor --keys-dir=iaks
+
+Check a PSA attestation token contained in my.cbor using the IAK endorsed, for
+the token's implementation and instance IDs, in one of the CoRIMs
+endorsements.cbor and more-endorsements.cbor:
+
+	evcli psa check -t my.cbor --corim=endorsements.cbor --corim=more-endorsements.cbor
+
+Check a PSA attestation token contained in my.cbor using es256.jwk, and save
+the outcome as an EAR attestation result, signed with the private key in
+ear.jwk, to my-ear.jwt:
+
+	evcli psa check -t my.cbor -k es256.jwk --ear-key=ear.jwk --ear=my-ear.jwt
 	`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			key, err := afero.ReadFile(fs, *checkKeyFile)
+			earKey, err := loadEARKey(fs, *checkEARKeyFile)
 			if err != nil {
-				return fmt.Errorf("error loading verification key from %s: %w", *checkKeyFile, err)
+				return err
 			}
 
-			pk, err := common.PubKeyFromJWK(key)
-			if err != nil {
-				return fmt.Errorf("error decoding verification key from %s: %w", *checkKeyFile, err)
-			}
+			tokenClaims, err := verifyTokenFile(fs, *checkTokenFile, common.VerificationKeyFlags{
+				KeyFile:         *checkKeyFile,
+				KeysDir:         *checkKeysDir,
+				CoRIMFiles:      *checkCoRIMFiles,
+				TrustAnchorFile: *checkTrustAnchorFile,
+				CRLFile:         *checkCRLFile,
+			}, *checkKeyFormat)
 
-			t, err := loadTokenFromFile(fs, *checkTokenFile)
-			if err != nil {
-				return err
+			if earKey != nil {
+				earErr := issueEAR(fs, *checkTokenFile, *checkEARFile, earKey, tokenClaims, err, nil)
+				if earErr != nil {
+					return earErr
+				}
 			}
 
-			err = t.Verify(pk)
 			if err != nil {
 				return err
 			}
-			fmt.Printf(">> %q verified\n", *checkTokenFile)
-
-			err = t.Claims.Validate()
-			if err != nil {
-				return fmt.Errorf("claims validation failed: %w", err)
-			}
 
-			claims, err := json.Marshal(t.Claims)
+			claims, err := json.Marshal(tokenClaims)
 			if err != nil {
 				return fmt.Errorf("claims extraction failed: %w", err)
 			}
@@ -94,21 +134,281 @@ es256.jwk and dump the embedded claims to standard output:
 	)
 
 	checkKeyFile = cmd.Flags().StringP(
-		"key", "k", "", "JWK file with the public Initial Attestation Key used for verification",
+		"key", "k", "", "file with the public Initial Attestation Key used for verification, "+
+			"or with the symmetric IAK for COSE_Mac0 tokens",
+	)
+
+	checkKeysDir = cmd.Flags().String(
+		"keys-dir", "", "directory with the verification keys of a number of devices, from which "+
+			"the one matching the token's kid or instance ID is picked.  Use instead of --key",
+	)
+
+	checkCoRIMFiles = cmd.Flags().StringArray(
+		"corim", nil, "CoRIM file, signed or unsigned, with the endorsed IAK of the device the "+
+			"token comes from.  May be specified multiple times.  Use instead of --key",
 	)
 
 	checkTokenFile = cmd.Flags().StringP(
 		"token", "t", "", "CBOR file containing the PSA attestation token to be verified",
 	)
 
+	checkKeyFormat = cmd.Flags().String(
+		"key-format", common.KeyFormatAuto, common.KeyFormatFlagUsage,
+	)
+
+	checkTrustAnchorFile = cmd.Flags().StringP(
+		"trust-anchor", "T", "",
+		"PEM file with the trust anchor certificate(s) against which the token's "+
+			"x5chain is validated.  Use instead of --key",
+	)
+
+	checkCRLFile = cmd.Flags().String(
+		"crl", "", "PEM or DER file with the CRL(s) used to check the revocation "+
+			"status of the x5chain certificates",
+	)
+
+	checkEARKeyFile = cmd.Flags().String(
+		"ear-key", "", "file with the private key used to sign an EAR attestation result "+
+			"describing the outcome of the verification.  If not set, no EAR is issued",
+	)
+
+	checkEARFile = cmd.Flags().String(
+		"ear", "", "name of the file where the EAR is saved.  Default is the name of the token "+
+			"file, with the -ear.jwt suffix",
+	)
+
 	return cmd
 }
 
+// verifyTokenFile verifies the PSA token in fn using the key designated by the
+// supplied flags, and returns its validated claims
+func verifyTokenFile(
+	fs afero.Fs, fn string, kf common.VerificationKeyFlags, keyFormat string,
+) (psatoken.IClaims, error) {
+	if err := kf.Check(); err != nil {
+		return nil, err
+	}
+
+	var (
+		pk     crypto.PublicKey
+		macKey jwk.Key
+	)
+
+	if kf.KeyFile != "" {
+		key, format, err := common.LoadKeyFile(fs, kf.KeyFile, keyFormat, "verification key")
+		if err != nil {
+			return nil, err
+		}
+
+		if macKey, err = loadMACKey(key, format); err != nil {
+			return nil, fmt.Errorf("error decoding verification key from %s: %w", kf.KeyFile, err)
+		}
+
+		if macKey == nil {
+			pk, err = common.PubKeyFromKey(key, format)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding verification key from %s: %w", kf.KeyFile, err)
+			}
+		}
+	}
+
+	if kf.KeysDir != "" || len(kf.CoRIMFiles) > 0 {
+		key, keyFile, err := lookupTokenKey(fs, kf.KeysDir, kf.CoRIMFiles, fn)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf(">> using the key in %q\n", keyFile)
+
+		if common.IsSymmetricKey(key) {
+			macKey = key
+		} else if pk, err = common.PubKeyFromParsedKey(key); err != nil {
+			return nil, fmt.Errorf("error decoding verification key from %s: %w", keyFile, err)
+		}
+	}
+
+	var tokenClaims psatoken.IClaims
+
+	if macKey != nil {
+		var err error
+		if tokenClaims, err = verifyMac0Token(fs, fn, macKey); err != nil {
+			return nil, err
+		}
+	} else {
+		raw, err := afero.ReadFile(fs, fn)
+		if err != nil {
+			return nil, err
+		}
+
+		if common.IsMac0(raw) {
+			return nil, fmt.Errorf("%s is a COSE_Mac0 token: a symmetric key is needed to verify it", fn)
+		}
+
+		t, err := psatoken.DecodeAndValidateEvidenceFromCOSE(raw)
+		if err != nil {
+			return nil, &common.EvidenceError{Err: err}
+		}
+
+		if kf.TrustAnchorFile != "" {
+			pk, err = common.CertifiedKeyFromToken(
+				fs, raw, common.X5ChainFromSign1, kf.TrustAnchorFile, kf.CRLFile,
+			)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Printf(">> %q x5chain validated\n", fn)
+		}
+
+		if err = t.Verify(pk); err != nil {
+			return nil, &common.SignatureError{Err: err}
+		}
+
+		tokenClaims = t.Claims
+	}
+	fmt.Printf(">> %q verified\n", fn)
+
+	if err := tokenClaims.Validate(); err != nil {
+		return nil, &common.EvidenceError{Err: fmt.Errorf("claims validation failed: %w", err)}
+	}
+
+	return tokenClaims, nil
+}
+
+// loadEARKey loads the EAR signing key in fn, if any
+func loadEARKey(fs afero.Fs, fn string) (jwk.Key, error) {
+	if fn == "" {
+		return nil, nil
+	}
+
+	return common.LoadEARSigningKey(fs, fn, common.KeyFormatAuto)
+}
+
+// issueEAR saves to earFile an EAR, signed with key, with the outcome of the
+// verification of the PSA token in fn and, if supplied, of the comparison of
+// its measurements with the reference values.  Malformed tokens get a
+// contraindicated EAR.  No EAR is issued if the token could not be verified
+// for other reasons, e.g., a missing key, as they say nothing about the token.
+func issueEAR(
+	fs afero.Fs, fn, earFile string, key jwk.Key, claims psatoken.IClaims, verifyErr error,
+	report *common.MeasurementsReport,
+) error {
+	var (
+		sigErr *common.SignatureError
+		evErr  *common.EvidenceError
+	)
+
+	if verifyErr != nil && !errors.As(verifyErr, &sigErr) && !errors.As(verifyErr, &evErr) {
+		fmt.Printf(">> no EAR issued for %q: %v\n", fn, verifyErr)
+		return nil
+	}
+
+	raw, err := afero.ReadFile(fs, fn)
+	if err != nil {
+		return fmt.Errorf("error loading PSA token from %s: %w", fn, err)
+	}
+
+	if claims == nil {
+		// the claims of a token that failed verification can still tell the
+		// nonce the EAR is about
+		if _, payload, err := common.PeekCOSE(raw); err == nil {
+			claims, _ = psatoken.DecodeClaimsFromCBOR(payload)
+		}
+	}
+
+	var nonce []byte
+	if claims != nil {
+		nonce, _ = claims.GetNonce()
+	}
+
+	ar := common.NewLocalAttestationResult(raw, nonce)
+
+	if evErr != nil {
+		ar.AddRejectedSubmod(common.SubmodPSA, evErr)
+	} else {
+		tv := common.SignatureTrustVector(verifyErr)
+		if report != nil {
+			tv.Executables = common.ExecutablesClaim(report.Outcome("psa-software-components"))
+		}
+
+		ar.AddSubmod(common.SubmodPSA, tv)
+	}
+
+	if earFile == "" {
+		earFile = common.MakeFileName(".", fn, "-ear.jwt")
+	}
+
+	return common.SaveAttestationResult(fs, ar, key, earFile)
+}
+
+// loadMACKey returns the supplied key if it is a symmetric one, or nil
+func loadMACKey(raw []byte, format string) (jwk.Key, error) {
+	key, err := common.ParseKey(raw, format)
+	if err != nil {
+		return nil, err
+	}
+
+	if !common.IsSymmetricKey(key) {
+		return nil, nil
+	}
+
+	return key, nil
+}
+
+// lookupTokenKey picks the key of the PSA token in fn either from the keys in
+// dir, using the kid in the COSE headers or, failing that, the instance ID
+// claim, or from the attestation verification keys in the CoRIMs, using the
+// implementation and instance ID claims
+func lookupTokenKey(fs afero.Fs, dir string, corims []string, fn string) (jwk.Key, string, error) {
+	raw, err := afero.ReadFile(fs, fn)
+	if err != nil {
+		return nil, "", err
+	}
+
+	kid, payload, err := common.PeekCOSE(raw)
+	if err != nil {
+		return nil, "", fmt.Errorf("error decoding %s: %w", fn, err)
+	}
+
+	var implID, instID []byte
+	if claims, err := psatoken.DecodeClaimsFromCBOR(payload); err == nil {
+		implID, _ = claims.GetImplID()
+		instID, _ = claims.GetInstID()
+	}
+
+	if len(corims) > 0 {
+		return common.LoadCoRIMAttestationKey(fs, corims, implID, instID)
+	}
+
+	ring, err := common.LoadKeyRing(fs, dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return ring.Lookup(kid, instID)
+}
+
+// verifyMac0Token checks the tag of the COSE_Mac0 token in fn and returns the
+// claims it carries
+func verifyMac0Token(fs afero.Fs, fn string, key jwk.Key) (psatoken.IClaims, error) {
+	raw, err := afero.ReadFile(fs, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := common.VerifyMac0(raw, key)
+	if err != nil {
+		return nil, &common.SignatureError{Err: err}
+	}
+
+	claims, err := psatoken.DecodeClaimsFromCBOR(payload)
+	if err != nil {
+		return nil, &common.EvidenceError{Err: fmt.Errorf("failed CBOR decoding of PSA claims: %w", err)}
+	}
+
+	return claims, nil
+}
+
 func init() {
 	if err := checkCmd.MarkFlagRequired("token"); err != nil {
 		panic(err)
 	}
-	if err := checkCmd.MarkFlagRequired("key"); err != nil {
-		panic(err)
-	}
 }
@@ -4,11 +4,17 @@
 package psa
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
 	"testing"
 
+	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/veraison/evcli/v2/common"
+	"github.com/veraison/psatoken"
 )
 
 func Test_CheckCmd_ok(t *testing.T) {
@@ -148,7 +154,7 @@ func Test_CheckCmd_bad_token(t *testing.T) {
 func Test_CheckCmd_bad_signature(t *testing.T) {
 	fs := afero.NewMemMapFs()
 
-	tamperedPSAToken := testValidP2PSAToken
+	tamperedPSAToken := bytes.Clone(testValidP2PSAToken)
 	tamperedPSAToken[len(tamperedPSAToken)-1] ^= 1
 
 	err := afero.WriteFile(fs, "psatoken.cbor", tamperedPSAToken, 0644)
@@ -170,3 +176,540 @@ func Test_CheckCmd_bad_signature(t *testing.T) {
 	err = cmd.Execute()
 	assert.EqualError(t, err, expectedErr)
 }
+
+func Test_CheckCmd_DER_key_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "es256-pub.der", testValidKeyPubDER, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--key=es256-pub.der",
+			"--key-format=der",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.NoError(t, err)
+}
+
+// createTokenWithX5Chain saves to psatoken.cbor a token signed with the IAK and
+// carrying its certificate chain
+func createTokenWithX5Chain(t *testing.T, fs afero.Fs) {
+	err := afero.WriteFile(fs, "es256.jwk", testValidKey, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "chain.pem", testIAKChain, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidP2PSAClaimsWithNonce, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--key=es256.jwk",
+			"--x5chain=chain.pem",
+			"--token=psatoken.cbor",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+}
+
+func Test_CheckCmd_trust_anchor_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	createTokenWithX5Chain(t, fs)
+
+	err := afero.WriteFile(fs, "root.pem", testRootCA, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--trust-anchor=root.pem",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func Test_CheckCmd_trust_anchor_untrusted(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	createTokenWithX5Chain(t, fs)
+
+	err := afero.WriteFile(fs, "root.pem", testOtherRootCA, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--trust-anchor=root.pem",
+		},
+	)
+
+	expectedErr := `certificate path validation failed`
+
+	err = cmd.Execute()
+	assert.ErrorContains(t, err, expectedErr)
+}
+
+func Test_CheckCmd_trust_anchor_revoked(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	createTokenWithX5Chain(t, fs)
+
+	err := afero.WriteFile(fs, "root.pem", testRootCA, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iak-ca.crl", testIAKRevokedCRL, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--trust-anchor=root.pem",
+			"--crl=iak-ca.crl",
+		},
+	)
+
+	expectedErr := `certificate "CN=Test IAK,O=Veraison" (serial 3) was revoked`
+
+	err = cmd.Execute()
+	assert.ErrorContains(t, err, expectedErr)
+}
+
+func Test_CheckCmd_trust_anchor_no_x5chain(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "root.pem", testRootCA, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--trust-anchor=root.pem",
+		},
+	)
+
+	expectedErr := `error extracting x5chain: no x5chain header found`
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
+func Test_CheckCmd_key_and_trust_anchor(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--key=es256.jwk",
+			"--trust-anchor=root.pem",
+		},
+	)
+
+	expectedErr := `--key and --trust-anchor are mutually exclusive`
+
+	err := cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
+func Test_CheckCmd_no_verification_key(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+		},
+	)
+
+	expectedErr := `one of --key, --keys-dir, --corim or --trust-anchor must be supplied`
+
+	err := cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
+func Test_CheckCmd_mac0_wrong_key(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	token := newTestMac0Token(t)
+
+	err := afero.WriteFile(fs, "my.cbor", token, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "hmac.jwk", testOtherHMACKey, 0600)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=my.cbor",
+			"--key=hmac.jwk",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, "MAC verification failed")
+}
+
+func Test_CheckCmd_mac0_asymmetric_key(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	token := newTestMac0Token(t)
+
+	err := afero.WriteFile(fs, "my.cbor", token, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "es256.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=my.cbor",
+			"--key=es256.jwk",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, "my.cbor is a COSE_Mac0 token: a symmetric key is needed to verify it")
+}
+
+func newTestMac0Token(t *testing.T) []byte {
+	key, err := common.ParseKey(testValidHMACKey, common.KeyFormatJWK)
+	require.NoError(t, err)
+
+	mk, err := common.NewMACKey(key)
+	require.NoError(t, err)
+
+	claims, err := claimsFromJSON(testValidP2PSAClaimsWithNonce, true)
+	require.NoError(t, err)
+
+	payload, err := psatoken.EncodeClaimsToCBOR(claims)
+	require.NoError(t, err)
+
+	token, err := common.CreateMac0(payload, mk)
+	require.NoError(t, err)
+
+	return token
+}
+
+func Test_CheckCmd_keys_dir_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "es256.jwk", testValidKey, 0600)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidP2PSAClaimsNoInstID, 0644)
+	require.NoError(t, err)
+
+	create := NewCreateCmd(fs)
+	create.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--key=es256.jwk",
+			"--token=my.cbor",
+			"--derive-claims",
+		},
+	)
+
+	err = create.Execute()
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iaks/device-1.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iaks/README", []byte("IAKs of the lab devices"), 0644)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=my.cbor",
+			"--keys-dir=iaks",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func Test_CheckCmd_keys_dir_no_match(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
+	require.NoError(t, err)
+
+	// the instance ID in the token does not derive from this key
+	err = afero.WriteFile(fs, "iaks/device-1.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--keys-dir=iaks",
+		},
+	)
+
+	expectedErr := `no key for instance ID 01a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3 in iaks`
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
+func Test_CheckCmd_corim_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "endorsements.cbor", testIAKCoRIM, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--corim=endorsements.cbor",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func Test_CheckCmd_corim_no_match(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "es256.jwk", testValidKey, 0600)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidP2PSAClaimsNoInstID, 0644)
+	require.NoError(t, err)
+
+	// the derived instance ID is not the endorsed one
+	create := NewCreateCmd(fs)
+	create.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--key=es256.jwk",
+			"--token=my.cbor",
+			"--derive-claims",
+		},
+	)
+
+	err = create.Execute()
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "endorsements.cbor", testIAKCoRIM, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=my.cbor",
+			"--corim=endorsements.cbor",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.ErrorContains(t, err, "no attestation verification key for implementation ID")
+}
+
+func loadTestEAR(t *testing.T, fs afero.Fs, fn string) *common.AttestationResult {
+	data, err := afero.ReadFile(fs, fn)
+	require.NoError(t, err)
+
+	key, err := jwk.ParseKey(testValidKeyPub)
+	require.NoError(t, err)
+
+	ar, err := common.DecodeAndVerifyAttestationResult(data, key)
+	require.NoError(t, err)
+
+	return ar
+}
+
+func Test_CheckCmd_ear_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "es256.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidKey, 0600)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--key=es256.jwk",
+			"--ear-key=ear.jwk",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	ar := loadTestEAR(t, fs, "psatoken-ear.jwt")
+
+	require.Contains(t, ar.Submods, common.SubmodPSA)
+	appraisal := ar.Submods[common.SubmodPSA]
+	assert.Equal(t, common.TrustTierAffirming, appraisal.Status)
+	assert.Equal(t, common.TrustClaim(2), appraisal.TrustVector.InstanceIdentity)
+	assert.Equal(t, common.TrustClaim(2), appraisal.TrustVector.Hardware)
+	assert.NotEmpty(t, ar.Nonce)
+}
+
+func Test_CheckCmd_ear_bad_signature(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	tamperedPSAToken := bytes.Clone(testValidP2PSAToken)
+	tamperedPSAToken[len(tamperedPSAToken)-1] ^= 1
+
+	err := afero.WriteFile(fs, "psatoken.cbor", tamperedPSAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "es256.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidKey, 0600)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--key=es256.jwk",
+			"--ear-key=ear.jwk",
+			"--ear=my-ear.jwt",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, `signature verification failed: verification error`)
+
+	ar := loadTestEAR(t, fs, "my-ear.jwt")
+
+	appraisal := ar.Submods[common.SubmodPSA]
+	assert.Equal(t, common.TrustTierContraindicated, appraisal.Status)
+	assert.Equal(t, common.TrustClaim(99), appraisal.TrustVector.InstanceIdentity)
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(testNonce), ar.Nonce)
+}
+
+func Test_CheckCmd_ear_bad_token(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testInvalidPSAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "es256.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidKey, 0600)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--key=es256.jwk",
+			"--ear-key=ear.jwk",
+		},
+	)
+
+	expectedErr := `failed CBOR decoding for CWT: cbor: invalid COSE_Sign1_Tagged object`
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+
+	ar := loadTestEAR(t, fs, "psatoken-ear.jwt")
+
+	appraisal := ar.Submods[common.SubmodPSA]
+	assert.Equal(t, common.TrustTierContraindicated, appraisal.Status)
+	assert.Equal(t, common.TrustClaim(1), appraisal.TrustVector.InstanceIdentity)
+	assert.Equal(t, expectedErr, appraisal.PolicyClaims["problem"])
+	assert.Empty(t, ar.Nonce)
+}
+
+func Test_CheckCmd_ear_key_not_found(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidKey, 0600)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--key=es256.jwk",
+			"--ear-key=ear.jwk",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, "error loading verification key from es256.jwk: open es256.jwk: file does not exist")
+
+	exists, err := afero.Exists(fs, "psatoken-ear.jwt")
+	require.NoError(t, err)
+	assert.False(t, exists, "no EAR is issued when the key cannot be loaded")
+}
+
+func Test_CheckCmd_ear_public_key(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "es256.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCheckCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--key=es256.jwk",
+			"--ear-key=es256.jwk",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, "error decoding EAR signing key from es256.jwk: not a private key")
+}
+
+func Test_issueEAR_token_not_found(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	key, err := jwk.ParseKey(testValidKey)
+	require.NoError(t, err)
+
+	err = issueEAR(fs, "psatoken.cbor", "", key, nil, &common.SignatureError{Err: errors.New("bad signature")}, nil)
+	assert.EqualError(t, err, "error loading PSA token from psatoken.cbor: open psatoken.cbor: file does not exist")
+}
@@ -9,7 +9,7 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var cmdValidArgs = []string{"verify-as", "create", "check"}
+var cmdValidArgs = []string{"verify-as", "create", "check", "serve-attester", "corim", "appraise"}
 
 var Cmd = &cobra.Command{
 	Use:   "psa",
@@ -28,4 +28,7 @@ func init() {
 	Cmd.AddCommand(checkCmd)
 	Cmd.AddCommand(verifyAsCmd)
 	Cmd.AddCommand(printCmd)
+	Cmd.AddCommand(serveAttesterCmd)
+	Cmd.AddCommand(corimCmd)
+	Cmd.AddCommand(appraiseCmd)
 }
@@ -4,7 +4,11 @@
 package psa
 
 import (
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/spf13/afero"
+	"github.com/veraison/evcli/v2/common"
 	"github.com/veraison/psatoken"
 )
 
@@ -32,3 +36,18 @@ func claimsFromJSON(j []byte, validate bool) (psatoken.IClaims, error) {
 	}
 	return psatoken.DecodeClaimsFromJSON(j)
 }
+
+// deriveKeyBoundClaims sets the claims that depend on the IAK, i.e., the
+// instance ID, from the supplied key
+func deriveKeyBoundClaims(claims psatoken.IClaims, key jwk.Key) error {
+	instID, err := common.InstanceIDFromKey(key)
+	if err != nil {
+		return fmt.Errorf("deriving instance ID: %w", err)
+	}
+
+	if err := claims.SetInstID(instID); err != nil {
+		return fmt.Errorf("setting instance ID: %w", err)
+	}
+
+	return nil
+}
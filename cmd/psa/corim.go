@@ -0,0 +1,250 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package psa
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/veraison/evcli/v2/common"
+	"github.com/veraison/psatoken"
+)
+
+var (
+	corimClaimsFile  *string
+	corimTokenFile   *string
+	corimKeyFile     *string
+	corimKeyFormat   *string
+	corimOutputFile  *string
+	corimVendor      *string
+	corimModel       *string
+	corimSignKeyFile *string
+)
+
+var corimCmd = NewCorimCmd(common.Fs)
+
+func NewCorimCmd(fs afero.Fs) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "corim",
+		Short: "create the CoRIM endorsements of a PSA device from its claims and IAK",
+		Long: `Create a CoRIM with the reference values of a PSA device, i.e., the
+measurements of its software components, and the endorsement of its Initial
+Attestation Key, using the CoRIM profile of Veraison's PSA scheme
+(http://arm.com/psa/iot/1).  The measurements, and the implementation and
+instance IDs that identify the device, are taken from the JSON-encoded claims
+or from a PSA attestation token.
+
+Create a CoRIM from the claims in claims.json and the public IAK in
+iak-pub.jwk, and save it to endorsements.cbor:
+
+	evcli psa corim --claims=claims.json --key=iak-pub.jwk --corim=endorsements.cbor
+
+Create a CoRIM from the claims in the token my.cbor, with the vendor and model
+of the device in the environment of the endorsements:
+
+	evcli psa corim -t my.cbor -k iak-pub.jwk --vendor=ACME --model=RoadRunner
+
+Create a CoRIM with the reference values only, and sign it with the key in
+corim-signer.jwk (any of the key references of "psa create" can be used):
+
+	evcli psa corim -c claims.json --sign-key=corim-signer.jwk
+
+If the claims carry no instance ID, it is derived from the IAK.
+	`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (*corimClaimsFile == "") == (*corimTokenFile == "") {
+				return errors.New("exactly one of --claims or --token must be supplied")
+			}
+
+			claims, err := loadCorimClaims(fs)
+			if err != nil {
+				return err
+			}
+
+			comid, err := psaCoMID(fs, claims)
+			if err != nil {
+				return err
+			}
+
+			corim, err := common.NewCoRIM(common.CoRIMProfilePSA, *comid)
+			if err != nil {
+				return err
+			}
+
+			data, err := corim.Encode()
+			if err != nil {
+				return fmt.Errorf("error encoding CoRIM: %w", err)
+			}
+
+			if *corimSignKeyFile != "" {
+				signer, err := common.LoadSigningKey(fs, *corimSignKeyFile, common.KeyFormatAuto, "CoRIM signing key")
+				if err != nil {
+					return err
+				}
+
+				if data, err = common.SignCoRIM(data, signer, corimSignerName()); err != nil {
+					return fmt.Errorf("error signing CoRIM: %w", err)
+				}
+			}
+
+			fn := corimFileName()
+
+			if err = afero.WriteFile(fs, fn, data, 0644); err != nil {
+				return fmt.Errorf("error saving CoRIM to file %s: %w", fn, err)
+			}
+
+			fmt.Printf(">> %q successfully created\n", fn)
+
+			return nil
+		},
+	}
+
+	corimClaimsFile = cmd.Flags().StringP(
+		"claims", "c", "", "JSON file containing the PSA attestation claims of the device",
+	)
+
+	corimTokenFile = cmd.Flags().StringP(
+		"token", "t", "", "PSA attestation token of the device, from which the claims are taken.  Use instead of --claims",
+	)
+
+	corimKeyFile = cmd.Flags().StringP(
+		"key", "k", "", "file with the public Initial Attestation Key of the device.  If not set, no key is endorsed",
+	)
+
+	corimKeyFormat = cmd.Flags().String(
+		"key-format", common.KeyFormatAuto, common.KeyFormatFlagUsage,
+	)
+
+	corimOutputFile = cmd.Flags().StringP(
+		"corim", "o", "", "name of the file where the produced CoRIM will be stored",
+	)
+
+	corimVendor = cmd.Flags().String(
+		"vendor", "", "vendor of the device, set in the environment of the endorsements",
+	)
+
+	corimModel = cmd.Flags().String(
+		"model", "", "model of the device, set in the environment of the endorsements",
+	)
+
+	corimSignKeyFile = cmd.Flags().String(
+		"sign-key", "", "file with the key used to sign the CoRIM, or a reference to it.  If not set, the CoRIM is unsigned",
+	)
+
+	return cmd
+}
+
+func loadCorimClaims(fs afero.Fs) (psatoken.IClaims, error) {
+	if *corimClaimsFile != "" {
+		return loadClaimsFromFile(fs, *corimClaimsFile, false)
+	}
+
+	raw, err := afero.ReadFile(fs, *corimTokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// the token need not be verified, as it is only a source of claims
+	_, payload, err := common.PeekCOSE(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", *corimTokenFile, err)
+	}
+
+	claims, err := psatoken.DecodeClaimsFromCBOR(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed CBOR decoding of PSA claims: %w", err)
+	}
+
+	return claims, nil
+}
+
+// psaCoMID creates the CoMID with the reference values of the device described
+// by the claims and, if a key is supplied, the endorsement of its IAK
+func psaCoMID(fs afero.Fs, claims psatoken.IClaims) (*common.CoMID, error) {
+	implID, err := claims.GetImplID()
+	if err != nil {
+		return nil, fmt.Errorf("getting implementation ID: %w", err)
+	}
+
+	comps, err := claims.GetSoftwareComponents()
+	if err != nil && !errors.Is(err, psatoken.ErrOptionalClaimMissing) {
+		return nil, fmt.Errorf("getting software components: %w", err)
+	}
+
+	measurements, err := common.SwComponentMeasurements(comps)
+	if err != nil {
+		return nil, err
+	}
+
+	env := common.NewImplEnvironment(implID, *corimVendor, *corimModel)
+
+	comid := common.CoMID{
+		TagIdentity: common.TagIdentity{TagID: "psa-endorsements"},
+	}
+
+	if len(measurements) > 0 {
+		comid.Triples.ReferenceValues = []common.RefValTriple{
+			{Environment: env, Measurements: measurements},
+		}
+	}
+
+	if *corimKeyFile != "" {
+		raw, err := afero.ReadFile(fs, *corimKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading IAK from %s: %w", *corimKeyFile, err)
+		}
+
+		iak, err := common.ParseKey(raw, *corimKeyFormat)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding IAK from %s: %w", *corimKeyFile, err)
+		}
+
+		// a missing instance ID is derived from the IAK
+		claimed, err := claims.GetInstID()
+		if err != nil {
+			claimed = nil
+		}
+
+		instID, err := common.CheckInstanceID(claimed, iak)
+		if err != nil {
+			return nil, err
+		}
+
+		triple, err := common.NewAttestKeyTriple(env, instID, iak)
+		if err != nil {
+			return nil, fmt.Errorf("error endorsing IAK from %s: %w", *corimKeyFile, err)
+		}
+
+		comid.Triples.AttestVerifKeys = []common.KeyTriple{triple}
+	}
+
+	if len(comid.Triples.ReferenceValues) == 0 && len(comid.Triples.AttestVerifKeys) == 0 {
+		return nil, errors.New("nothing to endorse: the claims have no software components and no IAK was supplied")
+	}
+
+	return &comid, nil
+}
+
+// corimSignerName is the name of the CoRIM signer in the corim-meta header
+func corimSignerName() string {
+	if *corimVendor != "" {
+		return *corimVendor
+	}
+	return "evcli"
+}
+
+func corimFileName() string {
+	if *corimOutputFile != "" {
+		return *corimOutputFile
+	}
+
+	in := *corimClaimsFile
+	if in == "" {
+		in = *corimTokenFile
+	}
+
+	return common.MakeFileName(".", in, "-corim.cbor")
+}
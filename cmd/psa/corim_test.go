@@ -0,0 +1,153 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package psa
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veraison/evcli/v2/common"
+)
+
+// createKeyBoundToken creates, in fn, a PSA token signed with testValidKey
+// whose instance ID is derived from it, so that it can be endorsed with
+// testValidKeyPub
+func createKeyBoundToken(t *testing.T, fs afero.Fs, fn string) {
+	err := afero.WriteFile(fs, "key-bound-claims.json", testValidP2PSAClaimsNoInstID, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "key-bound-iak.jwk", testValidKey, 0600)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=key-bound-claims.json",
+			"--key=key-bound-iak.jwk",
+			"--derive-claims",
+			"--token=" + fn,
+		},
+	)
+	require.NoError(t, cmd.Execute())
+}
+
+func Test_CorimCmd_token_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	createKeyBoundToken(t, fs, "psatoken.cbor")
+
+	err := afero.WriteFile(fs, "iak-pub.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCorimCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--key=iak-pub.jwk",
+			"--vendor=ACME",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	data, err := afero.ReadFile(fs, "psatoken-corim.cbor")
+	require.NoError(t, err)
+
+	corim, err := common.DecodeCoRIM(data)
+	require.NoError(t, err)
+	require.Len(t, corim.CoMIDs, 1)
+
+	refvals := corim.CoMIDs[0].Triples.ReferenceValues
+	require.Len(t, refvals, 1)
+	assert.Equal(t, "ACME", refvals[0].Environment.Class.Vendor)
+	assert.Len(t, refvals[0].Measurements, 2)
+
+	// the endorsed IAK verifies the token
+	check := NewCheckCmd(fs)
+	check.SetArgs(
+		[]string{
+			"--token=psatoken.cbor",
+			"--corim=psatoken-corim.cbor",
+		},
+	)
+
+	err = check.Execute()
+	assert.NoError(t, err)
+}
+
+func Test_CorimCmd_signed_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "claims.json", testValidP2PSAClaimsWithNonce, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "signer.jwk", testValidKey, 0600)
+	require.NoError(t, err)
+
+	cmd := NewCorimCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--sign-key=signer.jwk",
+			"--corim=endorsements.cbor",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	data, err := afero.ReadFile(fs, "endorsements.cbor")
+	require.NoError(t, err)
+
+	// a COSE_Sign1
+	assert.Equal(t, byte(0xd2), data[0])
+
+	corim, err := common.DecodeCoRIM(data)
+	require.NoError(t, err)
+	require.Len(t, corim.CoMIDs, 1)
+	assert.Empty(t, corim.CoMIDs[0].Triples.AttestVerifKeys)
+}
+
+func Test_CorimCmd_no_claims(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	cmd := NewCorimCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--key=iak-pub.jwk",
+		},
+	)
+
+	err := cmd.Execute()
+	assert.EqualError(t, err, "exactly one of --claims or --token must be supplied")
+}
+
+func Test_CorimCmd_instance_id_mismatch(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	// the instance ID in the claims is not derived from testValidKeyPub
+	err := afero.WriteFile(fs, "claims.json", testValidP2PSAClaims, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iak-pub.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCorimCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--key=iak-pub.jwk",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.ErrorContains(t, err, "the instance ID in the claims (01a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3) does not match the one derived from the IAK")
+
+	exists, err := afero.Exists(fs, "claims-corim.cbor")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
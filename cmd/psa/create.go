@@ -4,6 +4,7 @@
 package psa
 
 import (
+	"crypto/x509"
 	"errors"
 	"fmt"
 
@@ -16,9 +17,15 @@ import (
 var (
 	createClaimsFile   *string
 	createKeyFile      *string
+	createKeyFormat    *string
 	createTokenFile    *string
 	createTokenProfile *string
 	allowInvalidClaims *bool
+	createDeriveClaims *bool
+	createX5ChainFile  *string
+	createEmitTBSFile  *string
+	createAttachSig    *string
+	createStateFile    *string
 )
 
 var createCmd = NewCreateCmd(common.Fs)
@@ -46,15 +53,80 @@ te-profile1.cbor:
 	evcli psa create -c te-profile1.json -k es256.jwk -p PSA_IOT_PROFILE_1
 
 Note that the default profile is http://arm.com/psa/2.0.0.
+
+Create a PSA attestation token with the instance ID derived from the public
+part of es256.jwk, rather than taken from the claims file:
+
+	evcli psa create -c claims.json -k es256.jwk --derive-claims
+
+Create a PSA attestation token that carries the IAK certificate chain found in
+iak-chain.pem in its x5chain header:
+
+	evcli psa create -c claims.json -k es256.jwk --x5chain=iak-chain.pem
+
+Create a PSA attestation token signed with the IAK labelled "iak" in the
+PKCS#11 token "evcli", without the private key ever leaving the token:
+
+	evcli psa create -c claims.json \
+	    -k 'pkcs11:token=evcli;object=iak?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234'
+
+Create a PSA attestation token signed with the ECC key persisted at handle
+0x81000001 in the TPM, or in a swtpm instance listening on localhost:2321:
+
+	evcli psa create -c claims.json -k 'tpm:/dev/tpmrm0?handle=0x81000001'
+	evcli psa create -c claims.json -k 'tpm+swtpm://localhost:2321?handle=0x81000001'
+
+Create a PSA attestation token signed with the key iak of the Vault transit
+secrets engine mounted at transit, on the server set by VAULT_ADDR:
+
+	evcli psa create -c claims.json -k vault-transit://transit/iak
+
+Create a PSA attestation token signed with an ECDSA key held by ssh-agent,
+selected by its fingerprint (see ssh-add -l):
+
+	evcli psa create -c claims.json -k ssh-agent:SHA256:3vX1...
+
+Create a PSA attestation token signed by an external helper program, which is
+handed the data to be signed on stdin and prints the signature:
+
+	evcli psa create -c claims.json -k 'exec:/usr/local/bin/my-signer --rig lab3'
+
+Create a PSA attestation token authenticated with COSE_Mac0, rather than
+signed, using the symmetric IAK in hmac.jwk (an oct JWK, with an "alg" of
+HS256, HS384 or HS512, or a 32, 48 or 64-byte key):
+
+	evcli psa create -c claims.json -k hmac.jwk
+
+Create a PSA attestation token in two steps, for an IAK that is only usable
+offline.  First, save the COSE Sig_structure to be signed to iak.tbs, and the
+signing state to my.state; only the public part of the IAK is needed:
+
+	evcli psa create -c claims.json -k iak-pub.jwk --emit-tbs=iak.tbs --state=my.state
+
+Then, once iak.tbs has been signed, assemble the token from the signature in
+iak.sig (ECDSA signatures can be either r || s or ASN.1 encoded) and save it
+to my.cbor:
+
+	evcli psa create --state=my.state --attach-signature=iak.sig -t my.cbor
 	`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			validate := !*allowInvalidClaims
 
+			if err := checkOfflineSigningFlags(); err != nil {
+				return err
+			}
+
+			if *createAttachSig != "" {
+				return attachSignature(fs)
+			}
+
 			if err := checkProfile(createTokenProfile); err != nil {
 				return err
 			}
 
-			claims, err := loadClaimsFromFile(fs, *createClaimsFile, validate)
+			// key-bound claims may be missing from the file: validation is
+			// deferred until they have been derived
+			claims, err := loadClaimsFromFile(fs, *createClaimsFile, validate && !*createDeriveClaims)
 			if err != nil {
 				return err
 			}
@@ -68,6 +140,39 @@ Note that the default profile is http://arm.com/psa/2.0.0.
 
 			}
 
+			var (
+				signer *common.SigningKey
+				macKey *common.MACKey
+				tbs    *common.TBSRecorder
+			)
+
+			if *createEmitTBSFile != "" {
+				signer, tbs, err = common.LoadTBSRecorder(fs, *createKeyFile, *createKeyFormat, "public key")
+			} else {
+				signer, macKey, err = common.LoadAttestationKey(fs, *createKeyFile, *createKeyFormat, "signing key")
+			}
+			if err != nil {
+				return err
+			}
+
+			if macKey != nil {
+				return createMac0Token(fs, claims, macKey, validate)
+			}
+
+			var x5chain []*x509.Certificate
+			if *createX5ChainFile != "" {
+				x5chain, err = common.LoadX5Chain(fs, *createX5ChainFile, signer.Public)
+				if err != nil {
+					return err
+				}
+			}
+
+			if *createDeriveClaims {
+				if err = deriveKeyBoundClaims(claims, signer.Public); err != nil {
+					return err
+				}
+			}
+
 			evidence := psatoken.Evidence{}
 
 			if validate {
@@ -78,18 +183,10 @@ Note that the default profile is http://arm.com/psa/2.0.0.
 				evidence.Claims = claims
 			}
 
-			key, err := afero.ReadFile(fs, *createKeyFile)
-			if err != nil {
-				return fmt.Errorf("error loading signing key from %s: %w", *createKeyFile, err)
-			}
-
-			signer, err := common.SignerFromJWK(key)
-			if err != nil {
-				return fmt.Errorf("error decoding signing key from %s: %w", *createKeyFile, err)
-			}
-
 			var cwt []byte
-			if validate {
+			if x5chain != nil {
+				cwt, err = signWithX5Chain(claims, x5chain, signer, validate)
+			} else if validate {
 				cwt, err = evidence.ValidateAndSign(signer)
 			} else {
 				cwt, err = evidence.Sign(signer)
@@ -98,6 +195,10 @@ Note that the default profile is http://arm.com/psa/2.0.0.
 				return fmt.Errorf("signature failed: %w", err)
 			}
 
+			if tbs != nil {
+				return emitTBS(fs, tbs, cwt, signer)
+			}
+
 			fn := tokenFileName()
 
 			err = afero.WriteFile(fs, fn, cwt, 0644)
@@ -116,7 +217,7 @@ Note that the default profile is http://arm.com/psa/2.0.0.
 	)
 
 	createKeyFile = cmd.Flags().StringP(
-		"key", "k", "", "JWK file with the Initial Attestation Key used for signing",
+		"key", "k", "", "file with the Initial Attestation Key used for signing, "+common.KeyRefFlagUsage,
 	)
 
 	createTokenFile = cmd.Flags().StringP(
@@ -127,15 +228,190 @@ Note that the default profile is http://arm.com/psa/2.0.0.
 		"profile", "p", psatoken.Profile2Name, "name of the PSA profile to use",
 	)
 
+	createKeyFormat = cmd.Flags().String(
+		"key-format", common.KeyFormatAuto, common.KeyFormatFlagUsage,
+	)
+
 	allowInvalidClaims = cmd.Flags().BoolP(
 		"allow-invalid", "I", false,
 		"Do not validate provided claims, allowing invalid tokens to be generated. "+
 			"This is intended for testing.",
 	)
 
+	createDeriveClaims = cmd.Flags().BoolP(
+		"derive-claims", "D", false,
+		"set the instance ID claim from the IAK, overriding any value in the claims file",
+	)
+
+	createX5ChainFile = cmd.Flags().StringP(
+		"x5chain", "x", "",
+		"PEM file with the certificate chain of the IAK, leaf first, to embed in the x5chain header",
+	)
+
+	createEmitTBSFile = cmd.Flags().String(
+		"emit-tbs", "",
+		"save the COSE Sig_structure to be signed to this file, and the signing state to the "+
+			"--state file, rather than signing; --key only needs to hold the public key",
+	)
+
+	createAttachSig = cmd.Flags().String(
+		"attach-signature", "",
+		"file with the signature of the structure saved by --emit-tbs, from which to assemble "+
+			"the token kept in the --state file",
+	)
+
+	createStateFile = cmd.Flags().String(
+		"state", "", "file where --emit-tbs saves the signing state, for --attach-signature to use",
+	)
+
 	return cmd
 }
 
+// createMac0Token saves the claims in a COSE_Mac0 token, authenticated with
+// the supplied symmetric IAK
+func createMac0Token(fs afero.Fs, claims psatoken.IClaims, key *common.MACKey, validate bool) error {
+	if *createX5ChainFile != "" {
+		return errors.New("--x5chain cannot be used with a symmetric IAK")
+	}
+
+	if *createDeriveClaims {
+		return errors.New("--derive-claims cannot be used with a symmetric IAK")
+	}
+
+	var (
+		payload []byte
+		err     error
+	)
+
+	if validate {
+		payload, err = psatoken.ValidateAndEncodeClaimsToCBOR(claims)
+	} else {
+		payload, err = psatoken.EncodeClaimsToCBOR(claims)
+	}
+	if err != nil {
+		return fmt.Errorf("MAC failed: %w", err)
+	}
+
+	cwt, err := common.CreateMac0(payload, key)
+	if err != nil {
+		return fmt.Errorf("MAC failed: %w", err)
+	}
+
+	fn := tokenFileName()
+
+	if err = afero.WriteFile(fs, fn, cwt, 0644); err != nil {
+		return fmt.Errorf("error saving PSA attestation token to file %s: %w", fn, err)
+	}
+
+	fmt.Printf(">> %q successfully created\n", fn)
+
+	return nil
+}
+
+// checkOfflineSigningFlags checks the combination of flags, some of which are
+// only needed when a token is not signed in two steps
+func checkOfflineSigningFlags() error {
+	if *createEmitTBSFile != "" && *createAttachSig != "" {
+		return errors.New("--emit-tbs and --attach-signature are mutually exclusive")
+	}
+
+	if (*createEmitTBSFile != "" || *createAttachSig != "") && *createStateFile == "" {
+		return errors.New("--state must be supplied with --emit-tbs and --attach-signature")
+	}
+
+	if *createAttachSig != "" {
+		if *createClaimsFile != "" || *createKeyFile != "" {
+			return errors.New("--claims and --key cannot be used with --attach-signature")
+		}
+		return nil
+	}
+
+	if *createClaimsFile == "" || *createKeyFile == "" {
+		return errors.New("--claims and --key must be supplied")
+	}
+
+	return nil
+}
+
+// signWithX5Chain signs the claims with the x5chain in the protected header.
+// psatoken signs with the algorithm header only, so the COSE_Sign1 is built
+// here rather than by Evidence.Sign.
+func signWithX5Chain(
+	claims psatoken.IClaims, x5chain []*x509.Certificate, signer *common.SigningKey, validate bool,
+) ([]byte, error) {
+	var (
+		payload []byte
+		err     error
+	)
+
+	if validate {
+		payload, err = psatoken.ValidateAndEncodeClaimsToCBOR(claims)
+	} else {
+		payload, err = psatoken.EncodeClaimsToCBOR(claims)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return common.SignWithX5Chain(payload, x5chain, signer)
+}
+
+// emitTBS saves the structure to be signed and the state needed to assemble
+// the token once it has been signed
+func emitTBS(fs afero.Fs, tbs *common.TBSRecorder, cwt []byte, signer *common.SigningKey) error {
+	state, err := common.NewOfflineSigningState(common.OfflineSigningPSA, cwt, signer.Public)
+	if err != nil {
+		return err
+	}
+
+	if err = afero.WriteFile(fs, *createEmitTBSFile, tbs.TBS, 0644); err != nil {
+		return fmt.Errorf("error saving to-be-signed structure to file %s: %w", *createEmitTBSFile, err)
+	}
+
+	if err = state.Save(fs, *createStateFile); err != nil {
+		return err
+	}
+
+	fmt.Printf(">> %q successfully created\n", *createEmitTBSFile)
+	fmt.Printf(">> %q successfully created\n", *createStateFile)
+
+	return nil
+}
+
+// attachSignature assembles the token kept in the signing state with the
+// supplied signature
+func attachSignature(fs afero.Fs) error {
+	state, err := common.LoadOfflineSigningState(fs, *createStateFile, common.OfflineSigningPSA, 1)
+	if err != nil {
+		return err
+	}
+
+	key, err := state.Key(0)
+	if err != nil {
+		return fmt.Errorf("error decoding public key from %s: %w", *createStateFile, err)
+	}
+
+	sig, err := afero.ReadFile(fs, *createAttachSig)
+	if err != nil {
+		return fmt.Errorf("error loading signature from %s: %w", *createAttachSig, err)
+	}
+
+	cwt, err := common.AttachSignature(state.Token, sig, key)
+	if err != nil {
+		return fmt.Errorf("error attaching signature from %s: %w", *createAttachSig, err)
+	}
+
+	fn := tokenFileName()
+
+	if err = afero.WriteFile(fs, fn, cwt, 0644); err != nil {
+		return fmt.Errorf("error saving PSA attestation token to file %s: %w", fn, err)
+	}
+
+	fmt.Printf(">> %q successfully created\n", fn)
+
+	return nil
+}
+
 func checkProfile(profile *string) error {
 	if profile == nil {
 		return errors.New("nil profile")
@@ -154,17 +430,12 @@ func checkProfile(profile *string) error {
 
 func tokenFileName() string {
 	if createTokenFile == nil || *createTokenFile == "" {
+		// when attaching a signature, there is no claims file
+		if *createClaimsFile == "" {
+			return common.MakeFileName(".", *createStateFile, ".cbor")
+		}
 		return common.MakeFileName(".", *createClaimsFile, ".cbor")
 	}
 
 	return *createTokenFile
 }
-
-func init() {
-	if err := createCmd.MarkFlagRequired("claims"); err != nil {
-		panic(err)
-	}
-	if err := createCmd.MarkFlagRequired("key"); err != nil {
-		panic(err)
-	}
-}
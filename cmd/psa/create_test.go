@@ -4,11 +4,15 @@
 package psa
 
 import (
+	"crypto/rand"
+	"os"
 	"testing"
 
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/veraison/evcli/v2/common"
+	"github.com/veraison/psatoken"
 )
 
 func Test_CreateCmd_ok(t *testing.T) {
@@ -212,3 +216,359 @@ func Test_CreateCmd_bad_key(t *testing.T) {
 	err = cmd.Execute()
 	assert.ErrorContains(t, err, expectedErr)
 }
+
+func Test_CreateCmd_PEM_key_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "es256.pem", testValidKeyPEM, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidP2PSAClaimsWithNonce, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--key=es256.pem",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func Test_CreateCmd_wrong_key_format(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "es256.pem", testValidKeyPEM, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidP2PSAClaimsWithNonce, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--key=es256.pem",
+			"--key-format=jwk",
+		},
+	)
+
+	expectedErr := `error decoding signing key from es256.pem: failed to parse key`
+
+	err = cmd.Execute()
+	assert.ErrorContains(t, err, expectedErr)
+}
+
+func Test_CreateCmd_derive_claims_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "es256.jwk", testValidKey, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidP2PSAClaimsNoInstID, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--key=es256.jwk",
+			"--derive-claims",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	token, err := afero.ReadFile(fs, "claims.cbor")
+	require.NoError(t, err)
+
+	evidence, err := psatoken.DecodeAndValidateEvidenceFromCOSE(token)
+	require.NoError(t, err)
+
+	key, err := common.ParseKey(testValidKey, common.KeyFormatJWK)
+	require.NoError(t, err)
+
+	expected, err := common.InstanceIDFromKey(key)
+	require.NoError(t, err)
+
+	actual, err := evidence.Claims.GetInstID()
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func Test_CreateCmd_missing_inst_id(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "es256.jwk", testValidKey, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidP2PSAClaimsNoInstID, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--key=es256.jwk",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.ErrorContains(t, err, "validating instance id: missing mandatory claim")
+}
+
+func Test_CreateCmd_x5chain_key_mismatch(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "es256.jwk", testValidKey, 0644)
+	require.NoError(t, err)
+
+	// the root CA certificate does not certify the IAK
+	err = afero.WriteFile(fs, "chain.pem", testRootCA, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidP2PSAClaimsWithNonce, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--key=es256.jwk",
+			"--x5chain=chain.pem",
+		},
+	)
+
+	expectedErr := `the public key in certificate "CN=Test Root CA,O=Veraison" does not match the signing key`
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
+func Test_CreateCmd_emit_tbs_attach_signature_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "iak-pub.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidP2PSAClaimsWithNonce, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--key=iak-pub.jwk",
+			"--emit-tbs=iak.tbs",
+			"--state=my.state",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	_, err = fs.Stat("claims.cbor")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// offline signing ceremony
+	tbs, err := afero.ReadFile(fs, "iak.tbs")
+	require.NoError(t, err)
+
+	signer, err := common.SignerFromJWK(testValidKey)
+	require.NoError(t, err)
+
+	sig, err := signer.Sign(rand.Reader, tbs)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iak.sig", sig, 0644)
+	require.NoError(t, err)
+
+	cmd = NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--state=my.state",
+			"--attach-signature=iak.sig",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	token, err := afero.ReadFile(fs, "my.cbor")
+	require.NoError(t, err)
+
+	evidence, err := psatoken.DecodeAndValidateEvidenceFromCOSE(token)
+	require.NoError(t, err)
+
+	pub, err := common.PubKeyFromJWK(testValidKeyPub)
+	require.NoError(t, err)
+
+	assert.NoError(t, evidence.Verify(pub))
+}
+
+func Test_CreateCmd_attach_signature_bad_signature(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "iak-pub.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidP2PSAClaimsWithNonce, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--key=iak-pub.jwk",
+			"--emit-tbs=iak.tbs",
+			"--state=my.state",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	// a signature over something else
+	signer, err := common.SignerFromJWK(testValidKey)
+	require.NoError(t, err)
+
+	sig, err := signer.Sign(rand.Reader, []byte("something else"))
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "iak.sig", sig, 0644)
+	require.NoError(t, err)
+
+	cmd = NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--state=my.state",
+			"--attach-signature=iak.sig",
+			"--token=my.cbor",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.EqualError(t, err,
+		"error attaching signature from iak.sig: signature does not verify: verification error")
+}
+
+func Test_CreateCmd_offline_signing_bad_flags(t *testing.T) {
+	tvs := []struct {
+		args        []string
+		expectedErr string
+	}{
+		{
+			args:        []string{"--emit-tbs=iak.tbs", "--attach-signature=iak.sig", "--state=my.state"},
+			expectedErr: "--emit-tbs and --attach-signature are mutually exclusive",
+		},
+		{
+			args:        []string{"--claims=claims.json", "--key=iak-pub.jwk", "--emit-tbs=iak.tbs"},
+			expectedErr: "--state must be supplied with --emit-tbs and --attach-signature",
+		},
+		{
+			args:        []string{"--key=iak-pub.jwk", "--attach-signature=iak.sig", "--state=my.state"},
+			expectedErr: "--claims and --key cannot be used with --attach-signature",
+		},
+		{
+			args:        []string{"--claims=claims.json"},
+			expectedErr: "--claims and --key must be supplied",
+		},
+	}
+
+	for _, tv := range tvs {
+		cmd := NewCreateCmd(afero.NewMemMapFs())
+		cmd.SetArgs(tv.args)
+
+		err := cmd.Execute()
+		assert.EqualError(t, err, tv.expectedErr, tv.args)
+	}
+}
+
+func Test_CreateCmd_attach_signature_wrong_state(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "my.state", []byte(`{"kind": "cca", "token": "", "keys": [{}, {}]}`), 0644)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--state=my.state",
+			"--attach-signature=iak.sig",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, `my.state holds the signing state of a "cca" token, want "psa"`)
+}
+
+func Test_CreateCmd_mac0_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "hmac.jwk", testValidHMACKey, 0600)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidP2PSAClaimsWithNonce, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--key=hmac.jwk",
+			"--token=my.cbor",
+		},
+	)
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	token, err := afero.ReadFile(fs, "my.cbor")
+	require.NoError(t, err)
+	assert.True(t, common.IsMac0(token))
+
+	check := NewCheckCmd(fs)
+	check.SetArgs(
+		[]string{
+			"--token=my.cbor",
+			"--key=hmac.jwk",
+			"--claims=out.json",
+		},
+	)
+
+	err = check.Execute()
+	require.NoError(t, err)
+
+	claims, err := afero.ReadFile(fs, "out.json")
+	require.NoError(t, err)
+	assert.Contains(t, string(claims), `"psa-nonce":"QUp8F0FBs9DpodKK8xUg8NQimf6sQAfe2J1ormzZLxk="`)
+}
+
+func Test_CreateCmd_mac0_x5chain(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "hmac.jwk", testValidHMACKey, 0600)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "chain.pem", testRootCA, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "claims.json", testValidP2PSAClaimsWithNonce, 0644)
+	require.NoError(t, err)
+
+	cmd := NewCreateCmd(fs)
+	cmd.SetArgs(
+		[]string{
+			"--claims=claims.json",
+			"--key=hmac.jwk",
+			"--x5chain=chain.pem",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, "--x5chain cannot be used with a symmetric IAK")
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package psa
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/veraison/evcli/v2/common"
+)
+
+var (
+	serveAttesterClaimsFile *string
+	serveAttesterKeyFile    *string
+	serveAttesterKeyFormat  *string
+	serveAttesterListenAddr *string
+)
+
+var serveAttesterCmd = NewServeAttesterCmd(common.Fs, http.ListenAndServe)
+
+// NewServeAttesterCmd creates the "serve-attester" command.  The listen
+// function is used to start the HTTP server, which allows tests to run the
+// command without binding a socket.
+func NewServeAttesterCmd(fs afero.Fs, listen func(string, http.Handler) error) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve-attester",
+		Short: "run an HTTP service that emulates a PSA attester",
+		Long: `Run an HTTP service that emulates a PSA attester for relying-party
+testing.  The relying party POSTs a JSON object carrying a base64-encoded nonce
+to the /evidence endpoint:
+
+	{ "nonce": "AAECAwABAgMAAQIDAAECAwABAgMAAQIDAAECAwABAgM=" }
+
+and receives a PSA attestation token built from the supplied claims, bound to
+the nonce and signed with the supplied IAK.  The nonce must be 32, 48 or 64
+bytes long.
+
+	evcli psa serve-attester \
+	              --listen=localhost:8081 \
+	              --claims=claims.json \
+	              --key=es256.jwk
+	`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			validateClaims := false
+			claims, err := loadClaimsFromFile(fs, *serveAttesterClaimsFile, validateClaims)
+			if err != nil {
+				return err
+			}
+
+			signer, err := common.LoadSigningKey(
+				fs, *serveAttesterKeyFile, *serveAttesterKeyFormat, "signing key",
+			)
+			if err != nil {
+				return err
+			}
+
+			srv := &common.EvidenceServer{
+				Builder:   attesterEvidenceBuilder{Claims: claims, Signer: signer},
+				MediaType: PSATokenMediaType,
+				CheckNonce: func(nonce []byte) error {
+					return checkNonceSz(uint(len(nonce)))
+				},
+			}
+
+			fmt.Printf(">> PSA attester listening on http://%s%s\n",
+				*serveAttesterListenAddr, common.EvidencePath)
+
+			return listen(*serveAttesterListenAddr, srv.Handler())
+		},
+	}
+
+	serveAttesterClaimsFile = cmd.Flags().StringP(
+		"claims", "c", "", "JSON file containing the PSA attestation claims to be signed",
+	)
+
+	serveAttesterKeyFile = cmd.Flags().StringP(
+		"key", "k", "", "file with the Initial Attestation Key used for signing, "+common.KeyRefFlagUsage,
+	)
+
+	serveAttesterKeyFormat = cmd.Flags().String(
+		"key-format", common.KeyFormatAuto, common.KeyFormatFlagUsage,
+	)
+
+	serveAttesterListenAddr = cmd.Flags().StringP(
+		"listen", "l", "localhost:8081", "address the attester service listens on",
+	)
+
+	return cmd
+}
+
+func init() {
+	if err := serveAttesterCmd.MarkFlagRequired("claims"); err != nil {
+		panic(err)
+	}
+
+	if err := serveAttesterCmd.MarkFlagRequired("key"); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package psa
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veraison/evcli/v2/common"
+	"github.com/veraison/psatoken"
+)
+
+func serveAttesterHandler(t *testing.T) http.Handler {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "claims.json", testValidP2PSAClaims, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "es256.jwk", testValidKey, 0644)
+	require.NoError(t, err)
+
+	var handler http.Handler
+
+	listen := func(addr string, h http.Handler) error {
+		assert.Equal(t, "localhost:8081", addr)
+		handler = h
+		return nil
+	}
+
+	cmd := NewServeAttesterCmd(fs, listen)
+	cmd.SetArgs([]string{"--claims=claims.json", "--key=es256.jwk"})
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+	require.NotNil(t, handler)
+
+	return handler
+}
+
+func postChallenge(h http.Handler, body, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, common.EvidencePath, strings.NewReader(body))
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	return w
+}
+
+func Test_ServeAttesterCmd_key_not_found(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "claims.json", testValidP2PSAClaims, 0644)
+	require.NoError(t, err)
+
+	cmd := NewServeAttesterCmd(fs, func(string, http.Handler) error {
+		return errors.New("unexpected listen")
+	})
+	cmd.SetArgs([]string{"--claims=claims.json", "--key=es256.jwk"})
+
+	expectedErr := `error loading signing key from es256.jwk: open es256.jwk: file does not exist`
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
+func Test_ServeAttesterCmd_ok(t *testing.T) {
+	h := serveAttesterHandler(t)
+
+	nonce := bytes.Repeat([]byte{0xa5}, 48)
+
+	w := postChallenge(h, `{"nonce": "paWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWlpaWl"}`, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, PSATokenMediaType, w.Header().Get("Content-Type"))
+
+	cwt, err := io.ReadAll(w.Body)
+	require.NoError(t, err)
+
+	e, err := psatoken.DecodeAndValidateEvidenceFromCOSE(cwt)
+	require.NoError(t, err)
+
+	pk, err := common.PubKeyFromJWK(testValidKeyPub)
+	require.NoError(t, err)
+
+	assert.NoError(t, e.Verify(pk))
+
+	actual, err := e.Claims.GetNonce()
+	require.NoError(t, err)
+	assert.Equal(t, nonce, actual)
+}
+
+func Test_ServeAttesterCmd_bad_nonce_size(t *testing.T) {
+	h := serveAttesterHandler(t)
+
+	w := postChallenge(h, `{"nonce": "AAECAw=="}`, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, common.ProblemMediaType, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "wrong nonce length 4")
+}
+
+func Test_ServeAttesterCmd_not_acceptable(t *testing.T) {
+	h := serveAttesterHandler(t)
+
+	w := postChallenge(h,
+		`{"nonce": "AAECAwABAgMAAQIDAAECAwABAgMAAQIDAAECAwABAgM"}`,
+		"application/eat+cwt",
+	)
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+}
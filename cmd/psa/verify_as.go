@@ -5,14 +5,23 @@ package psa
 
 import (
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/veraison/evcli/v2/common"
 )
 
 var verifyValidArgs = []string{"attester", "relying-party"}
 
 const PSATokenMediaType = "application/psa-attestation-token"
 
+// verifyAsSubmods are the submods the verifier produces for the scheme
+var verifyAsSubmods = []string{common.SubmodPSA}
+
+// verifyAsClock returns the time against which the freshness of the attestation
+// results is checked
+var verifyAsClock = time.Now
+
 var verifyAsCmd = &cobra.Command{
 	Use:   "verify-as",
 	Short: "use Veraison REST API to verify PSA tokens",
@@ -29,3 +38,16 @@ func init() {
 	verifyAsCmd.AddCommand(relyingPartyCmd)
 	verifyAsCmd.AddCommand(attesterCmd)
 }
+
+// checkResultBinding checks that the attestation result refers to the evidence
+// with the supplied nonce, and that it was issued within maxAge
+func checkResultBinding(ar *common.AttestationResult, nonce []byte, maxAge time.Duration) error {
+	binding := common.ResultBinding{
+		Nonce:       nonce,
+		Submods:     verifyAsSubmods,
+		MaxAge:      maxAge,
+		CurrentTime: verifyAsClock(),
+	}
+
+	return binding.Check(ar)
+}
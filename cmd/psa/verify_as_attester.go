@@ -6,7 +6,9 @@ package psa
 import (
 	"errors"
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
@@ -21,10 +23,14 @@ import (
 var (
 	attesterClaimsFile *string
 	attesterKeyFile    *string
+	attesterKeyFormat  *string
 	attesterAPIURL     string
 	attesterNonceSz    uint
 	attesterIsInsecure bool
 	attesterCerts      []string
+	attesterEARKeyFile string
+	attesterExpect     *common.Expectation
+	attesterMaxAge     time.Duration
 )
 
 var (
@@ -49,7 +55,7 @@ to be created on the fly based on the attester's claims and signing IAK.
 	
 	`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := attesterCheckSubmitArgs(); err != nil {
+			if err := attesterCheckSubmitArgs(cmd); err != nil {
 				return err
 			}
 
@@ -59,16 +65,11 @@ to be created on the fly based on the attester's claims and signing IAK.
 				return err
 			}
 
-			key, err := afero.ReadFile(fs, *attesterKeyFile)
+			signer, err := common.LoadSigningKey(
+				fs, *attesterKeyFile, *attesterKeyFormat, "signing key",
+			)
 			if err != nil {
-				return fmt.Errorf("error loading signing key from %s: %w",
-					*attesterKeyFile, err)
-			}
-
-			signer, err := common.SignerFromJWK(key)
-			if err != nil {
-				return fmt.Errorf("error decoding signing key from %s: %w",
-					*attesterKeyFile, err)
+				return err
 			}
 
 			eb := attesterEvidenceBuilder{Claims: claims, Signer: signer}
@@ -93,7 +94,33 @@ to be created on the fly based on the attester's claims and signing IAK.
 				return err
 			}
 
-			fmt.Println(string(attestationResults))
+			earKey, err := common.LoadEARVerificationKey(
+				fs, attesterEARKeyFile, attesterAPIURL, attesterIsInsecure, attesterCerts,
+			)
+			if err != nil {
+				return err
+			}
+
+			ar, err := common.DecodeAndVerifyAttestationResult(attestationResults, earKey)
+			if err != nil {
+				return err
+			}
+
+			// the nonce is set by the evidence builder
+			nonce, err := claims.GetNonce()
+			if err != nil {
+				return fmt.Errorf("getting the nonce of the submitted evidence: %w", err)
+			}
+
+			if err = checkResultBinding(ar, nonce, attesterMaxAge); err != nil {
+				return err
+			}
+
+			ar.Report(os.Stdout)
+
+			if attesterExpect != nil {
+				return attesterExpect.Check(ar)
+			}
 
 			return nil
 		},
@@ -104,7 +131,11 @@ to be created on the fly based on the attester's claims and signing IAK.
 	)
 
 	attesterKeyFile = cmd.Flags().StringP(
-		"key", "k", "", "JWK file with the Initial Attestation Key used for signing",
+		"key", "k", "", "file with the Initial Attestation Key used for signing, "+common.KeyRefFlagUsage,
+	)
+
+	attesterKeyFormat = cmd.Flags().String(
+		"key-format", common.KeyFormatAuto, common.KeyFormatFlagUsage,
 	)
 
 	cmd.Flags().StringP(
@@ -123,23 +154,46 @@ to be created on the fly based on the attester's claims and signing IAK.
 		"ca-cert", "E", nil, "path to a CA cert that will be used in addition to system certs; may be specified multiple times",
 	)
 
+	cmd.Flags().StringP(
+		"ear-key", "K", "", "file with the public key used to verify the attestation result. "+
+			"If not set, the key is fetched from the verifier",
+	)
+
+	cmd.Flags().String(
+		"expect", "", "minimum acceptable appraisal status (affirming, warning or contraindicated), "+
+			"optionally followed by per-claim minimums, e.g. warning,executables=affirming",
+	)
+
+	cmd.Flags().Duration(
+		"max-age", common.DefaultResultMaxAge, "maximum distance between the time the attestation "+
+			"result was issued and the current time, e.g. 30s or 10m.  0 disables the freshness check",
+	)
+
+	return cmd
+}
+
+func attesterCheckSubmitArgs(cmd *cobra.Command) error {
+	// the flags are bound when the command runs, rather than when it is
+	// created, so that the commands sharing the config entries do not take
+	// them over from each other
+	var err error
 	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
 		cfgName := strings.ReplaceAll(flag.Name, "-", "_")
-		if cfgName == "claims" || cfgName == "key" {
+		if cfgName == "claims" || cfgName == "key" || cfgName == "key_format" {
 			// as claims and the corresponding key file are likely
 			// to be different on each invocation, it does not make
 			// sense for them be specified via the config.
 			return
 		}
 
-		err := viper.BindPFlag(cfgName, flag)
-		cobra.CheckErr(err)
+		if bindErr := viper.BindPFlag(cfgName, flag); bindErr != nil {
+			err = bindErr
+		}
 	})
+	if err != nil {
+		return err
+	}
 
-	return cmd
-}
-
-func attesterCheckSubmitArgs() error {
 	attesterAPIURL = viper.GetString("api_server")
 	if attesterAPIURL == "" {
 		return errors.New("API server URL is not configured")
@@ -152,6 +206,14 @@ func attesterCheckSubmitArgs() error {
 
 	attesterIsInsecure = viper.GetBool("insecure")
 	attesterCerts = viper.GetStringSlice("ca_cert")
+	attesterEARKeyFile = viper.GetString("ear_key")
+	attesterMaxAge = viper.GetDuration("max_age")
+
+	expect, err := common.ParseExpectation(viper.GetString("expect"))
+	if err != nil {
+		return err
+	}
+	attesterExpect = expect
 
 	return nil
 }
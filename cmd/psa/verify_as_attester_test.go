@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/spf13/afero"
@@ -131,18 +132,19 @@ func Test_AttesterCmd_bad_server_url(t *testing.T) {
 }
 
 func Test_AttesterCmd_ok(t *testing.T) {
+	setVerifyAsClock(t, testEARIssuedAt)
+
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	mc := mock_deps.NewMockIVeraisonClient(ctrl)
 
 	mc.EXPECT().SetSessionURI(testSessionURI)
-	mc.EXPECT().SetEvidenceBuilder(gomock.Any())
 	mc.EXPECT().SetIsInsecure(false)
 	mc.EXPECT().SetCerts([]string{})
 	mc.EXPECT().SetDeleteSession(true)
 	mc.EXPECT().SetNonceSz(uint(48))
-	mc.EXPECT().Run().Return([]byte("ok"), nil)
+	expectAttesterSession(mc, testNonce, testValidEAR)
 
 	fs := afero.NewMemMapFs()
 
@@ -152,12 +154,16 @@ func Test_AttesterCmd_ok(t *testing.T) {
 	err = afero.WriteFile(fs, "es256.jwk", testValidKey, 0644)
 	require.NoError(t, err)
 
+	err = afero.WriteFile(fs, "ear.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
 	cmd := NewAttesterCmd(fs, mc)
 	cmd.SetArgs(
 		[]string{
 			"--api-server=" + testSessionURI,
 			"--claims=claims.json",
 			"--key=es256.jwk",
+			"--ear-key=ear.jwk",
 		},
 	)
 
@@ -165,6 +171,67 @@ func Test_AttesterCmd_ok(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+// runAttesterCmd runs attester over testValidP2PSAClaims, with the verifier
+// returning the supplied attestation result.  As in the evcli binary, the
+// other verify-as commands are built too.
+func runAttesterCmd(t *testing.T, ear []byte, args ...string) error {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mock_deps.NewMockIVeraisonClient(ctrl)
+
+	mc.EXPECT().SetSessionURI(testSessionURI)
+	mc.EXPECT().SetIsInsecure(false)
+	mc.EXPECT().SetCerts([]string{})
+	mc.EXPECT().SetDeleteSession(true)
+	mc.EXPECT().SetNonceSz(uint(48))
+	expectAttesterSession(mc, testNonce, ear)
+
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "claims.json", testValidP2PSAClaims, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "es256.jwk", testValidKey, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewAttesterCmd(fs, mc)
+	NewRelyingPartyCmd(fs, relyingPartyVeraisonClient)
+	buildCCAVerifyAsCmds()
+
+	cmd.SetArgs(
+		append([]string{
+			"--api-server=" + testSessionURI,
+			"--claims=claims.json",
+			"--key=es256.jwk",
+			"--ear-key=ear.jwk",
+		}, args...),
+	)
+
+	return cmd.Execute()
+}
+
+func Test_AttesterCmd_options_not_shared(t *testing.T) {
+	setVerifyAsClock(t, testEARIssuedAt)
+
+	err := runAttesterCmd(t, testValidEAR, "--ear-key=other-ear.jwk")
+	assert.EqualError(t, err, "error loading EAR verification key from other-ear.jwk: open other-ear.jwk: file does not exist")
+
+	err = runAttesterCmd(t, testValidEAR, "--expect=affirming,configuration=affirming")
+	var exitErr *common.ExitError
+	require.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, common.ExitCodeTrustVectorNotMet, exitErr.Code)
+
+	// the result is stale, unless the freshness window is widened
+	setVerifyAsClock(t, testEARIssuedAt.Add(time.Hour))
+
+	err = runAttesterCmd(t, testValidEAR, "--max-age=2h")
+	assert.NoError(t, err)
+}
+
 func Test_AttesterCmd_bad_nonceSz(t *testing.T) {
 	fs := afero.NewMemMapFs()
 
@@ -4,17 +4,23 @@
 package psa
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	mock_deps "github.com/veraison/evcli/v2/cmd/mocks"
+	"github.com/veraison/evcli/v2/common"
 )
 
 func Test_RelyingPartyCmd_token_not_found(t *testing.T) {
@@ -75,6 +81,8 @@ func Test_RelyingPartyCmd_bad_server_url(t *testing.T) {
 }
 
 func Test_RelyingPartyCmd_ok(t *testing.T) {
+	setVerifyAsClock(t, testEARIssuedAt)
+
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
@@ -86,18 +94,212 @@ func Test_RelyingPartyCmd_ok(t *testing.T) {
 	mc.EXPECT().SetIsInsecure(false)
 	mc.EXPECT().SetCerts([]string{})
 	mc.EXPECT().SetDeleteSession(true)
-	mc.EXPECT().Run().Return([]byte("ok"), nil)
+	mc.EXPECT().Run().Return(testValidEAR, nil)
 
 	fs := afero.NewMemMapFs()
 
 	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
 	require.NoError(t, err)
 
+	err = afero.WriteFile(fs, "ear.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
 	cmd := NewRelyingPartyCmd(fs, mc)
 	cmd.SetArgs(
 		[]string{
 			"--api-server=" + testSessionURI,
 			"--token=psatoken.cbor",
+			"--ear-key=ear.jwk",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.NoError(t, err)
+}
+
+// runRelyingPartyCmd runs relying-party over testValidP2PSAToken, with the
+// verifier returning the supplied attestation result.  As in the evcli binary,
+// the other verify-as commands are built too.
+func runRelyingPartyCmd(t *testing.T, ear []byte, args ...string) error {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mock_deps.NewMockIVeraisonClient(ctrl)
+
+	mc.EXPECT().SetNonce(testNonce)
+	mc.EXPECT().SetSessionURI(testSessionURI)
+	mc.EXPECT().SetEvidenceBuilder(gomock.Any())
+	mc.EXPECT().SetIsInsecure(false)
+	mc.EXPECT().SetCerts([]string{})
+	mc.EXPECT().SetDeleteSession(true)
+	mc.EXPECT().Run().Return(ear, nil)
+
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewRelyingPartyCmd(fs, mc)
+	NewAttesterCmd(fs, attesterVeraisonClient)
+	buildCCAVerifyAsCmds()
+
+	cmd.SetArgs(
+		append([]string{
+			"--api-server=" + testSessionURI,
+			"--token=psatoken.cbor",
+			"--ear-key=ear.jwk",
+		}, args...),
+	)
+
+	return cmd.Execute()
+}
+
+func Test_RelyingPartyCmd_options_not_shared(t *testing.T) {
+	setVerifyAsClock(t, testEARIssuedAt)
+
+	err := runRelyingPartyCmd(t, testValidEAR, "--ear-key=other-ear.jwk")
+	assert.EqualError(t, err, "error loading EAR verification key from other-ear.jwk: open other-ear.jwk: file does not exist")
+
+	err = runRelyingPartyCmd(t, testValidEAR, "--expect=affirming,configuration=affirming")
+	var exitErr *common.ExitError
+	require.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, common.ExitCodeTrustVectorNotMet, exitErr.Code)
+
+	// the result is stale, unless the freshness window is widened
+	setVerifyAsClock(t, testEARIssuedAt.Add(time.Hour))
+
+	err = runRelyingPartyCmd(t, testValidEAR, "--max-age=2h")
+	assert.NoError(t, err)
+}
+
+func Test_RelyingPartyCmd_stale_result(t *testing.T) {
+	setVerifyAsClock(t, testEARIssuedAt.Add(time.Hour))
+
+	err := runRelyingPartyCmd(t, testValidEAR)
+	assert.EqualError(t, err, "the attestation result was issued at 2024-09-30T13:00:00Z, outside the 5m0s freshness window")
+
+	err = runRelyingPartyCmd(t, testValidEAR, "--max-age=2h")
+	assert.NoError(t, err)
+
+	err = runRelyingPartyCmd(t, testValidEAR, "--max-age=0")
+	assert.NoError(t, err)
+}
+
+func Test_RelyingPartyCmd_result_for_other_nonce(t *testing.T) {
+	otherNonce := []byte("another nonce")
+
+	ar := common.NewLocalAttestationResult(testValidP2PSAToken, otherNonce)
+	ar.AddSubmod(common.SubmodPSA, common.TrustVector{InstanceIdentity: 2})
+
+	key, err := jwk.ParseKey(testValidKey)
+	require.NoError(t, err)
+
+	ear, err := ar.Sign(key)
+	require.NoError(t, err)
+
+	err = runRelyingPartyCmd(t, ear)
+	assert.EqualError(t, err, fmt.Sprintf(
+		"the attestation result nonce %x does not match the evidence nonce %x", otherNonce, testNonce,
+	))
+}
+
+func Test_RelyingPartyCmd_expect_ok(t *testing.T) {
+	setVerifyAsClock(t, testEARIssuedAt)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mock_deps.NewMockIVeraisonClient(ctrl)
+
+	mc.EXPECT().SetNonce(testNonce)
+	mc.EXPECT().SetSessionURI(testSessionURI)
+	mc.EXPECT().SetEvidenceBuilder(gomock.Any())
+	mc.EXPECT().SetIsInsecure(false)
+	mc.EXPECT().SetCerts([]string{})
+	mc.EXPECT().SetDeleteSession(true)
+	mc.EXPECT().Run().Return(testValidEAR, nil)
+
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewRelyingPartyCmd(fs, mc)
+	cmd.SetArgs(
+		[]string{
+			"--api-server=" + testSessionURI,
+			"--token=psatoken.cbor",
+			"--ear-key=ear.jwk",
+			"--expect=affirming,instance-identity=affirming,hardware=affirming",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.NoError(t, err)
+}
+
+func Test_RelyingPartyCmd_bad_expect(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
+	require.NoError(t, err)
+
+	cmd := NewRelyingPartyCmd(fs, relyingPartyVeraisonClient)
+	cmd.SetArgs(
+		[]string{
+			"--api-server=" + testSessionURI,
+			"--token=psatoken.cbor",
+			"--expect=great",
+		},
+	)
+
+	expectedErr := `parsing expected status: unknown trust tier "great": allowed values are none, affirming, warning and contraindicated`
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
+func Test_RelyingPartyCmd_ear_key_from_verifier_ok(t *testing.T) {
+	setVerifyAsClock(t, testEARIssuedAt)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, common.VerificationWellKnownPath, r.URL.Path)
+		w.Header().Set("Content-Type", "application/vnd.veraison.discovery+json")
+		fmt.Fprintf(w, `{"ear-verification-key": %s}`, testValidKeyPub)
+	}))
+	defer srv.Close()
+
+	sessionURI := srv.URL + "/challenge-response/v1/newSession"
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mock_deps.NewMockIVeraisonClient(ctrl)
+
+	mc.EXPECT().SetNonce(testNonce)
+	mc.EXPECT().SetSessionURI(sessionURI)
+	mc.EXPECT().SetEvidenceBuilder(gomock.Any())
+	mc.EXPECT().SetIsInsecure(false)
+	mc.EXPECT().SetCerts([]string{})
+	mc.EXPECT().SetDeleteSession(true)
+	mc.EXPECT().Run().Return(testValidEAR, nil)
+
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
+	require.NoError(t, err)
+
+	cmd := NewRelyingPartyCmd(fs, mc)
+	cmd.SetArgs(
+		[]string{
+			"--api-server=" + sessionURI,
+			"--token=psatoken.cbor",
 		},
 	)
 
@@ -105,6 +307,78 @@ func Test_RelyingPartyCmd_ok(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func Test_RelyingPartyCmd_ear_bad_signature(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mock_deps.NewMockIVeraisonClient(ctrl)
+
+	// corrupt the first signature byte
+	badEAR := bytes.Replace(testValidEAR, []byte(".c5EV"), []byte(".d5EV"), 1)
+
+	mc.EXPECT().SetNonce(testNonce)
+	mc.EXPECT().SetSessionURI(testSessionURI)
+	mc.EXPECT().SetEvidenceBuilder(gomock.Any())
+	mc.EXPECT().SetIsInsecure(false)
+	mc.EXPECT().SetCerts([]string{})
+	mc.EXPECT().SetDeleteSession(true)
+	mc.EXPECT().Run().Return(badEAR, nil)
+
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testValidKeyPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewRelyingPartyCmd(fs, mc)
+	cmd.SetArgs(
+		[]string{
+			"--api-server=" + testSessionURI,
+			"--token=psatoken.cbor",
+			"--ear-key=ear.jwk",
+		},
+	)
+
+	err = cmd.Execute()
+	assert.ErrorContains(t, err, "verifying attestation result signature")
+}
+
+func Test_RelyingPartyCmd_ear_key_not_found(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mc := mock_deps.NewMockIVeraisonClient(ctrl)
+
+	mc.EXPECT().SetNonce(testNonce)
+	mc.EXPECT().SetSessionURI(testSessionURI)
+	mc.EXPECT().SetEvidenceBuilder(gomock.Any())
+	mc.EXPECT().SetIsInsecure(false)
+	mc.EXPECT().SetCerts([]string{})
+	mc.EXPECT().SetDeleteSession(true)
+	mc.EXPECT().Run().Return(testValidEAR, nil)
+
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psatoken.cbor", testValidP2PSAToken, 0644)
+	require.NoError(t, err)
+
+	cmd := NewRelyingPartyCmd(fs, mc)
+	cmd.SetArgs(
+		[]string{
+			"--api-server=" + testSessionURI,
+			"--token=psatoken.cbor",
+			"--ear-key=ear.jwk",
+		},
+	)
+
+	expectedErr := `error loading EAR verification key from ear.jwk: open ear.jwk: file does not exist`
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
 func Test_RelyingPartyCmd_protocol_run_failed(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -4,20 +4,26 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 
 	"github.com/spf13/cobra"
 	"github.com/veraison/evcli/v2/cmd/cca"
+	"github.com/veraison/evcli/v2/cmd/keys"
+	"github.com/veraison/evcli/v2/cmd/policy"
+	"github.com/veraison/evcli/v2/cmd/provision"
 	"github.com/veraison/evcli/v2/cmd/psa"
+	"github.com/veraison/evcli/v2/cmd/serve"
+	"github.com/veraison/evcli/v2/common"
 
 	"github.com/spf13/viper"
 )
 
 var (
 	cfgFile   string
-	validArgs = []string{"psa", "cca"}
+	validArgs = []string{"psa", "cca", "keys", "serve", "provision", "policy"}
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -31,7 +37,15 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute() {
-	cobra.CheckErr(rootCmd.Execute())
+	err := rootCmd.Execute()
+
+	var exitErr *common.ExitError
+	if errors.As(err, &exitErr) {
+		fmt.Fprintln(os.Stderr, "Error:", exitErr)
+		os.Exit(exitErr.Code)
+	}
+
+	cobra.CheckErr(err)
 }
 
 func init() {
@@ -41,6 +55,10 @@ func init() {
 
 	rootCmd.AddCommand(psa.Cmd)
 	rootCmd.AddCommand(cca.Cmd)
+	rootCmd.AddCommand(keys.Cmd)
+	rootCmd.AddCommand(serve.Cmd)
+	rootCmd.AddCommand(provision.Cmd)
+	rootCmd.AddCommand(policy.Cmd)
 }
 
 // initConfig reads in config file and ENV variables if set
@@ -0,0 +1,418 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package serve
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/veraison/apiclient/verification"
+	"github.com/veraison/ccatoken"
+	"github.com/veraison/evcli/v2/cmd/cca"
+	"github.com/veraison/evcli/v2/cmd/psa"
+	"github.com/veraison/evcli/v2/common"
+	"github.com/veraison/psatoken"
+)
+
+const (
+	newSessionPath     = "/challenge-response/v1/newSession"
+	sessionPathPrefix  = "/challenge-response/v1/session/"
+	sessionMediaType   = "application/vnd.veraison.challenge-response-session+json"
+	discoveryMediaType = "application/vnd.veraison.discovery+json"
+
+	sessionTTL = 2 * time.Minute
+	minNonceSz = 8
+	maxNonceSz = 64
+)
+
+// mockVerifier is an in-memory stand-in for the Veraison challenge-response
+// API.  Evidence is verified against the configured keys, and the outcome is
+// returned as an EAR signed with earKey.
+type mockVerifier struct {
+	NonceSz uint
+	PSAKey  crypto.PublicKey
+	CCAKey  crypto.PublicKey
+	EARKey  jwk.Key
+
+	mu       sync.Mutex
+	sessions map[string]*mockSession
+	now      func() time.Time
+}
+
+// mockSession is a challenge-response session, which is forgotten once expired
+type mockSession struct {
+	verification.ChallengeResponseSession
+	expiry time.Time
+}
+
+func newMockVerifier(nonceSz uint, psaKey, ccaKey crypto.PublicKey, earKey jwk.Key) *mockVerifier {
+	return &mockVerifier{
+		NonceSz:  nonceSz,
+		PSAKey:   psaKey,
+		CCAKey:   ccaKey,
+		EARKey:   earKey,
+		sessions: make(map[string]*mockSession),
+		now:      time.Now,
+	}
+}
+
+// Handler returns the HTTP handler implementing the API endpoints
+func (o *mockVerifier) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET "+common.VerificationWellKnownPath, o.discovery)
+	mux.HandleFunc("POST "+newSessionPath, o.newSession)
+	mux.HandleFunc("GET "+sessionPathPrefix+"{id}", o.getSession)
+	mux.HandleFunc("POST "+sessionPathPrefix+"{id}", o.submitEvidence)
+	mux.HandleFunc("DELETE "+sessionPathPrefix+"{id}", o.deleteSession)
+
+	return mux
+}
+
+func (o *mockVerifier) mediaTypes() []string {
+	var mts []string
+
+	if o.PSAKey != nil {
+		mts = append(mts, psa.PSATokenMediaType)
+	}
+
+	if o.CCAKey != nil {
+		mts = append(mts, cca.CCATokenMediaType)
+	}
+
+	return mts
+}
+
+func (o *mockVerifier) discovery(w http.ResponseWriter, r *http.Request) {
+	pub, err := jwk.PublicKeyOf(o.EARKey)
+	if err != nil {
+		common.WriteProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	info := map[string]any{
+		"ear-verification-key": pub,
+		"media-types":          o.mediaTypes(),
+		"version":              common.LocalVerifierID.Build,
+		"service-state":        "READY",
+		"api-endpoints": map[string]string{
+			"newChallengeResponseSession": newSessionPath,
+		},
+	}
+
+	writeJSON(w, http.StatusOK, discoveryMediaType, info)
+}
+
+func (o *mockVerifier) newSession(w http.ResponseWriter, r *http.Request) {
+	nonce, err := o.sessionNonce(r)
+	if err != nil {
+		common.WriteProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		common.WriteProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	expiry := o.now().Add(sessionTTL)
+
+	s := &mockSession{
+		ChallengeResponseSession: verification.ChallengeResponseSession{
+			Nonce:  nonce,
+			Expiry: expiry.UTC().Format(time.RFC3339),
+			Accept: o.mediaTypes(),
+			Status: "waiting",
+		},
+		expiry: expiry,
+	}
+
+	sid := hex.EncodeToString(id[:])
+
+	o.mu.Lock()
+	o.pruneSessions()
+	o.sessions[sid] = s
+	o.mu.Unlock()
+
+	w.Header().Set("Location", "session/"+sid)
+	writeJSON(w, http.StatusCreated, sessionMediaType, s)
+}
+
+// sessionNonce returns the nonce supplied by the client, or a fresh one of the
+// requested (or default) size
+func (o *mockVerifier) sessionNonce(r *http.Request) ([]byte, error) {
+	q := r.URL.Query()
+
+	if n := q.Get("nonce"); n != "" {
+		nonce, err := common.DecodeNonce(n)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkNonceSz(uint(len(nonce))); err != nil {
+			return nil, err
+		}
+		return nonce, nil
+	}
+
+	sz := o.NonceSz
+
+	if s := q.Get("nonceSize"); s != "" {
+		v, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed nonce size: %w", err)
+		}
+		sz = uint(v)
+	}
+
+	if err := checkNonceSz(sz); err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, sz)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return nonce, nil
+}
+
+func checkNonceSz(sz uint) error {
+	if sz < minNonceSz || sz > maxNonceSz {
+		return fmt.Errorf(
+			"wrong nonce length %d: allowed values are between %d and %d",
+			sz, minNonceSz, maxNonceSz,
+		)
+	}
+	return nil
+}
+
+// lookupSession returns a copy of the session in the request path, taken
+// while holding o.mu
+func (o *mockVerifier) lookupSession(w http.ResponseWriter, r *http.Request) (mockSession, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	s := o.sessionLocked(w, r)
+	if s == nil {
+		return mockSession{}, false
+	}
+
+	return *s, true
+}
+
+// sessionLocked returns the session in the request path.  Expired sessions are
+// deleted and reported as not found.  The caller holds o.mu.
+func (o *mockVerifier) sessionLocked(w http.ResponseWriter, r *http.Request) *mockSession {
+	sid := r.PathValue("id")
+
+	s, ok := o.sessions[sid]
+	if !ok {
+		common.WriteProblem(w, http.StatusNotFound, "session not found")
+		return nil
+	}
+
+	if !o.now().Before(s.expiry) {
+		delete(o.sessions, sid)
+		common.WriteProblem(w, http.StatusNotFound, "session expired")
+		return nil
+	}
+
+	return s
+}
+
+// pruneSessions deletes the expired sessions, so that those abandoned by their
+// clients do not pile up.  The caller holds o.mu.
+func (o *mockVerifier) pruneSessions() {
+	now := o.now()
+
+	for sid, s := range o.sessions {
+		if !now.Before(s.expiry) {
+			delete(o.sessions, sid)
+		}
+	}
+}
+
+func (o *mockVerifier) getSession(w http.ResponseWriter, r *http.Request) {
+	s, ok := o.lookupSession(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sessionMediaType, s)
+}
+
+func (o *mockVerifier) deleteSession(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	delete(o.sessions, r.PathValue("id"))
+	o.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (o *mockVerifier) submitEvidence(w http.ResponseWriter, r *http.Request) {
+	evidence, err := readBody(r)
+	if err != nil {
+		common.WriteProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	mt := r.Header.Get("Content-Type")
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	s := o.sessionLocked(w, r)
+	if s == nil {
+		return
+	}
+
+	if s.Status != "waiting" {
+		common.WriteProblem(w, http.StatusBadRequest, fmt.Sprintf("session is in state %q", s.Status))
+		return
+	}
+
+	var ar *common.AttestationResult
+
+	switch {
+	case mt == psa.PSATokenMediaType && o.PSAKey != nil:
+		ar, err = o.appraisePSA(evidence, s.Nonce)
+	case mt == cca.CCATokenMediaType && o.CCAKey != nil:
+		ar, err = o.appraiseCCA(evidence, s.Nonce)
+	default:
+		common.WriteProblem(w, http.StatusUnsupportedMediaType,
+			fmt.Sprintf("unsupported evidence media type %q", mt))
+		return
+	}
+
+	if err != nil {
+		common.WriteProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	jwt, err := ar.Sign(o.EARKey)
+	if err != nil {
+		common.WriteProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	result, err := json.Marshal(string(jwt))
+	if err != nil {
+		common.WriteProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.Evidence = verification.Blob{Type: mt, Value: evidence}
+	s.Result = result
+	s.Status = "complete"
+
+	writeJSON(w, http.StatusOK, sessionMediaType, s)
+}
+
+func (o *mockVerifier) appraisePSA(evidence, nonce []byte) (*common.AttestationResult, error) {
+	e, err := psatoken.DecodeAndValidateEvidenceFromCOSE(evidence)
+	if err != nil {
+		return nil, fmt.Errorf("decoding PSA evidence: %w", err)
+	}
+
+	n, err := e.Claims.GetNonce()
+	if err != nil {
+		return nil, fmt.Errorf("extracting nonce from PSA evidence: %w", err)
+	}
+
+	if !bytes.Equal(n, nonce) {
+		return nil, fmt.Errorf("expecting nonce %x, got %x", nonce, n)
+	}
+
+	return o.attestationResult(evidence, nonce, submodResult{common.SubmodPSA, e.Verify(o.PSAKey)}), nil
+}
+
+func (o *mockVerifier) appraiseCCA(evidence, nonce []byte) (*common.AttestationResult, error) {
+	e, err := ccatoken.DecodeAndValidateEvidenceFromCBOR(evidence)
+	if err != nil {
+		return nil, fmt.Errorf("decoding CCA evidence: %w", err)
+	}
+
+	c, err := e.RealmClaims.GetChallenge()
+	if err != nil {
+		return nil, fmt.Errorf("extracting challenge from CCA evidence: %w", err)
+	}
+
+	if !bytes.Equal(c, nonce) {
+		return nil, fmt.Errorf("expecting challenge %x, got %x", nonce, c)
+	}
+
+	platformErr, realmErr := common.VerifyCCAEvidence(e, evidence, o.CCAKey)
+
+	return o.attestationResult(
+		evidence, nonce,
+		submodResult{common.SubmodCCAPlatform, platformErr},
+		submodResult{common.SubmodCCARealm, realmErr},
+	), nil
+}
+
+// submodResult is the outcome of the verification of the part of the evidence
+// appraised in a submod
+type submodResult struct {
+	submod    string
+	verifyErr error
+}
+
+// attestationResult builds the EAR for the supplied evidence, with one submod
+// per result.  Successfully verified evidence is affirmed on both instance
+// identity and hardware; there are no reference values to appraise the other
+// aspects against.
+func (o *mockVerifier) attestationResult(
+	evidence, nonce []byte, results ...submodResult,
+) *common.AttestationResult {
+	ar := common.NewLocalAttestationResult(evidence, nonce)
+	ar.IssuedAt = o.now().Unix()
+
+	for _, res := range results {
+		if res.verifyErr != nil {
+			log.Printf("%s evidence verification failed: %v", res.submod, res.verifyErr)
+		}
+
+		ar.AddSubmod(res.submod, common.SignatureTrustVector(res.verifyErr))
+	}
+
+	return ar
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		return nil, fmt.Errorf("reading request body: %w", err)
+	}
+
+	if buf.Len() == 0 {
+		return nil, errors.New("empty request body")
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, mt string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		common.WriteProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", mt)
+	w.WriteHeader(status)
+	w.Write(data) // nolint: errcheck
+}
@@ -0,0 +1,318 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package serve
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veraison/apiclient/verification"
+	"github.com/veraison/evcli/v2/cmd/cca"
+	"github.com/veraison/evcli/v2/cmd/psa"
+	"github.com/veraison/evcli/v2/common"
+)
+
+type staticEvidenceBuilder struct {
+	Evidence  []byte
+	MediaType string
+}
+
+func (o staticEvidenceBuilder) BuildEvidence(nonce []byte, accept []string) ([]byte, string, error) {
+	return o.Evidence, o.MediaType, nil
+}
+
+func mustPubKey(t *testing.T, j []byte) crypto.PublicKey {
+	pk, err := common.PubKeyFromJWK(j)
+	require.NoError(t, err)
+	return pk
+}
+
+func newTestVerifier(t *testing.T, psaKey, ccaKey []byte) *httptest.Server {
+	earKey, err := jwk.ParseKey(testEARKey)
+	require.NoError(t, err)
+
+	var psaPK, ccaPK crypto.PublicKey
+	if psaKey != nil {
+		psaPK = mustPubKey(t, psaKey)
+	}
+	if ccaKey != nil {
+		ccaPK = mustPubKey(t, ccaKey)
+	}
+
+	v := newMockVerifier(32, psaPK, ccaPK, earKey)
+
+	return httptest.NewServer(v.Handler())
+}
+
+func runSession(srvURL string, nonce []byte, eb verification.EvidenceBuilder) ([]byte, error) {
+	cfg := verification.ChallengeResponseConfig{}
+
+	if err := cfg.SetNonce(nonce); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.SetSessionURI(srvURL + newSessionPath); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.SetEvidenceBuilder(eb); err != nil {
+		return nil, err
+	}
+
+	cfg.SetDeleteSession(true)
+
+	return cfg.Run()
+}
+
+func Test_mockVerifier_PSA_ok(t *testing.T) {
+	srv := newTestVerifier(t, testPSAKeyPub, nil)
+	defer srv.Close()
+
+	res, err := runSession(srv.URL, testPSANonce,
+		staticEvidenceBuilder{Evidence: testPSAToken, MediaType: psa.PSATokenMediaType})
+	require.NoError(t, err)
+
+	key, err := common.FetchEARVerificationKey(srv.URL+newSessionPath, false, nil)
+	require.NoError(t, err)
+
+	ar, err := common.DecodeAndVerifyAttestationResult(res, key)
+	require.NoError(t, err)
+
+	assert.Equal(t, "AAECAwABAgMAAQIDAAECAwABAgMAAQIDAAECAwABAgM", ar.Nonce)
+	require.Contains(t, ar.Submods, common.SubmodPSA)
+	assert.Equal(t, common.TrustTierAffirming, ar.Submods[common.SubmodPSA].Status)
+	assert.Equal(t, common.TrustClaim(2), ar.Submods[common.SubmodPSA].TrustVector.InstanceIdentity)
+}
+
+func Test_mockVerifier_CCA_ok(t *testing.T) {
+	srv := newTestVerifier(t, nil, testCCAKeyPub)
+	defer srv.Close()
+
+	res, err := runSession(srv.URL, testCCANonce,
+		staticEvidenceBuilder{Evidence: testCCAToken, MediaType: cca.CCATokenMediaType})
+	require.NoError(t, err)
+
+	key, err := common.EARVerificationKeyFromJWK(testEARKeyPub)
+	require.NoError(t, err)
+
+	ar, err := common.DecodeAndVerifyAttestationResult(res, key)
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{common.SubmodCCAPlatform, common.SubmodCCARealm}, ar.SubmodNames())
+	assert.Equal(t, common.TrustTierAffirming, ar.Submods[common.SubmodCCAPlatform].Status)
+	assert.Equal(t, common.TrustTierAffirming, ar.Submods[common.SubmodCCARealm].Status)
+}
+
+// tamperCCARealmToken returns a copy of the supplied CCA token whose realm
+// token signature no longer verifies
+func tamperCCARealmToken(t *testing.T, token []byte) []byte {
+	var tag cbor.Tag
+	require.NoError(t, cbor.Unmarshal(token, &tag))
+
+	var collection map[uint64][]byte
+	raw, err := cbor.Marshal(tag.Content)
+	require.NoError(t, err)
+	require.NoError(t, cbor.Unmarshal(raw, &collection))
+
+	realm := collection[44241]
+	require.NotEmpty(t, realm)
+	realm[len(realm)-1] ^= 0xff
+
+	tag.Content = collection
+	tampered, err := cbor.Marshal(tag)
+	require.NoError(t, err)
+
+	return tampered
+}
+
+func Test_mockVerifier_CCA_bad_realm_signature(t *testing.T) {
+	srv := newTestVerifier(t, nil, testCCAKeyPub)
+	defer srv.Close()
+
+	res, err := runSession(srv.URL, testCCANonce,
+		staticEvidenceBuilder{Evidence: tamperCCARealmToken(t, testCCAToken), MediaType: cca.CCATokenMediaType})
+	require.NoError(t, err)
+
+	key, err := common.EARVerificationKeyFromJWK(testEARKeyPub)
+	require.NoError(t, err)
+
+	ar, err := common.DecodeAndVerifyAttestationResult(res, key)
+	require.NoError(t, err)
+
+	assert.Equal(t, common.TrustTierAffirming, ar.Submods[common.SubmodCCAPlatform].Status)
+	assert.Equal(t, common.TrustTierContraindicated, ar.Submods[common.SubmodCCARealm].Status)
+	assert.Equal(t, common.TrustClaim(99), ar.Submods[common.SubmodCCARealm].TrustVector.InstanceIdentity)
+}
+
+func Test_mockVerifier_CCA_wrong_key(t *testing.T) {
+	// the EAR key is not the one that signed the CCA platform token
+	srv := newTestVerifier(t, nil, testEARKeyPub)
+	defer srv.Close()
+
+	res, err := runSession(srv.URL, testCCANonce,
+		staticEvidenceBuilder{Evidence: testCCAToken, MediaType: cca.CCATokenMediaType})
+	require.NoError(t, err)
+
+	key, err := common.EARVerificationKeyFromJWK(testEARKeyPub)
+	require.NoError(t, err)
+
+	ar, err := common.DecodeAndVerifyAttestationResult(res, key)
+	require.NoError(t, err)
+
+	// the realm token is only vouched for by the platform token
+	assert.Equal(t, common.TrustTierContraindicated, ar.Submods[common.SubmodCCAPlatform].Status)
+	assert.Equal(t, common.TrustTierContraindicated, ar.Submods[common.SubmodCCARealm].Status)
+}
+
+func Test_mockVerifier_PSA_wrong_key(t *testing.T) {
+	// the EAR key is not the one that signed the PSA token
+	srv := newTestVerifier(t, testEARKeyPub, nil)
+	defer srv.Close()
+
+	res, err := runSession(srv.URL, testPSANonce,
+		staticEvidenceBuilder{Evidence: testPSAToken, MediaType: psa.PSATokenMediaType})
+	require.NoError(t, err)
+
+	key, err := common.EARVerificationKeyFromJWK(testEARKeyPub)
+	require.NoError(t, err)
+
+	ar, err := common.DecodeAndVerifyAttestationResult(res, key)
+	require.NoError(t, err)
+
+	assert.Equal(t, common.TrustTierContraindicated, ar.Submods[common.SubmodPSA].Status)
+	assert.Equal(t, common.TrustClaim(99), ar.Submods[common.SubmodPSA].TrustVector.InstanceIdentity)
+}
+
+func Test_mockVerifier_nonce_mismatch(t *testing.T) {
+	srv := newTestVerifier(t, testPSAKeyPub, nil)
+	defer srv.Close()
+
+	otherNonce := bytes.Repeat([]byte{0xff}, 32)
+
+	_, err := runSession(srv.URL, otherNonce,
+		staticEvidenceBuilder{Evidence: testPSAToken, MediaType: psa.PSATokenMediaType})
+	assert.ErrorContains(t, err, "400 Bad Request")
+}
+
+func Test_mockVerifier_unsupported_media_type(t *testing.T) {
+	srv := newTestVerifier(t, testPSAKeyPub, nil)
+	defer srv.Close()
+
+	_, err := runSession(srv.URL, testCCANonce,
+		staticEvidenceBuilder{Evidence: testCCAToken, MediaType: cca.CCATokenMediaType})
+	assert.ErrorContains(t, err, "415 Unsupported Media Type")
+}
+
+func Test_mockVerifier_newSession_nonce_size(t *testing.T) {
+	srv := newTestVerifier(t, testPSAKeyPub, testCCAKeyPub)
+	defer srv.Close()
+
+	tvs := []struct {
+		query    string
+		status   int
+		nonceLen int
+	}{
+		{"", http.StatusCreated, 32},
+		{"?nonceSize=64", http.StatusCreated, 64},
+		{"?nonceSize=4", http.StatusBadRequest, 0},
+		{"?nonceSize=x", http.StatusBadRequest, 0},
+		{"?nonce=AAECAwABAgMAAQIDAAECAw==", http.StatusCreated, 16},
+	}
+
+	for _, tv := range tvs {
+		res, err := http.Post(srv.URL+newSessionPath+tv.query, "", http.NoBody)
+		require.NoError(t, err)
+
+		assert.Equal(t, tv.status, res.StatusCode, tv.query)
+		if tv.status == http.StatusCreated {
+			s := verification.ChallengeResponseSession{}
+			err = json.NewDecoder(res.Body).Decode(&s)
+			require.NoError(t, err)
+			assert.Len(t, s.Nonce, tv.nonceLen, tv.query)
+			assert.True(t, strings.HasPrefix(res.Header.Get("Location"), "session/"))
+			assert.Equal(t, []string{psa.PSATokenMediaType, cca.CCATokenMediaType}, s.Accept)
+		}
+		res.Body.Close()
+	}
+}
+
+func Test_mockVerifier_session_not_found(t *testing.T) {
+	srv := newTestVerifier(t, testPSAKeyPub, nil)
+	defer srv.Close()
+
+	res, err := http.Get(fmt.Sprintf("%s%s%s", srv.URL, sessionPathPrefix, "1234"))
+	require.NoError(t, err)
+	res.Body.Close()
+
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Equal(t, common.ProblemMediaType, res.Header.Get("Content-Type"))
+}
+
+func Test_mockVerifier_session_expired(t *testing.T) {
+	earKey, err := jwk.ParseKey(testEARKey)
+	require.NoError(t, err)
+
+	now := time.Unix(1727701200, 0)
+
+	v := newMockVerifier(32, mustPubKey(t, testPSAKeyPub), nil, earKey)
+	v.now = func() time.Time { return now }
+
+	srv := httptest.NewServer(v.Handler())
+	defer srv.Close()
+
+	newSession := func() string {
+		res, err := http.Post(srv.URL+newSessionPath, "", http.NoBody)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		return srv.URL + "/challenge-response/v1/" + res.Header.Get("Location")
+	}
+
+	getSession := func(uri string) (int, string) {
+		res, err := http.Get(uri)
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		var p struct {
+			Detail string `json:"detail"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&p)
+
+		return res.StatusCode, p.Detail
+	}
+
+	first := newSession()
+
+	now = now.Add(sessionTTL - time.Second)
+	status, _ := getSession(first)
+	assert.Equal(t, http.StatusOK, status)
+
+	second := newSession()
+
+	now = now.Add(time.Second)
+	status, detail := getSession(first)
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "session expired", detail)
+	assert.Len(t, v.sessions, 1, "the expired session should be deleted")
+
+	// the expired second session is pruned when a new one is created
+	now = now.Add(sessionTTL)
+	newSession()
+	assert.Len(t, v.sessions, 1, "the expired sessions should be pruned")
+
+	status, detail = getSession(second)
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "session not found", detail)
+}
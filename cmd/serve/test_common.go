@@ -0,0 +1,101 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package serve
+
+import "github.com/veraison/evcli/v2/common"
+
+var (
+	// PSA profile 2 token signed with the private counterpart of
+	// testPSAKeyPub, carrying testPSANonce
+	testPSAToken = common.MustHexDecode(`
+d28443a10126a0590174a91901097818687474703a2f2f61726d2e636f6d2f7073612f32
+2e302e3019095a0119095b19300019095c58205051525354555657505152535455565750
+51525354555657505152535455565719095d5820deadbeefdeadbeefdeadbeefdeadbeef
+deadbeefdeadbeefdeadbeefdeadbeef19095f82a30162424c0258200001020400010204
+000102040001020400010204000102040001020400010204055820519200ff519200ff51
+9200ff519200ff519200ff519200ff519200ff519200ffa3016450526f54025820050607
+0805060708050607080506070805060708050607080506070805060708055820519200ff
+519200ff519200ff519200ff519200ff519200ff519200ff519200ff0a58200001020300
+010203000102030001020300010203000102030001020300010203190100582101a0a1a2
+a3a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a319096078186874
+7470733a2f2f7073612d76657269666965722e6f72675840dbb4871fbb6ebcd573502e98
+a30743291628fa5286f056f6f848c2107f59abe0f9ee034cbd68d8e7ed1d7a073fbd1039
+d9637dbde057197f5b096669ea9a2b7b
+`)
+	testPSANonce = []byte{
+		0, 1, 2, 3, 0, 1, 2, 3, 0, 1, 2, 3, 0, 1, 2, 3,
+		0, 1, 2, 3, 0, 1, 2, 3, 0, 1, 2, 3, 0, 1, 2, 3,
+	}
+	testPSAKeyPub = []byte(`{
+		"kty": "EC",
+		"crv": "P-256",
+		"x": "MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4",
+		"y": "4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM"
+	}`)
+	// CCA token whose platform token is signed with the private counterpart
+	// of testCCAKeyPub, carrying testCCANonce
+	testCCAToken = common.MustHexDecode(`
+	d9018fa219acca590192d28443a10126a0590146a9190109781c68747470
+	3a2f2f61726d2e636f6d2f4343412d5353442f312e302e300a584005e6b5
+	8844c6a0cd19382069bafdb0e494662a3adcf8fde11478e933951af1790f
+	f5de5c78e3db1123da0a207a8b66556e0a22f19ee64bdc2f89953b6b3255
+	5f19095c5820000000000000000000000000000000000000000000000000
+	000000000000000019010058210102020202020202020202020202020202
+	020202020202020202020202020202021909614301020319095b19300019
+	095f81a20258200303030303030303030303030303030303030303030303
+	030303030303030303055820040404040404040404040404040404040404
+	0404040404040404040404040404190960782e68747470733a2f2f766572
+	6169736f6e2e6578616d706c652f76312f6368616c6c656e67652d726573
+	706f6e7365190962677368612d3235365840339616282f17512b612d477c
+	7984dba0f304ddb382de043e0226ae153ffef183a0a364ef7171ec3833cc
+	1b887fce47755bdccb1bbae5d32285c6f905c8b789ec19acd15902c3d284
+	44a1013822a0590256a70a58404142414241424142414241424142414241
+	424142414241424142414241424142414241424142414241424142414241
+	424142414241424142414241424142414219accb58404144414441444144
+	414441444144414441444144414441444144414441444144414441444144
+	414441444144414441444144414441444144414441444144414419acce58
+	404343434343434343434343434343434343434343434343434343434343
+	434343434343434343434343434343434343434343434343434343434343
+	434343434319accf84584043434343434343434343434343434343434343
+	434343434343434343434343434343434343434343434343434343434343
+	434343434343434343434343434343584043434343434343434343434343
+	434343434343434343434343434343434343434343434343434343434343
+	434343434343434343434343434343434343434343584043434343434343
+	434343434343434343434343434343434343434343434343434343434343
+	434343434343434343434343434343434343434343434343434343584043
+	434343434343434343434343434343434343434343434343434343434343
+	434343434343434343434343434343434343434343434343434343434343
+	43434319accc677368612d32353619accd58610482fbd132a9b5c396879f
+	bb15340d9050978e55c79d5279a2ba0e95854f37e20cd2f64f3b72b570bb
+	d773eee2ce768425edf545edbe89ffafe0e96bbd46e270f20796c448b98d
+	af46a764d27442e6e6ed84f8cec817e6ecc6a71d3a3de7d67ecd19acd067
+	7368612d3531325860078bb77c24009613ca322a1dad08178c5d4a81dd7c
+	41cb1012993766e31204ddfa2da2884a7a2a60e6623079dbcc16da402430
+	18eb9f4a98bfcd563773c3bfe6137c53a8404d9f97d8e22246a23a364abb
+	715cc17a6a2465798cfa4cb956d049
+`)
+	testCCANonce  = []byte("ABABABABABABABABABABABABABABABABABABABABABABABABABABABABABABABAB")
+	testCCAKeyPub = []byte(`{
+		"kid": "valid-iak-pub",
+		"kty": "EC",
+		"crv": "P-256",
+		"x": "MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4",
+		"y": "4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM"
+	}`)
+	testEARKey = []byte(`{
+		"kid": "valid-rak",
+		"kty": "EC",
+		"crv": "P-384",
+		"x": "gvvRMqm1w5aHn7sVNA2QUJeOVcedUnmiug6VhU834gzS9k87crVwu9dz7uLOdoQl",
+		"y": "7fVF7b6J_6_g6Wu9RuJw8geWxEi5ja9Gp2TSdELm5u2E-M7IF-bsxqcdOj3n1n7N",
+		"d": "ODkwMTIzNDU2Nzg5MDEyMz7deMbyLt8g4cjcxozuIoygLLlAeoQ1AfM9TSvxkFHJ"
+	}`)
+	testEARKeyPub = []byte(`{
+		"kid": "valid-rak-pub",
+		"kty": "EC",
+		"crv": "P-384",
+		"x": "gvvRMqm1w5aHn7sVNA2QUJeOVcedUnmiug6VhU834gzS9k87crVwu9dz7uLOdoQl",
+		"y": "7fVF7b6J_6_g6Wu9RuJw8geWxEi5ja9Gp2TSdELm5u2E-M7IF-bsxqcdOj3n1n7N"
+	}`)
+)
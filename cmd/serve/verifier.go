@@ -0,0 +1,149 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package serve
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/veraison/evcli/v2/common"
+)
+
+var (
+	verifierListenAddr *string
+	verifierNonceSz    *uint
+	verifierPSAKeyFile *string
+	verifierCCAKeyFile *string
+	verifierEARKeyFile *string
+	verifierKeyFormat  *string
+)
+
+var verifierCmd = NewVerifierCmd(common.Fs, http.ListenAndServe)
+
+// NewVerifierCmd creates the "serve verifier" command.  The listen function is
+// used to start the HTTP server, which allows tests to run the command without
+// binding a socket.
+func NewVerifierCmd(fs afero.Fs, listen func(string, http.Handler) error) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verifier",
+		Short: "run a mock Veraison verification service",
+		Long: `Run a local stand-in for the Veraison challenge-response API.
+
+Evidence submitted by PSA and CCA attesters is verified using the supplied
+public IAKs, and the outcome is returned as an EAT Attestation Result (EAR)
+signed with the supplied private key.  If no EAR signing key is supplied, an
+ephemeral P-256 key is generated.  The public EAR verification key is published
+at the verifier's /.well-known/veraison/verification endpoint.
+
+	evcli serve verifier \
+	              --listen=localhost:8080 \
+	              --psa-key=psa-iak-pub.jwk \
+	              --cca-key=cca-iak-pub.jwk \
+	              --ear-key=ear.jwk
+
+The verifier can then be used by the "verify-as" commands:
+
+	evcli psa verify-as attester \
+	              --api-server=http://localhost:8080/challenge-response/v1/newSession \
+	              --claims=claims.json \
+	              --key=psa-iak.jwk
+	`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if *verifierPSAKeyFile == "" && *verifierCCAKeyFile == "" {
+				return errors.New("at least one of --psa-key or --cca-key must be supplied")
+			}
+
+			if err := checkNonceSz(*verifierNonceSz); err != nil {
+				return err
+			}
+
+			psaKey, err := loadPubKey(fs, *verifierPSAKeyFile, "PSA")
+			if err != nil {
+				return err
+			}
+
+			ccaKey, err := loadPubKey(fs, *verifierCCAKeyFile, "CCA")
+			if err != nil {
+				return err
+			}
+
+			earKey, err := loadEARSigningKey(fs, *verifierEARKeyFile)
+			if err != nil {
+				return err
+			}
+
+			v := newMockVerifier(*verifierNonceSz, psaKey, ccaKey, earKey)
+
+			fmt.Printf(">> mock verifier listening on http://%s%s\n",
+				*verifierListenAddr, newSessionPath)
+
+			return listen(*verifierListenAddr, v.Handler())
+		},
+	}
+
+	verifierListenAddr = cmd.Flags().StringP(
+		"listen", "l", "localhost:8080", "address the verification service listens on",
+	)
+
+	verifierNonceSz = cmd.Flags().UintP(
+		"nonce-size", "n", 32,
+		"size of the nonces issued when the client does not request a specific size",
+	)
+
+	verifierPSAKeyFile = cmd.Flags().StringP(
+		"psa-key", "P", "", "file with the public IAK used to verify PSA attestation tokens",
+	)
+
+	verifierCCAKeyFile = cmd.Flags().StringP(
+		"cca-key", "C", "", "file with the public IAK used to verify CCA attestation tokens",
+	)
+
+	verifierEARKeyFile = cmd.Flags().StringP(
+		"ear-key", "K", "", "file with the private key used to sign attestation results",
+	)
+
+	verifierKeyFormat = cmd.Flags().String(
+		"key-format", common.KeyFormatAuto, common.KeyFormatFlagUsage,
+	)
+
+	return cmd
+}
+
+func loadPubKey(fs afero.Fs, fn, scheme string) (crypto.PublicKey, error) {
+	if fn == "" {
+		return nil, nil
+	}
+
+	key, err := afero.ReadFile(fs, fn)
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s verification key from %s: %w", scheme, fn, err)
+	}
+
+	pk, err := common.PubKeyFromKey(key, *verifierKeyFormat)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s verification key from %s: %w", scheme, fn, err)
+	}
+
+	return pk, nil
+}
+
+func loadEARSigningKey(fs afero.Fs, fn string) (jwk.Key, error) {
+	if fn == "" {
+		sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("error generating EAR signing key: %w", err)
+		}
+		return jwk.FromRaw(sk)
+	}
+
+	return common.LoadEARSigningKey(fs, fn, *verifierKeyFormat)
+}
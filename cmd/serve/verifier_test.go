@@ -0,0 +1,114 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package serve
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veraison/evcli/v2/common"
+)
+
+func noListen(string, http.Handler) error {
+	return errors.New("unexpected listen")
+}
+
+func Test_VerifierCmd_no_keys(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	cmd := NewVerifierCmd(fs, noListen)
+	cmd.SetArgs([]string{"--ear-key=ear.jwk"})
+
+	expectedErr := `at least one of --psa-key or --cca-key must be supplied`
+
+	err := cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
+func Test_VerifierCmd_bad_nonce_size(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	cmd := NewVerifierCmd(fs, noListen)
+	cmd.SetArgs([]string{"--psa-key=psa.jwk", "--nonce-size=65"})
+
+	expectedErr := `wrong nonce length 65: allowed values are between 8 and 64`
+
+	err := cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
+func Test_VerifierCmd_key_not_found(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	cmd := NewVerifierCmd(fs, noListen)
+	cmd.SetArgs([]string{"--cca-key=cca.jwk"})
+
+	expectedErr := `error loading CCA verification key from cca.jwk: open cca.jwk: file does not exist`
+
+	err := cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
+func Test_VerifierCmd_ear_key_not_private(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psa.jwk", testPSAKeyPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "ear.jwk", testEARKeyPub, 0644)
+	require.NoError(t, err)
+
+	cmd := NewVerifierCmd(fs, noListen)
+	cmd.SetArgs([]string{"--psa-key=psa.jwk", "--ear-key=ear.jwk"})
+
+	expectedErr := `error decoding EAR signing key from ear.jwk: not a private key`
+
+	err = cmd.Execute()
+	assert.EqualError(t, err, expectedErr)
+}
+
+func Test_VerifierCmd_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "psa.jwk", testPSAKeyPub, 0644)
+	require.NoError(t, err)
+
+	err = afero.WriteFile(fs, "cca.jwk", testCCAKeyPub, 0644)
+	require.NoError(t, err)
+
+	var (
+		addr    string
+		handler http.Handler
+	)
+
+	listen := func(a string, h http.Handler) error {
+		addr, handler = a, h
+		return nil
+	}
+
+	cmd := NewVerifierCmd(fs, listen)
+	cmd.SetArgs([]string{
+		"--listen=127.0.0.1:9090",
+		"--psa-key=psa.jwk",
+		"--cca-key=cca.jwk",
+	})
+
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	assert.Equal(t, "127.0.0.1:9090", addr)
+	require.NotNil(t, handler)
+
+	// without --ear-key an ephemeral key is generated and published
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	_, err = common.FetchEARVerificationKey(srv.URL+newSessionPath, false, nil)
+	assert.NoError(t, err)
+}
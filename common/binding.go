@@ -0,0 +1,93 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultResultMaxAge is the default freshness window of the attestation
+// results returned by the verifier
+const DefaultResultMaxAge = 5 * time.Minute
+
+// ResultBinding describes the evidence an attestation result is expected to
+// refer to
+type ResultBinding struct {
+	// Nonce is the nonce, or challenge, of the submitted evidence
+	Nonce []byte
+	// Submods are the names of the submods the verifier can produce for the
+	// attestation scheme of the evidence
+	Submods []string
+	// MaxAge is the maximum distance between the time the attestation result
+	// was issued and the current time.  Zero disables the check.
+	MaxAge time.Duration
+	// CurrentTime is the time against which the freshness of the attestation
+	// result is checked.  If zero, the current time is used.
+	CurrentTime time.Time
+}
+
+// Check verifies that the attestation result is bound to the evidence, i.e.,
+// that it carries the nonce of the evidence, that it is fresh and that its
+// submods belong to the attestation scheme of the evidence
+func (o ResultBinding) Check(ar *AttestationResult) error {
+	if o.Nonce != nil {
+		if ar.Nonce == "" {
+			return errors.New("the attestation result has no nonce")
+		}
+
+		nonce, err := DecodeNonce(ar.Nonce)
+		if err != nil {
+			return fmt.Errorf("decoding the attestation result nonce: %w", err)
+		}
+
+		if !bytes.Equal(nonce, o.Nonce) {
+			return fmt.Errorf(
+				"the attestation result nonce %x does not match the evidence nonce %x",
+				nonce, o.Nonce,
+			)
+		}
+	}
+
+	if o.MaxAge != 0 {
+		now := o.CurrentTime
+		if now.IsZero() {
+			now = time.Now()
+		}
+
+		iat := time.Unix(ar.IssuedAt, 0)
+
+		if age := now.Sub(iat); age > o.MaxAge || age < -o.MaxAge {
+			return fmt.Errorf(
+				"the attestation result was issued at %s, outside the %s freshness window",
+				iat.UTC().Format(time.RFC3339), o.MaxAge,
+			)
+		}
+	}
+
+	if len(o.Submods) > 0 {
+		for _, name := range ar.SubmodNames() {
+			if !o.expectsSubmod(name) {
+				return fmt.Errorf(
+					"unexpected submod %q in the attestation result (expecting %s)",
+					name, strings.Join(o.Submods, " or "),
+				)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (o ResultBinding) expectsSubmod(name string) bool {
+	for _, s := range o.Submods {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
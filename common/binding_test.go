@@ -0,0 +1,91 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testBindingIssuedAt = time.Unix(1727701200, 0)
+
+func newTestBoundResult() *AttestationResult {
+	return &AttestationResult{
+		Profile:  EARProfile,
+		IssuedAt: testBindingIssuedAt.Unix(),
+		Nonce:    "AAECAw",
+		Submods: map[string]Appraisal{
+			SubmodCCAPlatform: {Status: TrustTierAffirming},
+			SubmodCCARealm:    {Status: TrustTierAffirming},
+		},
+	}
+}
+
+func newTestBinding() ResultBinding {
+	return ResultBinding{
+		Nonce:       []byte{0, 1, 2, 3},
+		Submods:     []string{SubmodCCAPlatform, SubmodCCARealm},
+		MaxAge:      time.Minute,
+		CurrentTime: testBindingIssuedAt.Add(30 * time.Second),
+	}
+}
+
+func Test_ResultBinding_Check_ok(t *testing.T) {
+	assert.NoError(t, newTestBinding().Check(newTestBoundResult()))
+}
+
+func Test_ResultBinding_Check_padded_std_nonce(t *testing.T) {
+	ar := newTestBoundResult()
+	ar.Nonce = "+/8="
+
+	b := newTestBinding()
+	b.Nonce = []byte{0xfb, 0xff}
+
+	assert.NoError(t, b.Check(ar))
+}
+
+func Test_ResultBinding_Check_nonce_mismatch(t *testing.T) {
+	ar := newTestBoundResult()
+	ar.Nonce = "AAECBA"
+
+	err := newTestBinding().Check(ar)
+	assert.EqualError(t, err, "the attestation result nonce 00010204 does not match the evidence nonce 00010203")
+}
+
+func Test_ResultBinding_Check_no_nonce(t *testing.T) {
+	ar := newTestBoundResult()
+	ar.Nonce = ""
+
+	err := newTestBinding().Check(ar)
+	assert.EqualError(t, err, "the attestation result has no nonce")
+}
+
+func Test_ResultBinding_Check_stale(t *testing.T) {
+	b := newTestBinding()
+	b.CurrentTime = testBindingIssuedAt.Add(2 * time.Minute)
+
+	err := b.Check(newTestBoundResult())
+	assert.EqualError(t, err, "the attestation result was issued at 2024-09-30T13:00:00Z, outside the 1m0s freshness window")
+
+	b.MaxAge = 0
+	assert.NoError(t, b.Check(newTestBoundResult()))
+}
+
+func Test_ResultBinding_Check_issued_in_the_future(t *testing.T) {
+	b := newTestBinding()
+	b.CurrentTime = testBindingIssuedAt.Add(-2 * time.Minute)
+
+	err := b.Check(newTestBoundResult())
+	assert.ErrorContains(t, err, "outside the 1m0s freshness window")
+}
+
+func Test_ResultBinding_Check_unexpected_submod(t *testing.T) {
+	b := newTestBinding()
+	b.Submods = []string{SubmodPSA}
+
+	err := b.Check(newTestBoundResult())
+	assert.EqualError(t, err, `unexpected submod "CCA_REALM" in the attestation result (expecting PSA_IOT)`)
+}
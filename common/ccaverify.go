@@ -0,0 +1,71 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto"
+	"fmt"
+
+	"github.com/veraison/ccatoken"
+	cose "github.com/veraison/go-cose"
+)
+
+// RealmSignatureError reports the failure of the verification of the realm
+// token of a CCA token whose platform token has been verified
+type RealmSignatureError struct {
+	Err error
+}
+
+func (o *RealmSignatureError) Error() string {
+	return o.Err.Error()
+}
+
+func (o *RealmSignatureError) Unwrap() error {
+	return o.Err
+}
+
+// VerifyCCAEvidence verifies the CCA token, decoded in e, with the supplied IAK
+// and returns the outcome of the verification of its platform token and of its
+// realm token.  The realm token is only vouched for by the platform token: if
+// the platform token fails verification, so does the realm token.
+func VerifyCCAEvidence(e *ccatoken.Evidence, token []byte, iak crypto.PublicKey) (platformErr, realmErr error) {
+	if err := verifyCCAPlatformToken(token, iak); err != nil {
+		err = fmt.Errorf("unable to verify platform token: %w", err)
+		return err, err
+	}
+
+	// the platform token is verified again, along with the realm token and
+	// the binding between the two
+	if err := e.Verify(iak); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func verifyCCAPlatformToken(token []byte, iak crypto.PublicKey) error {
+	platformToken, err := PlatformTokenFromCCAToken(token)
+	if err != nil {
+		return err
+	}
+
+	// the errors are those of ccatoken's own verification of the token
+	var msg cose.Sign1Message
+
+	if err = msg.UnmarshalCBOR(platformToken); err != nil {
+		return fmt.Errorf("failed CBOR decoding for CWT: %w", err)
+	}
+
+	alg, err := msg.Headers.Protected.Algorithm()
+	if err != nil {
+		return fmt.Errorf("unable to get verification algorithm: %w", err)
+	}
+
+	verifier, err := cose.NewVerifier(alg, iak)
+	if err != nil {
+		return fmt.Errorf("unable to instantiate verifier: %w", err)
+	}
+
+	return msg.Verify(nil, verifier)
+}
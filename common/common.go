@@ -6,14 +6,18 @@ package common
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
+	"crypto/rsa"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"reflect"
 	"regexp"
 	"strings"
 
+	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/spf13/afero"
 	cose "github.com/veraison/go-cose"
@@ -21,44 +25,126 @@ import (
 
 var Fs = afero.NewOsFs()
 
-func getAlgAndKeyFromJWK(rawJWK []byte) (cose.Algorithm, crypto.Signer, error) {
+func getAlgAndKey(rawKey []byte, format string) (cose.Algorithm, crypto.Signer, error) {
 	var (
 		alg  cose.Algorithm
 		sKey crypto.Signer
 	)
 
-	key, err := jwk.ParseKey(rawJWK)
+	key, err := ParseKey(rawKey, format)
 	if err != nil {
-		return alg, sKey, fmt.Errorf("failed to parse key: %w", err)
+		return alg, sKey, err
 	}
 
 	if err := key.Raw(&sKey); err != nil {
 		return alg, sKey, fmt.Errorf("failed to create key: %w", err)
 	}
 
-	switch v := sKey.(type) {
-	case *ecdsa.PrivateKey:
-		crv := v.Curve
-		if crv == elliptic.P256() {
-			alg = cose.AlgorithmES256
-			break
+	alg, err = coseAlgorithmForKey(key, sKey.Public())
+	if err != nil {
+		return alg, sKey, err
+	}
+
+	return alg, sKey, nil
+}
+
+// jwaToCOSE maps the JWS algorithms that can be used to sign and verify
+// attestation tokens to their COSE counterparts
+var jwaToCOSE = map[jwa.SignatureAlgorithm]cose.Algorithm{
+	jwa.ES256: cose.AlgorithmES256,
+	jwa.ES384: cose.AlgorithmES384,
+	jwa.ES512: cose.AlgorithmES512,
+	jwa.EdDSA: cose.AlgorithmEdDSA,
+	jwa.PS256: cose.AlgorithmPS256,
+	jwa.PS384: cose.AlgorithmPS384,
+	jwa.PS512: cose.AlgorithmPS512,
+}
+
+// coseAlgorithmForKey returns the COSE signature algorithm to use with the
+// supplied key.  If the JWK has an "alg" member, it is used, provided that it
+// is consistent with the key type.  Otherwise, the algorithm is inferred from
+// the key: ECDSA keys use the algorithm matching their curve, Ed25519 keys
+// use EdDSA and RSA keys use PS256.
+func coseAlgorithmForKey(key jwk.Key, pub crypto.PublicKey) (cose.Algorithm, error) {
+	var alg cose.Algorithm
+
+	if ka := key.Algorithm(); ka != nil && ka.String() != "" {
+		var ok bool
+
+		alg, ok = jwaToCOSE[jwa.SignatureAlgorithm(ka.String())]
+		if !ok {
+			return alg, fmt.Errorf("unsupported JWK algorithm %s", ka)
 		}
-		if crv == elliptic.P384() {
-			alg = cose.AlgorithmES384
-			break
+
+		if err := checkAlgMatchesKey(alg, pub); err != nil {
+			return alg, fmt.Errorf("JWK algorithm %s: %w", ka, err)
 		}
-		return alg, sKey, fmt.Errorf("unknown elliptic curve %v", crv)
-	default:
-		return alg, sKey, fmt.Errorf("unknown private key type %v", reflect.TypeOf(key))
+
+		return alg, nil
 	}
 
-	return alg, sKey, nil
+	switch v := pub.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsaCOSEAlgorithm(v.Curve)
+	case ed25519.PublicKey:
+		return cose.AlgorithmEdDSA, nil
+	case *rsa.PublicKey:
+		return cose.AlgorithmPS256, nil
+	}
+
+	return alg, fmt.Errorf("unknown private key type %v", reflect.TypeOf(pub))
+}
+
+func ecdsaCOSEAlgorithm(crv elliptic.Curve) (cose.Algorithm, error) {
+	switch crv {
+	case elliptic.P256():
+		return cose.AlgorithmES256, nil
+	case elliptic.P384():
+		return cose.AlgorithmES384, nil
+	case elliptic.P521():
+		return cose.AlgorithmES512, nil
+	}
+
+	return cose.AlgorithmReserved, fmt.Errorf("unknown elliptic curve %v", crv.Params().Name)
+}
+
+func checkAlgMatchesKey(alg cose.Algorithm, pub crypto.PublicKey) error {
+	switch v := pub.(type) {
+	case *ecdsa.PublicKey:
+		crvAlg, err := ecdsaCOSEAlgorithm(v.Curve)
+		if err != nil {
+			return err
+		}
+		if crvAlg != alg {
+			return fmt.Errorf("cannot be used with curve %s", v.Curve.Params().Name)
+		}
+		return nil
+	case ed25519.PublicKey:
+		if alg != cose.AlgorithmEdDSA {
+			return errors.New("cannot be used with an Ed25519 key")
+		}
+		return nil
+	case *rsa.PublicKey:
+		switch alg {
+		case cose.AlgorithmPS256, cose.AlgorithmPS384, cose.AlgorithmPS512:
+			return nil
+		}
+		return errors.New("cannot be used with an RSA key")
+	}
+
+	return fmt.Errorf("unknown key type %v", reflect.TypeOf(pub))
 }
 
 // SignerFromJWK creates a go-cose Signer object from the supplied JSON Web Key
 // (JWK) description
 func SignerFromJWK(rawJWK []byte) (cose.Signer, error) {
-	alg, key, err := getAlgAndKeyFromJWK(rawJWK)
+	return SignerFromKey(rawJWK, KeyFormatJWK)
+}
+
+// SignerFromKey creates a go-cose Signer object from the supplied private key,
+// encoded in one of the formats understood by ParseKey
+func SignerFromKey(rawKey []byte, format string) (cose.Signer, error) {
+	alg, key, err := getAlgAndKey(rawKey, format)
 	if err != nil {
 		return nil, err
 	}
@@ -68,11 +154,38 @@ func SignerFromJWK(rawJWK []byte) (cose.Signer, error) {
 
 // PubKeyFromJWK extracts a crypto.PublicKey from the supplied JSON Web Key
 func PubKeyFromJWK(rawJWK []byte) (crypto.PublicKey, error) {
+	return PubKeyFromKey(rawJWK, KeyFormatJWK)
+}
+
+// PubKeyFromKey extracts a crypto.PublicKey from the supplied key, encoded in
+// one of the formats understood by ParseKey
+func PubKeyFromKey(rawKey []byte, format string) (crypto.PublicKey, error) {
+	key, err := ParseKey(rawKey, format)
+	if err != nil {
+		return nil, err
+	}
+
+	return PubKeyFromParsedKey(key)
+}
+
+// PubKeyFromParsedKey is like PubKeyFromKey, for a key that has already been
+// parsed
+func PubKeyFromParsedKey(key jwk.Key) (crypto.PublicKey, error) {
 	var pKey crypto.PublicKey
 
-	err := jwk.ParseRawKey(rawJWK, &pKey)
+	if err := key.Raw(&pKey); err != nil {
+		return nil, fmt.Errorf("failed to create key: %w", err)
+	}
+
+	// a private key is returned as-is, and will be refused by the verifier,
+	// but the "alg" member is still checked against its public part
+	pub, err := jwk.PublicRawKeyOf(pKey)
 	if err != nil {
-		return nil, fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("failed to create key: %w", err)
+	}
+
+	if _, err := coseAlgorithmForKey(key, pub); err != nil {
+		return nil, err
 	}
 
 	return pKey, nil
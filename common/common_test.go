@@ -0,0 +1,111 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cose "github.com/veraison/go-cose"
+)
+
+func mustJWKs(t *testing.T, raw any, alg jwa.SignatureAlgorithm) (priv, pub []byte) {
+	key, err := jwk.FromRaw(raw)
+	require.NoError(t, err)
+
+	if alg != "" {
+		require.NoError(t, key.Set(jwk.AlgorithmKey, alg))
+	}
+
+	pk, err := key.PublicKey()
+	require.NoError(t, err)
+
+	priv, err = json.Marshal(key)
+	require.NoError(t, err)
+
+	pub, err = json.Marshal(pk)
+	require.NoError(t, err)
+
+	return priv, pub
+}
+
+func Test_SignerFromJWK_PubKeyFromJWK_roundtrip(t *testing.T) {
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	require.NoError(t, err)
+
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tvs := []struct {
+		desc     string
+		raw      crypto.Signer
+		alg      jwa.SignatureAlgorithm
+		expected cose.Algorithm
+	}{
+		{"P-521", p521, "", cose.AlgorithmES512},
+		{"Ed25519", ed, "", cose.AlgorithmEdDSA},
+		{"RSA default", rsaKey, "", cose.AlgorithmPS256},
+		{"RSA with alg", rsaKey, jwa.PS512, cose.AlgorithmPS512},
+	}
+
+	for _, tv := range tvs {
+		priv, pub := mustJWKs(t, tv.raw, tv.alg)
+
+		signer, err := SignerFromJWK(priv)
+		require.NoError(t, err, tv.desc)
+		assert.Equal(t, tv.expected, signer.Algorithm(), tv.desc)
+
+		sig, err := signer.Sign(rand.Reader, []byte("payload"))
+		require.NoError(t, err, tv.desc)
+
+		pk, err := PubKeyFromJWK(pub)
+		require.NoError(t, err, tv.desc)
+
+		verifier, err := cose.NewVerifier(tv.expected, pk)
+		require.NoError(t, err, tv.desc)
+
+		assert.NoError(t, verifier.Verify([]byte("payload"), sig), tv.desc)
+	}
+}
+
+func Test_SignerFromJWK_alg_mismatch(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tvs := []struct {
+		raw         any
+		alg         jwa.SignatureAlgorithm
+		expectedErr string
+	}{
+		{p256, jwa.ES384, "JWK algorithm ES384: cannot be used with curve P-256"},
+		{ed, jwa.PS256, "JWK algorithm PS256: cannot be used with an Ed25519 key"},
+		{p256, jwa.RS256, "unsupported JWK algorithm RS256"},
+	}
+
+	for _, tv := range tvs {
+		priv, pub := mustJWKs(t, tv.raw, tv.alg)
+
+		_, err := SignerFromJWK(priv)
+		assert.EqualError(t, err, tv.expectedErr)
+
+		_, err = PubKeyFromJWK(pub)
+		assert.EqualError(t, err, tv.expectedErr)
+	}
+}
@@ -0,0 +1,496 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	cose "github.com/veraison/go-cose"
+	"github.com/veraison/psatoken"
+)
+
+// CBOR tags of the CoRIM data model (draft-ietf-rats-corim) and of the Arm
+// PSA and CCA profiles
+const (
+	CBORTagURI                 = 32
+	CBORTagUUID                = 37
+	CBORTagCoRIM               = 501
+	CBORTagCoMID               = 506
+	CBORTagUEID                = 550
+	CBORTagPKIXKey             = 554
+	CBORTagPKIXCert            = 555
+	CBORTagCOSEKey             = 558
+	CBORTagBytes               = 560
+	CBORTagDERCert             = 562
+	CBORTagImplID              = 600
+	CBORTagPSARefValID         = 601
+	CBORTagCCAPlatformConfigID = 602
+)
+
+// CoRIM profiles of the Arm PSA and CCA endorsements, as known to Veraison
+const (
+	CoRIMProfilePSA         = "http://arm.com/psa/iot/1"
+	CoRIMProfileCCAPlatform = "http://arm.com/cca/ssd/1"
+	CoRIMProfileCCARealm    = "http://arm.com/cca/realm/1"
+)
+
+const (
+	// content type of the payload of signed CoRIMs
+	coRIMContentType = "application/rim+cbor"
+	// media types of unsigned and signed CoRIMs
+	coRIMUnsignedMediaType = "application/corim-unsigned+cbor"
+	coRIMSignedMediaType   = "application/rim+cose"
+	// COSE header label of the corim-meta-map of signed CoRIMs
+	coseHeaderLabelCoRIMMeta = 8
+)
+
+// IDs of the hash algorithms in the IANA Named Information Hash Algorithm
+// Registry, used by CoRIM digests
+var hashAlgIDs = map[string]uint64{
+	"sha-256": 1,
+	"sha-384": 7,
+	"sha-512": 8,
+}
+
+// CoRIM is an unsigned CoRIM (concise-rim-type-choice), of which only the
+// CoMID tags are kept
+type CoRIM struct {
+	ID      any        `cbor:"0,keyasint"`
+	Tags    []cbor.Tag `cbor:"1,keyasint"`
+	Profile *cbor.Tag  `cbor:"3,keyasint,omitempty"`
+	CoMIDs  []CoMID    `cbor:"-"`
+}
+
+// CoMID is a concise-mid-tag
+type CoMID struct {
+	TagIdentity TagIdentity `cbor:"1,keyasint"`
+	Triples     Triples     `cbor:"4,keyasint"`
+}
+
+// TagIdentity is the tag-identity-map of a CoMID
+type TagIdentity struct {
+	TagID      any    `cbor:"0,keyasint"`
+	TagVersion uint64 `cbor:"1,keyasint,omitempty"`
+}
+
+// Triples is the triples-map of a CoMID
+type Triples struct {
+	ReferenceValues []RefValTriple `cbor:"0,keyasint,omitempty"`
+	AttestVerifKeys []KeyTriple    `cbor:"3,keyasint,omitempty"`
+}
+
+// Environment is the environment-map a triple applies to
+type Environment struct {
+	Class    *Class    `cbor:"0,keyasint,omitempty"`
+	Instance *cbor.Tag `cbor:"1,keyasint,omitempty"`
+}
+
+// Class is the class-map of an Environment
+type Class struct {
+	ClassID *cbor.Tag `cbor:"0,keyasint,omitempty"`
+	Vendor  string    `cbor:"1,keyasint,omitempty"`
+	Model   string    `cbor:"2,keyasint,omitempty"`
+}
+
+// KeyTriple is an attest-key-triple-record: the keys used by the environment
+// to sign its evidence
+type KeyTriple struct {
+	_           struct{} `cbor:",toarray"`
+	Environment Environment
+	Keys        []cbor.Tag
+}
+
+// RefValTriple is a reference-triple-record: the measurements that the
+// environment is expected to report
+type RefValTriple struct {
+	_            struct{} `cbor:",toarray"`
+	Environment  Environment
+	Measurements []Measurement
+}
+
+// Measurement is a measurement-map
+type Measurement struct {
+	Key   *cbor.Tag         `cbor:"0,keyasint,omitempty"`
+	Value MeasurementValues `cbor:"1,keyasint"`
+}
+
+// MeasurementValues is the measurement-values-map of a Measurement, of which
+// only the members used by the Arm profiles are supported
+type MeasurementValues struct {
+	Digests            []Digest            `cbor:"2,keyasint,omitempty"`
+	RawValue           *cbor.Tag           `cbor:"4,keyasint,omitempty"`
+	IntegrityRegisters map[string][]Digest `cbor:"14,keyasint,omitempty"`
+}
+
+// Digest is a digest: a hash algorithm ID and a hash value
+type Digest struct {
+	_     struct{} `cbor:",toarray"`
+	Alg   uint64
+	Value []byte
+}
+
+// PSARefValID identifies a PSA or CCA platform software component
+type PSARefValID struct {
+	Label    string `cbor:"1,keyasint,omitempty"`
+	Version  string `cbor:"4,keyasint,omitempty"`
+	SignerID []byte `cbor:"5,keyasint"`
+}
+
+// NewCoRIM creates a CoRIM with the supplied profile and CoMIDs, and a random
+// UUID as its ID
+func NewCoRIM(profile string, comids ...CoMID) (*CoRIM, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	// version 4, variant 10
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	return &CoRIM{
+		ID:      cbor.Tag{Number: CBORTagUUID, Content: id},
+		Profile: &cbor.Tag{Number: CBORTagURI, Content: profile},
+		CoMIDs:  comids,
+	}, nil
+}
+
+// Encode encodes the CoRIM, with its CoMIDs, as a tagged unsigned CoRIM
+func (o CoRIM) Encode() ([]byte, error) {
+	em, err := cbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		return nil, err
+	}
+
+	o.Tags = nil
+
+	for i, comid := range o.CoMIDs {
+		encoded, err := em.Marshal(comid)
+		if err != nil {
+			return nil, fmt.Errorf("encoding CoMID #%d: %w", i, err)
+		}
+
+		o.Tags = append(o.Tags, cbor.Tag{Number: CBORTagCoMID, Content: encoded})
+	}
+
+	return em.Marshal(cbor.Tag{Number: CBORTagCoRIM, Content: o})
+}
+
+// SignCoRIM wraps an unsigned CoRIM in a COSE_Sign1 signed with the supplied
+// signer, whose name goes in the corim-meta header
+func SignCoRIM(unsigned []byte, signer cose.Signer, signerName string) ([]byte, error) {
+	msg := cose.NewSign1Message()
+	msg.Headers.Protected.SetAlgorithm(signer.Algorithm())
+	msg.Headers.Protected[cose.HeaderLabelContentType] = coRIMContentType
+	msg.Headers.Protected[int64(coseHeaderLabelCoRIMMeta)] = map[int]any{
+		0: map[int]any{0: signerName},
+	}
+	msg.Payload = unsigned
+
+	if err := msg.Sign(rand.Reader, nil, signer); err != nil {
+		return nil, err
+	}
+
+	return msg.MarshalCBOR()
+}
+
+// NewImplEnvironment returns the environment of the PSA or CCA platforms with
+// the supplied implementation ID
+func NewImplEnvironment(implID []byte, vendor, model string) Environment {
+	return Environment{
+		Class: &Class{
+			ClassID: &cbor.Tag{Number: CBORTagImplID, Content: implID},
+			Vendor:  vendor,
+			Model:   model,
+		},
+	}
+}
+
+// NewAttestKeyTriple endorses the supplied key, as a PEM SPKI, as the
+// attestation key of the platform with the supplied instance ID in env
+func NewAttestKeyTriple(env Environment, instID []byte, key jwk.Key) (KeyTriple, error) {
+	pub, err := jwk.PublicKeyOf(key)
+	if err != nil {
+		return KeyTriple{}, fmt.Errorf("failed to extract public key: %w", err)
+	}
+
+	pemKey, err := EncodeKey(pub, KeyFormatPEM)
+	if err != nil {
+		return KeyTriple{}, err
+	}
+
+	env.Instance = &cbor.Tag{Number: CBORTagUEID, Content: instID}
+
+	return KeyTriple{
+		Environment: env,
+		Keys:        []cbor.Tag{{Number: CBORTagPKIXKey, Content: string(pemKey)}},
+	}, nil
+}
+
+// NewDigest returns the digest of a measurement made with the named hash
+// algorithm (e.g., "sha-256") or, if alg is empty, with the SHA-2 algorithm
+// that produces values of its size
+func NewDigest(alg string, value []byte) (Digest, error) {
+	if alg == "" {
+		switch len(value) {
+		case 32:
+			alg = "sha-256"
+		case 48:
+			alg = "sha-384"
+		case 64:
+			alg = "sha-512"
+		default:
+			return Digest{}, fmt.Errorf("cannot infer the hash algorithm of a %d-byte measurement", len(value))
+		}
+	}
+
+	id, ok := hashAlgIDs[alg]
+	if !ok {
+		return Digest{}, fmt.Errorf("unsupported hash algorithm %q", alg)
+	}
+
+	return Digest{Alg: id, Value: value}, nil
+}
+
+// SwComponentMeasurements returns the reference values of the supplied PSA or
+// CCA platform software components
+func SwComponentMeasurements(comps []psatoken.ISwComponent) ([]Measurement, error) {
+	measurements := make([]Measurement, 0, len(comps))
+
+	for i, c := range comps {
+		signerID, err := c.GetSignerID()
+		if err != nil {
+			return nil, fmt.Errorf("software component #%d: %w", i, err)
+		}
+
+		value, err := c.GetMeasurementValue()
+		if err != nil {
+			return nil, fmt.Errorf("software component #%d: %w", i, err)
+		}
+
+		// the optional members are left out when missing
+		label, _ := c.GetMeasurementType()
+		version, _ := c.GetVersion()
+		desc, _ := c.GetMeasurementDesc()
+
+		digest, err := NewDigest(desc, value)
+		if err != nil {
+			return nil, fmt.Errorf("software component #%d: %w", i, err)
+		}
+
+		measurements = append(measurements, Measurement{
+			Key: &cbor.Tag{
+				Number:  CBORTagPSARefValID,
+				Content: PSARefValID{Label: label, Version: version, SignerID: signerID},
+			},
+			Value: MeasurementValues{Digests: []Digest{digest}},
+		})
+	}
+
+	return measurements, nil
+}
+
+// DecodeCoRIM decodes a CoRIM, either unsigned (tagged 501) or signed, i.e., a
+// COSE_Sign1 carrying an unsigned CoRIM.  The signature of a signed CoRIM is
+// not checked.
+func DecodeCoRIM(data []byte) (*CoRIM, error) {
+	var tag cbor.RawTag
+	if err := cbor.Unmarshal(data, &tag); err != nil {
+		return nil, fmt.Errorf("decoding CoRIM: %w", err)
+	}
+
+	if tag.Number == cborTagSign1 {
+		_, payload, err := PeekCOSE(data)
+		if err != nil {
+			return nil, fmt.Errorf("decoding signed CoRIM: %w", err)
+		}
+
+		if err = cbor.Unmarshal(payload, &tag); err != nil {
+			return nil, fmt.Errorf("decoding signed CoRIM payload: %w", err)
+		}
+	}
+
+	if tag.Number != CBORTagCoRIM {
+		return nil, fmt.Errorf("want a CoRIM (CBOR tag %d), got CBOR tag %d", CBORTagCoRIM, tag.Number)
+	}
+
+	var corim CoRIM
+
+	if err := cbor.Unmarshal(tag.Content, &corim); err != nil {
+		return nil, fmt.Errorf("decoding CoRIM: %w", err)
+	}
+
+	for i, t := range corim.Tags {
+		// CoSWID and CoBOM tags are skipped
+		if t.Number != CBORTagCoMID {
+			continue
+		}
+
+		encoded, ok := t.Content.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("CoRIM tag #%d: want bstr, got %T", i, t.Content)
+		}
+
+		var comid CoMID
+		if err := cbor.Unmarshal(encoded, &comid); err != nil {
+			return nil, fmt.Errorf("decoding CoMID in CoRIM tag #%d: %w", i, err)
+		}
+
+		corim.CoMIDs = append(corim.CoMIDs, comid)
+	}
+
+	return &corim, nil
+}
+
+// CoRIMMediaType returns the media type under which the supplied CoRIM, signed
+// or unsigned, is submitted to the Veraison provisioning API.  As the Veraison
+// schemes take reference values and trust anchors alike in the CoRIMs of their
+// profiles, the media type is inferred from the profile of the CoRIM.
+func CoRIMMediaType(data []byte) (string, error) {
+	corim, err := DecodeCoRIM(data)
+	if err != nil {
+		return "", err
+	}
+
+	if corim.Profile == nil {
+		return "", errors.New("the CoRIM has no profile")
+	}
+
+	profile, ok := corim.Profile.Content.(string)
+	if !ok || corim.Profile.Number != CBORTagURI {
+		return "", fmt.Errorf("unsupported CoRIM profile (CBOR tag %d)", corim.Profile.Number)
+	}
+
+	var tag cbor.RawTag
+	if err = cbor.Unmarshal(data, &tag); err != nil {
+		return "", err
+	}
+
+	if tag.Number == cborTagSign1 {
+		return fmt.Sprintf("%s; profile=%q", coRIMSignedMediaType, profile), nil
+	}
+
+	return fmt.Sprintf("%s; profile=%q", coRIMUnsignedMediaType, profile), nil
+}
+
+// AttestationKey returns the first attestation verification key of the
+// environment with the supplied implementation and instance IDs, or nil if
+// there is none.  As in the Veraison PSA and CCA schemes, the environment is
+// identified by the instance ID (a UEID) and, if there is one, the
+// implementation ID in its class.
+func (o CoRIM) AttestationKey(implID, instID []byte) (jwk.Key, error) {
+	for _, comid := range o.CoMIDs {
+		for _, t := range comid.Triples.AttestVerifKeys {
+			if !t.Environment.matches(implID, instID) {
+				continue
+			}
+
+			if len(t.Keys) == 0 {
+				return nil, errors.New("attestation key triple with no keys")
+			}
+
+			return keyFromCoRIM(t.Keys[0])
+		}
+	}
+
+	return nil, nil
+}
+
+func (o Environment) matches(implID, instID []byte) bool {
+	if o.Instance == nil || o.Instance.Number != CBORTagUEID {
+		return false
+	}
+
+	if ueid, ok := o.Instance.Content.([]byte); !ok || !bytes.Equal(ueid, instID) {
+		return false
+	}
+
+	if o.Class == nil || o.Class.ClassID == nil {
+		return true
+	}
+
+	id, ok := o.Class.ClassID.Content.([]byte)
+
+	return ok && o.Class.ClassID.Number == CBORTagImplID && bytes.Equal(id, implID)
+}
+
+// keyFromCoRIM decodes a $crypto-key-type-choice holding a public key or a
+// certificate
+func keyFromCoRIM(t cbor.Tag) (jwk.Key, error) {
+	var (
+		raw    []byte
+		format string
+	)
+
+	switch t.Number {
+	case CBORTagPKIXKey, CBORTagPKIXCert:
+		s, ok := t.Content.(string)
+		if !ok {
+			return nil, fmt.Errorf("key tagged %d: want tstr, got %T", t.Number, t.Content)
+		}
+
+		raw, format = []byte(s), KeyFormatPEM
+
+		// tolerate base64 without the PEM armour
+		if !isPEM(raw) {
+			der, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("key tagged %d: %w", t.Number, err)
+			}
+			raw, format = der, KeyFormatDER
+		}
+	case CBORTagCOSEKey, CBORTagDERCert:
+		b, ok := t.Content.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("key tagged %d: want bstr, got %T", t.Number, t.Content)
+		}
+
+		raw, format = b, KeyFormatDER
+		if t.Number == CBORTagCOSEKey {
+			format = KeyFormatCOSE
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type (CBOR tag %d)", t.Number)
+	}
+
+	return ParseKey(raw, format)
+}
+
+// LoadCoRIMAttestationKey looks up the attestation verification key of the
+// environment with the supplied implementation and instance IDs in the CoRIM
+// files, in turn, and returns it together with the file it has been found in
+func LoadCoRIMAttestationKey(fs afero.Fs, fns []string, implID, instID []byte) (jwk.Key, string, error) {
+	for _, fn := range fns {
+		raw, err := afero.ReadFile(fs, fn)
+		if err != nil {
+			return nil, "", fmt.Errorf("error loading CoRIM from %s: %w", fn, err)
+		}
+
+		corim, err := DecodeCoRIM(raw)
+		if err != nil {
+			return nil, "", fmt.Errorf("error decoding CoRIM from %s: %w", fn, err)
+		}
+
+		key, err := corim.AttestationKey(implID, instID)
+		if err != nil {
+			return nil, "", fmt.Errorf("error decoding attestation verification key from %s: %w", fn, err)
+		}
+
+		if key != nil {
+			return key, fn, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf(
+		"no attestation verification key for implementation ID %x and instance ID %x in the CoRIMs",
+		implID, instID,
+	)
+}
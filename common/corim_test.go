@@ -0,0 +1,331 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cose "github.com/veraison/go-cose"
+)
+
+var (
+	testCoRIMImplID = MustHexDecode("5051525354555657505152535455565750515253545556575051525354555657")
+	testCoRIMInstID = MustHexDecode("01a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3a0a1a2a3")
+)
+
+func newTestCoRIM(t *testing.T, env Environment, keys ...cbor.Tag) []byte {
+	comid := CoMID{
+		TagIdentity: TagIdentity{TagID: "test-comid"},
+		Triples: Triples{
+			AttestVerifKeys: []KeyTriple{{Environment: env, Keys: keys}},
+		},
+	}
+
+	encoded, err := cbor.Marshal(comid)
+	require.NoError(t, err)
+
+	corim := CoRIM{
+		ID: "test-corim",
+		Tags: []cbor.Tag{
+			// a CoSWID, which is skipped
+			{Number: 505, Content: []byte{0xa0}},
+			{Number: CBORTagCoMID, Content: encoded},
+		},
+	}
+
+	data, err := cbor.Marshal(cbor.Tag{Number: CBORTagCoRIM, Content: corim})
+	require.NoError(t, err)
+
+	return data
+}
+
+func newTestEnvironment(implID []byte) Environment {
+	env := Environment{
+		Instance: &cbor.Tag{Number: CBORTagUEID, Content: testCoRIMInstID},
+	}
+
+	if implID != nil {
+		env.Class = &Class{
+			ClassID: &cbor.Tag{Number: CBORTagImplID, Content: implID},
+			Vendor:  "ACME",
+		}
+	}
+
+	return env
+}
+
+func newTestPKIXKey(t *testing.T, key jwk.Key) cbor.Tag {
+	pub, err := key.PublicKey()
+	require.NoError(t, err)
+
+	pemKey, err := EncodeKey(pub, KeyFormatPEM)
+	require.NoError(t, err)
+
+	return cbor.Tag{Number: CBORTagPKIXKey, Content: string(pemKey)}
+}
+
+func Test_CoRIM_AttestationKey(t *testing.T) {
+	_, key := newTestPrivateKey(t)
+
+	data := newTestCoRIM(t, newTestEnvironment(testCoRIMImplID), newTestPKIXKey(t, key))
+
+	corim, err := DecodeCoRIM(data)
+	require.NoError(t, err)
+	require.Len(t, corim.CoMIDs, 1)
+
+	actual, err := corim.AttestationKey(testCoRIMImplID, testCoRIMInstID)
+	require.NoError(t, err)
+	assert.True(t, sameKey(key, actual))
+
+	// the implementation ID must match too
+	actual, err = corim.AttestationKey(make([]byte, 32), testCoRIMInstID)
+	require.NoError(t, err)
+	assert.Nil(t, actual)
+
+	actual, err = corim.AttestationKey(testCoRIMImplID, make([]byte, 33))
+	require.NoError(t, err)
+	assert.Nil(t, actual)
+}
+
+func Test_CoRIM_AttestationKey_no_class(t *testing.T) {
+	_, key := newTestPrivateKey(t)
+
+	pub, err := key.PublicKey()
+	require.NoError(t, err)
+
+	coseKey, err := EncodeKey(pub, KeyFormatCOSE)
+	require.NoError(t, err)
+
+	data := newTestCoRIM(t, newTestEnvironment(nil), cbor.Tag{Number: CBORTagCOSEKey, Content: coseKey})
+
+	corim, err := DecodeCoRIM(data)
+	require.NoError(t, err)
+
+	actual, err := corim.AttestationKey(testCoRIMImplID, testCoRIMInstID)
+	require.NoError(t, err)
+	assert.True(t, sameKey(key, actual))
+}
+
+func Test_CoRIM_AttestationKey_bare_base64(t *testing.T) {
+	_, key := newTestPrivateKey(t)
+
+	pub, err := key.PublicKey()
+	require.NoError(t, err)
+
+	der, err := EncodeKey(pub, KeyFormatDER)
+	require.NoError(t, err)
+
+	data := newTestCoRIM(t, newTestEnvironment(testCoRIMImplID),
+		cbor.Tag{Number: CBORTagPKIXKey, Content: base64.StdEncoding.EncodeToString(der)})
+
+	corim, err := DecodeCoRIM(data)
+	require.NoError(t, err)
+
+	actual, err := corim.AttestationKey(testCoRIMImplID, testCoRIMInstID)
+	require.NoError(t, err)
+	assert.True(t, sameKey(key, actual))
+}
+
+func Test_CoRIM_AttestationKey_unsupported(t *testing.T) {
+	data := newTestCoRIM(t, newTestEnvironment(testCoRIMImplID),
+		cbor.Tag{Number: 557, Content: []any{1, []byte{0x00}}})
+
+	corim, err := DecodeCoRIM(data)
+	require.NoError(t, err)
+
+	_, err = corim.AttestationKey(testCoRIMImplID, testCoRIMInstID)
+	assert.EqualError(t, err, "unsupported key type (CBOR tag 557)")
+}
+
+func Test_DecodeCoRIM_signed(t *testing.T) {
+	_, key := newTestPrivateKey(t)
+
+	unsigned := newTestCoRIM(t, newTestEnvironment(testCoRIMImplID), newTestPKIXKey(t, key))
+
+	raw, err := json.Marshal(key)
+	require.NoError(t, err)
+
+	signer, err := SignerFromJWK(raw)
+	require.NoError(t, err)
+
+	msg := cose.NewSign1Message()
+	msg.Headers.Protected.SetAlgorithm(signer.Algorithm())
+	msg.Headers.Protected[cose.HeaderLabelContentType] = "application/rim+cbor"
+	msg.Payload = unsigned
+	require.NoError(t, msg.Sign(rand.Reader, nil, signer))
+
+	signed, err := msg.MarshalCBOR()
+	require.NoError(t, err)
+
+	corim, err := DecodeCoRIM(signed)
+	require.NoError(t, err)
+
+	actual, err := corim.AttestationKey(testCoRIMImplID, testCoRIMInstID)
+	require.NoError(t, err)
+	assert.True(t, sameKey(key, actual))
+}
+
+func Test_DecodeCoRIM_fail(t *testing.T) {
+	_, err := DecodeCoRIM([]byte{0xa0})
+	assert.ErrorContains(t, err, "decoding CoRIM")
+
+	_, err = DecodeCoRIM(MustHexDecode("d9018fa0"))
+	assert.EqualError(t, err, "want a CoRIM (CBOR tag 501), got CBOR tag 399")
+}
+
+func Test_LoadCoRIMAttestationKey(t *testing.T) {
+	_, key := newTestPrivateKey(t)
+
+	fs := afero.NewMemMapFs()
+
+	other := newTestCoRIM(t, newTestEnvironment(make([]byte, 32)), newTestPKIXKey(t, key))
+	require.NoError(t, afero.WriteFile(fs, "other.cbor", other, 0644))
+
+	mine := newTestCoRIM(t, newTestEnvironment(testCoRIMImplID), newTestPKIXKey(t, key))
+	require.NoError(t, afero.WriteFile(fs, "mine.cbor", mine, 0644))
+
+	actual, fn, err := LoadCoRIMAttestationKey(fs, []string{"other.cbor", "mine.cbor"}, testCoRIMImplID, testCoRIMInstID)
+	require.NoError(t, err)
+	assert.Equal(t, "mine.cbor", fn)
+	assert.True(t, sameKey(key, actual))
+
+	_, _, err = LoadCoRIMAttestationKey(fs, []string{"other.cbor"}, testCoRIMImplID, testCoRIMInstID)
+	assert.ErrorContains(t, err, "no attestation verification key for implementation ID 5051")
+
+	_, _, err = LoadCoRIMAttestationKey(fs, []string{"missing.cbor"}, testCoRIMImplID, testCoRIMInstID)
+	assert.ErrorContains(t, err, "error loading CoRIM from missing.cbor")
+}
+
+func Test_CoRIM_Encode_roundtrip(t *testing.T) {
+	_, key := newTestPrivateKey(t)
+
+	env := NewImplEnvironment(testCoRIMImplID, "ACME", "RoadRunner")
+
+	triple, err := NewAttestKeyTriple(env, testCoRIMInstID, key)
+	require.NoError(t, err)
+
+	digest, err := NewDigest("", make([]byte, 32))
+	require.NoError(t, err)
+
+	comid := CoMID{
+		TagIdentity: TagIdentity{TagID: "test-comid"},
+		Triples: Triples{
+			ReferenceValues: []RefValTriple{
+				{
+					Environment: env,
+					Measurements: []Measurement{
+						{Value: MeasurementValues{Digests: []Digest{digest}}},
+					},
+				},
+			},
+			AttestVerifKeys: []KeyTriple{triple},
+		},
+	}
+
+	corim, err := NewCoRIM(CoRIMProfilePSA, comid)
+	require.NoError(t, err)
+
+	data, err := corim.Encode()
+	require.NoError(t, err)
+
+	decoded, err := DecodeCoRIM(data)
+	require.NoError(t, err)
+	require.Len(t, decoded.CoMIDs, 1)
+	require.NotNil(t, decoded.Profile)
+	assert.Equal(t, CoRIMProfilePSA, decoded.Profile.Content)
+
+	refvals := decoded.CoMIDs[0].Triples.ReferenceValues
+	require.Len(t, refvals, 1)
+	assert.Equal(t, "RoadRunner", refvals[0].Environment.Class.Model)
+	assert.Equal(t, []Digest{{Alg: 1, Value: make([]byte, 32)}}, refvals[0].Measurements[0].Value.Digests)
+
+	actual, err := decoded.AttestationKey(testCoRIMImplID, testCoRIMInstID)
+	require.NoError(t, err)
+	assert.True(t, sameKey(key, actual))
+}
+
+func Test_SignCoRIM(t *testing.T) {
+	_, key := newTestPrivateKey(t)
+
+	raw, err := json.Marshal(key)
+	require.NoError(t, err)
+
+	signer, err := SignerFromJWK(raw)
+	require.NoError(t, err)
+
+	unsigned := newTestCoRIM(t, newTestEnvironment(testCoRIMImplID), newTestPKIXKey(t, key))
+
+	signed, err := SignCoRIM(unsigned, signer, "ACME")
+	require.NoError(t, err)
+
+	var msg cose.Sign1Message
+	require.NoError(t, msg.UnmarshalCBOR(signed))
+	assert.Equal(t, "application/rim+cbor", msg.Headers.Protected[cose.HeaderLabelContentType])
+	assert.Equal(t, unsigned, msg.Payload)
+
+	pub, err := key.PublicKey()
+	require.NoError(t, err)
+
+	pk, err := PubKeyFromParsedKey(pub)
+	require.NoError(t, err)
+
+	verifier, err := cose.NewVerifier(signer.Algorithm(), pk)
+	require.NoError(t, err)
+	assert.NoError(t, msg.Verify(nil, verifier))
+}
+
+func Test_NewDigest(t *testing.T) {
+	d, err := NewDigest("sha-384", make([]byte, 48))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(7), d.Alg)
+
+	d, err = NewDigest("", make([]byte, 64))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(8), d.Alg)
+
+	_, err = NewDigest("", make([]byte, 20))
+	assert.EqualError(t, err, "cannot infer the hash algorithm of a 20-byte measurement")
+
+	_, err = NewDigest("md5", make([]byte, 16))
+	assert.EqualError(t, err, `unsupported hash algorithm "md5"`)
+}
+
+func Test_CoRIMMediaType(t *testing.T) {
+	corim, err := NewCoRIM(CoRIMProfileCCARealm)
+	require.NoError(t, err)
+
+	unsigned, err := corim.Encode()
+	require.NoError(t, err)
+
+	mt, err := CoRIMMediaType(unsigned)
+	require.NoError(t, err)
+	assert.Equal(t, `application/corim-unsigned+cbor; profile="http://arm.com/cca/realm/1"`, mt)
+
+	_, key := newTestPrivateKey(t)
+
+	raw, err := json.Marshal(key)
+	require.NoError(t, err)
+
+	signer, err := SignerFromJWK(raw)
+	require.NoError(t, err)
+
+	signed, err := SignCoRIM(unsigned, signer, "ACME")
+	require.NoError(t, err)
+
+	mt, err = CoRIMMediaType(signed)
+	require.NoError(t, err)
+	assert.Equal(t, `application/rim+cose; profile="http://arm.com/cca/realm/1"`, mt)
+
+	// no profile
+	_, err = CoRIMMediaType(newTestCoRIM(t, newTestEnvironment(nil)))
+	assert.EqualError(t, err, "the CoRIM has no profile")
+}
@@ -0,0 +1,591 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/spf13/afero"
+	apicommon "github.com/veraison/apiclient/common"
+)
+
+// EARProfile is the EAT profile of the EAT Attestation Results (EAR) produced
+// by Veraison
+const EARProfile = "tag:github.com,2023:veraison/ear"
+
+// VerificationWellKnownPath is the path of the Veraison verification API
+// discovery endpoint, relative to the API server root
+const VerificationWellKnownPath = "/.well-known/veraison/verification"
+
+// TrustTier is the AR4SI trustworthiness tier associated with an appraisal
+// status, or with an individual trustworthiness claim
+type TrustTier int8
+
+const (
+	TrustTierNone            TrustTier = 0
+	TrustTierAffirming       TrustTier = 2
+	TrustTierWarning         TrustTier = 32
+	TrustTierContraindicated TrustTier = 96
+)
+
+var trustTierNames = map[TrustTier]string{
+	TrustTierNone:            "none",
+	TrustTierAffirming:       "affirming",
+	TrustTierWarning:         "warning",
+	TrustTierContraindicated: "contraindicated",
+}
+
+var trustTierMeanings = map[TrustTier]string{
+	TrustTierNone:            "the verifier makes no claim about the attester's trustworthiness",
+	TrustTierAffirming:       "the verifier affirms the attester's trustworthiness",
+	TrustTierWarning:         "the verifier has found something the relying party should be aware of",
+	TrustTierContraindicated: "the verifier has found a problem that contraindicates trusting the attester",
+}
+
+// TrustTierFromString returns the TrustTier with the supplied name
+func TrustTierFromString(s string) (TrustTier, error) {
+	for t, name := range trustTierNames {
+		if name == s {
+			return t, nil
+		}
+	}
+
+	return TrustTierNone, fmt.Errorf(
+		"unknown trust tier %q: allowed values are none, affirming, warning and contraindicated", s,
+	)
+}
+
+func (o TrustTier) String() string {
+	if name, ok := trustTierNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("TrustTier(%d)", o)
+}
+
+// Meaning returns a plain-English description of the tier
+func (o TrustTier) Meaning() string {
+	return trustTierMeanings[o]
+}
+
+// MarshalJSON encodes the tier using its name, as in "ear.status"
+func (o TrustTier) MarshalJSON() ([]byte, error) {
+	if _, ok := trustTierNames[o]; !ok {
+		return nil, fmt.Errorf("invalid trust tier %d", o)
+	}
+	return json.Marshal(o.String())
+}
+
+// UnmarshalJSON decodes a tier from either its name or its numeric value
+func (o *TrustTier) UnmarshalJSON(data []byte) error {
+	var s string
+
+	if err := json.Unmarshal(data, &s); err == nil {
+		t, err := TrustTierFromString(s)
+		if err != nil {
+			return err
+		}
+		*o = t
+		return nil
+	}
+
+	var i int8
+	if err := json.Unmarshal(data, &i); err != nil {
+		return fmt.Errorf("trust tier must be a string or an integer: %w", err)
+	}
+
+	t := TrustTier(i)
+	if _, ok := trustTierNames[t]; !ok {
+		return fmt.Errorf("invalid trust tier %d", i)
+	}
+	*o = t
+
+	return nil
+}
+
+// TrustClaim is the value of an AR4SI trustworthiness claim
+type TrustClaim int8
+
+// Tier returns the trust tier the claim value belongs to
+func (o TrustClaim) Tier() TrustTier {
+	switch {
+	case o >= -1 && o <= 1:
+		return TrustTierNone
+	case (o >= -32 && o <= -2) || (o >= 2 && o <= 31):
+		return TrustTierAffirming
+	case (o >= -96 && o <= -33) || (o >= 32 && o <= 95):
+		return TrustTierWarning
+	default:
+		return TrustTierContraindicated
+	}
+}
+
+// generic meanings that apply to every trustworthiness claim
+var genericTrustClaimMeanings = map[TrustClaim]string{
+	-1: "the verifier malfunctioned while appraising this aspect",
+	0:  "no claim is being made",
+	1:  "the evidence received is insufficient to make a conclusion",
+	99: "cryptographic validation of the evidence has failed",
+}
+
+var trustClaimMeanings = map[string]map[TrustClaim]string{
+	"instance-identity": {
+		2:  "the attesting environment is recognized, and the associated instance of the attester is not known to be compromised",
+		96: "the attesting environment is recognized, but its unique private key is known to be compromised",
+		97: "the attesting environment is not recognized",
+	},
+	"configuration": {
+		2:  "the configuration is a known and approved config",
+		3:  "the configuration includes or exposes no known vulnerabilities",
+		32: "the configuration includes or exposes known vulnerabilities",
+		96: "the configuration is unsupportable as it exposes unacceptable security vulnerabilities",
+	},
+	"executables": {
+		2:  "only a recognized genuine set of approved executables, scripts, files, and/or objects have been loaded during and after the boot process",
+		3:  "only a recognized genuine set of approved executables have been loaded during the boot process",
+		32: "only a recognized genuine set of executables, scripts, files, and/or objects have been loaded, however the verifier cannot vouch for a subset of these due to known bugs or other known vulnerabilities",
+		33: "runtime memory includes executables, scripts, files, and/or objects which are not recognized",
+		96: "runtime memory includes executables, scripts, files, and/or objects which are contraindicated",
+	},
+	"file-system": {
+		2:  "only a recognized set of approved files are found",
+		32: "the file system includes unrecognized executables, scripts, or files",
+		96: "the file system includes contraindicated executables, scripts, or files",
+	},
+	"hardware": {
+		2:  "an attester has passed its hardware and/or firmware verifications needed to demonstrate that these are genuine/supported",
+		32: "an attester contains only genuine/supported hardware and/or firmware, but there are known security vulnerabilities",
+		96: "attester hardware and/or firmware is recognized, but its trustworthiness is contraindicated",
+		97: "a verifier does not recognize an attester's hardware or firmware, but it should be recognized",
+	},
+	"runtime-opaque": {
+		2:  "the attester's executing target environment and attesting environments are encrypted and within trusted execution environment(s) opaque to the operating system, virtual machine manager, and peer applications",
+		32: "the attester's executing target environment and attesting environments are inaccessible from any other parallel application or guest VM running on the attester's physical device",
+		96: "the verifier has concluded that in memory objects are unacceptably visible within the physical host that supports the attester",
+	},
+	"storage-opaque": {
+		2:  "the attester encrypts all secrets in persistent storage via using keys which are never visible outside an HSM or the trusted execution environment hardware",
+		32: "the attester encrypts all persistently stored secrets, but without using hardware backed keys",
+		96: "there are persistent secrets which are stored unencrypted in an attester",
+	},
+	"sourced-data": {
+		2:  "all essential attester source data objects have been provided by other attester(s) whose most recent appraisal(s) had both no trustworthiness claims of \"0\" where the current trustworthiness claim is \"affirming\", as well as no \"warning\" or \"contraindicated\" trustworthiness claims",
+		32: "attester source data objects come from unattested sources, or attested sources with \"warning\" type trustworthiness claims",
+		96: "attester source data objects come from contraindicated sources",
+	},
+}
+
+// Meaning returns a plain-English description of the claim value within the
+// context of the named trustworthiness vector entry
+func (o TrustClaim) Meaning(name string) string {
+	if m, ok := genericTrustClaimMeanings[o]; ok {
+		return m
+	}
+
+	if m, ok := trustClaimMeanings[name][o]; ok {
+		return m
+	}
+
+	return "unrecognized claim value"
+}
+
+// TrustVector is the AR4SI trustworthiness vector
+type TrustVector struct {
+	InstanceIdentity TrustClaim `json:"instance-identity"`
+	Configuration    TrustClaim `json:"configuration"`
+	Executables      TrustClaim `json:"executables"`
+	FileSystem       TrustClaim `json:"file-system"`
+	Hardware         TrustClaim `json:"hardware"`
+	RuntimeOpaque    TrustClaim `json:"runtime-opaque"`
+	StorageOpaque    TrustClaim `json:"storage-opaque"`
+	SourcedData      TrustClaim `json:"sourced-data"`
+}
+
+// TrustVectorClaimNames lists the trustworthiness vector entries in their
+// canonical order
+var TrustVectorClaimNames = []string{
+	"instance-identity",
+	"configuration",
+	"executables",
+	"file-system",
+	"hardware",
+	"runtime-opaque",
+	"storage-opaque",
+	"sourced-data",
+}
+
+// Claim returns a pointer to the trustworthiness vector entry with the given
+// name, or nil if no such entry exists
+func (o *TrustVector) Claim(name string) *TrustClaim {
+	switch name {
+	case "instance-identity":
+		return &o.InstanceIdentity
+	case "configuration":
+		return &o.Configuration
+	case "executables":
+		return &o.Executables
+	case "file-system":
+		return &o.FileSystem
+	case "hardware":
+		return &o.Hardware
+	case "runtime-opaque":
+		return &o.RuntimeOpaque
+	case "storage-opaque":
+		return &o.StorageOpaque
+	case "sourced-data":
+		return &o.SourcedData
+	}
+	return nil
+}
+
+// Status returns the worst tier of the vector claims, which is the status of an
+// appraisal with this vector
+func (o TrustVector) Status() TrustTier {
+	status := TrustTierNone
+
+	for _, cn := range TrustVectorClaimNames {
+		if t := o.Claim(cn).Tier(); t > status {
+			status = t
+		}
+	}
+
+	return status
+}
+
+// VerifierIdentity identifies the verifier that produced an attestation result
+type VerifierIdentity struct {
+	Build     string `json:"build"`
+	Developer string `json:"developer"`
+}
+
+// Appraisal is the appraisal outcome for one submodule of the attester
+type Appraisal struct {
+	Status            TrustTier      `json:"ear.status"`
+	TrustVector       *TrustVector   `json:"ear.trustworthiness-vector,omitempty"`
+	AppraisalPolicyID string         `json:"ear.appraisal-policy-id,omitempty"`
+	AnnotatedEvidence map[string]any `json:"ear.veraison.annotated-evidence,omitempty"`
+	PolicyClaims      map[string]any `json:"ear.veraison.policy-claims,omitempty"`
+}
+
+// AttestationResult is an EAT Attestation Result (EAR)
+type AttestationResult struct {
+	Profile     string               `json:"eat_profile"`
+	IssuedAt    int64                `json:"iat"`
+	VerifierID  *VerifierIdentity    `json:"ear.verifier-id,omitempty"`
+	Nonce       string               `json:"eat_nonce,omitempty"`
+	RawEvidence string               `json:"ear.raw-evidence,omitempty"`
+	Submods     map[string]Appraisal `json:"submods"`
+}
+
+// Validate checks that the mandatory EAR claims are present
+func (o AttestationResult) Validate() error {
+	if o.Profile != EARProfile {
+		return fmt.Errorf("unexpected EAT profile %q (expecting %q)", o.Profile, EARProfile)
+	}
+
+	if o.IssuedAt == 0 {
+		return errors.New("missing issued at (iat) claim")
+	}
+
+	if len(o.Submods) == 0 {
+		return errors.New("no submods found")
+	}
+
+	return nil
+}
+
+// SubmodNames returns the names of the submods in a stable order
+func (o AttestationResult) SubmodNames() []string {
+	names := make([]string, 0, len(o.Submods))
+	for name := range o.Submods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Report writes a human readable description of the attestation result to w
+func (o AttestationResult) Report(w io.Writer) {
+	fmt.Fprintf(w, ">> attestation result issued at %s\n",
+		time.Unix(o.IssuedAt, 0).UTC().Format(time.RFC3339))
+
+	if o.VerifierID != nil {
+		fmt.Fprintf(w, ">> verifier: %s (build: %s)\n",
+			o.VerifierID.Developer, o.VerifierID.Build)
+	}
+
+	if o.Nonce != "" {
+		fmt.Fprintf(w, ">> nonce: %s\n", o.Nonce)
+	}
+
+	for _, name := range o.SubmodNames() {
+		a := o.Submods[name]
+
+		fmt.Fprintf(w, ">> submod %q\n", name)
+		fmt.Fprintf(w, "   status: %s (%s)\n", a.Status, a.Status.Meaning())
+
+		if a.AppraisalPolicyID != "" {
+			fmt.Fprintf(w, "   appraisal policy: %s\n", a.AppraisalPolicyID)
+		}
+
+		if a.TrustVector == nil {
+			fmt.Fprintln(w, "   trust vector: not present")
+			continue
+		}
+
+		fmt.Fprintln(w, "   trust vector:")
+		for _, cn := range TrustVectorClaimNames {
+			c := *a.TrustVector.Claim(cn)
+			fmt.Fprintf(w, "     %-18s %s (%d): %s\n",
+				cn+":", c.Tier(), c, c.Meaning(cn))
+		}
+	}
+}
+
+// Sign validates the attestation result and encodes it as a JWT signed with the
+// supplied private key
+func (o AttestationResult) Sign(key jwk.Key) ([]byte, error) {
+	if err := o.Validate(); err != nil {
+		return nil, fmt.Errorf("validating attestation result: %w", err)
+	}
+
+	payload, err := json.Marshal(o)
+	if err != nil {
+		return nil, fmt.Errorf("encoding attestation result: %w", err)
+	}
+
+	alg, err := JWSAlgorithmForKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	hdrs := jws.NewHeaders()
+	if err := hdrs.Set(jws.TypeKey, "JWT"); err != nil {
+		return nil, err
+	}
+
+	jwt, err := jws.Sign(payload, jws.WithKey(alg, key, jws.WithProtectedHeaders(hdrs)))
+	if err != nil {
+		return nil, fmt.Errorf("signing attestation result: %w", err)
+	}
+
+	return jwt, nil
+}
+
+// JWSAlgorithmForKey returns the algorithm associated with the key if it has
+// one, or the default algorithm for its key type otherwise
+func JWSAlgorithmForKey(key jwk.Key) (jwa.SignatureAlgorithm, error) {
+	var alg jwa.SignatureAlgorithm
+
+	if ka := key.Algorithm(); ka != nil && ka.String() != "" {
+		if err := alg.Accept(ka.String()); err != nil {
+			return alg, fmt.Errorf("unsupported key algorithm: %w", err)
+		}
+		return alg, nil
+	}
+
+	switch k := key.(type) {
+	case jwk.ECDSAPrivateKey:
+		return ecdsaJWSAlgorithm(k.Crv())
+	case jwk.ECDSAPublicKey:
+		return ecdsaJWSAlgorithm(k.Crv())
+	case jwk.OKPPrivateKey, jwk.OKPPublicKey:
+		return jwa.EdDSA, nil
+	case jwk.RSAPrivateKey, jwk.RSAPublicKey:
+		return jwa.PS256, nil
+	}
+
+	return alg, fmt.Errorf("unsupported key type %s", key.KeyType())
+}
+
+func ecdsaJWSAlgorithm(crv jwa.EllipticCurveAlgorithm) (jwa.SignatureAlgorithm, error) {
+	switch crv {
+	case jwa.P256:
+		return jwa.ES256, nil
+	case jwa.P384:
+		return jwa.ES384, nil
+	case jwa.P521:
+		return jwa.ES512, nil
+	}
+	return "", fmt.Errorf("unknown elliptic curve %s", crv)
+}
+
+// DecodeAndVerifyAttestationResult checks the signature on the supplied EAR
+// JWT using the supplied key and returns the decoded attestation result.  The
+// JWT can be either bare or wrapped in a JSON string, as found in the
+// "result" field of a Veraison challenge-response session.
+func DecodeAndVerifyAttestationResult(data []byte, key jwk.Key) (*AttestationResult, error) {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		data = []byte(s)
+	}
+
+	alg, err := earSignatureAlgorithm(data, key)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := jws.Verify(data, jws.WithKey(alg, key))
+	if err != nil {
+		return nil, fmt.Errorf("verifying attestation result signature: %w", err)
+	}
+
+	var ar AttestationResult
+	if err := json.Unmarshal(payload, &ar); err != nil {
+		return nil, fmt.Errorf("decoding attestation result: %w", err)
+	}
+
+	if err := ar.Validate(); err != nil {
+		return nil, fmt.Errorf("validating attestation result: %w", err)
+	}
+
+	return &ar, nil
+}
+
+// earSignatureAlgorithm returns the algorithm the attestation result must be
+// signed with: the one associated with the key if it has one, or the one
+// following from the key type otherwise.  The unauthenticated JWS protected
+// header can only pick another algorithm of the same family, i.e., the RSA-PSS
+// hash for RSA keys.
+func earSignatureAlgorithm(data []byte, key jwk.Key) (jwa.SignatureAlgorithm, error) {
+	var alg jwa.SignatureAlgorithm
+
+	msg, err := jws.Parse(data)
+	if err != nil {
+		return alg, fmt.Errorf("decoding attestation result: %w", err)
+	}
+
+	sigs := msg.Signatures()
+	if len(sigs) != 1 {
+		return alg, fmt.Errorf("expecting exactly one signature, found %d", len(sigs))
+	}
+
+	hdrAlg := sigs[0].ProtectedHeaders().Algorithm()
+	if hdrAlg == jwa.NoSignature || hdrAlg == "" {
+		return alg, errors.New("unsigned attestation result")
+	}
+
+	if alg, err = JWSAlgorithmForKey(key); err != nil {
+		return alg, err
+	}
+
+	if hdrAlg == alg {
+		return alg, nil
+	}
+
+	if ka := key.Algorithm(); ka == nil || ka.String() == "" {
+		switch hdrAlg {
+		case jwa.PS256, jwa.PS384, jwa.PS512:
+			if key.KeyType() == jwa.RSA {
+				return hdrAlg, nil
+			}
+		}
+	}
+
+	return alg, fmt.Errorf(
+		"attestation result signed with %s, where %s is expected for the %s verification key",
+		hdrAlg, alg, key.KeyType(),
+	)
+}
+
+// EARVerificationKeyFromJWK parses the public key used to verify attestation
+// results from the supplied JWK
+func EARVerificationKeyFromJWK(rawJWK []byte) (jwk.Key, error) {
+	return EARVerificationKeyFromKey(rawJWK, KeyFormatJWK)
+}
+
+// EARVerificationKeyFromKey is like EARVerificationKeyFromJWK, but accepts any
+// of the key formats understood by ParseKey
+func EARVerificationKeyFromKey(rawKey []byte, format string) (jwk.Key, error) {
+	key, err := ParseKey(rawKey, format)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwk.PublicKeyOf(key)
+}
+
+// FetchEARVerificationKey retrieves the attestation results verification key
+// from the discovery endpoint of the Veraison verifier hosting the supplied API
+// URL
+func FetchEARVerificationKey(apiURL string, isInsecure bool, certs []string) (jwk.Key, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("malformed API server URL: %w", err)
+	}
+
+	wk := url.URL{Scheme: u.Scheme, Host: u.Host, Path: VerificationWellKnownPath}
+
+	var client *apicommon.Client
+
+	switch {
+	case u.Scheme != "https":
+		client = apicommon.NewClient(nil)
+	case isInsecure:
+		client = apicommon.NewInsecureTLSClient(nil)
+	default:
+		if client, err = apicommon.NewTLSClient(nil, certs); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := client.HTTPClient.Get(wk.String())
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", wk.String(), err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: unexpected status: %s", wk.String(), res.Status)
+	}
+
+	var info struct {
+		EARVerificationKey json.RawMessage `json:"ear-verification-key"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("decoding verifier discovery information: %w", err)
+	}
+
+	if len(info.EARVerificationKey) == 0 {
+		return nil, errors.New("verifier discovery information does not include an EAR verification key")
+	}
+
+	return EARVerificationKeyFromJWK(info.EARVerificationKey)
+}
+
+// LoadEARVerificationKey loads the attestation results verification key from
+// keyFile if set, or fetches it from the verifier otherwise
+func LoadEARVerificationKey(
+	fs afero.Fs, keyFile string, apiURL string, isInsecure bool, certs []string,
+) (jwk.Key, error) {
+	if keyFile == "" {
+		key, err := FetchEARVerificationKey(apiURL, isInsecure, certs)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving EAR verification key: %w", err)
+		}
+		return key, nil
+	}
+
+	raw, err := afero.ReadFile(fs, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading EAR verification key from %s: %w", keyFile, err)
+	}
+
+	key, err := EARVerificationKeyFromKey(raw, KeyFormatAuto)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding EAR verification key from %s: %w", keyFile, err)
+	}
+
+	return key, nil
+}
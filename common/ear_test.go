@@ -0,0 +1,228 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_TrustClaim_Tier(t *testing.T) {
+	tvs := []struct {
+		claim    TrustClaim
+		expected TrustTier
+	}{
+		{-1, TrustTierNone},
+		{0, TrustTierNone},
+		{1, TrustTierNone},
+		{2, TrustTierAffirming},
+		{-2, TrustTierAffirming},
+		{31, TrustTierAffirming},
+		{32, TrustTierWarning},
+		{-33, TrustTierWarning},
+		{95, TrustTierWarning},
+		{96, TrustTierContraindicated},
+		{99, TrustTierContraindicated},
+		{-128, TrustTierContraindicated},
+	}
+
+	for _, tv := range tvs {
+		assert.Equal(t, tv.expected, tv.claim.Tier(), "claim %d", tv.claim)
+	}
+}
+
+func Test_TrustClaim_Meaning(t *testing.T) {
+	assert.Equal(t, "the attesting environment is not recognized",
+		TrustClaim(97).Meaning("instance-identity"))
+	assert.Equal(t, "no claim is being made", TrustClaim(0).Meaning("hardware"))
+	assert.Equal(t, "unrecognized claim value", TrustClaim(50).Meaning("hardware"))
+}
+
+func Test_TrustTier_JSON_roundtrip(t *testing.T) {
+	for _, tier := range []TrustTier{
+		TrustTierNone, TrustTierAffirming, TrustTierWarning, TrustTierContraindicated,
+	} {
+		data, err := json.Marshal(tier)
+		require.NoError(t, err)
+
+		var actual TrustTier
+		require.NoError(t, json.Unmarshal(data, &actual))
+		assert.Equal(t, tier, actual)
+	}
+}
+
+func Test_TrustTier_UnmarshalJSON(t *testing.T) {
+	var tier TrustTier
+
+	assert.NoError(t, json.Unmarshal([]byte(`96`), &tier))
+	assert.Equal(t, TrustTierContraindicated, tier)
+
+	assert.EqualError(t, json.Unmarshal([]byte(`"bogus"`), &tier),
+		`unknown trust tier "bogus": allowed values are none, affirming, warning and contraindicated`)
+
+	assert.EqualError(t, json.Unmarshal([]byte(`3`), &tier), `invalid trust tier 3`)
+}
+
+func Test_DecodeAndVerifyAttestationResult_unsigned(t *testing.T) {
+	key, err := EARVerificationKeyFromJWK([]byte(`{
+		"kty": "EC",
+		"crv": "P-256",
+		"x": "MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4",
+		"y": "4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM"
+	}`))
+	require.NoError(t, err)
+
+	// {"alg":"none"}.{"eat_profile":"tag:github.com,2023:veraison/ear"}.
+	unsigned := []byte(`eyJhbGciOiJub25lIn0.eyJlYXRfcHJvZmlsZSI6InRhZzpnaXRodWIuY29tLDIwMjM6dmVyYWlzb24vZWFyIn0.`)
+
+	_, err = DecodeAndVerifyAttestationResult(unsigned, key)
+	assert.EqualError(t, err, "unsigned attestation result")
+}
+
+func Test_AttestationResult_Sign_roundtrip(t *testing.T) {
+	sk, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	key, err := jwk.FromRaw(sk)
+	require.NoError(t, err)
+
+	ar := AttestationResult{
+		Profile:  EARProfile,
+		IssuedAt: 1727701200,
+		Nonce:    "AAECAw",
+		Submods: map[string]Appraisal{
+			"PSA_IOT": {Status: TrustTierWarning},
+		},
+	}
+
+	jwt, err := ar.Sign(key)
+	require.NoError(t, err)
+
+	pub, err := jwk.PublicKeyOf(key)
+	require.NoError(t, err)
+
+	actual, err := DecodeAndVerifyAttestationResult(jwt, pub)
+	require.NoError(t, err)
+
+	assert.Equal(t, ar, *actual)
+}
+
+func Test_DecodeAndVerifyAttestationResult_header_alg(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	ar := AttestationResult{
+		Profile:  EARProfile,
+		IssuedAt: 1727701200,
+		Submods: map[string]Appraisal{
+			"PSA_IOT": {Status: TrustTierAffirming},
+		},
+	}
+
+	tvs := []struct {
+		desc        string
+		signer      any
+		signerAlg   jwa.SignatureAlgorithm
+		verifier    any
+		verifierAlg jwa.SignatureAlgorithm
+		expectedErr string
+	}{
+		{
+			desc:      "RSA-PSS hash picked by the header",
+			signer:    rsaKey,
+			signerAlg: jwa.PS512,
+			verifier:  rsaKey,
+		},
+		{
+			desc:        "RSA-PSS hash other than the key's",
+			signer:      rsaKey,
+			signerAlg:   jwa.PS512,
+			verifier:    rsaKey,
+			verifierAlg: jwa.PS256,
+			expectedErr: "attestation result signed with PS512, where PS256 is expected for the RSA verification key",
+		},
+		{
+			desc:        "algorithm of another key type",
+			signer:      ed,
+			verifier:    p256,
+			expectedErr: "attestation result signed with EdDSA, where ES256 is expected for the EC verification key",
+		},
+	}
+
+	for _, tv := range tvs {
+		sk, err := jwk.FromRaw(tv.signer)
+		require.NoError(t, err)
+		if tv.signerAlg != "" {
+			require.NoError(t, sk.Set(jwk.AlgorithmKey, tv.signerAlg))
+		}
+
+		jwt, err := ar.Sign(sk)
+		require.NoError(t, err, tv.desc)
+
+		vk, err := jwk.FromRaw(tv.verifier)
+		require.NoError(t, err)
+		vk, err = jwk.PublicKeyOf(vk)
+		require.NoError(t, err)
+		if tv.verifierAlg != "" {
+			require.NoError(t, vk.Set(jwk.AlgorithmKey, tv.verifierAlg))
+		}
+
+		_, err = DecodeAndVerifyAttestationResult(jwt, vk)
+		if tv.expectedErr != "" {
+			assert.EqualError(t, err, tv.expectedErr, tv.desc)
+		} else {
+			assert.NoError(t, err, tv.desc)
+		}
+	}
+}
+
+func Test_JWSAlgorithmForKey(t *testing.T) {
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	require.NoError(t, err)
+
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	withAlg, err := jwk.FromRaw(p521)
+	require.NoError(t, err)
+	require.NoError(t, withAlg.Set(jwk.AlgorithmKey, jwa.ES384))
+
+	tvs := []struct {
+		raw      any
+		key      jwk.Key
+		expected jwa.SignatureAlgorithm
+	}{
+		{raw: p521, expected: jwa.ES512},
+		{raw: ed, expected: jwa.EdDSA},
+		{key: withAlg, expected: jwa.ES384},
+	}
+
+	for _, tv := range tvs {
+		key := tv.key
+		if key == nil {
+			key, err = jwk.FromRaw(tv.raw)
+			require.NoError(t, err)
+		}
+
+		alg, err := JWSAlgorithmForKey(key)
+		require.NoError(t, err)
+		assert.Equal(t, tv.expected, alg)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/veraison/apiclient/verification"
+)
+
+const (
+	// EvidencePath is the endpoint at which an EvidenceServer accepts
+	// challenges from relying parties
+	EvidencePath = "/evidence"
+
+	ProblemMediaType = "application/problem+json"
+)
+
+// EvidenceRequest is the body of a request to an EvidenceServer.  The nonce
+// is base64 encoded, using either the standard or the URL-safe alphabet, with
+// or without padding.
+type EvidenceRequest struct {
+	Nonce string `json:"nonce"`
+}
+
+// EvidenceServer emulates an attester: it receives a challenge from a relying
+// party and responds with evidence freshly built around it
+type EvidenceServer struct {
+	// Builder creates the evidence for the supplied nonce
+	Builder verification.EvidenceBuilder
+	// MediaType is the media type of the evidence produced by Builder.  It
+	// is used when the relying party does not state a preference.
+	MediaType string
+	// CheckNonce, if set, validates the nonce before evidence is built
+	CheckNonce func(nonce []byte) error
+
+	// the claims held by the builder are updated on each request
+	mu sync.Mutex
+}
+
+// Handler returns the HTTP handler implementing the evidence endpoint
+func (o *EvidenceServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST "+EvidencePath, o.evidence)
+
+	return mux
+}
+
+func (o *EvidenceServer) evidence(w http.ResponseWriter, r *http.Request) {
+	var req EvidenceRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteProblem(w, http.StatusBadRequest, fmt.Sprintf("malformed request: %v", err))
+		return
+	}
+
+	nonce, err := DecodeNonce(req.Nonce)
+	if err != nil {
+		WriteProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if o.CheckNonce != nil {
+		if err = o.CheckNonce(nonce); err != nil {
+			WriteProblem(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	o.mu.Lock()
+	evidence, mt, err := o.Builder.BuildEvidence(nonce, o.accept(r))
+	o.mu.Unlock()
+
+	if err != nil {
+		WriteProblem(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", mt)
+	w.WriteHeader(http.StatusOK)
+	w.Write(evidence) // nolint: errcheck
+}
+
+// accept returns the media types listed in the request's Accept header, or the
+// server's own media type if the relying party accepts anything
+func (o *EvidenceServer) accept(r *http.Request) []string {
+	var mts []string
+
+	for _, h := range r.Header.Values("Accept") {
+		for _, mt := range strings.Split(h, ",") {
+			mt = strings.TrimSpace(mt)
+			if mt == "" {
+				continue
+			}
+
+			if base, _, err := mime.ParseMediaType(mt); err == nil && base == "*/*" {
+				return []string{o.MediaType}
+			}
+
+			mts = append(mts, mt)
+		}
+	}
+
+	if len(mts) == 0 {
+		return []string{o.MediaType}
+	}
+
+	return mts
+}
+
+// DecodeNonce decodes a base64 nonce, accepting both the standard and the
+// URL-safe alphabets, with or without padding
+func DecodeNonce(s string) ([]byte, error) {
+	if s == "" {
+		return nil, errors.New("no nonce supplied")
+	}
+
+	for _, enc := range []*base64.Encoding{
+		base64.URLEncoding,
+		base64.RawURLEncoding,
+		base64.StdEncoding,
+		base64.RawStdEncoding,
+	} {
+		if nonce, err := enc.DecodeString(s); err == nil {
+			return nonce, nil
+		}
+	}
+
+	return nil, fmt.Errorf("malformed nonce %q: not base64", s)
+}
+
+// WriteProblem reports an error to an HTTP client as an RFC 7807 problem
+// details object
+func WriteProblem(w http.ResponseWriter, status int, detail string) {
+	p := map[string]any{
+		"title":  http.StatusText(status),
+		"status": status,
+		"detail": detail,
+	}
+
+	data, _ := json.Marshal(p)
+
+	w.Header().Set("Content-Type", ProblemMediaType)
+	w.WriteHeader(status)
+	w.Write(data) // nolint: errcheck
+}
@@ -0,0 +1,27 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DecodeNonce(t *testing.T) {
+	expected := []byte{0xfb, 0xff, 0xbf, 0x00}
+
+	for _, s := range []string{"-_-_AA==", "-_-_AA", "+/+/AA==", "+/+/AA"} {
+		nonce, err := DecodeNonce(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, expected, nonce, s)
+	}
+
+	_, err := DecodeNonce("")
+	assert.EqualError(t, err, "no nonce supplied")
+
+	_, err = DecodeNonce("not*base64")
+	assert.EqualError(t, err, `malformed nonce "not*base64": not base64`)
+}
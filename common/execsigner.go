@@ -0,0 +1,199 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	cose "github.com/veraison/go-cose"
+)
+
+const (
+	execScheme = "exec:"
+
+	// ExecSignAlgEnv names the environment variable that tells the signing
+	// helper which COSE algorithm (e.g., ES256) to sign with
+	ExecSignAlgEnv = "EVCLI_SIGN_ALG"
+
+	execOpPublicKey = "public-key"
+	execOpSign      = "sign"
+)
+
+// IsExecRef tells whether the supplied key reference designates an external
+// signing helper
+func IsExecRef(ref string) bool {
+	return strings.HasPrefix(ref, execScheme)
+}
+
+// execSigner is a cose.Signer that delegates signing to an external helper
+// program.  The helper is invoked with the arguments from the key reference,
+// followed by the operation:
+//
+//   - public-key: write the public key to stdout, in any of the formats
+//     understood by ParseKey;
+//   - sign: read the COSE Sig_structure from stdin and write the signature to
+//     stdout.  The signature is in COSE form, though ECDSA signatures may also
+//     be ASN.1 encoded.  The algorithm is given in EVCLI_SIGN_ALG.
+//
+// A non-zero exit status means failure; whatever the helper wrote to stderr
+// is reported.
+type execSigner struct {
+	argv     []string
+	alg      cose.Algorithm
+	pub      jwk.Key
+	verifier cose.Verifier
+}
+
+// NewExecSigningKey creates a SigningKey backed by the signing helper
+// designated by ref, e.g., "exec:/usr/local/bin/my-signer --rig 'lab 3'".  The
+// program and its arguments are split as described in splitExecArgs.  The
+// public key returned by the helper is decoded according to format.
+func NewExecSigningKey(ref, format string) (*SigningKey, error) {
+	argv, err := splitExecArgs(strings.TrimPrefix(ref, execScheme))
+	if err != nil {
+		return nil, fmt.Errorf("parsing signing helper command line: %w", err)
+	}
+
+	if len(argv) == 0 {
+		return nil, errors.New("no signing helper program")
+	}
+
+	s := &execSigner{argv: argv}
+
+	out, err := s.run(execOpPublicKey, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.pub, s.alg, err = publicKeyAndAlgorithm(out, format); err != nil {
+		return nil, fmt.Errorf("decoding public key from signing helper: %w", err)
+	}
+
+	pub, err := RawPublicKey(s.pub)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.verifier, err = cose.NewVerifier(s.alg, pub); err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{Signer: s, Public: s.pub}, nil
+}
+
+// splitExecArgs splits the command line of a signing helper into the program
+// and its arguments, as a POSIX shell would, minus the expansions: words are
+// separated by blanks, which lose their meaning within single or double
+// quotes.  Single quotes preserve everything up to the closing quote.  Outside
+// quotes, a backslash preserves the next character; within double quotes, it
+// only does so for a double quote or a backslash.
+func splitExecArgs(s string) ([]string, error) {
+	var (
+		argv    []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			if quote == '"' && r != '"' && r != '\\' {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				argv = append(argv, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+
+	if inWord {
+		argv = append(argv, word.String())
+	}
+
+	return argv, nil
+}
+
+func (o *execSigner) run(op string, stdin []byte, env []string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(o.argv[0], append(o.argv[1:], op)...) // nolint: gosec
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), env...)
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("signing helper %s %s: %w: %s", o.argv[0], op, err, msg)
+		}
+		return nil, fmt.Errorf("signing helper %s %s: %w", o.argv[0], op, err)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func (o *execSigner) Algorithm() cose.Algorithm {
+	return o.alg
+}
+
+// Sign has the helper sign the supplied Sig_structure.  The signature is
+// checked before it is returned, so that a misbehaving helper is caught here
+// rather than by the verifier.
+func (o *execSigner) Sign(_ io.Reader, content []byte) ([]byte, error) {
+	sig, err := o.run(execOpSign, content, []string{ExecSignAlgEnv + "=" + o.alg.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	if sig, err = normalizeSignature(o.pub, sig); err != nil {
+		return nil, fmt.Errorf("decoding signature from signing helper: %w", err)
+	}
+
+	if err = o.verifier.Verify(content, sig); err != nil {
+		return nil, fmt.Errorf("signature from signing helper does not verify: %w", err)
+	}
+
+	return sig, nil
+}
@@ -0,0 +1,262 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cose "github.com/veraison/go-cose"
+)
+
+// When testExecHelperKeyEnv is set, the test binary acts as a signing helper
+// (see execSigner) for the JWK in that file.  testExecHelperModeEnv selects
+// how it misbehaves, if at all.
+const (
+	testExecHelperKeyEnv  = "EVCLI_TEST_EXEC_HELPER_KEY"
+	testExecHelperModeEnv = "EVCLI_TEST_EXEC_HELPER_MODE"
+)
+
+func TestMain(m *testing.M) {
+	if fn := os.Getenv(testExecHelperKeyEnv); fn != "" {
+		if err := testExecHelper(fn, os.Args[len(os.Args)-1]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func testExecHelper(fn, op string) error {
+	raw, err := os.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+
+	mode := os.Getenv(testExecHelperModeEnv)
+
+	switch op {
+	case execOpPublicKey:
+		key, err := ParseKey(raw, KeyFormatJWK)
+		if err != nil {
+			return err
+		}
+
+		pub, err := jwk.PublicKeyOf(key)
+		if err != nil {
+			return err
+		}
+
+		return json.NewEncoder(os.Stdout).Encode(pub)
+	case execOpSign:
+		if mode == "fail" {
+			return fmt.Errorf("lab rig unavailable")
+		}
+
+		if os.Getenv(ExecSignAlgEnv) != "ES256" {
+			return fmt.Errorf("unexpected %s", ExecSignAlgEnv)
+		}
+
+		tbs, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+
+		key, err := ParseKey(raw, KeyFormatJWK)
+		if err != nil {
+			return err
+		}
+
+		var priv ecdsa.PrivateKey
+		if err = key.Raw(&priv); err != nil {
+			return err
+		}
+
+		if mode == "wrong-content" {
+			tbs = []byte("something else")
+		}
+
+		digest := sha256.Sum256(tbs)
+
+		// ASN.1, as produced by most signing tools
+		sig, err := ecdsa.SignASN1(rand.Reader, &priv, digest[:])
+		if err != nil {
+			return err
+		}
+
+		if mode == "truncated" {
+			sig = sig[:10]
+		}
+
+		_, err = os.Stdout.Write(sig)
+
+		return err
+	}
+
+	return fmt.Errorf("unknown operation %q", op)
+}
+
+// newTestExecHelper returns an exec: reference to the test binary acting as a
+// signing helper for a fresh P-256 key, and that key
+func newTestExecHelper(t *testing.T, mode string) (string, *ecdsa.PrivateKey) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	key, err := jwk.FromRaw(priv)
+	require.NoError(t, err)
+
+	raw, err := json.Marshal(key)
+	require.NoError(t, err)
+
+	fn := filepath.Join(t.TempDir(), "key.jwk")
+	require.NoError(t, os.WriteFile(fn, raw, 0600))
+
+	t.Setenv(testExecHelperKeyEnv, fn)
+	t.Setenv(testExecHelperModeEnv, mode)
+
+	exe, err := os.Executable()
+	require.NoError(t, err)
+
+	return "exec:" + exe + " -test.run=^$", priv
+}
+
+func Test_LoadSigningKey_exec_ok(t *testing.T) {
+	ref, priv := newTestExecHelper(t, "")
+
+	sk, err := LoadSigningKey(afero.NewMemMapFs(), ref, KeyFormatAuto, "IAK signing key")
+	require.NoError(t, err)
+	assert.Equal(t, cose.AlgorithmES256, sk.Algorithm())
+
+	pub, err := RawPublicKey(sk.Public)
+	require.NoError(t, err)
+	assert.True(t, priv.PublicKey.Equal(pub))
+
+	msg := cose.NewSign1Message()
+	msg.Payload = []byte("payload")
+	msg.Headers.Protected.SetAlgorithm(sk.Algorithm())
+	require.NoError(t, msg.Sign(rand.Reader, nil, sk))
+
+	verifier, err := cose.NewVerifier(cose.AlgorithmES256, &priv.PublicKey)
+	require.NoError(t, err)
+	assert.NoError(t, msg.Verify(nil, verifier))
+}
+
+func Test_LoadSigningKey_exec_sign_fail(t *testing.T) {
+	tvs := []struct {
+		mode        string
+		expectedErr string
+	}{
+		{
+			mode:        "fail",
+			expectedErr: "exit status 1: lab rig unavailable",
+		},
+		{
+			mode:        "wrong-content",
+			expectedErr: "signature from signing helper does not verify: verification error",
+		},
+		{
+			mode:        "truncated",
+			expectedErr: "decoding signature from signing helper: unexpected ECDSA signature size 10",
+		},
+	}
+
+	for _, tv := range tvs {
+		t.Run(tv.mode, func(t *testing.T) {
+			ref, _ := newTestExecHelper(t, tv.mode)
+
+			sk, err := LoadSigningKey(afero.NewMemMapFs(), ref, KeyFormatAuto, "IAK signing key")
+			require.NoError(t, err)
+
+			msg := cose.NewSign1Message()
+			msg.Payload = []byte("payload")
+			msg.Headers.Protected.SetAlgorithm(sk.Algorithm())
+			assert.ErrorContains(t, msg.Sign(rand.Reader, nil, sk), tv.expectedErr)
+		})
+	}
+}
+
+func Test_LoadSigningKey_exec_fail(t *testing.T) {
+	_, err := LoadSigningKey(afero.NewMemMapFs(), "exec:", KeyFormatAuto, "IAK signing key")
+	assert.EqualError(t, err, "error opening IAK signing key exec:: no signing helper program")
+
+	_, err = LoadSigningKey(afero.NewMemMapFs(), "exec:/nonexistent/signer", KeyFormatAuto, "IAK signing key")
+	assert.ErrorContains(t, err,
+		"error opening IAK signing key exec:/nonexistent/signer: signing helper /nonexistent/signer public-key:")
+
+	_, err = LoadSigningKey(afero.NewMemMapFs(), "exec:'/opt/lab tools/signer", KeyFormatAuto, "IAK signing key")
+	assert.EqualError(t, err,
+		"error opening IAK signing key exec:'/opt/lab tools/signer: parsing signing helper command line: unterminated ' quote")
+
+	// a helper that prints nothing
+	_, err = LoadSigningKey(afero.NewMemMapFs(), "exec:true", KeyFormatAuto, "IAK signing key")
+	assert.ErrorContains(t, err, "decoding public key from signing helper")
+}
+
+func Test_LoadSigningKey_exec_quoted_path(t *testing.T) {
+	ref, priv := newTestExecHelper(t, "")
+
+	// the test binary, in a directory with a blank in its name
+	exe, err := os.Executable()
+	require.NoError(t, err)
+
+	link := filepath.Join(t.TempDir(), "lab rig", "signer")
+	require.NoError(t, os.Mkdir(filepath.Dir(link), 0700))
+	require.NoError(t, os.Symlink(exe, link))
+
+	ref = strings.Replace(ref, exe, `"`+link+`"`, 1)
+
+	sk, err := LoadSigningKey(afero.NewMemMapFs(), ref, KeyFormatAuto, "IAK signing key")
+	require.NoError(t, err)
+
+	pub, err := RawPublicKey(sk.Public)
+	require.NoError(t, err)
+	assert.True(t, priv.PublicKey.Equal(pub))
+}
+
+func Test_splitExecArgs(t *testing.T) {
+	tvs := []struct {
+		in       string
+		expected []string
+	}{
+		{``, nil},
+		{` 	 `, nil},
+		{`/usr/local/bin/my-signer --rig lab3`, []string{"/usr/local/bin/my-signer", "--rig", "lab3"}},
+		{`  signer   --rig	lab3  `, []string{"signer", "--rig", "lab3"}},
+		{`'/opt/lab tools/signer' --rig 'lab 3'`, []string{"/opt/lab tools/signer", "--rig", "lab 3"}},
+		{`"/opt/lab tools/signer" --label="rig \"3\""`, []string{"/opt/lab tools/signer", `--label=rig "3"`}},
+		{`signer 'it''s' "a\b" a\ b \'`, []string{"signer", "its", `a\b`, "a b", "'"}},
+		{`signer '' ""`, []string{"signer", "", ""}},
+		{`signer '"' "'"`, []string{"signer", `"`, "'"}},
+	}
+
+	for _, tv := range tvs {
+		actual, err := splitExecArgs(tv.in)
+		require.NoError(t, err, tv.in)
+		assert.Equal(t, tv.expected, actual, tv.in)
+	}
+
+	_, err := splitExecArgs(`signer 'lab 3`)
+	assert.EqualError(t, err, "unterminated ' quote")
+
+	_, err = splitExecArgs(`signer "lab 3`)
+	assert.EqualError(t, err, `unterminated " quote`)
+
+	_, err = splitExecArgs(`signer lab\`)
+	assert.EqualError(t, err, "trailing backslash")
+}
@@ -0,0 +1,159 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Exit codes used when an attestation result does not meet the expectations
+// set by the user.  Any other failure results in exit code 1.
+const (
+	ExitCodeStatusNotMet      = 2
+	ExitCodeTrustVectorNotMet = 3
+)
+
+// ExitError is an error that carries the process exit code that should be
+// used to report it
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (o *ExitError) Error() string {
+	return o.Err.Error()
+}
+
+func (o *ExitError) Unwrap() error {
+	return o.Err
+}
+
+// Expectation holds the minimum acceptable appraisal status and, optionally,
+// the minimum acceptable tier for individual trustworthiness vector claims
+type Expectation struct {
+	Status TrustTier
+	Claims map[string]TrustTier
+}
+
+// ParseExpectation parses an expectation in the form
+//
+//	<status>[,<claim>=<tier>...]
+//
+// for example "warning,executables=affirming,hardware=affirming".  An empty
+// string results in a nil Expectation.
+func ParseExpectation(s string) (*Expectation, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(s, ",")
+
+	status, err := expectedTierFromString(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return nil, fmt.Errorf("parsing expected status: %w", err)
+	}
+
+	e := Expectation{Status: status, Claims: map[string]TrustTier{}}
+
+	for _, p := range parts[1:] {
+		name, tier, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok {
+			return nil, fmt.Errorf(
+				"malformed trust vector expectation %q: must be in the form <claim>=<tier>", p,
+			)
+		}
+
+		var tv TrustVector
+		if tv.Claim(name) == nil {
+			return nil, fmt.Errorf(
+				"unknown trust vector claim %q: allowed claims are %s",
+				name, strings.Join(TrustVectorClaimNames, ", "),
+			)
+		}
+
+		if e.Claims[name], err = expectedTierFromString(tier); err != nil {
+			return nil, fmt.Errorf("parsing expectation for %s: %w", name, err)
+		}
+	}
+
+	return &e, nil
+}
+
+func expectedTierFromString(s string) (TrustTier, error) {
+	t, err := TrustTierFromString(s)
+	if err == nil && t == TrustTierNone {
+		err = errors.New("none cannot be used as an expectation")
+	}
+	if err != nil {
+		return TrustTierNone, err
+	}
+	return t, nil
+}
+
+// tierRank orders tiers from the least to the most trustworthy.  "none" sits
+// between contraindicated and warning: the absence of a claim never meets an
+// affirming or warning expectation.
+func tierRank(t TrustTier) int {
+	switch t {
+	case TrustTierAffirming:
+		return 3
+	case TrustTierWarning:
+		return 2
+	case TrustTierNone:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func meets(actual, expected TrustTier) bool {
+	return tierRank(actual) >= tierRank(expected)
+}
+
+// Check verifies that each submod in the supplied attestation result meets the
+// expectation.  An *ExitError is returned if that is not the case.
+func (o Expectation) Check(ar *AttestationResult) error {
+	for _, name := range ar.SubmodNames() {
+		a := ar.Submods[name]
+
+		if !meets(a.Status, o.Status) {
+			return &ExitError{
+				Code: ExitCodeStatusNotMet,
+				Err: fmt.Errorf(
+					"submod %q: status %s does not meet the expected %s",
+					name, a.Status, o.Status,
+				),
+			}
+		}
+
+		for _, cn := range TrustVectorClaimNames {
+			expected, ok := o.Claims[cn]
+			if !ok {
+				continue
+			}
+
+			if a.TrustVector == nil {
+				return &ExitError{
+					Code: ExitCodeTrustVectorNotMet,
+					Err:  fmt.Errorf("submod %q: no trust vector found", name),
+				}
+			}
+
+			actual := *a.TrustVector.Claim(cn)
+			if !meets(actual.Tier(), expected) {
+				return &ExitError{
+					Code: ExitCodeTrustVectorNotMet,
+					Err: fmt.Errorf(
+						"submod %q: %s claim %d (%s) does not meet the expected %s",
+						name, cn, actual, actual.Tier(), expected,
+					),
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseExpectation_ok(t *testing.T) {
+	e, err := ParseExpectation("warning, executables=affirming,hardware=contraindicated")
+	require.NoError(t, err)
+
+	expected := Expectation{
+		Status: TrustTierWarning,
+		Claims: map[string]TrustTier{
+			"executables": TrustTierAffirming,
+			"hardware":    TrustTierContraindicated,
+		},
+	}
+
+	assert.Equal(t, expected, *e)
+}
+
+func Test_ParseExpectation_empty(t *testing.T) {
+	e, err := ParseExpectation("")
+	assert.NoError(t, err)
+	assert.Nil(t, e)
+}
+
+func Test_ParseExpectation_fail(t *testing.T) {
+	tvs := []struct {
+		in       string
+		expected string
+	}{
+		{"bogus", `parsing expected status: unknown trust tier "bogus": allowed values are none, affirming, warning and contraindicated`},
+		{"none", `parsing expected status: none cannot be used as an expectation`},
+		{"affirming,executables", `malformed trust vector expectation "executables": must be in the form <claim>=<tier>`},
+		{"affirming,foo=warning", `unknown trust vector claim "foo": allowed claims are instance-identity, configuration, executables, file-system, hardware, runtime-opaque, storage-opaque, sourced-data`},
+		{"affirming,hardware=bar", `parsing expectation for hardware: unknown trust tier "bar": allowed values are none, affirming, warning and contraindicated`},
+	}
+
+	for _, tv := range tvs {
+		_, err := ParseExpectation(tv.in)
+		assert.EqualError(t, err, tv.expected, tv.in)
+	}
+}
+
+func testAttestationResult(status TrustTier, tv *TrustVector) *AttestationResult {
+	return &AttestationResult{
+		Profile:  EARProfile,
+		IssuedAt: 1,
+		Submods: map[string]Appraisal{
+			"test": {Status: status, TrustVector: tv},
+		},
+	}
+}
+
+func Test_Expectation_Check(t *testing.T) {
+	tv := &TrustVector{Executables: 33, Hardware: 2}
+
+	tvs := []struct {
+		expect   string
+		ar       *AttestationResult
+		exitCode int
+	}{
+		{"affirming", testAttestationResult(TrustTierAffirming, tv), 0},
+		{"warning", testAttestationResult(TrustTierAffirming, tv), 0},
+		{"affirming", testAttestationResult(TrustTierWarning, tv), ExitCodeStatusNotMet},
+		{"warning", testAttestationResult(TrustTierNone, tv), ExitCodeStatusNotMet},
+		{"contraindicated", testAttestationResult(TrustTierNone, tv), 0},
+		{"warning", testAttestationResult(TrustTierContraindicated, tv), ExitCodeStatusNotMet},
+		{"warning,hardware=affirming", testAttestationResult(TrustTierWarning, tv), 0},
+		{"warning,executables=warning", testAttestationResult(TrustTierWarning, tv), 0},
+		{"warning,executables=affirming", testAttestationResult(TrustTierWarning, tv), ExitCodeTrustVectorNotMet},
+		{"warning,configuration=warning", testAttestationResult(TrustTierWarning, tv), ExitCodeTrustVectorNotMet},
+		{"warning,hardware=affirming", testAttestationResult(TrustTierWarning, nil), ExitCodeTrustVectorNotMet},
+	}
+
+	for _, v := range tvs {
+		e, err := ParseExpectation(v.expect)
+		require.NoError(t, err)
+
+		err = e.Check(v.ar)
+		if v.exitCode == 0 {
+			assert.NoError(t, err, v.expect)
+			continue
+		}
+
+		var exitErr *ExitError
+		require.True(t, errors.As(err, &exitErr), v.expect)
+		assert.Equal(t, v.exitCode, exitErr.Code, v.expect)
+	}
+}
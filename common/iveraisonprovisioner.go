@@ -0,0 +1,14 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+// IVeraisonProvisioner is an interface for dealing with Veraison's
+// apiclient/provisioning SubmitConfig objects
+type IVeraisonProvisioner interface {
+	Run(endorsement []byte, mediaType string) error
+	SetSubmitURI(uri string) error
+	SetDeleteSession(session bool)
+	SetIsInsecure(val bool)
+	SetCerts(paths []string)
+}
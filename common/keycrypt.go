@@ -0,0 +1,288 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwe"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	"github.com/youmark/pkcs8"
+	"golang.org/x/term"
+)
+
+const (
+	// KeyPassphraseEnv names the environment variable with the passphrase of
+	// encrypted key files
+	KeyPassphraseEnv = "EVCLI_KEY_PASSPHRASE"
+	// KeyPassphraseFDEnv names the environment variable with the number of a
+	// file descriptor to read the passphrases of encrypted key files from, one
+	// per line
+	KeyPassphraseFDEnv = "EVCLI_KEY_PASSPHRASE_FD"
+
+	pemEncryptedPrivateKey = "ENCRYPTED PRIVATE KEY"
+
+	// JWE content type of an encrypted JWK (RFC 7517, section 7)
+	jweContentTypeJWK = "jwk+json"
+)
+
+var oidPBES2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+
+// LoadKeyFile reads the key in fn, decrypting it if it is encrypted, and
+// returns it together with the format to pass to ParseKey.  Decrypted keys are
+// returned as JWKs.  what describes the key in error messages and passphrase
+// prompts, e.g., "IAK signing key".
+func LoadKeyFile(fs afero.Fs, fn, format, what string) ([]byte, string, error) {
+	raw, err := afero.ReadFile(fs, fn)
+	if err != nil {
+		return nil, "", fmt.Errorf("error loading %s from %s: %w", what, fn, err)
+	}
+
+	if !IsEncryptedKey(raw) {
+		return raw, format, nil
+	}
+
+	passphrase, err := ReadKeyPassphrase(fmt.Sprintf("%s %s", what, fn), false)
+	if err != nil {
+		return nil, "", fmt.Errorf("error decrypting %s from %s: %w", what, fn, err)
+	}
+
+	key, err := DecryptKey(raw, passphrase)
+	if err != nil {
+		return nil, "", fmt.Errorf("error decrypting %s from %s: %w", what, fn, err)
+	}
+
+	if raw, err = json.Marshal(key); err != nil {
+		return nil, "", fmt.Errorf("error decrypting %s from %s: %w", what, fn, err)
+	}
+
+	return raw, KeyFormatJWK, nil
+}
+
+// IsEncryptedKey tells whether raw is an encrypted key: a JWE, in compact or
+// JSON serialization, or a PBES2-encrypted PKCS#8 private key, in PEM or DER
+func IsEncryptedKey(raw []byte) bool {
+	return isJWE(raw) || isEncryptedPKCS8(raw)
+}
+
+func isJWE(raw []byte) bool {
+	raw = bytes.TrimSpace(raw)
+
+	// the header of a compact JWE is a base64url-encoded JSON object
+	if bytes.HasPrefix(raw, []byte("eyJ")) && bytes.Count(raw, []byte(".")) == 4 {
+		return true
+	}
+
+	var m map[string]json.RawMessage
+	if json.Unmarshal(raw, &m) != nil {
+		return false
+	}
+
+	_, ok := m["ciphertext"]
+
+	return ok
+}
+
+func isEncryptedPKCS8(raw []byte) bool {
+	if isPEM(raw) {
+		block, _ := pem.Decode(raw)
+		if block == nil || block.Type != pemEncryptedPrivateKey {
+			return false
+		}
+		raw = block.Bytes
+	}
+
+	var info struct {
+		Algo pkix.AlgorithmIdentifier
+		Data []byte
+	}
+
+	rest, err := asn1.Unmarshal(raw, &info)
+
+	return err == nil && len(rest) == 0 && info.Algo.Algorithm.Equal(oidPBES2)
+}
+
+// DecryptKey decrypts a JWE-encrypted JWK, which must use one of the PBES2 key
+// management algorithms, or a PBES2-encrypted PKCS#8 private key
+func DecryptKey(raw, passphrase []byte) (jwk.Key, error) {
+	if isJWE(raw) {
+		return decryptJWK(raw, passphrase)
+	}
+
+	der := raw
+	if block, _ := pem.Decode(raw); block != nil {
+		der = block.Bytes
+	}
+
+	k, err := pkcs8.ParsePKCS8PrivateKey(der, passphrase)
+	if err != nil {
+		// wrong passphrases are usually caught by the padding check
+		return nil, fmt.Errorf("failed to decrypt PKCS#8 private key (wrong passphrase?): %w", err)
+	}
+
+	return jwkFromRaw(k)
+}
+
+func decryptJWK(raw, passphrase []byte) (jwk.Key, error) {
+	msg, err := jwe.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWE: %w", err)
+	}
+
+	if len(msg.Recipients()) != 1 {
+		return nil, fmt.Errorf("want a JWE with one recipient, got %d", len(msg.Recipients()))
+	}
+
+	alg := msg.Recipients()[0].Headers().Algorithm()
+
+	switch alg {
+	case jwa.PBES2_HS256_A128KW, jwa.PBES2_HS384_A192KW, jwa.PBES2_HS512_A256KW:
+	default:
+		return nil, fmt.Errorf("unsupported JWE key management algorithm %s: a PBES2 one is needed", alg)
+	}
+
+	plain, err := jwe.Decrypt(raw, jwe.WithKey(alg, passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt JWE (wrong passphrase?): %w", err)
+	}
+
+	key, err := jwk.ParseKey(plain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key: %w", err)
+	}
+
+	return key, nil
+}
+
+// EncryptKey encrypts a private key with the supplied passphrase: as a JWE
+// (PBES2-HS256+A128KW and A128CBC-HS256, as in RFC 7517) for KeyFormatJWK, and
+// as a PBES2-encrypted PKCS#8 private key (PBKDF2 with SHA-256 and AES-256-CBC)
+// for KeyFormatPEM and KeyFormatDER
+func EncryptKey(key jwk.Key, format string, passphrase []byte) ([]byte, error) {
+	if isPrivate, err := jwk.IsPrivateKey(key); err != nil || !isPrivate {
+		return nil, errors.New("only private keys can be encrypted")
+	}
+
+	switch format {
+	case KeyFormatJWK:
+		plain, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+
+		hdrs := jwe.NewHeaders()
+		if err = hdrs.Set(jwe.ContentTypeKey, jweContentTypeJWK); err != nil {
+			return nil, err
+		}
+
+		return jwe.Encrypt(plain,
+			jwe.WithKey(jwa.PBES2_HS256_A128KW, passphrase),
+			jwe.WithContentEncryption(jwa.A128CBC_HS256),
+			jwe.WithProtectedHeaders(hdrs),
+		)
+	case KeyFormatPEM, KeyFormatDER:
+		var k any
+		if err := key.Raw(&k); err != nil {
+			return nil, fmt.Errorf("failed to export key: %w", err)
+		}
+
+		der, err := pkcs8.MarshalPrivateKey(k, passphrase, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt key: %w", err)
+		}
+
+		if format == KeyFormatDER {
+			return der, nil
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: pemEncryptedPrivateKey, Bytes: der}), nil
+	}
+
+	return nil, fmt.Errorf(
+		"key format %q does not support encryption: use %s, %s or %s",
+		format, KeyFormatJWK, KeyFormatPEM, KeyFormatDER,
+	)
+}
+
+// passphraseReaders keeps the file descriptors that passphrases are read
+// from open, so that each key gets its own line
+var passphraseReaders = map[int]*bufio.Reader{}
+
+// ReadKeyPassphrase obtains the passphrase of the key described by what: from
+// EVCLI_KEY_PASSPHRASE if set, otherwise from the next line of the file
+// descriptor in EVCLI_KEY_PASSPHRASE_FD, otherwise by prompting for it on the
+// terminal.  If confirm is set, as when a key is being encrypted, the prompt
+// asks for the passphrase twice.
+func ReadKeyPassphrase(what string, confirm bool) ([]byte, error) {
+	if p, ok := os.LookupEnv(KeyPassphraseEnv); ok {
+		return []byte(p), nil
+	}
+
+	if v := os.Getenv(KeyPassphraseFDEnv); v != "" {
+		fd, err := strconv.Atoi(v)
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("invalid %s %q", KeyPassphraseFDEnv, v)
+		}
+		return readPassphraseFromFD(fd)
+	}
+
+	stdin := int(os.Stdin.Fd())
+
+	if !term.IsTerminal(stdin) {
+		return nil, fmt.Errorf(
+			"no passphrase: set %s or %s, or run from a terminal",
+			KeyPassphraseEnv, KeyPassphraseFDEnv,
+		)
+	}
+
+	fmt.Fprintf(os.Stderr, "Passphrase for %s: ", what)
+	p, err := term.ReadPassword(stdin)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("reading passphrase: %w", err)
+	}
+
+	if confirm {
+		fmt.Fprintf(os.Stderr, "Repeat the passphrase for %s: ", what)
+		again, err := term.ReadPassword(stdin)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("reading passphrase: %w", err)
+		}
+
+		if !bytes.Equal(p, again) {
+			return nil, errors.New("the passphrases do not match")
+		}
+	}
+
+	return p, nil
+}
+
+func readPassphraseFromFD(fd int) ([]byte, error) {
+	r, ok := passphraseReaders[fd]
+	if !ok {
+		r = bufio.NewReader(os.NewFile(uintptr(fd), "passphrase"))
+		passphraseReaders[fd] = r
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		return nil, fmt.Errorf("reading passphrase from file descriptor %d: %w", fd, err)
+	}
+
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
@@ -0,0 +1,253 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// EncodeKey serialises the supplied key in one of the formats understood by
+// ParseKey.  Private keys are encoded as PKCS#8 in the PEM and DER formats,
+// public keys as SubjectPublicKeyInfo.
+func EncodeKey(key jwk.Key, format string) ([]byte, error) {
+	switch format {
+	case KeyFormatJWK:
+		return json.MarshalIndent(key, "", "  ")
+	case KeyFormatPEM, KeyFormatDER:
+		der, blockType, err := x509FromJWK(key)
+		if err != nil {
+			return nil, err
+		}
+		if format == KeyFormatDER {
+			return der, nil
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), nil
+	case KeyFormatCOSE:
+		return COSEKeyFromJWK(key)
+	}
+
+	return nil, fmt.Errorf(
+		"unknown output key format %q: allowed formats are %s, %s, %s and %s",
+		format, KeyFormatJWK, KeyFormatPEM, KeyFormatDER, KeyFormatCOSE,
+	)
+}
+
+func x509FromJWK(key jwk.Key) ([]byte, string, error) {
+	var raw any
+
+	if err := key.Raw(&raw); err != nil {
+		return nil, "", fmt.Errorf("failed to create key: %w", err)
+	}
+
+	switch raw.(type) {
+	case *ecdsa.PrivateKey, ed25519.PrivateKey, *rsa.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(raw)
+		if err != nil {
+			return nil, "", fmt.Errorf("PKCS#8 encoding failed: %w", err)
+		}
+		return der, "PRIVATE KEY", nil
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(raw)
+	if err != nil {
+		return nil, "", fmt.Errorf("SubjectPublicKeyInfo encoding failed: %w", err)
+	}
+
+	return der, "PUBLIC KEY", nil
+}
+
+// COSEKeyFromJWK encodes the supplied key as a COSE_Key, using the
+// deterministic CBOR encoding.  The "kid" and "alg" members are carried over.
+// For a public EC key without kid and alg, the result is the encoding expected
+// in the CCA cca-realm-public-key claim.
+func COSEKeyFromJWK(key jwk.Key) ([]byte, error) {
+	var raw any
+
+	if err := key.Raw(&raw); err != nil {
+		return nil, fmt.Errorf("failed to create key: %w", err)
+	}
+
+	m := map[int]any{}
+
+	switch k := raw.(type) {
+	case *ecdsa.PrivateKey:
+		if err := coseKeyFromECDSA(m, &k.PublicKey); err != nil {
+			return nil, err
+		}
+		m[coseKeyD] = k.D.FillBytes(make([]byte, coordinateSize(k.Curve)))
+	case *ecdsa.PublicKey:
+		if err := coseKeyFromECDSA(m, k); err != nil {
+			return nil, err
+		}
+	case ed25519.PrivateKey:
+		m[coseKeyKty] = coseKtyOKP
+		m[coseKeyCrv] = coseCrvEd25519
+		m[coseKeyX] = []byte(k.Public().(ed25519.PublicKey))
+		m[coseKeyD] = k.Seed()
+	case ed25519.PublicKey:
+		m[coseKeyKty] = coseKtyOKP
+		m[coseKeyCrv] = coseCrvEd25519
+		m[coseKeyX] = []byte(k)
+	case *rsa.PrivateKey:
+		coseKeyFromRSA(m, &k.PublicKey)
+		if len(k.Primes) != 2 {
+			return nil, fmt.Errorf("multi-prime RSA keys are not supported")
+		}
+		k.Precompute()
+		m[coseKeyRSAD] = k.D.Bytes()
+		m[coseKeyP] = k.Primes[0].Bytes()
+		m[coseKeyQ] = k.Primes[1].Bytes()
+		m[coseKeyDP] = k.Precomputed.Dp.Bytes()
+		m[coseKeyDQ] = k.Precomputed.Dq.Bytes()
+		m[coseKeyQInv] = k.Precomputed.Qinv.Bytes()
+	case *rsa.PublicKey:
+		coseKeyFromRSA(m, k)
+	default:
+		return nil, fmt.Errorf("unsupported key type %v", reflect.TypeOf(raw))
+	}
+
+	if kid := key.KeyID(); kid != "" {
+		m[coseKeyKid] = []byte(kid)
+	}
+
+	if ka := key.Algorithm(); ka != nil && ka.String() != "" {
+		alg, ok := jwaToCOSE[jwa.SignatureAlgorithm(ka.String())]
+		if !ok {
+			return nil, fmt.Errorf("unsupported JWK algorithm %s", ka)
+		}
+		m[coseKeyAlg] = int64(alg)
+	}
+
+	em, err := cbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		return nil, err
+	}
+
+	return em.Marshal(m)
+}
+
+func coseKeyFromECDSA(m map[int]any, k *ecdsa.PublicKey) error {
+	var crv int
+
+	switch k.Curve {
+	case elliptic.P256():
+		crv = coseCrvP256
+	case elliptic.P384():
+		crv = coseCrvP384
+	case elliptic.P521():
+		crv = coseCrvP521
+	default:
+		return fmt.Errorf("unknown elliptic curve %v", k.Curve.Params().Name)
+	}
+
+	sz := coordinateSize(k.Curve)
+
+	m[coseKeyKty] = coseKtyEC2
+	m[coseKeyCrv] = crv
+	m[coseKeyX] = k.X.FillBytes(make([]byte, sz))
+	m[coseKeyY] = k.Y.FillBytes(make([]byte, sz))
+
+	return nil
+}
+
+func coseKeyFromRSA(m map[int]any, k *rsa.PublicKey) {
+	m[coseKeyKty] = coseKtyRSA
+	m[coseKeyN] = k.N.Bytes()
+	m[coseKeyE] = big.NewInt(int64(k.E)).Bytes()
+}
+
+func coordinateSize(crv elliptic.Curve) int {
+	return (crv.Params().BitSize + 7) / 8
+}
+
+// InstanceIDFromKey computes the PSA and CCA instance ID of the device owning
+// the supplied attestation key: a 0x01 (RAND UEID) type byte followed by the
+// SHA-256 hash of the public key.  EC keys are hashed in uncompressed point
+// form, Ed25519 keys in their raw form and RSA keys as PKCS#1 DER.
+func InstanceIDFromKey(key jwk.Key) ([]byte, error) {
+	pub, err := RawPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var encoded []byte
+
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		sz := coordinateSize(k.Curve)
+		encoded = append([]byte{0x04}, k.X.FillBytes(make([]byte, sz))...)
+		encoded = append(encoded, k.Y.FillBytes(make([]byte, sz))...)
+	case ed25519.PublicKey:
+		encoded = k
+	case *rsa.PublicKey:
+		encoded = x509.MarshalPKCS1PublicKey(k)
+	default:
+		return nil, fmt.Errorf("unsupported key type %v", reflect.TypeOf(pub))
+	}
+
+	h := sha256.Sum256(encoded)
+
+	return append([]byte{0x01}, h[:]...), nil
+}
+
+// CheckInstanceID returns the instance ID of the device owning the supplied
+// attestation key, after making sure that claimed, the instance ID found in
+// the claims, if any, is the same.  Only the derivation from EC keys is
+// standard: for other keys, a different claimed instance ID is kept, with a
+// warning.
+func CheckInstanceID(claimed []byte, key jwk.Key) ([]byte, error) {
+	instID, err := InstanceIDFromKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("deriving instance ID: %w", err)
+	}
+
+	if claimed == nil || bytes.Equal(claimed, instID) {
+		return instID, nil
+	}
+
+	if key.KeyType() != jwa.EC {
+		fmt.Printf(
+			">> warning: the instance ID in the claims (%x) does not match the one derived from the IAK (%x), "+
+				"which is not standard for %s keys: keeping the former\n",
+			claimed, instID, key.KeyType(),
+		)
+		return claimed, nil
+	}
+
+	return nil, fmt.Errorf(
+		"the instance ID in the claims (%x) does not match the one derived from the IAK (%x)",
+		claimed, instID,
+	)
+}
+
+// RawPublicKey returns the public part of the supplied key
+func RawPublicKey(key jwk.Key) (crypto.PublicKey, error) {
+	pk, err := jwk.PublicKeyOf(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract public key: %w", err)
+	}
+
+	var pub crypto.PublicKey
+	if err := pk.Raw(&pub); err != nil {
+		return nil, fmt.Errorf("failed to create key: %w", err)
+	}
+
+	return pub, nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_EncodeKey_ParseKey_roundtrip(t *testing.T) {
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	require.NoError(t, err)
+
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	for _, raw := range []crypto.Signer{p521, ed, rsaKey} {
+		key, err := jwk.FromRaw(raw)
+		require.NoError(t, err)
+		require.NoError(t, key.Set(jwk.KeyIDKey, "k1"))
+
+		expectedTP, err := key.Thumbprint(crypto.SHA256)
+		require.NoError(t, err)
+
+		pub, err := key.PublicKey()
+		require.NoError(t, err)
+
+		for _, k := range []jwk.Key{key, pub} {
+			isPrivate, _ := jwk.IsPrivateKey(k)
+
+			for _, format := range []string{KeyFormatJWK, KeyFormatPEM, KeyFormatDER, KeyFormatCOSE} {
+				data, err := EncodeKey(k, format)
+				require.NoError(t, err, format)
+
+				actual, err := ParseKey(data, KeyFormatAuto)
+				require.NoError(t, err, format)
+
+				actualTP, err := actual.Thumbprint(crypto.SHA256)
+				require.NoError(t, err, format)
+				assert.Equal(t, expectedTP, actualTP, format)
+
+				actualIsPrivate, _ := jwk.IsPrivateKey(actual)
+				assert.Equal(t, isPrivate, actualIsPrivate, format)
+			}
+		}
+	}
+}
+
+func Test_COSEKeyFromJWK_alg(t *testing.T) {
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	key, err := jwk.FromRaw(sk)
+	require.NoError(t, err)
+	require.NoError(t, key.Set(jwk.AlgorithmKey, jwa.ES256))
+
+	data, err := COSEKeyFromJWK(key)
+	require.NoError(t, err)
+
+	actual, err := ParseKey(data, KeyFormatCOSE)
+	require.NoError(t, err)
+
+	assert.Equal(t, jwa.ES256, actual.Algorithm())
+}
+
+func Test_InstanceIDFromKey(t *testing.T) {
+	key, err := ParseKey(testCOSEKeyP384, KeyFormatCOSE)
+	require.NoError(t, err)
+
+	id, err := InstanceIDFromKey(key)
+	require.NoError(t, err)
+
+	// 0x01 || SHA-256(0x04 || x || y)
+	expected := MustHexDecode(`
+		01b44e080a9160d73583d92f4082fefb58972b6c636c47ca751ed1f08069c4962a
+	`)
+
+	assert.Equal(t, expected, id)
+}
+
+func Test_CheckInstanceID(t *testing.T) {
+	key, err := ParseKey(testCOSEKeyP384, KeyFormatCOSE)
+	require.NoError(t, err)
+
+	expected, err := InstanceIDFromKey(key)
+	require.NoError(t, err)
+
+	id, err := CheckInstanceID(nil, key)
+	require.NoError(t, err)
+	assert.Equal(t, expected, id)
+
+	id, err = CheckInstanceID(expected, key)
+	require.NoError(t, err)
+	assert.Equal(t, expected, id)
+
+	other := append([]byte{0x01}, make([]byte, 32)...)
+
+	_, err = CheckInstanceID(other, key)
+	assert.EqualError(t, err, fmt.Sprintf(
+		"the instance ID in the claims (%x) does not match the one derived from the IAK (%x)",
+		other, expected,
+	))
+}
+
+func Test_CheckInstanceID_not_EC(t *testing.T) {
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	other := append([]byte{0x01}, make([]byte, 32)...)
+
+	for _, raw := range []crypto.Signer{ed, rsaKey} {
+		key, err := jwk.FromRaw(raw)
+		require.NoError(t, err)
+
+		expected, err := InstanceIDFromKey(key)
+		require.NoError(t, err)
+
+		id, err := CheckInstanceID(nil, key)
+		require.NoError(t, err)
+		assert.Equal(t, expected, id)
+
+		// the derivation is not standard for these keys, so the claimed
+		// instance ID is kept
+		id, err = CheckInstanceID(other, key)
+		require.NoError(t, err, key.KeyType())
+		assert.Equal(t, other, id)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+)
+
+const (
+	cborTagSign1         = 18
+	coseHeaderLabelKeyID = 4
+)
+
+// KeyRing is a set of attestation verification keys, looked up by the key ID
+// found in the token or by the instance ID of the device the token comes from
+type KeyRing struct {
+	// Dir is the directory the keys have been read from
+	Dir string
+
+	byKID    map[string]keyRingEntry
+	byInstID map[string]keyRingEntry
+}
+
+type keyRingEntry struct {
+	key  jwk.Key
+	file string
+}
+
+// LoadKeyRing reads the keys in dir: JWKs, COSE_Keys, PEM or DER keys, and
+// X.509 certificates, as understood by ParseKey.  Private keys are reduced to
+// their public part.  Each asymmetric key is indexed by the instance ID derived
+// from it (see InstanceIDFromKey) and, if it has one, by its "kid" member.
+// Symmetric keys, which only have a kid, are kept as they are.  Hidden files,
+// sub-directories and files that do not look like keys (see looksLikeKey) are
+// skipped, while files that look like keys but cannot be decoded are reported.
+// So are different keys with the same kid, and keys with the same instance ID.
+func LoadKeyRing(fs afero.Fs, dir string) (*KeyRing, error) {
+	entries, err := afero.ReadDir(fs, dir)
+	if err != nil {
+		return nil, fmt.Errorf("error loading keys from %s: %w", dir, err)
+	}
+
+	ring := KeyRing{
+		Dir:      dir,
+		byKID:    map[string]keyRingEntry{},
+		byInstID: map[string]keyRingEntry{},
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+
+		fn := filepath.Join(dir, e.Name())
+
+		raw, err := afero.ReadFile(fs, fn)
+		if err != nil {
+			return nil, fmt.Errorf("error loading key from %s: %w", fn, err)
+		}
+
+		if !looksLikeKey(fn, raw) {
+			continue
+		}
+
+		key, err := ParseKey(raw, KeyFormatAuto)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding key from %s: %w", fn, err)
+		}
+
+		if err = ring.add(key, fn); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(ring.byKID) == 0 && len(ring.byInstID) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", dir)
+	}
+
+	return &ring, nil
+}
+
+func (o *KeyRing) add(key jwk.Key, fn string) error {
+	if !IsSymmetricKey(key) {
+		pub, err := jwk.PublicKeyOf(key)
+		if err != nil {
+			return fmt.Errorf("error decoding key from %s: %w", fn, err)
+		}
+		key = pub
+
+		instID, err := InstanceIDFromKey(key)
+		if err != nil {
+			return fmt.Errorf("error decoding key from %s: %w", fn, err)
+		}
+
+		if prev, ok := o.byInstID[string(instID)]; ok {
+			return fmt.Errorf("the keys in %s and %s have the same instance ID %x", prev.file, fn, instID)
+		}
+
+		o.byInstID[string(instID)] = keyRingEntry{key, fn}
+	}
+
+	kid := key.KeyID()
+	if kid == "" {
+		return nil
+	}
+
+	if prev, ok := o.byKID[kid]; ok && !sameKey(prev.key, key) {
+		return fmt.Errorf("kid %q is used by different keys in %s and %s", kid, prev.file, fn)
+	}
+
+	o.byKID[kid] = keyRingEntry{key, fn}
+
+	return nil
+}
+
+// extensions of the files expected to hold a key
+var keyFileExts = map[string]bool{
+	".jwk": true, ".json": true, ".pem": true, ".der": true, ".cose": true,
+	".crt": true, ".cer": true, ".key": true, ".pub": true,
+}
+
+// looksLikeKey tells whether the file fn, with contents raw, is expected to
+// hold a key, i.e., whether it has a key file extension, or is PEM or JSON
+func looksLikeKey(fn string, raw []byte) bool {
+	if keyFileExts[strings.ToLower(filepath.Ext(fn))] {
+		return true
+	}
+
+	return isPEM(raw) || bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{"))
+}
+
+func sameKey(a, b jwk.Key) bool {
+	ta, err := a.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return false
+	}
+
+	tb, err := b.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(ta, tb)
+}
+
+// Lookup returns the key with the supplied key ID or, failing that, the key
+// of the device with the supplied instance ID, together with the file it has
+// been read from.  Either ID can be nil.
+func (o KeyRing) Lookup(kid, instID []byte) (jwk.Key, string, error) {
+	if kid != nil {
+		if e, ok := o.byKID[string(kid)]; ok {
+			return e.key, e.file, nil
+		}
+	}
+
+	if instID != nil {
+		if e, ok := o.byInstID[string(instID)]; ok {
+			return e.key, e.file, nil
+		}
+	}
+
+	switch {
+	case kid != nil && instID != nil:
+		return nil, "", fmt.Errorf("no key with kid %q nor for instance ID %x in %s", kid, instID, o.Dir)
+	case kid != nil:
+		return nil, "", fmt.Errorf("no key with kid %q in %s", kid, o.Dir)
+	case instID != nil:
+		return nil, "", fmt.Errorf("no key for instance ID %x in %s", instID, o.Dir)
+	}
+
+	return nil, "", errors.New("the token has neither a kid nor an instance ID")
+}
+
+// PeekCOSE returns the key ID in the headers of a COSE_Sign1 or COSE_Mac0
+// message, if any, and its payload, without checking the signature or tag
+func PeekCOSE(msg []byte) ([]byte, []byte, error) {
+	var tag cbor.RawTag
+	if err := cbor.Unmarshal(msg, &tag); err != nil ||
+		(tag.Number != cborTagSign1 && tag.Number != cborTagMac0) {
+		return nil, nil, errors.New("not a COSE_Sign1 or COSE_Mac0 message")
+	}
+
+	var parts struct {
+		_           struct{} `cbor:",toarray"`
+		Protected   []byte
+		Unprotected map[any]cbor.RawMessage
+		Payload     []byte
+		Tag         cbor.RawMessage
+	}
+
+	if err := cbor.Unmarshal(tag.Content, &parts); err != nil {
+		return nil, nil, fmt.Errorf("decoding COSE message: %w", err)
+	}
+
+	var protected map[any]cbor.RawMessage
+	if len(parts.Protected) > 0 {
+		if err := cbor.Unmarshal(parts.Protected, &protected); err != nil {
+			return nil, nil, fmt.Errorf("decoding COSE protected header: %w", err)
+		}
+	}
+
+	var kid []byte
+
+	for _, h := range []map[any]cbor.RawMessage{protected, parts.Unprotected} {
+		// positive integer labels are decoded as uint64
+		if v, ok := h[uint64(coseHeaderLabelKeyID)]; ok {
+			if err := cbor.Unmarshal(v, &kid); err != nil {
+				return nil, nil, fmt.Errorf("decoding COSE kid header: %w", err)
+			}
+			break
+		}
+	}
+
+	return kid, parts.Payload, nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cose "github.com/veraison/go-cose"
+)
+
+func writeTestKey(t *testing.T, fs afero.Fs, fn string, key jwk.Key, format string) {
+	raw, err := EncodeKey(key, format)
+	require.NoError(t, err)
+	require.NoError(t, afero.WriteFile(fs, fn, raw, 0644))
+}
+
+func Test_KeyRing_Lookup(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	_, k1 := newTestPrivateKey(t)
+	_, k2 := newTestPrivateKey(t)
+	require.NoError(t, k2.Set(jwk.KeyIDKey, "iak-2"))
+
+	writeTestKey(t, fs, "iaks/k1.pem", k1, KeyFormatPEM)
+	writeTestKey(t, fs, "iaks/k2.jwk", k2, KeyFormatJWK)
+	require.NoError(t, afero.WriteFile(fs, "iaks/notes.txt", []byte("not a key"), 0644))
+	require.NoError(t, afero.WriteFile(fs, "iaks/.hidden", []byte("{}"), 0644))
+
+	ring, err := LoadKeyRing(fs, "iaks")
+	require.NoError(t, err)
+
+	instID1, err := InstanceIDFromKey(k1)
+	require.NoError(t, err)
+
+	key, fn, err := ring.Lookup(nil, instID1)
+	require.NoError(t, err)
+	assert.Equal(t, "iaks/k1.pem", fn)
+	assert.True(t, sameKey(k1, key))
+
+	isPrivate, err := jwk.IsPrivateKey(key)
+	require.NoError(t, err)
+	assert.False(t, isPrivate)
+
+	// the kid takes precedence over the instance ID
+	_, fn, err = ring.Lookup([]byte("iak-2"), instID1)
+	require.NoError(t, err)
+	assert.Equal(t, "iaks/k2.jwk", fn)
+
+	_, _, err = ring.Lookup([]byte("iak-3"), nil)
+	assert.EqualError(t, err, `no key with kid "iak-3" in iaks`)
+
+	_, _, err = ring.Lookup([]byte("iak-3"), []byte{0x01, 0x02})
+	assert.EqualError(t, err, `no key with kid "iak-3" nor for instance ID 0102 in iaks`)
+
+	_, _, err = ring.Lookup(nil, nil)
+	assert.EqualError(t, err, "the token has neither a kid nor an instance ID")
+}
+
+func Test_LoadKeyRing_fail(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	_, err := LoadKeyRing(fs, "iaks")
+	assert.ErrorContains(t, err, "error loading keys from iaks")
+
+	require.NoError(t, afero.WriteFile(fs, "iaks/notes.txt", []byte("not a key"), 0644))
+
+	_, err = LoadKeyRing(fs, "iaks")
+	assert.EqualError(t, err, "no keys found in iaks")
+
+	_, k1 := newTestPrivateKey(t)
+	_, k2 := newTestPrivateKey(t)
+	require.NoError(t, k1.Set(jwk.KeyIDKey, "iak"))
+	require.NoError(t, k2.Set(jwk.KeyIDKey, "iak"))
+
+	writeTestKey(t, fs, "iaks/k1.jwk", k1, KeyFormatJWK)
+	writeTestKey(t, fs, "iaks/k2.jwk", k2, KeyFormatJWK)
+
+	_, err = LoadKeyRing(fs, "iaks")
+	assert.EqualError(t, err, `kid "iak" is used by different keys in iaks/k1.jwk and iaks/k2.jwk`)
+}
+
+func Test_LoadKeyRing_bad_key(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	_, k1 := newTestPrivateKey(t)
+	writeTestKey(t, fs, "iaks/k1.jwk", k1, KeyFormatJWK)
+
+	// not a key, and does not look like one
+	require.NoError(t, afero.WriteFile(fs, "iaks/notes.txt", []byte("not a key"), 0644))
+
+	_, err := LoadKeyRing(fs, "iaks")
+	require.NoError(t, err)
+
+	// looks like a key because of its extension
+	require.NoError(t, afero.WriteFile(fs, "iaks/k2.der", []byte{0x30, 0x01, 0x00}, 0644))
+
+	_, err = LoadKeyRing(fs, "iaks")
+	assert.ErrorContains(t, err, "error decoding key from iaks/k2.der: failed to parse key")
+
+	require.NoError(t, fs.Remove("iaks/k2.der"))
+
+	// looks like a key because of its contents
+	require.NoError(t, afero.WriteFile(fs, "iaks/k3", []byte(`{"kty": "EC"}`), 0644))
+
+	_, err = LoadKeyRing(fs, "iaks")
+	assert.ErrorContains(t, err, "error decoding key from iaks/k3: failed to parse key")
+}
+
+func Test_LoadKeyRing_duplicate_instance_ID(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	_, k1 := newTestPrivateKey(t)
+	writeTestKey(t, fs, "iaks/k1.jwk", k1, KeyFormatJWK)
+	writeTestKey(t, fs, "iaks/k1.pem", k1, KeyFormatPEM)
+
+	instID, err := InstanceIDFromKey(k1)
+	require.NoError(t, err)
+
+	_, err = LoadKeyRing(fs, "iaks")
+	assert.EqualError(t, err, fmt.Sprintf(
+		"the keys in iaks/k1.jwk and iaks/k1.pem have the same instance ID %x", instID,
+	))
+}
+
+func Test_PeekCOSE(t *testing.T) {
+	_, key := newTestPrivateKey(t)
+
+	raw, err := json.Marshal(key)
+	require.NoError(t, err)
+
+	signer, err := SignerFromJWK(raw)
+	require.NoError(t, err)
+
+	msg := cose.NewSign1Message()
+	msg.Headers.Protected.SetAlgorithm(signer.Algorithm())
+	msg.Headers.Unprotected[cose.HeaderLabelKeyID] = []byte("iak-1")
+	msg.Payload = []byte{0xa0}
+	require.NoError(t, msg.Sign(rand.Reader, nil, signer))
+
+	sign1, err := msg.MarshalCBOR()
+	require.NoError(t, err)
+
+	kid, payload, err := PeekCOSE(sign1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("iak-1"), kid)
+	assert.Equal(t, []byte{0xa0}, payload)
+
+	mac0, err := CreateMac0([]byte{0xa1, 0x01, 0x02}, &MACKey{
+		Alg: AlgorithmHMAC256,
+		Key: newTestSymmetricKey(t, 32, ""),
+	})
+	require.NoError(t, err)
+
+	kid, payload, err = PeekCOSE(mac0)
+	require.NoError(t, err)
+	assert.Nil(t, kid)
+	assert.Equal(t, []byte{0xa1, 0x01, 0x02}, payload)
+
+	_, _, err = PeekCOSE([]byte{0xa0})
+	assert.EqualError(t, err, "not a COSE_Sign1 or COSE_Mac0 message")
+}
@@ -0,0 +1,423 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	cose "github.com/veraison/go-cose"
+)
+
+// Supported key file formats
+const (
+	KeyFormatAuto = "auto"
+	KeyFormatJWK  = "jwk"
+	KeyFormatPEM  = "pem"
+	KeyFormatDER  = "der"
+	KeyFormatCOSE = "cose"
+)
+
+// KeyFormatFlagUsage is the usage string of the --key-format flag
+const KeyFormatFlagUsage = "format of the key files: jwk, pem (PKCS#8, SEC1, PKCS#1, SPKI or " +
+	"certificate), der (same as pem, binary), cose (COSE_Key) or auto to detect it"
+
+// KeyRefFlagUsage completes the usage string of the flags that take a signing
+// key, following the description of the key file
+const KeyRefFlagUsage = "or a pkcs11:, tpm:, vault-transit:, ssh-agent: or exec: reference to the key.  " +
+	"The exec: command line is split into words at blanks, which can be quoted with '...' or \"...\" " +
+	"or escaped with \\ as in a shell"
+
+// ParseKey decodes a key in the supplied format (one of the KeyFormat
+// constants) into a JWK.  If the format is KeyFormatAuto or empty, it is
+// detected from the contents.
+func ParseKey(raw []byte, format string) (jwk.Key, error) {
+	if format == "" || format == KeyFormatAuto {
+		format = detectKeyFormat(raw)
+	}
+
+	switch format {
+	case KeyFormatJWK:
+		key, err := jwk.ParseKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key: %w", err)
+		}
+		return key, nil
+	case KeyFormatPEM:
+		return keyFromPEM(raw)
+	case KeyFormatDER:
+		return keyFromDER(raw)
+	case KeyFormatCOSE:
+		return keyFromCOSEKey(raw)
+	}
+
+	return nil, fmt.Errorf(
+		"unknown key format %q: allowed formats are %s, %s, %s, %s and %s",
+		format, KeyFormatAuto, KeyFormatJWK, KeyFormatPEM, KeyFormatDER, KeyFormatCOSE,
+	)
+}
+
+// detectKeyFormat guesses the format of a key file.  Anything that is neither
+// PEM, nor a DER SEQUENCE, nor a CBOR map is assumed to be a JWK.
+func detectKeyFormat(raw []byte) string {
+	switch {
+	case isPEM(raw):
+		return KeyFormatPEM
+	case len(raw) > 0 && raw[0] == 0x30:
+		return KeyFormatDER
+	case len(raw) > 0 && raw[0] >= 0xa0 && raw[0] <= 0xbf:
+		return KeyFormatCOSE
+	}
+
+	return KeyFormatJWK
+}
+
+func isPEM(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN "))
+}
+
+func keyFromPEM(raw []byte) (jwk.Key, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("failed to parse key: no PEM block found")
+	}
+
+	var (
+		k   any
+		err error
+	)
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		k, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		k, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		k, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		k, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		k, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		k, err = publicKeyFromCertificate(block.Bytes)
+	default:
+		return nil, fmt.Errorf("failed to parse key: unsupported PEM block type %q", block.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key: %w", err)
+	}
+
+	return jwkFromRaw(k)
+}
+
+// keyFromDER tries the DER encodings that can be carried in a PEM file, in
+// turn
+func keyFromDER(raw []byte) (jwk.Key, error) {
+	if k, err := x509.ParsePKCS8PrivateKey(raw); err == nil {
+		return jwkFromRaw(k)
+	}
+
+	if k, err := x509.ParseECPrivateKey(raw); err == nil {
+		return jwkFromRaw(k)
+	}
+
+	if k, err := x509.ParsePKCS1PrivateKey(raw); err == nil {
+		return jwkFromRaw(k)
+	}
+
+	if k, err := x509.ParsePKIXPublicKey(raw); err == nil {
+		return jwkFromRaw(k)
+	}
+
+	if k, err := x509.ParsePKCS1PublicKey(raw); err == nil {
+		return jwkFromRaw(k)
+	}
+
+	if k, err := publicKeyFromCertificate(raw); err == nil {
+		return jwkFromRaw(k)
+	}
+
+	return nil, errors.New(
+		"failed to parse key: DER data is not a PKCS#8, SEC1 or PKCS#1 private key, " +
+			"nor an SPKI or PKCS#1 public key, nor a certificate",
+	)
+}
+
+func publicKeyFromCertificate(der []byte) (any, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return cert.PublicKey, nil
+}
+
+func jwkFromRaw(k any) (jwk.Key, error) {
+	key, err := jwk.FromRaw(k)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key: %w", err)
+	}
+	return key, nil
+}
+
+// COSE_Key labels (RFC 9052 and RFC 9053)
+const (
+	coseKeyKty = 1
+	coseKeyKid = 2
+	coseKeyAlg = 3
+
+	coseKeyCrv = -1
+	coseKeyX   = -2
+	coseKeyY   = -3
+	coseKeyD   = -4
+
+	coseKeyN    = -1
+	coseKeyE    = -2
+	coseKeyRSAD = -3
+	coseKeyP    = -4
+	coseKeyQ    = -5
+	coseKeyDP   = -6
+	coseKeyDQ   = -7
+	coseKeyQInv = -8
+
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+
+	coseCrvP256    = 1
+	coseCrvP384    = 2
+	coseCrvP521    = 3
+	coseCrvEd25519 = 6
+)
+
+// keyFromCOSEKey decodes a COSE_Key.  The "kid" and "alg" parameters, if
+// present, are carried over to the JWK.
+func keyFromCOSEKey(raw []byte) (jwk.Key, error) {
+	var m map[int]cbor.RawMessage
+
+	if err := cbor.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse key: decoding COSE_Key: %w", err)
+	}
+
+	var kty int64
+	if err := coseKeyParam(m, coseKeyKty, &kty); err != nil {
+		return nil, err
+	}
+
+	var (
+		k   any
+		err error
+	)
+
+	switch kty {
+	case coseKtyEC2:
+		k, err = ecdsaKeyFromCOSEKey(m)
+	case coseKtyOKP:
+		k, err = ed25519KeyFromCOSEKey(m)
+	case coseKtyRSA:
+		k, err = rsaKeyFromCOSEKey(m)
+	default:
+		err = fmt.Errorf("failed to parse key: unsupported COSE_Key kty %d", kty)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := jwkFromRaw(k)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := m[coseKeyKid]; ok {
+		var kid []byte
+		if err := coseKeyParam(m, coseKeyKid, &kid); err != nil {
+			return nil, err
+		}
+		if err := key.Set(jwk.KeyIDKey, string(kid)); err != nil {
+			return nil, fmt.Errorf("failed to create key: %w", err)
+		}
+	}
+
+	if _, ok := m[coseKeyAlg]; ok {
+		var alg int64
+		if err := coseKeyParam(m, coseKeyAlg, &alg); err != nil {
+			return nil, err
+		}
+		if err := key.Set(jwk.AlgorithmKey, jwaFromCOSE(cose.Algorithm(alg))); err != nil {
+			return nil, fmt.Errorf("failed to create key: %w", err)
+		}
+	}
+
+	return key, nil
+}
+
+func coseKeyParam(m map[int]cbor.RawMessage, label int, v any) error {
+	raw, ok := m[label]
+	if !ok {
+		return fmt.Errorf("failed to parse key: COSE_Key parameter %d missing", label)
+	}
+
+	if err := cbor.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("failed to parse key: COSE_Key parameter %d: %w", label, err)
+	}
+
+	return nil
+}
+
+func coseKeyBigInt(m map[int]cbor.RawMessage, label int) (*big.Int, error) {
+	var b []byte
+	if err := coseKeyParam(m, label, &b); err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func ecdsaKeyFromCOSEKey(m map[int]cbor.RawMessage) (any, error) {
+	var crv int64
+	if err := coseKeyParam(m, coseKeyCrv, &crv); err != nil {
+		return nil, err
+	}
+
+	var curve elliptic.Curve
+
+	switch crv {
+	case coseCrvP256:
+		curve = elliptic.P256()
+	case coseCrvP384:
+		curve = elliptic.P384()
+	case coseCrvP521:
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("failed to parse key: unsupported COSE_Key EC2 curve %d", crv)
+	}
+
+	x, err := coseKeyBigInt(m, coseKeyX)
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := coseKeyBigInt(m, coseKeyY)
+	if err != nil {
+		return nil, err
+	}
+
+	pub := ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("failed to parse key: COSE_Key point is not on the curve")
+	}
+
+	if _, ok := m[coseKeyD]; !ok {
+		return &pub, nil
+	}
+
+	d, err := coseKeyBigInt(m, coseKeyD)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ecdsa.PrivateKey{PublicKey: pub, D: d}, nil
+}
+
+func ed25519KeyFromCOSEKey(m map[int]cbor.RawMessage) (any, error) {
+	var crv int64
+	if err := coseKeyParam(m, coseKeyCrv, &crv); err != nil {
+		return nil, err
+	}
+
+	if crv != coseCrvEd25519 {
+		return nil, fmt.Errorf("failed to parse key: unsupported COSE_Key OKP curve %d", crv)
+	}
+
+	if _, ok := m[coseKeyD]; ok {
+		var d []byte
+		if err := coseKeyParam(m, coseKeyD, &d); err != nil {
+			return nil, err
+		}
+		if len(d) != ed25519.SeedSize {
+			return nil, fmt.Errorf("failed to parse key: wrong Ed25519 private key length %d", len(d))
+		}
+		return ed25519.NewKeyFromSeed(d), nil
+	}
+
+	var x []byte
+	if err := coseKeyParam(m, coseKeyX, &x); err != nil {
+		return nil, err
+	}
+
+	if len(x) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("failed to parse key: wrong Ed25519 public key length %d", len(x))
+	}
+
+	return ed25519.PublicKey(x), nil
+}
+
+func rsaKeyFromCOSEKey(m map[int]cbor.RawMessage) (any, error) {
+	n, err := coseKeyBigInt(m, coseKeyN)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := coseKeyBigInt(m, coseKeyE)
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.IsInt64() || e.Int64() > int64(^uint32(0)>>1) {
+		return nil, errors.New("failed to parse key: RSA public exponent too large")
+	}
+
+	pub := rsa.PublicKey{N: n, E: int(e.Int64())}
+
+	if _, ok := m[coseKeyRSAD]; !ok {
+		return &pub, nil
+	}
+
+	d, err := coseKeyBigInt(m, coseKeyRSAD)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := coseKeyBigInt(m, coseKeyP)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := coseKeyBigInt(m, coseKeyQ)
+	if err != nil {
+		return nil, err
+	}
+
+	sk := &rsa.PrivateKey{PublicKey: pub, D: d, Primes: []*big.Int{p, q}}
+
+	if err := sk.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to parse key: %w", err)
+	}
+
+	sk.Precompute()
+
+	return sk, nil
+}
+
+func jwaFromCOSE(alg cose.Algorithm) jwa.SignatureAlgorithm {
+	for j, c := range jwaToCOSE {
+		if c == alg {
+			return j
+		}
+	}
+	// leave it to the algorithm checks to reject it
+	return jwa.SignatureAlgorithm(alg.String())
+}
@@ -0,0 +1,150 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// COSE_Key from misc/ec384-pub.diag
+var testCOSEKeyP384 = MustHexDecode(`
+	a4 01 02 20 02
+	21 5830 82fbd132a9b5c396879fbb15340d9050978e55c79d5279a2ba0e95854f37e20cd2f64f3b72b570bbd773eee2ce768425
+	22 5830 edf545edbe89ffafe0e96bbd46e270f20796c448b98daf46a764d27442e6e6ed84f8cec817e6ecc6a71d3a3de7d67ecd
+`)
+
+func Test_ParseKey_PEM_and_DER(t *testing.T) {
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(ec)
+	require.NoError(t, err)
+
+	sec1, err := x509.MarshalECPrivateKey(ec)
+	require.NoError(t, err)
+
+	spki, err := x509.MarshalPKIXPublicKey(&ec.PublicKey)
+	require.NoError(t, err)
+
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &ec.PublicKey, ec)
+	require.NoError(t, err)
+
+	tvs := []struct {
+		desc      string
+		pemType   string
+		der       []byte
+		isPrivate bool
+		kty       jwa.KeyType
+	}{
+		{"PKCS#8", "PRIVATE KEY", pkcs8, true, jwa.EC},
+		{"SEC1", "EC PRIVATE KEY", sec1, true, jwa.EC},
+		{"PKCS#1", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey), true, jwa.RSA},
+		{"SPKI", "PUBLIC KEY", spki, false, jwa.EC},
+		{"PKCS#1 public", "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey), false, jwa.RSA},
+		{"certificate", "CERTIFICATE", cert, false, jwa.EC},
+	}
+
+	for _, tv := range tvs {
+		pemData := pem.EncodeToMemory(&pem.Block{Type: tv.pemType, Bytes: tv.der})
+
+		for _, in := range [][]byte{pemData, tv.der} {
+			key, err := ParseKey(in, KeyFormatAuto)
+			require.NoError(t, err, tv.desc)
+
+			assert.Equal(t, tv.kty, key.KeyType(), tv.desc)
+
+			isPrivate, err := jwk.IsPrivateKey(key)
+			if tv.isPrivate {
+				require.NoError(t, err, tv.desc)
+				assert.True(t, isPrivate, tv.desc)
+			} else {
+				assert.False(t, isPrivate, tv.desc)
+			}
+		}
+	}
+}
+
+func Test_ParseKey_COSE_Key(t *testing.T) {
+	key, err := ParseKey(testCOSEKeyP384, KeyFormatAuto)
+	require.NoError(t, err)
+
+	expected, err := jwk.ParseKey([]byte(`{
+		"kty": "EC",
+		"crv": "P-384",
+		"x": "gvvRMqm1w5aHn7sVNA2QUJeOVcedUnmiug6VhU834gzS9k87crVwu9dz7uLOdoQl",
+		"y": "7fVF7b6J_6_g6Wu9RuJw8geWxEi5ja9Gp2TSdELm5u2E-M7IF-bsxqcdOj3n1n7N"
+	}`))
+	require.NoError(t, err)
+
+	actualTP, err := key.Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+
+	expectedTP, err := expected.Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+
+	assert.Equal(t, expectedTP, actualTP)
+}
+
+func Test_ParseKey_COSE_Key_OKP_with_kid_and_alg(t *testing.T) {
+	_, sk, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	ck, err := cbor.Marshal(map[int]any{
+		1:  1,                                       // kty: OKP
+		2:  []byte("my-key"),                        // kid
+		3:  -8,                                      // alg: EdDSA
+		-1: 6,                                       // crv: Ed25519
+		-2: []byte(sk.Public().(ed25519.PublicKey)), // x
+		-4: sk.Seed(),                               // d
+	})
+	require.NoError(t, err)
+
+	signer, err := SignerFromKey(ck, KeyFormatCOSE)
+	require.NoError(t, err)
+	assert.Equal(t, "EdDSA", signer.Algorithm().String())
+
+	key, err := ParseKey(ck, KeyFormatCOSE)
+	require.NoError(t, err)
+	assert.Equal(t, "my-key", key.KeyID())
+}
+
+func Test_ParseKey_errors(t *testing.T) {
+	_, err := ParseKey([]byte(`{}`), "pkcs12")
+	assert.EqualError(t, err, `unknown key format "pkcs12": allowed formats are auto, jwk, pem, der and cose`)
+
+	_, err = ParseKey([]byte("-----BEGIN FOO-----\nAAAA\n-----END FOO-----\n"), KeyFormatAuto)
+	assert.EqualError(t, err, `failed to parse key: unsupported PEM block type "FOO"`)
+
+	_, err = ParseKey([]byte{0x30, 0x00}, KeyFormatAuto)
+	assert.ErrorContains(t, err, "failed to parse key: DER data is not")
+
+	_, err = ParseKey([]byte{0xa1, 0x01, 0x04}, KeyFormatAuto)
+	assert.EqualError(t, err, "failed to parse key: unsupported COSE_Key kty 4")
+}
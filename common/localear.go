@@ -0,0 +1,173 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+)
+
+// Submods of the attestation results, named after the Veraison schemes
+const (
+	SubmodPSA         = "PSA_IOT"
+	SubmodCCAPlatform = "CCA_SSD_PLATFORM"
+	SubmodCCARealm    = "CCA_REALM"
+)
+
+// LocalVerifierID is the identity of evcli when it issues attestation results
+var LocalVerifierID = VerifierIdentity{Build: "evcli", Developer: "Veraison Project"}
+
+// SignatureError reports the failure of the cryptographic verification of a
+// token, as opposed to the failure to decode it or to load its key
+type SignatureError struct {
+	Err error
+}
+
+func (o *SignatureError) Error() string {
+	return o.Err.Error()
+}
+
+func (o *SignatureError) Unwrap() error {
+	return o.Err
+}
+
+// EvidenceError reports malformed evidence, e.g., a token that cannot be
+// decoded or whose claims are invalid, as opposed to the failure to read it or
+// to load its key
+type EvidenceError struct {
+	Err error
+}
+
+func (o *EvidenceError) Error() string {
+	return o.Err.Error()
+}
+
+func (o *EvidenceError) Unwrap() error {
+	return o.Err
+}
+
+// NewLocalAttestationResult returns an attestation result, with no submods
+// yet, about the supplied evidence
+func NewLocalAttestationResult(evidence, nonce []byte) *AttestationResult {
+	ar := &AttestationResult{
+		Profile:     EARProfile,
+		IssuedAt:    time.Now().Unix(),
+		VerifierID:  &LocalVerifierID,
+		RawEvidence: base64.RawURLEncoding.EncodeToString(evidence),
+		Submods:     map[string]Appraisal{},
+	}
+
+	if nonce != nil {
+		ar.Nonce = base64.RawURLEncoding.EncodeToString(nonce)
+	}
+
+	return ar
+}
+
+// AddSubmod adds a submod with the supplied trustworthiness vector, whose
+// status is the worst tier of the vector claims
+func (o *AttestationResult) AddSubmod(name string, tv TrustVector) {
+	o.Submods[name] = Appraisal{
+		Status:            tv.Status(),
+		TrustVector:       &tv,
+		AppraisalPolicyID: "policy:evcli/" + strings.ToLower(name),
+	}
+}
+
+// AddRejectedSubmod adds a contraindicated submod for evidence that could not be
+// appraised because of err, e.g., an EvidenceError.  The trustworthiness vector
+// claims are all set to "the evidence received is insufficient to make a
+// conclusion", and err is recorded in the "problem" policy claim.
+func (o *AttestationResult) AddRejectedSubmod(name string, err error) {
+	var tv TrustVector
+
+	for _, cn := range TrustVectorClaimNames {
+		*tv.Claim(cn) = 1
+	}
+
+	o.Submods[name] = Appraisal{
+		Status:            TrustTierContraindicated,
+		TrustVector:       &tv,
+		AppraisalPolicyID: "policy:evcli/" + strings.ToLower(name),
+		PolicyClaims:      map[string]any{"problem": err.Error()},
+	}
+}
+
+// SignatureTrustVector returns the trustworthiness vector that follows from
+// the outcome of the verification of the token signature: genuine hardware and
+// instance if the signature is verified, and the failure of the cryptographic
+// validation otherwise
+func SignatureTrustVector(verifyErr error) TrustVector {
+	if verifyErr != nil {
+		return TrustVector{InstanceIdentity: 99}
+	}
+
+	return TrustVector{InstanceIdentity: 2, Hardware: 2}
+}
+
+// ExecutablesClaim returns the "executables" trustworthiness claim that
+// follows from the supplied measurements outcome
+func ExecutablesClaim(outcome string) TrustClaim {
+	switch outcome {
+	case MeasurementMatch:
+		return 2
+	case MeasurementMismatch:
+		return 33
+	}
+	return 0
+}
+
+// ConfigurationClaim returns the "configuration" trustworthiness claim that
+// follows from the supplied measurements outcome
+func ConfigurationClaim(outcome string) TrustClaim {
+	switch outcome {
+	case MeasurementMatch:
+		return 2
+	case MeasurementMismatch:
+		return 32
+	}
+	return 0
+}
+
+// LoadEARSigningKey loads the private key used to sign attestation results
+// from the file fn
+func LoadEARSigningKey(fs afero.Fs, fn, format string) (jwk.Key, error) {
+	raw, format, err := LoadKeyFile(fs, fn, format, "EAR signing key")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParseKey(raw, format)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding EAR signing key from %s: %w", fn, err)
+	}
+
+	if isPrivate, err := jwk.IsPrivateKey(key); err != nil || !isPrivate {
+		return nil, fmt.Errorf("error decoding EAR signing key from %s: not a private key", fn)
+	}
+
+	return key, nil
+}
+
+// SaveAttestationResult signs the attestation result with the supplied key and
+// saves the resulting EAR JWT to the file fn
+func SaveAttestationResult(fs afero.Fs, ar *AttestationResult, key jwk.Key, fn string) error {
+	jwt, err := ar.Sign(key)
+	if err != nil {
+		return err
+	}
+
+	if err = afero.WriteFile(fs, fn, jwt, 0644); err != nil {
+		return fmt.Errorf("error saving attestation result to file %s: %w", fn, err)
+	}
+
+	fmt.Printf(">> %q successfully created\n", fn)
+
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_AttestationResult_AddSubmod(t *testing.T) {
+	ar := NewLocalAttestationResult([]byte("evidence"), []byte{1, 2, 3})
+
+	assert.Equal(t, "ZXZpZGVuY2U", ar.RawEvidence)
+	assert.Equal(t, "AQID", ar.Nonce)
+
+	ar.AddSubmod(SubmodPSA, TrustVector{InstanceIdentity: 2, Hardware: 2})
+	ar.AddSubmod(SubmodCCAPlatform, TrustVector{InstanceIdentity: 2, Executables: 33})
+	ar.AddSubmod(SubmodCCARealm, TrustVector{InstanceIdentity: 99})
+
+	assert.Equal(t, TrustTierAffirming, ar.Submods[SubmodPSA].Status)
+	assert.Equal(t, TrustTierWarning, ar.Submods[SubmodCCAPlatform].Status)
+	assert.Equal(t, TrustTierContraindicated, ar.Submods[SubmodCCARealm].Status)
+	assert.Equal(t, "policy:evcli/psa_iot", ar.Submods[SubmodPSA].AppraisalPolicyID)
+
+	require.NoError(t, ar.Validate())
+}
+
+func Test_AttestationResult_AddRejectedSubmod(t *testing.T) {
+	ar := NewLocalAttestationResult([]byte("evidence"), nil)
+
+	ar.AddRejectedSubmod(SubmodPSA, &EvidenceError{Err: errors.New("missing mandatory instance ID")})
+
+	a := ar.Submods[SubmodPSA]
+	assert.Equal(t, TrustTierContraindicated, a.Status)
+	assert.Equal(t, TrustClaim(1), a.TrustVector.Executables)
+	assert.Equal(t, TrustTierNone, a.TrustVector.Status())
+	assert.Equal(t, map[string]any{"problem": "missing mandatory instance ID"}, a.PolicyClaims)
+
+	require.NoError(t, ar.Validate())
+}
+
+func Test_SignatureTrustVector(t *testing.T) {
+	assert.Equal(t, TrustVector{InstanceIdentity: 2, Hardware: 2}, SignatureTrustVector(nil))
+	assert.Equal(t, TrustVector{InstanceIdentity: 99}, SignatureTrustVector(errors.New("bad signature")))
+}
+
+func Test_ExecutablesClaim_ConfigurationClaim(t *testing.T) {
+	assert.Equal(t, TrustClaim(2), ExecutablesClaim(MeasurementMatch))
+	assert.Equal(t, TrustClaim(32), ConfigurationClaim(MeasurementMismatch))
+	assert.Equal(t, TrustClaim(0), ExecutablesClaim(MeasurementNoRefVal))
+}
+
+func Test_LoadEARSigningKey_not_private(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := afero.WriteFile(fs, "pub.jwk", []byte(`{
+		"kty": "EC",
+		"crv": "P-256",
+		"x": "MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4",
+		"y": "4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM"
+	}`), 0644)
+	require.NoError(t, err)
+
+	_, err = LoadEARSigningKey(fs, "pub.jwk", KeyFormatAuto)
+	assert.EqualError(t, err, "error decoding EAR signing key from pub.jwk: not a private key")
+}
@@ -0,0 +1,237 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	cose "github.com/veraison/go-cose"
+)
+
+// COSE HMAC algorithms (RFC 9053, section 3.1), which go-cose does not know
+// about
+const (
+	AlgorithmHMAC256 cose.Algorithm = 5
+	AlgorithmHMAC384 cose.Algorithm = 6
+	AlgorithmHMAC512 cose.Algorithm = 7
+)
+
+const (
+	cborTagMac0        = 17
+	coseHeaderLabelAlg = 1
+	mac0Context        = "MAC0"
+)
+
+// mac0Message is a COSE_Mac0 message (RFC 9052, section 6.2)
+type mac0Message struct {
+	_           struct{} `cbor:",toarray"`
+	Protected   []byte
+	Unprotected map[any]any
+	Payload     []byte
+	Tag         []byte
+}
+
+// MACKey is a symmetric attestation key, used to authenticate tokens with
+// COSE_Mac0 instead of signing them
+type MACKey struct {
+	// Alg is the COSE HMAC algorithm
+	Alg cose.Algorithm
+	// Key is the oct JWK
+	Key jwk.Key
+}
+
+// IsSymmetricKey tells whether the supplied key is a symmetric (oct) one
+func IsSymmetricKey(key jwk.Key) bool {
+	return key.KeyType() == jwa.OctetSeq
+}
+
+// NewMACKey creates a MACKey from an oct JWK.  The HMAC algorithm comes from
+// the "alg" member of the JWK (HS256, HS384 or HS512) or, if absent, from the
+// size of the key: 32, 48 or 64 bytes.
+func NewMACKey(key jwk.Key) (*MACKey, error) {
+	secret, err := symmetricKeyBytes(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		alg     cose.Algorithm
+		algName string
+	)
+
+	if ka := key.Algorithm(); ka != nil {
+		algName = ka.String()
+	}
+
+	switch jwa.SignatureAlgorithm(algName) {
+	case jwa.HS256:
+		alg = AlgorithmHMAC256
+	case jwa.HS384:
+		alg = AlgorithmHMAC384
+	case jwa.HS512:
+		alg = AlgorithmHMAC512
+	case "":
+		switch len(secret) {
+		case sha256.Size:
+			alg = AlgorithmHMAC256
+		case sha512.Size384:
+			alg = AlgorithmHMAC384
+		case sha512.Size:
+			alg = AlgorithmHMAC512
+		default:
+			return nil, fmt.Errorf(
+				"cannot infer the MAC algorithm from a %d-byte key: set the alg member of the JWK",
+				len(secret),
+			)
+		}
+	default:
+		return nil, fmt.Errorf(
+			"unsupported MAC algorithm %s: allowed algorithms are HS256, HS384 and HS512",
+			algName,
+		)
+	}
+
+	newHash, _ := hmacHash(alg)
+
+	if len(secret) < newHash().Size() {
+		return nil, fmt.Errorf("the key is too short for %s: want at least %d bytes, got %d",
+			algName, newHash().Size(), len(secret))
+	}
+
+	return &MACKey{Alg: alg, Key: key}, nil
+}
+
+func symmetricKeyBytes(key jwk.Key) ([]byte, error) {
+	if !IsSymmetricKey(key) {
+		return nil, fmt.Errorf("want a symmetric (oct) key, got %s", key.KeyType())
+	}
+
+	var secret []byte
+	if err := key.Raw(&secret); err != nil {
+		return nil, fmt.Errorf("failed to export key: %w", err)
+	}
+
+	return secret, nil
+}
+
+func hmacHash(alg cose.Algorithm) (func() hash.Hash, error) {
+	switch alg {
+	case AlgorithmHMAC256:
+		return sha256.New, nil
+	case AlgorithmHMAC384:
+		return sha512.New384, nil
+	case AlgorithmHMAC512:
+		return sha512.New, nil
+	}
+
+	return nil, fmt.Errorf("unsupported MAC algorithm %d", alg)
+}
+
+// IsMac0 tells whether the supplied token is a (tagged) COSE_Mac0 message
+func IsMac0(token []byte) bool {
+	var tag cbor.RawTag
+	return cbor.Unmarshal(token, &tag) == nil && tag.Number == cborTagMac0
+}
+
+// CreateMac0 wraps the payload in a tagged COSE_Mac0 message authenticated
+// with the supplied key.  As for the signed tokens, the external AAD is empty.
+func CreateMac0(payload []byte, key *MACKey) ([]byte, error) {
+	em, err := cbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		return nil, err
+	}
+
+	protected, err := em.Marshal(map[int]int{coseHeaderLabelAlg: int(key.Alg)})
+	if err != nil {
+		return nil, err
+	}
+
+	msg := mac0Message{
+		Protected:   protected,
+		Unprotected: map[any]any{},
+		Payload:     payload,
+	}
+
+	if msg.Tag, err = mac0Tag(&msg, key.Alg, key.Key); err != nil {
+		return nil, err
+	}
+
+	return em.Marshal(cbor.Tag{Number: cborTagMac0, Content: msg})
+}
+
+// VerifyMac0 checks the tag of the supplied COSE_Mac0 message with the
+// symmetric key and returns its payload.  The algorithm in the protected
+// header must be the one the key is for.
+func VerifyMac0(token []byte, key jwk.Key) ([]byte, error) {
+	mk, err := NewMACKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var tag cbor.RawTag
+	if err = cbor.Unmarshal(token, &tag); err != nil || tag.Number != cborTagMac0 {
+		return nil, errors.New("not a COSE_Mac0 message")
+	}
+
+	var msg mac0Message
+	if err = cbor.Unmarshal(tag.Content, &msg); err != nil {
+		return nil, fmt.Errorf("decoding COSE_Mac0: %w", err)
+	}
+
+	var protected map[int]cbor.RawMessage
+	if err = cbor.Unmarshal(msg.Protected, &protected); err != nil {
+		return nil, fmt.Errorf("decoding COSE_Mac0 protected header: %w", err)
+	}
+
+	var alg cose.Algorithm
+	if err = cbor.Unmarshal(protected[coseHeaderLabelAlg], &alg); err != nil {
+		return nil, errors.New("no MAC algorithm in the COSE_Mac0 protected header")
+	}
+
+	if alg != mk.Alg {
+		return nil, fmt.Errorf("MAC algorithm mismatch: the token uses %d, the key is for %d", alg, mk.Alg)
+	}
+
+	expected, err := mac0Tag(&msg, alg, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal(expected, msg.Tag) {
+		return nil, errors.New("MAC verification failed")
+	}
+
+	return msg.Payload, nil
+}
+
+// mac0Tag computes the tag over the MAC_structure of the message
+func mac0Tag(msg *mac0Message, alg cose.Algorithm, key jwk.Key) ([]byte, error) {
+	secret, err := symmetricKeyBytes(key)
+	if err != nil {
+		return nil, err
+	}
+
+	newHash, err := hmacHash(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	tbm, err := cbor.Marshal([]any{mac0Context, msg.Protected, []byte{}, msg.Payload})
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(newHash, secret)
+	mac.Write(tbm)
+
+	return mac.Sum(nil), nil
+}
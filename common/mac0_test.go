@@ -0,0 +1,114 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cose "github.com/veraison/go-cose"
+)
+
+func newTestSymmetricKey(t *testing.T, size int, alg string) jwk.Key {
+	key, err := jwk.FromRaw(bytes.Repeat([]byte{0xa5}, size))
+	require.NoError(t, err)
+
+	if alg != "" {
+		require.NoError(t, key.Set(jwk.AlgorithmKey, alg))
+	}
+
+	return key
+}
+
+func Test_Mac0_roundtrip(t *testing.T) {
+	tvs := []struct {
+		size     int
+		alg      string
+		expected cose.Algorithm
+	}{
+		{32, "", AlgorithmHMAC256},
+		{48, "", AlgorithmHMAC384},
+		{64, "", AlgorithmHMAC512},
+		{64, "HS256", AlgorithmHMAC256},
+		{48, "HS384", AlgorithmHMAC384},
+	}
+
+	payload := []byte{0xa1, 0x0a, 0x41, 0x00}
+
+	for _, tv := range tvs {
+		key := newTestSymmetricKey(t, tv.size, tv.alg)
+
+		mk, err := NewMACKey(key)
+		require.NoError(t, err)
+		assert.Equal(t, tv.expected, mk.Alg)
+
+		token, err := CreateMac0(payload, mk)
+		require.NoError(t, err)
+		assert.True(t, IsMac0(token))
+
+		actual, err := VerifyMac0(token, key)
+		require.NoError(t, err)
+		assert.Equal(t, payload, actual)
+	}
+}
+
+func Test_VerifyMac0_fail(t *testing.T) {
+	key := newTestSymmetricKey(t, 32, "")
+
+	mk, err := NewMACKey(key)
+	require.NoError(t, err)
+
+	token, err := CreateMac0([]byte{0xa0}, mk)
+	require.NoError(t, err)
+
+	_, err = VerifyMac0(token, newTestSymmetricKey(t, 32, jwa.HS256.String()))
+	assert.NoError(t, err)
+
+	other, err := jwk.FromRaw(bytes.Repeat([]byte{0x5a}, 32))
+	require.NoError(t, err)
+
+	_, err = VerifyMac0(token, other)
+	assert.EqualError(t, err, "MAC verification failed")
+
+	_, err = VerifyMac0(token, newTestSymmetricKey(t, 64, ""))
+	assert.EqualError(t, err, "MAC algorithm mismatch: the token uses 5, the key is for 7")
+
+	// an empty COSE_Sign1
+	_, err = VerifyMac0(MustHexDecode("d28440a04040"), key)
+	assert.EqualError(t, err, "not a COSE_Mac0 message")
+}
+
+func Test_NewMACKey_fail(t *testing.T) {
+	_, err := NewMACKey(newTestSymmetricKey(t, 16, ""))
+	assert.EqualError(t, err, "cannot infer the MAC algorithm from a 16-byte key: set the alg member of the JWK")
+
+	_, err = NewMACKey(newTestSymmetricKey(t, 32, "HS512"))
+	assert.EqualError(t, err, "the key is too short for HS512: want at least 64 bytes, got 32")
+
+	_, err = NewMACKey(newTestSymmetricKey(t, 32, "A256KW"))
+	assert.EqualError(t, err, "unsupported MAC algorithm A256KW: allowed algorithms are HS256, HS384 and HS512")
+
+	_, key := newTestPrivateKey(t)
+
+	_, err = NewMACKey(key)
+	assert.EqualError(t, err, "want a symmetric (oct) key, got EC")
+}
+
+func Test_LoadSigningKey_symmetric(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "hmac.jwk",
+		[]byte(`{"kty": "oct", "k": "AAECAwQFBgcICQoLDA0ODwABAgMEBQYHCAkKCwwNDg8"}`), 0600))
+
+	_, mk, err := LoadAttestationKey(fs, "hmac.jwk", KeyFormatAuto, "IAK")
+	require.NoError(t, err)
+	assert.Equal(t, AlgorithmHMAC256, mk.Alg)
+
+	_, err = LoadSigningKey(fs, "hmac.jwk", KeyFormatAuto, "IAK")
+	assert.EqualError(t, err, "error decoding IAK from hmac.jwk: symmetric keys cannot be used for signing")
+}
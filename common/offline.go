@@ -0,0 +1,258 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	"github.com/veraison/ccatoken"
+	cose "github.com/veraison/go-cose"
+)
+
+// offlineSignaturePlaceholder stands in for the signatures of a token whose
+// signing is deferred: COSE_Sign1 messages cannot be encoded without one
+var offlineSignaturePlaceholder = []byte{0}
+
+// TBSRecorder is a cose.Signer that records the bytes to be signed (the COSE
+// Sig_structure) rather than signing them, so that they can be signed offline.
+// It only needs the public part of the signing key.
+type TBSRecorder struct {
+	alg cose.Algorithm
+
+	// TBS is the last Sig_structure passed to Sign
+	TBS []byte
+}
+
+// LoadTBSRecorder returns a signing key that records the data to be signed
+// instead of signing it, together with its recorder.  fn holds the public key
+// (a private key would do too) in one of the formats understood by ParseKey.
+// what describes the key in error messages, e.g., "IAK public key".
+func LoadTBSRecorder(fs afero.Fs, fn, format, what string) (*SigningKey, *TBSRecorder, error) {
+	raw, format, err := LoadKeyFile(fs, fn, format, what)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, alg, err := publicKeyAndAlgorithm(raw, format)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding %s from %s: %w", what, fn, err)
+	}
+
+	rec := &TBSRecorder{alg: alg}
+
+	return &SigningKey{Signer: rec, Public: key}, rec, nil
+}
+
+// publicKeyAndAlgorithm decodes a (public or private) key and returns its
+// public part and the COSE algorithm to use with it
+func publicKeyAndAlgorithm(raw []byte, format string) (jwk.Key, cose.Algorithm, error) {
+	key, err := ParseKey(raw, format)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pubKey, err := jwk.PublicKeyOf(key)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to extract public key: %w", err)
+	}
+
+	pub, err := RawPublicKey(pubKey)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	alg, err := coseAlgorithmForKey(key, pub)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return pubKey, alg, nil
+}
+
+func (o *TBSRecorder) Algorithm() cose.Algorithm {
+	return o.alg
+}
+
+func (o *TBSRecorder) Sign(_ io.Reader, content []byte) ([]byte, error) {
+	o.TBS = content
+	return offlineSignaturePlaceholder, nil
+}
+
+// Kinds of tokens in an OfflineSigningState
+const (
+	OfflineSigningPSA = "psa"
+	OfflineSigningCCA = "cca"
+)
+
+// OfflineSigningState is what "create --emit-tbs" saves for "create
+// --attach-signature" to assemble the final token
+type OfflineSigningState struct {
+	// Kind is the kind of token, one of the OfflineSigning constants
+	Kind string `json:"kind"`
+	// Token is the attestation token, with placeholder signatures
+	Token []byte `json:"token"`
+	// Keys are the public keys (JWK) that verify the signatures, in the
+	// order in which the to-be-signed structures are emitted
+	Keys []json.RawMessage `json:"keys"`
+}
+
+// NewOfflineSigningState creates the state for a token whose signatures will
+// be verified by the supplied keys
+func NewOfflineSigningState(kind string, token []byte, keys ...jwk.Key) (*OfflineSigningState, error) {
+	s := &OfflineSigningState{Kind: kind, Token: token}
+
+	for _, key := range keys {
+		raw, err := json.Marshal(key)
+		if err != nil {
+			return nil, fmt.Errorf("encoding public key: %w", err)
+		}
+		s.Keys = append(s.Keys, raw)
+	}
+
+	return s, nil
+}
+
+// LoadOfflineSigningState reads the state saved in fn, which must be for a
+// token of the supplied kind with the expected number of keys
+func LoadOfflineSigningState(fs afero.Fs, fn, kind string, numKeys int) (*OfflineSigningState, error) {
+	raw, err := afero.ReadFile(fs, fn)
+	if err != nil {
+		return nil, fmt.Errorf("error loading signing state from %s: %w", fn, err)
+	}
+
+	var s OfflineSigningState
+
+	if err = json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("error decoding signing state from %s: %w", fn, err)
+	}
+
+	if s.Kind != kind {
+		return nil, fmt.Errorf("%s holds the signing state of a %q token, want %q", fn, s.Kind, kind)
+	}
+
+	if len(s.Keys) != numKeys {
+		return nil, fmt.Errorf("%s: want %d public key(s), got %d", fn, numKeys, len(s.Keys))
+	}
+
+	return &s, nil
+}
+
+// Save writes the state to fn
+func (o OfflineSigningState) Save(fs afero.Fs, fn string) error {
+	raw, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = afero.WriteFile(fs, fn, raw, 0644); err != nil {
+		return fmt.Errorf("error saving signing state to file %s: %w", fn, err)
+	}
+
+	return nil
+}
+
+// Key returns the i-th public key
+func (o OfflineSigningState) Key(i int) (jwk.Key, error) {
+	return jwk.ParseKey(o.Keys[i])
+}
+
+// AttachSignature replaces the placeholder signature of a COSE_Sign1 message
+// with sig, once checked against the supplied public key.  ECDSA signatures
+// may be either in COSE (r || s) or in ASN.1 form.
+func AttachSignature(sign1, sig []byte, key jwk.Key) ([]byte, error) {
+	var msg cose.Sign1Message
+
+	if err := msg.UnmarshalCBOR(sign1); err != nil {
+		return nil, fmt.Errorf("decoding COSE_Sign1: %w", err)
+	}
+
+	alg, err := msg.Headers.Protected.Algorithm()
+	if err != nil {
+		return nil, fmt.Errorf("reading signature algorithm: %w", err)
+	}
+
+	pub, err := RawPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.Signature, err = normalizeSignature(key, sig); err != nil {
+		return nil, err
+	}
+
+	verifier, err := cose.NewVerifier(alg, pub)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = msg.Verify(nil, verifier); err != nil {
+		return nil, fmt.Errorf("signature does not verify: %w", err)
+	}
+
+	return msg.MarshalCBOR()
+}
+
+// AttachCCASignatures assembles a CCA attestation token from its unsigned
+// form and the signatures of the realm and platform tokens
+func AttachCCASignatures(token, realmSig, platformSig []byte, rak, iak jwk.Key) ([]byte, error) {
+	var collection ccatoken.CBORCollection
+
+	if err := ccaDecMode.Unmarshal(token, &collection); err != nil {
+		return nil, fmt.Errorf("decoding CCA token: %w", err)
+	}
+
+	if collection.RealmToken == nil || collection.PlatformToken == nil {
+		return nil, errors.New("incomplete CCA token")
+	}
+
+	realmToken, err := AttachSignature(*collection.RealmToken, realmSig, rak)
+	if err != nil {
+		return nil, fmt.Errorf("realm token: %w", err)
+	}
+
+	platformToken, err := AttachSignature(*collection.PlatformToken, platformSig, iak)
+	if err != nil {
+		return nil, fmt.Errorf("platform token: %w", err)
+	}
+
+	collection.RealmToken = &realmToken
+	collection.PlatformToken = &platformToken
+
+	return ccaEncMode.Marshal(collection)
+}
+
+// normalizeSignature converts an ASN.1 ECDSA signature into the fixed-size
+// r || s form used by COSE.  Other signatures are returned unchanged.
+func normalizeSignature(key jwk.Key, sig []byte) ([]byte, error) {
+	var pub ecdsa.PublicKey
+	if err := key.Raw(&pub); err != nil {
+		// not an ECDSA key
+		return sig, nil
+	}
+
+	sz := coordinateSize(pub.Curve)
+	if len(sig) == 2*sz {
+		return sig, nil
+	}
+
+	var rs struct{ R, S *big.Int }
+	if rest, err := asn1.Unmarshal(sig, &rs); err != nil || len(rest) != 0 ||
+		rs.R.BitLen() > 8*sz || rs.S.BitLen() > 8*sz {
+		return nil, fmt.Errorf("unexpected ECDSA signature size %d", len(sig))
+	}
+
+	out := make([]byte, 2*sz)
+	rs.R.FillBytes(out[:sz])
+	rs.S.FillBytes(out[sz:])
+
+	return out, nil
+}
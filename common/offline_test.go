@@ -0,0 +1,85 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/json"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cose "github.com/veraison/go-cose"
+)
+
+func Test_TBSRecorder_AttachSignature_ASN1(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	pub, err := jwk.FromRaw(&priv.PublicKey)
+	require.NoError(t, err)
+
+	raw, err := json.Marshal(pub)
+	require.NoError(t, err)
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "pub.jwk", raw, 0644))
+
+	sk, rec, err := LoadTBSRecorder(fs, "pub.jwk", KeyFormatAuto, "public key")
+	require.NoError(t, err)
+	assert.Equal(t, cose.AlgorithmES384, sk.Algorithm())
+
+	msg := cose.NewSign1Message()
+	msg.Payload = []byte("payload")
+	msg.Headers.Protected.SetAlgorithm(sk.Algorithm())
+	require.NoError(t, msg.Sign(rand.Reader, nil, sk))
+
+	unsigned, err := msg.MarshalCBOR()
+	require.NoError(t, err)
+
+	digest := sha512.Sum384(rec.TBS)
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+	require.NoError(t, err)
+
+	signed, err := AttachSignature(unsigned, sig, pub)
+	require.NoError(t, err)
+
+	var decoded cose.Sign1Message
+	require.NoError(t, decoded.UnmarshalCBOR(signed))
+
+	verifier, err := cose.NewVerifier(cose.AlgorithmES384, &priv.PublicKey)
+	require.NoError(t, err)
+	assert.NoError(t, decoded.Verify(nil, verifier))
+	assert.Len(t, decoded.Signature, 96)
+}
+
+func Test_LoadOfflineSigningState(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	key, err := jwk.FromRaw([]byte("not an asymmetric key"))
+	require.NoError(t, err)
+
+	state, err := NewOfflineSigningState(OfflineSigningPSA, []byte{0xd2}, key)
+	require.NoError(t, err)
+	require.NoError(t, state.Save(fs, "my.state"))
+
+	actual, err := LoadOfflineSigningState(fs, "my.state", OfflineSigningPSA, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xd2}, actual.Token)
+
+	_, err = LoadOfflineSigningState(fs, "my.state", OfflineSigningCCA, 2)
+	assert.EqualError(t, err, `my.state holds the signing state of a "psa" token, want "cca"`)
+
+	_, err = LoadOfflineSigningState(fs, "my.state", OfflineSigningPSA, 2)
+	assert.EqualError(t, err, "my.state: want 2 public key(s), got 1")
+
+	_, err = LoadOfflineSigningState(fs, "nonexistent.state", OfflineSigningPSA, 1)
+	assert.EqualError(t, err,
+		"error loading signing state from nonexistent.state: open nonexistent.state: file does not exist")
+}
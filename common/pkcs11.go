@@ -0,0 +1,349 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build cgo
+
+package common
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+	"github.com/spf13/afero"
+)
+
+var (
+	oidNamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidNamedCurveP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+	oidNamedCurveP521 = asn1.ObjectIdentifier{1, 3, 132, 0, 35}
+)
+
+// pkcs11Signer is a crypto.Signer whose private key never leaves the PKCS#11
+// token.  The module and the session stay open for the life of the process.
+type pkcs11Signer struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	key     pkcs11.ObjectHandle
+	pub     crypto.PublicKey
+
+	// PKCS#11 sessions must not be used concurrently
+	mu sync.Mutex
+}
+
+// NewPKCS11Signer opens the private key designated by the supplied PKCS#11
+// URI.  ECDSA (P-256, P-384 and P-521) and RSA keys are supported; the public
+// key is read from the matching public key object, or from the private key
+// object itself for RSA.
+func NewPKCS11Signer(fs afero.Fs, uri string) (crypto.Signer, error) {
+	u, err := parsePKCS11URI(fs, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := pkcs11.New(u.ModulePath)
+	if ctx == nil {
+		return nil, fmt.Errorf("cannot load PKCS#11 module %s", u.ModulePath)
+	}
+
+	if err = ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("initializing PKCS#11 module %s: %w", u.ModulePath, err)
+	}
+
+	s := &pkcs11Signer{ctx: ctx}
+
+	if err = s.open(u); err != nil {
+		ctx.Finalize() // nolint: errcheck
+		ctx.Destroy()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (o *pkcs11Signer) open(u *pkcs11URI) error {
+	slot, err := findPKCS11Slot(o.ctx, u)
+	if err != nil {
+		return err
+	}
+
+	o.session, err = o.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return fmt.Errorf("opening PKCS#11 session: %w", err)
+	}
+
+	if u.PIN != "" {
+		err = o.ctx.Login(o.session, pkcs11.CKU_USER, u.PIN)
+		if err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+			return fmt.Errorf("PKCS#11 login: %w", err)
+		}
+	}
+
+	o.key, err = o.findObject(pkcs11.CKO_PRIVATE_KEY, u)
+	if err != nil {
+		return err
+	}
+
+	o.pub, err = o.publicKey(u)
+
+	return err
+}
+
+func findPKCS11Slot(ctx *pkcs11.Ctx, u *pkcs11URI) (uint, error) {
+	if u.SlotID != nil {
+		return *u.SlotID, nil
+	}
+
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("listing PKCS#11 slots: %w", err)
+	}
+
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			continue
+		}
+
+		// the token info fields are padded with blanks
+		if u.Token != "" && strings.TrimRight(info.Label, " ") != u.Token {
+			continue
+		}
+
+		if u.Serial != "" && strings.TrimRight(info.SerialNumber, " ") != u.Serial {
+			continue
+		}
+
+		return slot, nil
+	}
+
+	return 0, errors.New("no matching PKCS#11 token found")
+}
+
+func (o *pkcs11Signer) findObject(class uint, u *pkcs11URI) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}
+
+	if u.Object != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, u.Object))
+	}
+
+	if u.ID != nil {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, u.ID))
+	}
+
+	if err := o.ctx.FindObjectsInit(o.session, template); err != nil {
+		return 0, fmt.Errorf("searching PKCS#11 objects: %w", err)
+	}
+
+	objs, _, err := o.ctx.FindObjects(o.session, 2)
+
+	if ferr := o.ctx.FindObjectsFinal(o.session); err == nil {
+		err = ferr
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("searching PKCS#11 objects: %w", err)
+	}
+
+	switch len(objs) {
+	case 0:
+		return 0, errors.New("no matching PKCS#11 key found")
+	case 1:
+		return objs[0], nil
+	}
+
+	return 0, errors.New("more than one matching PKCS#11 key found")
+}
+
+func (o *pkcs11Signer) publicKey(u *pkcs11URI) (crypto.PublicKey, error) {
+	attrs, err := o.ctx.GetAttributeValue(o.session, o.key, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading PKCS#11 key type: %w", err)
+	}
+
+	switch kt := bytesToUint(attrs[0].Value); kt {
+	case pkcs11.CKK_EC:
+		pubObj, err := o.findObject(pkcs11.CKO_PUBLIC_KEY, u)
+		if err != nil {
+			return nil, fmt.Errorf("looking up EC public key: %w", err)
+		}
+
+		attrs, err = o.ctx.GetAttributeValue(o.session, pubObj, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading EC public key: %w", err)
+		}
+
+		return ecdsaPublicKeyFromPKCS11(attrs[0].Value, attrs[1].Value)
+	case pkcs11.CKK_RSA:
+		attrs, err = o.ctx.GetAttributeValue(o.session, o.key, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading RSA public key: %w", err)
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(attrs[0].Value),
+			E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#11 key type %#x", kt)
+	}
+}
+
+func ecdsaPublicKeyFromPKCS11(params, point []byte) (*ecdsa.PublicKey, error) {
+	var oid asn1.ObjectIdentifier
+
+	if _, err := asn1.Unmarshal(params, &oid); err != nil {
+		return nil, fmt.Errorf("decoding EC parameters: %w", err)
+	}
+
+	var crv elliptic.Curve
+
+	switch {
+	case oid.Equal(oidNamedCurveP256):
+		crv = elliptic.P256()
+	case oid.Equal(oidNamedCurveP384):
+		crv = elliptic.P384()
+	case oid.Equal(oidNamedCurveP521):
+		crv = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %s", oid)
+	}
+
+	// CKA_EC_POINT is the DER encoding of an OCTET STRING, though some
+	// modules return the bare point
+	var raw []byte
+	if _, err := asn1.Unmarshal(point, &raw); err != nil || len(raw) == 0 {
+		raw = point
+	}
+
+	pub, err := ecdsaPublicKeyFromPoint(crv, raw)
+	if err != nil {
+		return nil, fmt.Errorf("decoding EC point: %w", err)
+	}
+
+	return pub, nil
+}
+
+func ecdsaPublicKeyFromPoint(crv elliptic.Curve, point []byte) (*ecdsa.PublicKey, error) {
+	sz := coordinateSize(crv)
+
+	if len(point) != 1+2*sz || point[0] != 0x04 {
+		return nil, errors.New("not an uncompressed point")
+	}
+
+	x := new(big.Int).SetBytes(point[1 : 1+sz])
+	y := new(big.Int).SetBytes(point[1+sz:])
+
+	if !crv.IsOnCurve(x, y) {
+		return nil, errors.New("point is not on the curve")
+	}
+
+	return &ecdsa.PublicKey{Curve: crv, X: x, Y: y}, nil
+}
+
+// bytesToUint decodes a CK_ULONG attribute value, which is returned in native
+// byte order
+func bytesToUint(b []byte) uint {
+	switch len(b) {
+	case 8:
+		return uint(binary.NativeEndian.Uint64(b))
+	case 4:
+		return uint(binary.NativeEndian.Uint32(b))
+	}
+
+	return 0
+}
+
+func (o *pkcs11Signer) Public() crypto.PublicKey {
+	return o.pub
+}
+
+// Sign signs the supplied digest.  ECDSA signatures are returned in ASN.1
+// form, as expected from a crypto.Signer.
+func (o *pkcs11Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mech *pkcs11.Mechanism
+
+	switch pub := o.pub.(type) {
+	case *ecdsa.PublicKey:
+		mech = pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+	case *rsa.PublicKey:
+		pssOpts, ok := opts.(*rsa.PSSOptions)
+		if !ok {
+			return nil, errors.New("only RSA-PSS signatures are supported")
+		}
+
+		hashMech, mgf, err := pkcs11PSSParams(pssOpts.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		saltLen := pssOpts.SaltLength
+		if saltLen == rsa.PSSSaltLengthEqualsHash {
+			saltLen = pssOpts.Hash.Size()
+		} else if saltLen == rsa.PSSSaltLengthAuto {
+			saltLen = (pub.N.BitLen()-1+7)/8 - 2 - pssOpts.Hash.Size()
+		}
+
+		mech = pkcs11.NewMechanism(
+			pkcs11.CKM_RSA_PKCS_PSS,
+			pkcs11.NewPSSParams(hashMech, mgf, uint(saltLen)),
+		)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", pub)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.ctx.SignInit(o.session, []*pkcs11.Mechanism{mech}, o.key); err != nil {
+		return nil, fmt.Errorf("PKCS#11 sign init: %w", err)
+	}
+
+	sig, err := o.ctx.Sign(o.session, digest)
+	if err != nil {
+		return nil, fmt.Errorf("PKCS#11 sign: %w", err)
+	}
+
+	if _, ok := o.pub.(*ecdsa.PublicKey); ok {
+		// CKM_ECDSA returns r || s
+		half := len(sig) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			new(big.Int).SetBytes(sig[:half]),
+			new(big.Int).SetBytes(sig[half:]),
+		})
+	}
+
+	return sig, nil
+}
+
+func pkcs11PSSParams(h crypto.Hash) (uint, uint, error) {
+	switch h {
+	case crypto.SHA256:
+		return pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256, nil
+	case crypto.SHA384:
+		return pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384, nil
+	case crypto.SHA512:
+		return pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512, nil
+	}
+
+	return 0, 0, fmt.Errorf("unsupported RSA-PSS hash %v", h)
+}
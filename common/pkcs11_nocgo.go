@@ -0,0 +1,23 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !cgo
+
+package common
+
+import (
+	"crypto"
+	"errors"
+
+	"github.com/spf13/afero"
+)
+
+// NewPKCS11Signer is not available in builds without cgo, which is needed to
+// load PKCS#11 modules
+func NewPKCS11Signer(fs afero.Fs, uri string) (crypto.Signer, error) {
+	if _, err := parsePKCS11URI(fs, uri); err != nil {
+		return nil, err
+	}
+
+	return nil, errors.New("PKCS#11 support requires a cgo-enabled build")
+}
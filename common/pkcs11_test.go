@@ -0,0 +1,151 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build cgo
+
+package common
+
+import (
+	"crypto/rand"
+	"encoding/asn1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cose "github.com/veraison/go-cose"
+)
+
+// testSoftHSMModuleEnv names the environment variable with the path to the
+// SoftHSM2 module (e.g., /usr/lib/softhsm/libsofthsm2.so).  The PKCS#11 tests
+// are skipped if it is not set.
+const testSoftHSMModuleEnv = "EVCLI_TEST_SOFTHSM2_MODULE"
+
+// newTestSoftHSMToken creates a SoftHSM2 token labelled "evcli", with user
+// PIN 1234, holding a P-256 key pair labelled "iak"
+func newTestSoftHSMToken(t *testing.T) string {
+	module := os.Getenv(testSoftHSMModuleEnv)
+	if module == "" {
+		t.Skipf("%s not set", testSoftHSMModuleEnv)
+	}
+
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "softhsm2.conf")
+	err := os.WriteFile(conf, []byte("directories.tokendir = "+dir+"\n"), 0600)
+	require.NoError(t, err)
+	t.Setenv("SOFTHSM2_CONF", conf)
+
+	ctx := pkcs11.New(module)
+	require.NotNil(t, ctx)
+	require.NoError(t, ctx.Initialize())
+	defer ctx.Destroy()
+	defer ctx.Finalize() // nolint: errcheck
+
+	slots, err := ctx.GetSlotList(false)
+	require.NoError(t, err)
+	require.NotEmpty(t, slots)
+	require.NoError(t, ctx.InitToken(slots[0], "5678", "evcli"))
+
+	// SoftHSM moves the initialised token to a new slot
+	u := &pkcs11URI{Token: "evcli"}
+	slot, err := findPKCS11Slot(ctx, u)
+	require.NoError(t, err)
+
+	sh, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	require.NoError(t, err)
+	defer ctx.CloseSession(sh) // nolint: errcheck
+
+	require.NoError(t, ctx.Login(sh, pkcs11.CKU_SO, "5678"))
+	require.NoError(t, ctx.InitPIN(sh, "1234"))
+	require.NoError(t, ctx.Logout(sh))
+	require.NoError(t, ctx.Login(sh, pkcs11.CKU_USER, "1234"))
+
+	params, err := asn1.Marshal(oidNamedCurveP256)
+	require.NoError(t, err)
+
+	_, _, err = ctx.GenerateKeyPair(sh,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, "iak"),
+			pkcs11.NewAttribute(pkcs11.CKA_ID, []byte{1}),
+		},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, "iak"),
+			pkcs11.NewAttribute(pkcs11.CKA_ID, []byte{1}),
+		},
+	)
+	require.NoError(t, err)
+	require.NoError(t, ctx.Logout(sh))
+
+	return module
+}
+
+func Test_LoadSigningKey_PKCS11_SoftHSM(t *testing.T) {
+	module := newTestSoftHSMToken(t)
+
+	uri := "pkcs11:token=evcli;object=iak?module-path=" + module + "&pin-value=1234"
+
+	sk, err := LoadSigningKey(afero.NewMemMapFs(), uri, KeyFormatAuto, "IAK signing key")
+	require.NoError(t, err)
+	assert.Equal(t, cose.AlgorithmES256, sk.Algorithm())
+
+	msg := cose.NewSign1Message()
+	msg.Payload = []byte("payload")
+	msg.Headers.Protected.SetAlgorithm(sk.Algorithm())
+	require.NoError(t, msg.Sign(rand.Reader, nil, sk))
+
+	pub, err := RawPublicKey(sk.Public)
+	require.NoError(t, err)
+
+	verifier, err := cose.NewVerifier(cose.AlgorithmES256, pub)
+	require.NoError(t, err)
+	assert.NoError(t, msg.Verify(nil, verifier))
+}
+
+func Test_LoadSigningKey_PKCS11_SoftHSM_fail(t *testing.T) {
+	module := newTestSoftHSMToken(t)
+
+	tvs := []struct {
+		uri         string
+		expectedErr string
+	}{
+		{
+			uri:         "pkcs11:token=evcli;object=rak?pin-value=1234",
+			expectedErr: "no matching PKCS#11 key found",
+		},
+		{
+			uri:         "pkcs11:token=other;object=iak?pin-value=1234",
+			expectedErr: "no matching PKCS#11 token found",
+		},
+		{
+			uri:         "pkcs11:token=evcli;object=iak?pin-value=0000",
+			expectedErr: "PKCS#11 login: pkcs11: 0xA0: CKR_PIN_INCORRECT",
+		},
+	}
+
+	t.Setenv(PKCS11ModuleEnv, module)
+
+	for _, tv := range tvs {
+		_, err := LoadSigningKey(afero.NewMemMapFs(), tv.uri, KeyFormatAuto, "IAK signing key")
+		require.Error(t, err)
+		assert.True(t, strings.HasPrefix(err.Error(), "error opening IAK signing key "+tv.uri), err.Error())
+		assert.ErrorContains(t, err, tv.expectedErr)
+	}
+}
+
+func Test_NewPKCS11Signer_no_module(t *testing.T) {
+	_, err := NewPKCS11Signer(afero.NewMemMapFs(), "pkcs11:object=iak?module-path=/nonexistent/p11.so")
+	assert.EqualError(t, err, "cannot load PKCS#11 module /nonexistent/p11.so")
+}
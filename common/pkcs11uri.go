@@ -0,0 +1,156 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	pkcs11Scheme = "pkcs11:"
+
+	// PKCS11ModuleEnv names the environment variable holding the path to the
+	// PKCS#11 module, used when the URI has no module-path attribute
+	PKCS11ModuleEnv = "EVCLI_PKCS11_MODULE"
+	// PKCS11PINEnv names the environment variable holding the user PIN,
+	// used when the URI has neither a pin-value nor a pin-source attribute
+	PKCS11PINEnv = "EVCLI_PKCS11_PIN"
+)
+
+// pkcs11URI holds the attributes of a PKCS#11 URI (RFC 7512) that are used to
+// locate a private key
+type pkcs11URI struct {
+	Token      string
+	Serial     string
+	SlotID     *uint
+	Object     string
+	ID         []byte
+	ModulePath string
+	PIN        string
+}
+
+// IsPKCS11URI tells whether the supplied key reference is a PKCS#11 URI
+func IsPKCS11URI(ref string) bool {
+	return strings.HasPrefix(ref, pkcs11Scheme)
+}
+
+// parsePKCS11URI decodes a PKCS#11 URI such as:
+//
+//	pkcs11:token=evcli;object=iak?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234
+//
+// The key must be identified by the object and/or id attributes.  The module
+// path and the PIN may instead be supplied through the environment; a
+// pin-source is read from the supplied file system.
+func parsePKCS11URI(fs afero.Fs, s string) (*pkcs11URI, error) {
+	if !IsPKCS11URI(s) {
+		return nil, fmt.Errorf("%q is not a PKCS#11 URI", s)
+	}
+
+	path, query, _ := strings.Cut(strings.TrimPrefix(s, pkcs11Scheme), "?")
+
+	var u pkcs11URI
+
+	for _, attr := range strings.Split(path, ";") {
+		if attr == "" {
+			continue
+		}
+
+		k, v, err := splitPKCS11Attr(attr)
+		if err != nil {
+			return nil, err
+		}
+
+		switch k {
+		case "token":
+			u.Token = v
+		case "serial":
+			u.Serial = v
+		case "object":
+			u.Object = v
+		case "id":
+			u.ID = []byte(v)
+		case "slot-id":
+			n, err := strconv.ParseUint(v, 10, 0)
+			if err != nil {
+				return nil, fmt.Errorf("invalid slot-id %q: %w", v, err)
+			}
+			id := uint(n)
+			u.SlotID = &id
+		case "type":
+			if v != "private" {
+				return nil, fmt.Errorf("unsupported object type %q: want private", v)
+			}
+		default:
+			// other attributes (e.g., manufacturer or model) further
+			// narrow the search, which we do not need
+		}
+	}
+
+	var pinSource string
+
+	for _, attr := range strings.Split(query, "&") {
+		if attr == "" {
+			continue
+		}
+
+		k, v, err := splitPKCS11Attr(attr)
+		if err != nil {
+			return nil, err
+		}
+
+		switch k {
+		case "module-path":
+			u.ModulePath = v
+		case "pin-value":
+			u.PIN = v
+		case "pin-source":
+			pinSource = v
+		}
+	}
+
+	if u.Object == "" && u.ID == nil {
+		return nil, errors.New("the key must be identified by an object or id attribute")
+	}
+
+	if u.ModulePath == "" {
+		if u.ModulePath = os.Getenv(PKCS11ModuleEnv); u.ModulePath == "" {
+			return nil, fmt.Errorf("no PKCS#11 module: set module-path or %s", PKCS11ModuleEnv)
+		}
+	}
+
+	if u.PIN == "" {
+		if pinSource != "" {
+			pin, err := afero.ReadFile(fs, strings.TrimPrefix(pinSource, "file:"))
+			if err != nil {
+				return nil, fmt.Errorf("reading PIN: %w", err)
+			}
+			u.PIN = strings.TrimRight(string(pin), "\r\n")
+		} else {
+			u.PIN = os.Getenv(PKCS11PINEnv)
+		}
+	}
+
+	return &u, nil
+}
+
+func splitPKCS11Attr(attr string) (string, string, error) {
+	k, v, ok := strings.Cut(attr, "=")
+	if !ok {
+		return "", "", fmt.Errorf("malformed PKCS#11 URI attribute %q", attr)
+	}
+
+	v, err := url.PathUnescape(v)
+	if err != nil {
+		return "", "", fmt.Errorf("malformed PKCS#11 URI attribute %q: %w", attr, err)
+	}
+
+	return k, v, nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parsePKCS11URI_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/run/pin.txt", []byte("4321\n"), 0600))
+
+	slot := uint(3)
+
+	tvs := []struct {
+		desc     string
+		uri      string
+		env      map[string]string
+		expected pkcs11URI
+	}{
+		{
+			desc: "label, module and PIN",
+			uri:  "pkcs11:token=evcli;object=iak?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234",
+			expected: pkcs11URI{
+				Token:      "evcli",
+				Object:     "iak",
+				ModulePath: "/usr/lib/softhsm/libsofthsm2.so",
+				PIN:        "1234",
+			},
+		},
+		{
+			desc: "percent-encoded id, slot and PIN file",
+			uri:  "pkcs11:slot-id=3;id=%01%02;type=private?module-path=/p11.so&pin-source=file:/run/pin.txt",
+			expected: pkcs11URI{
+				SlotID:     &slot,
+				ID:         []byte{0x01, 0x02},
+				ModulePath: "/p11.so",
+				PIN:        "4321",
+			},
+		},
+		{
+			desc: "module and PIN from the environment",
+			uri:  "pkcs11:serial=0123;object=my%20key;manufacturer=SoftHSM%20project",
+			env:  map[string]string{PKCS11ModuleEnv: "/env/p11.so", PKCS11PINEnv: "0000"},
+			expected: pkcs11URI{
+				Serial:     "0123",
+				Object:     "my key",
+				ModulePath: "/env/p11.so",
+				PIN:        "0000",
+			},
+		},
+	}
+
+	for _, tv := range tvs {
+		t.Run(tv.desc, func(t *testing.T) {
+			t.Setenv(PKCS11ModuleEnv, "")
+			t.Setenv(PKCS11PINEnv, "")
+			for k, v := range tv.env {
+				t.Setenv(k, v)
+			}
+
+			actual, err := parsePKCS11URI(fs, tv.uri)
+			require.NoError(t, err)
+			assert.Equal(t, tv.expected, *actual)
+		})
+	}
+}
+
+func Test_parsePKCS11URI_fail(t *testing.T) {
+	t.Setenv(PKCS11ModuleEnv, "")
+
+	tvs := []struct {
+		uri         string
+		expectedErr string
+	}{
+		{
+			uri:         "iak.jwk",
+			expectedErr: `"iak.jwk" is not a PKCS#11 URI`,
+		},
+		{
+			uri:         "pkcs11:token=evcli?module-path=/p11.so",
+			expectedErr: "the key must be identified by an object or id attribute",
+		},
+		{
+			uri:         "pkcs11:object=iak",
+			expectedErr: "no PKCS#11 module: set module-path or EVCLI_PKCS11_MODULE",
+		},
+		{
+			uri:         "pkcs11:object=iak;type=public?module-path=/p11.so",
+			expectedErr: `unsupported object type "public": want private`,
+		},
+		{
+			uri:         "pkcs11:object?module-path=/p11.so",
+			expectedErr: `malformed PKCS#11 URI attribute "object"`,
+		},
+		{
+			uri:         "pkcs11:slot-id=x;object=iak?module-path=/p11.so",
+			expectedErr: `invalid slot-id "x"`,
+		},
+		{
+			uri:         "pkcs11:object=iak?module-path=/p11.so&pin-source=/nonexistent",
+			expectedErr: "reading PIN: open /nonexistent: file does not exist",
+		},
+	}
+
+	for _, tv := range tvs {
+		_, err := parsePKCS11URI(afero.NewMemMapFs(), tv.uri)
+		assert.ErrorContains(t, err, tv.expectedErr, tv.uri)
+	}
+}
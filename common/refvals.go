@@ -0,0 +1,420 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/spf13/afero"
+	"github.com/veraison/psatoken"
+)
+
+// ExitCodeRefValsNotMatched is the exit code used when some measurements do not
+// match the reference values
+const ExitCodeRefValsNotMatched = 4
+
+// SwComponentRefVal is the reference value of a PSA or CCA platform software
+// component.  The members that are not set are not compared.
+type SwComponentRefVal struct {
+	MeasurementType  string `json:"measurement-type,omitempty"`
+	MeasurementValue []byte `json:"measurement-value"`
+	SignerID         []byte `json:"signer-id,omitempty"`
+	Version          string `json:"version,omitempty"`
+}
+
+// RealmRefVal holds the reference values of a CCA realm, which is identified
+// by its initial measurement
+type RealmRefVal struct {
+	InitialMeasurement     []byte   `json:"cca-realm-initial-measurement"`
+	ExtensibleMeasurements [][]byte `json:"cca-realm-extensible-measurements,omitempty"`
+	PersonalizationValue   []byte   `json:"cca-realm-personalization-value,omitempty"`
+}
+
+// ReferenceValues are the measurements that a PSA device, or a CCA platform
+// and its realms, are expected to report
+type ReferenceValues struct {
+	SwComponents   []SwComponentRefVal
+	PlatformConfig []byte
+	Realms         []RealmRefVal
+}
+
+// Add merges the supplied reference values into o
+func (o *ReferenceValues) Add(rv ReferenceValues) {
+	o.SwComponents = append(o.SwComponents, rv.SwComponents...)
+	if rv.PlatformConfig != nil {
+		o.PlatformConfig = rv.PlatformConfig
+	}
+	o.Realms = append(o.Realms, rv.Realms...)
+}
+
+// LoadReferenceValues loads the reference values in the supplied files, which
+// are either CoRIMs, of which only the reference values of the platform with
+// the supplied implementation ID are used, or JSON documents decoded by
+// fromJSON
+func LoadReferenceValues(
+	fs afero.Fs, fns []string, implID []byte, fromJSON func([]byte) (ReferenceValues, error),
+) (ReferenceValues, error) {
+	var rv ReferenceValues
+
+	for _, fn := range fns {
+		data, err := afero.ReadFile(fs, fn)
+		if err != nil {
+			return rv, fmt.Errorf("error loading reference values from %s: %w", fn, err)
+		}
+
+		var loaded ReferenceValues
+
+		if json.Valid(data) {
+			loaded, err = fromJSON(data)
+		} else {
+			var corim *CoRIM
+			if corim, err = DecodeCoRIM(data); err == nil {
+				loaded, err = corim.ReferenceValues(implID)
+			}
+		}
+		if err != nil {
+			return rv, fmt.Errorf("error decoding reference values from %s: %w", fn, err)
+		}
+
+		rv.Add(loaded)
+	}
+
+	return rv, nil
+}
+
+// ReferenceValues returns the reference values in the CoRIM of the platform
+// with the supplied implementation ID, and of the realms, using the
+// measurements of the Veraison PSA and CCA CoRIM profiles
+func (o CoRIM) ReferenceValues(implID []byte) (ReferenceValues, error) {
+	var rv ReferenceValues
+
+	for _, comid := range o.CoMIDs {
+		for _, t := range comid.Triples.ReferenceValues {
+			env := t.Environment
+
+			if env.Instance != nil && env.Instance.Number == CBORTagBytes {
+				realm, err := realmRefVal(env.Instance, t.Measurements)
+				if err != nil {
+					return rv, err
+				}
+				rv.Realms = append(rv.Realms, realm)
+				continue
+			}
+
+			if env.Class == nil || env.Class.ClassID == nil {
+				continue
+			}
+
+			id, ok := env.Class.ClassID.Content.([]byte)
+			if !ok || env.Class.ClassID.Number != CBORTagImplID || !bytes.Equal(id, implID) {
+				continue
+			}
+
+			if err := rv.addPlatformMeasurements(t.Measurements); err != nil {
+				return rv, err
+			}
+		}
+	}
+
+	return rv, nil
+}
+
+func (o *ReferenceValues) addPlatformMeasurements(measurements []Measurement) error {
+	for i, m := range measurements {
+		if m.Key == nil {
+			continue
+		}
+
+		switch m.Key.Number {
+		case CBORTagPSARefValID:
+			var id PSARefValID
+			if err := recodeCBOR(m.Key.Content, &id); err != nil {
+				return fmt.Errorf("measurement #%d: decoding PSA reference value ID: %w", i, err)
+			}
+
+			for _, d := range m.Value.Digests {
+				o.SwComponents = append(o.SwComponents, SwComponentRefVal{
+					MeasurementType:  id.Label,
+					MeasurementValue: d.Value,
+					SignerID:         id.SignerID,
+					Version:          id.Version,
+				})
+			}
+		case CBORTagCCAPlatformConfigID:
+			if m.Value.RawValue == nil {
+				return fmt.Errorf("measurement #%d: platform configuration with no raw value", i)
+			}
+
+			config, ok := m.Value.RawValue.Content.([]byte)
+			if !ok {
+				return fmt.Errorf("measurement #%d: want bstr platform configuration, got %T", i, m.Value.RawValue.Content)
+			}
+			o.PlatformConfig = config
+		}
+	}
+
+	return nil
+}
+
+func realmRefVal(instance *cbor.Tag, measurements []Measurement) (RealmRefVal, error) {
+	rim, ok := instance.Content.([]byte)
+	if !ok {
+		return RealmRefVal{}, fmt.Errorf("want bstr realm instance, got %T", instance.Content)
+	}
+
+	realm := RealmRefVal{InitialMeasurement: rim}
+
+	for _, m := range measurements {
+		for i := 0; ; i++ {
+			digests, ok := m.Value.IntegrityRegisters[fmt.Sprintf("rem%d", i)]
+			if !ok || len(digests) == 0 {
+				break
+			}
+			realm.ExtensibleMeasurements = append(realm.ExtensibleMeasurements, digests[0].Value)
+		}
+
+		if m.Value.RawValue != nil {
+			if rpv, ok := m.Value.RawValue.Content.([]byte); ok {
+				realm.PersonalizationValue = rpv
+			}
+		}
+	}
+
+	return realm, nil
+}
+
+// recodeCBOR decodes into v the generic CBOR-decoded value src
+func recodeCBOR(src any, v any) error {
+	data, err := cbor.Marshal(src)
+	if err != nil {
+		return err
+	}
+
+	return cbor.Unmarshal(data, v)
+}
+
+// Outcomes of the comparison of a claim with its reference values
+const (
+	MeasurementMatch    = "match"
+	MeasurementMismatch = "mismatch"
+	MeasurementNoRefVal = "no reference value"
+)
+
+// MeasurementCheck is the outcome of the comparison of a claim with its
+// reference values
+type MeasurementCheck struct {
+	Claim   string
+	Outcome string
+	Detail  string
+}
+
+// MeasurementsReport is the per-claim outcome of the comparison of the
+// measurements in a token with the reference values
+type MeasurementsReport struct {
+	Checks []MeasurementCheck
+}
+
+func (o *MeasurementsReport) add(claim, outcome, detail string) {
+	o.Checks = append(o.Checks, MeasurementCheck{Claim: claim, Outcome: outcome, Detail: detail})
+}
+
+// Report prints the outcome of the comparison of each claim
+func (o MeasurementsReport) Report(w io.Writer) {
+	width := 0
+	for _, e := range o.Checks {
+		if len(e.Claim) > width {
+			width = len(e.Claim)
+		}
+	}
+
+	for _, e := range o.Checks {
+		fmt.Fprintf(w, "   %-*s %s", width+1, e.Claim+":", e.Outcome)
+		if e.Detail != "" {
+			fmt.Fprintf(w, " (%s)", e.Detail)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// Err returns an *ExitError if some claims do not match their reference
+// values, or nil
+func (o MeasurementsReport) Err() error {
+	var mismatches []string
+	for _, e := range o.Checks {
+		if e.Outcome == MeasurementMismatch {
+			mismatches = append(mismatches, e.Claim)
+		}
+	}
+
+	if len(mismatches) == 0 {
+		return nil
+	}
+
+	return &ExitError{
+		Code: ExitCodeRefValsNotMatched,
+		Err: fmt.Errorf(
+			"the reference values are not matched by %s", strings.Join(mismatches, ", "),
+		),
+	}
+}
+
+// Outcome returns the overall outcome of the comparison of the claims whose
+// name starts with any of the supplied prefixes: a mismatch if any of them
+// does not match, a match if some of them match, and no reference value
+// otherwise
+func (o MeasurementsReport) Outcome(prefixes ...string) string {
+	outcome := MeasurementNoRefVal
+
+	for _, c := range o.Checks {
+		for _, p := range prefixes {
+			if !strings.HasPrefix(c.Claim, p) {
+				continue
+			}
+
+			switch c.Outcome {
+			case MeasurementMismatch:
+				return MeasurementMismatch
+			case MeasurementMatch:
+				outcome = MeasurementMatch
+			}
+		}
+	}
+
+	return outcome
+}
+
+// AppraiseSwComponents compares the software components claimed in the token
+// with their reference values.  A component matches if any of the reference
+// values has the same measurement value and, for the members that are set in
+// the reference value, the same type, signer ID and version.
+func (o *MeasurementsReport) AppraiseSwComponents(claim string, comps []psatoken.ISwComponent, refvals []SwComponentRefVal) {
+	for i, c := range comps {
+		// the optional members are left out when missing
+		mtype, _ := c.GetMeasurementType()
+		value, _ := c.GetMeasurementValue()
+		signerID, _ := c.GetSignerID()
+		version, _ := c.GetVersion()
+
+		name := fmt.Sprintf("%s[%d]", claim, i)
+		if mtype != "" {
+			name += fmt.Sprintf(" (%s)", mtype)
+		}
+
+		if len(refvals) == 0 {
+			o.add(name, MeasurementNoRefVal, "")
+			continue
+		}
+
+		outcome, detail := MeasurementMismatch, "no reference value with this measurement value"
+
+		for _, rv := range refvals {
+			if !bytes.Equal(rv.MeasurementValue, value) {
+				// report the expected value of the component with the same type
+				if mtype != "" && rv.MeasurementType == mtype {
+					detail = fmt.Sprintf("measurement-value %s, want %s", shortHex(value), shortHex(rv.MeasurementValue))
+				}
+				continue
+			}
+
+			switch {
+			case rv.MeasurementType != "" && rv.MeasurementType != mtype:
+				detail = fmt.Sprintf("measurement-type %q, want %q", mtype, rv.MeasurementType)
+			case rv.SignerID != nil && !bytes.Equal(rv.SignerID, signerID):
+				detail = fmt.Sprintf("signer-id %s, want %s", shortHex(signerID), shortHex(rv.SignerID))
+			case rv.Version != "" && rv.Version != version:
+				detail = fmt.Sprintf("version %q, want %q", version, rv.Version)
+			default:
+				outcome, detail = MeasurementMatch, ""
+			}
+
+			if outcome == MeasurementMatch {
+				break
+			}
+		}
+
+		o.add(name, outcome, detail)
+	}
+}
+
+// AppraiseValue compares a claimed value with its reference value, if any
+func (o *MeasurementsReport) AppraiseValue(claim string, value, refval []byte) {
+	switch {
+	case refval == nil:
+		o.add(claim, MeasurementNoRefVal, "")
+	case bytes.Equal(value, refval):
+		o.add(claim, MeasurementMatch, "")
+	default:
+		o.add(claim, MeasurementMismatch, fmt.Sprintf("%s, want %s", shortHex(value), shortHex(refval)))
+	}
+}
+
+// AppraiseRealm compares the measurements claimed by a CCA realm with the
+// reference values of the realm with the same initial measurement.  If there
+// is none, the initial measurement does not match and, if there is a single
+// realm, the other measurements are compared with its reference values.
+func (o *MeasurementsReport) AppraiseRealm(rim []byte, rems [][]byte, rpv []byte, realms []RealmRefVal) {
+	const (
+		rimClaim = "cca-realm-initial-measurement"
+		remClaim = "cca-realm-extensible-measurements"
+		rpvClaim = "cca-realm-personalization-value"
+	)
+
+	var realm *RealmRefVal
+
+	for i := range realms {
+		if bytes.Equal(realms[i].InitialMeasurement, rim) {
+			realm = &realms[i]
+			o.add(rimClaim, MeasurementMatch, "")
+			break
+		}
+	}
+
+	if realm == nil {
+		switch len(realms) {
+		case 0:
+			o.add(rimClaim, MeasurementNoRefVal, "")
+			o.add(remClaim, MeasurementNoRefVal, "")
+			o.add(rpvClaim, MeasurementNoRefVal, "")
+			return
+		case 1:
+			realm = &realms[0]
+			o.AppraiseValue(rimClaim, rim, realm.InitialMeasurement)
+		default:
+			o.add(rimClaim, MeasurementMismatch, fmt.Sprintf("no realm with initial measurement %s", shortHex(rim)))
+			return
+		}
+	}
+
+	if realm.ExtensibleMeasurements == nil {
+		o.add(remClaim, MeasurementNoRefVal, "")
+	} else {
+		for i, rem := range rems {
+			name := fmt.Sprintf("%s[%d]", remClaim, i)
+			if i >= len(realm.ExtensibleMeasurements) {
+				o.add(name, MeasurementNoRefVal, "")
+				continue
+			}
+			o.AppraiseValue(name, rem, realm.ExtensibleMeasurements[i])
+		}
+	}
+
+	o.AppraiseValue(rpvClaim, rpv, realm.PersonalizationValue)
+}
+
+// shortHex is the hex encoding of b, with long values abbreviated
+func shortHex(b []byte) string {
+	const maxLen = 16
+
+	if len(b) <= maxLen {
+		return hex.EncodeToString(b)
+	}
+
+	return hex.EncodeToString(b[:maxLen]) + "..."
+}
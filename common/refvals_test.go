@@ -0,0 +1,125 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/veraison/psatoken"
+)
+
+func newTestSwComponent(t *testing.T, mtype string, value, signerID []byte) psatoken.ISwComponent {
+	c := &psatoken.SwComponent{}
+	require.NoError(t, c.SetMeasurementType(mtype))
+	require.NoError(t, c.SetMeasurementValue(value))
+	require.NoError(t, c.SetSignerID(signerID))
+	return c
+}
+
+func Test_MeasurementsReport_AppraiseSwComponents(t *testing.T) {
+	bl := bytes.Repeat([]byte{0x01}, 32)
+	prot := bytes.Repeat([]byte{0x02}, 32)
+	signer := bytes.Repeat([]byte{0x0a}, 32)
+
+	comps := []psatoken.ISwComponent{
+		newTestSwComponent(t, "BL", bl, signer),
+		newTestSwComponent(t, "PRoT", prot, signer),
+		newTestSwComponent(t, "ARoT", prot, signer),
+	}
+
+	refvals := []SwComponentRefVal{
+		{MeasurementType: "BL", MeasurementValue: bl, SignerID: signer},
+		{MeasurementType: "PRoT", MeasurementValue: bl},
+		// the type is not compared, if not set
+		{MeasurementValue: prot, SignerID: bytes.Repeat([]byte{0x0b}, 32)},
+	}
+
+	var report MeasurementsReport
+
+	report.AppraiseSwComponents("psa-software-components", comps, refvals)
+
+	assert.Equal(t, []MeasurementCheck{
+		{Claim: "psa-software-components[0] (BL)", Outcome: MeasurementMatch},
+		{
+			Claim:   "psa-software-components[1] (PRoT)",
+			Outcome: MeasurementMismatch,
+			Detail:  "signer-id 0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a..., want 0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b...",
+		},
+		{
+			Claim:   "psa-software-components[2] (ARoT)",
+			Outcome: MeasurementMismatch,
+			Detail:  "signer-id 0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a..., want 0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b...",
+		},
+	}, report.Checks)
+
+	assert.EqualError(t, report.Err(),
+		"the reference values are not matched by psa-software-components[1] (PRoT), psa-software-components[2] (ARoT)")
+
+	report = MeasurementsReport{}
+	report.AppraiseSwComponents("psa-software-components", comps[:1], nil)
+	assert.Equal(t, MeasurementNoRefVal, report.Checks[0].Outcome)
+	assert.NoError(t, report.Err())
+}
+
+func Test_MeasurementsReport_AppraiseRealm(t *testing.T) {
+	rim := []byte{0x01}
+	rems := [][]byte{{0x02}, {0x03}}
+
+	realms := []RealmRefVal{
+		{InitialMeasurement: []byte{0x10}},
+		{InitialMeasurement: rim, ExtensibleMeasurements: [][]byte{{0x02}, {0x04}}},
+	}
+
+	var report MeasurementsReport
+
+	report.AppraiseRealm(rim, rems, nil, realms)
+
+	assert.Equal(t, []MeasurementCheck{
+		{Claim: "cca-realm-initial-measurement", Outcome: MeasurementMatch},
+		{Claim: "cca-realm-extensible-measurements[0]", Outcome: MeasurementMatch},
+		{Claim: "cca-realm-extensible-measurements[1]", Outcome: MeasurementMismatch, Detail: "03, want 04"},
+		{Claim: "cca-realm-personalization-value", Outcome: MeasurementNoRefVal},
+	}, report.Checks)
+
+	report = MeasurementsReport{}
+	report.AppraiseRealm([]byte{0x20}, rems, nil, realms)
+
+	assert.Equal(t, []MeasurementCheck{
+		{Claim: "cca-realm-initial-measurement", Outcome: MeasurementMismatch, Detail: "no realm with initial measurement 20"},
+	}, report.Checks)
+}
+
+func Test_MeasurementsReport_Outcome(t *testing.T) {
+	report := MeasurementsReport{
+		Checks: []MeasurementCheck{
+			{Claim: "cca-platform-sw-components[0]", Outcome: MeasurementMatch},
+			{Claim: "cca-platform-config", Outcome: MeasurementMismatch},
+			{Claim: "cca-realm-initial-measurement", Outcome: MeasurementNoRefVal},
+		},
+	}
+
+	assert.Equal(t, MeasurementMatch, report.Outcome("cca-platform-sw-components"))
+	assert.Equal(t, MeasurementMismatch, report.Outcome("cca-platform-"))
+	assert.Equal(t, MeasurementNoRefVal, report.Outcome("cca-realm-"))
+}
+
+func Test_MeasurementsReport_Report(t *testing.T) {
+	report := MeasurementsReport{
+		Checks: []MeasurementCheck{
+			{Claim: "cca-platform-config", Outcome: MeasurementMismatch, Detail: "01, want 02"},
+			{Claim: "cca-realm-personalization-value", Outcome: MeasurementMatch},
+		},
+	}
+
+	var w bytes.Buffer
+	report.Report(&w)
+
+	expected := `   cca-platform-config:             mismatch (01, want 02)
+   cca-realm-personalization-value: match
+`
+	assert.Equal(t, expected, w.String())
+}
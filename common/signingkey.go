@@ -0,0 +1,167 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	cose "github.com/veraison/go-cose"
+)
+
+// SigningKey is a cose.Signer together with the public part of its key.  The
+// private key is either held in memory, when it is read from a file, or by a
+// signing backend, such as a PKCS#11 token, that signs on our behalf.
+type SigningKey struct {
+	cose.Signer
+
+	// Public is the public part of the signing key
+	Public jwk.Key
+}
+
+// LoadSigningKey returns the signing key identified by ref, which is either
+// the name of a key file, decoded according to format and decrypted if needed
+// (see LoadKeyFile), or a URI designating a key held by a signing backend:
+//
+//   - pkcs11:... (RFC 7512) for a key stored in a PKCS#11 token
+//   - tpm:... or tpm+swtpm://... for an ECC key held in a TPM 2.0
+//   - exec:<program> [<args>...] for a key used by an external signing helper
+//     (see execSigner)
+//   - vault-transit://<mount>/<key name> for a key in the transit secrets
+//     engine of the Vault server set in the configuration
+//   - ssh-agent:<fingerprint> for an ECDSA key held by the ssh-agent listening
+//     on SSH_AUTH_SOCK
+//
+// what describes the key in error messages, e.g., "IAK signing key".
+func LoadSigningKey(fs afero.Fs, ref, format, what string) (*SigningKey, error) {
+	sk, mk, err := LoadAttestationKey(fs, ref, format, what)
+	if err != nil {
+		return nil, err
+	}
+
+	if mk != nil {
+		return nil, fmt.Errorf("error decoding %s from %s: symmetric keys cannot be used for signing", what, ref)
+	}
+
+	return sk, nil
+}
+
+// LoadAttestationKey is like LoadSigningKey, except that a key file may also
+// hold a symmetric (oct) JWK, for which a MACKey is returned instead of a
+// SigningKey
+func LoadAttestationKey(fs afero.Fs, ref, format, what string) (*SigningKey, *MACKey, error) {
+	if IsExecRef(ref) || IsVaultTransitURI(ref) || IsSSHAgentRef(ref) {
+		var (
+			sk  *SigningKey
+			err error
+		)
+
+		switch {
+		case IsExecRef(ref):
+			sk, err = NewExecSigningKey(ref, format)
+		case IsVaultTransitURI(ref):
+			sk, err = NewVaultTransitSigningKey(ref, VaultConfigFromViper())
+		default:
+			sk, err = NewSSHAgentSigningKey(ref)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening %s %s: %w", what, ref, err)
+		}
+
+		return sk, nil, nil
+	}
+
+	var newSigner func(afero.Fs, string) (crypto.Signer, error)
+
+	switch {
+	case IsPKCS11URI(ref):
+		newSigner = NewPKCS11Signer
+	case IsTPMURI(ref):
+		newSigner = NewTPMSigner
+	}
+
+	if newSigner != nil {
+		s, err := newSigner(fs, ref)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening %s %s: %w", what, ref, err)
+		}
+
+		sk, err := NewSigningKey(s)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening %s %s: %w", what, ref, err)
+		}
+
+		return sk, nil, nil
+	}
+
+	raw, format, err := LoadKeyFile(fs, ref, format, what)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := ParseKey(raw, format)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding %s from %s: %w", what, ref, err)
+	}
+
+	if IsSymmetricKey(key) {
+		mk, err := NewMACKey(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error decoding %s from %s: %w", what, ref, err)
+		}
+		return nil, mk, nil
+	}
+
+	sk, err := SigningKeyFromKey(raw, format)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding %s from %s: %w", what, ref, err)
+	}
+
+	return sk, nil, nil
+}
+
+// SigningKeyFromKey creates a SigningKey from a private key in one of the
+// formats understood by ParseKey
+func SigningKeyFromKey(rawKey []byte, format string) (*SigningKey, error) {
+	signer, err := SignerFromKey(rawKey, format)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParseKey(rawKey, format)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := jwk.PublicKeyOf(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract public key: %w", err)
+	}
+
+	return &SigningKey{Signer: signer, Public: pub}, nil
+}
+
+// NewSigningKey creates a SigningKey from a crypto.Signer, typically one whose
+// private key is held by a signing backend.  The signature algorithm is
+// inferred from the public key, as for key files without an "alg" member.
+func NewSigningKey(s crypto.Signer) (*SigningKey, error) {
+	pub, err := jwk.FromRaw(s.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key: %w", err)
+	}
+
+	alg, err := coseAlgorithmForKey(pub, s.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := cose.NewSigner(alg, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{Signer: signer, Public: pub}, nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cose "github.com/veraison/go-cose"
+)
+
+func Test_LoadSigningKey_file(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	key, err := jwk.FromRaw(priv)
+	require.NoError(t, err)
+
+	raw, err := EncodeKey(key, KeyFormatJWK)
+	require.NoError(t, err)
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "es256.jwk", raw, 0644))
+
+	sk, err := LoadSigningKey(fs, "es256.jwk", KeyFormatAuto, "IAK signing key")
+	require.NoError(t, err)
+	assert.Equal(t, cose.AlgorithmES256, sk.Algorithm())
+
+	var pub ecdsa.PublicKey
+	require.NoError(t, sk.Public.Raw(&pub))
+	assert.True(t, priv.PublicKey.Equal(&pub))
+
+	_, err = LoadSigningKey(fs, "missing.jwk", KeyFormatAuto, "IAK signing key")
+	assert.EqualError(t, err, "error loading IAK signing key from missing.jwk: open missing.jwk: file does not exist")
+
+	_, err = LoadSigningKey(fs, "es256.jwk", KeyFormatPEM, "IAK signing key")
+	assert.ErrorContains(t, err, "error decoding IAK signing key from es256.jwk: failed to parse key")
+}
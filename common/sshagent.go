@@ -0,0 +1,194 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	cose "github.com/veraison/go-cose"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	sshAgentScheme = "ssh-agent:"
+
+	// SSHAuthSockEnv names the environment variable with the path of the
+	// ssh-agent socket
+	SSHAuthSockEnv = "SSH_AUTH_SOCK"
+)
+
+// IsSSHAgentRef tells whether the supplied key reference designates a key held
+// by ssh-agent
+func IsSSHAgentRef(ref string) bool {
+	return strings.HasPrefix(ref, sshAgentScheme)
+}
+
+// sshAgentSigner is a cose.Signer that has the ssh-agent listening on
+// SSH_AUTH_SOCK sign with one of its ECDSA keys.  The agent hashes the data to
+// be signed with the hash that goes with the curve of the key, which is the
+// one of the matching COSE algorithm: ES256 for ecdsa-sha2-nistp256, ES384 for
+// ecdsa-sha2-nistp384.
+type sshAgentSigner struct {
+	sock     string
+	key      ssh.PublicKey
+	alg      cose.Algorithm
+	pub      jwk.Key
+	verifier cose.Verifier
+}
+
+// NewSSHAgentSigningKey creates a SigningKey backed by the ssh-agent key
+// designated by ref, that is ssh-agent:<fingerprint>, where the fingerprint is
+// as printed by "ssh-add -l": SHA256:<base64> or MD5:<hex>.
+func NewSSHAgentSigningKey(ref string) (*SigningKey, error) {
+	fp := strings.TrimPrefix(ref, sshAgentScheme)
+	if fp == "" {
+		return nil, errors.New("no key fingerprint")
+	}
+
+	sock := os.Getenv(SSHAuthSockEnv)
+	if sock == "" {
+		return nil, fmt.Errorf("no ssh-agent: %s is not set", SSHAuthSockEnv)
+	}
+
+	s := &sshAgentSigner{sock: sock}
+
+	var keys []*agent.Key
+
+	err := s.withAgent(func(a agent.ExtendedAgent) (err error) {
+		keys, err = a.List()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range keys {
+		if sshFingerprintMatches(k, fp) {
+			if s.key, err = ssh.ParsePublicKey(k.Blob); err != nil {
+				return nil, fmt.Errorf("decoding key %s: %w", fp, err)
+			}
+			break
+		}
+	}
+
+	if s.key == nil {
+		return nil, fmt.Errorf("no key with fingerprint %s in ssh-agent", fp)
+	}
+
+	switch s.key.Type() {
+	case ssh.KeyAlgoECDSA256:
+		s.alg = cose.AlgorithmES256
+	case ssh.KeyAlgoECDSA384:
+		s.alg = cose.AlgorithmES384
+	default:
+		return nil, fmt.Errorf(
+			"%s key: only %s and %s keys are supported",
+			s.key.Type(), ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384,
+		)
+	}
+
+	var pub *ecdsa.PublicKey
+	if cpk, ok := s.key.(ssh.CryptoPublicKey); ok {
+		pub, _ = cpk.CryptoPublicKey().(*ecdsa.PublicKey)
+	}
+	if pub == nil {
+		return nil, fmt.Errorf("%s key: not an ECDSA key", s.key.Type())
+	}
+
+	if s.pub, err = jwk.FromRaw(pub); err != nil {
+		return nil, fmt.Errorf("failed to create key: %w", err)
+	}
+
+	if s.verifier, err = cose.NewVerifier(s.alg, pub); err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{Signer: s, Public: s.pub}, nil
+}
+
+func sshFingerprintMatches(k *agent.Key, fp string) bool {
+	if md5, ok := strings.CutPrefix(fp, "MD5:"); ok {
+		return ssh.FingerprintLegacyMD5(k) == strings.ToLower(md5)
+	}
+
+	return ssh.FingerprintSHA256(k) == fp
+}
+
+// withAgent connects to the agent for the duration of f.  A connection is made
+// for every operation, as the agent may be restarted while a long-running
+// attester holds on to the signer.
+func (o *sshAgentSigner) withAgent(f func(agent.ExtendedAgent) error) error {
+	conn, err := net.Dial("unix", o.sock)
+	if err != nil {
+		return fmt.Errorf("connecting to ssh-agent: %w", err)
+	}
+	defer conn.Close()
+
+	if err = f(agent.NewClient(conn)); err != nil {
+		return fmt.Errorf("ssh-agent: %w", err)
+	}
+
+	return nil
+}
+
+func (o *sshAgentSigner) Algorithm() cose.Algorithm {
+	return o.alg
+}
+
+// Sign has the agent sign the supplied Sig_structure, and converts the
+// signature from the SSH wire format (two mpints) to the r || s form used by
+// COSE
+func (o *sshAgentSigner) Sign(_ io.Reader, content []byte) ([]byte, error) {
+	var sig *ssh.Signature
+
+	err := o.withAgent(func(a agent.ExtendedAgent) (err error) {
+		sig, err = a.Sign(o.key, content)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if sig.Format != o.key.Type() {
+		return nil, fmt.Errorf("unexpected signature format %q from ssh-agent", sig.Format)
+	}
+
+	var rs struct {
+		R *big.Int
+		S *big.Int
+	}
+
+	if err = ssh.Unmarshal(sig.Blob, &rs); err != nil {
+		return nil, fmt.Errorf("decoding signature from ssh-agent: %w", err)
+	}
+
+	var pub ecdsa.PublicKey
+	if err = o.pub.Raw(&pub); err != nil {
+		return nil, err
+	}
+
+	sz := coordinateSize(pub.Curve)
+	if rs.R.Sign() < 0 || rs.S.Sign() < 0 || rs.R.BitLen() > 8*sz || rs.S.BitLen() > 8*sz {
+		return nil, errors.New("decoding signature from ssh-agent: r or s out of range")
+	}
+
+	out := make([]byte, 2*sz)
+	rs.R.FillBytes(out[:sz])
+	rs.S.FillBytes(out[sz:])
+
+	if err = o.verifier.Verify(content, out); err != nil {
+		return nil, fmt.Errorf("signature from ssh-agent does not verify: %w", err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cose "github.com/veraison/go-cose"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// newTestSSHAgent serves an in-memory keyring holding the supplied keys on a
+// Unix socket, which SSH_AUTH_SOCK is set to
+func newTestSSHAgent(t *testing.T, keys ...any) {
+	keyring := agent.NewKeyring()
+	for _, k := range keys {
+		require.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: k}))
+	}
+
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+
+	l, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+
+	t.Setenv(SSHAuthSockEnv, sock)
+}
+
+func sshFingerprint(t *testing.T, pub any) string {
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+
+	return ssh.FingerprintSHA256(sshPub)
+}
+
+func Test_LoadSigningKey_sshagent_ok(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	newTestSSHAgent(t, p256, p384)
+
+	tvs := []struct {
+		priv *ecdsa.PrivateKey
+		alg  cose.Algorithm
+	}{
+		{priv: p256, alg: cose.AlgorithmES256},
+		{priv: p384, alg: cose.AlgorithmES384},
+	}
+
+	for _, tv := range tvs {
+		t.Run(tv.alg.String(), func(t *testing.T) {
+			ref := "ssh-agent:" + sshFingerprint(t, &tv.priv.PublicKey)
+
+			sk, err := LoadSigningKey(afero.NewMemMapFs(), ref, KeyFormatAuto, "IAK signing key")
+			require.NoError(t, err)
+			assert.Equal(t, tv.alg, sk.Algorithm())
+
+			pub, err := RawPublicKey(sk.Public)
+			require.NoError(t, err)
+			assert.True(t, tv.priv.PublicKey.Equal(pub))
+
+			msg := cose.NewSign1Message()
+			msg.Payload = []byte("payload")
+			msg.Headers.Protected.SetAlgorithm(sk.Algorithm())
+			require.NoError(t, msg.Sign(rand.Reader, nil, sk))
+
+			verifier, err := cose.NewVerifier(tv.alg, &tv.priv.PublicKey)
+			require.NoError(t, err)
+			assert.NoError(t, msg.Verify(nil, verifier))
+		})
+	}
+}
+
+func Test_LoadSigningKey_sshagent_md5(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	newTestSSHAgent(t, priv)
+
+	sshPub, err := ssh.NewPublicKey(&priv.PublicKey)
+	require.NoError(t, err)
+
+	_, err = LoadSigningKey(afero.NewMemMapFs(), "ssh-agent:MD5:"+ssh.FingerprintLegacyMD5(sshPub),
+		KeyFormatAuto, "IAK signing key")
+	assert.NoError(t, err)
+}
+
+func Test_LoadSigningKey_sshagent_fail(t *testing.T) {
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	newTestSSHAgent(t, ed)
+
+	edRef := "ssh-agent:" + sshFingerprint(t, ed.Public())
+
+	_, err = LoadSigningKey(afero.NewMemMapFs(), edRef, KeyFormatAuto, "IAK signing key")
+	assert.EqualError(t, err, "error opening IAK signing key "+edRef+
+		": ssh-ed25519 key: only ecdsa-sha2-nistp256 and ecdsa-sha2-nistp384 keys are supported")
+
+	_, err = LoadSigningKey(afero.NewMemMapFs(), "ssh-agent:SHA256:nope", KeyFormatAuto, "IAK signing key")
+	assert.EqualError(t, err,
+		"error opening IAK signing key ssh-agent:SHA256:nope: no key with fingerprint SHA256:nope in ssh-agent")
+
+	_, err = LoadSigningKey(afero.NewMemMapFs(), "ssh-agent:", KeyFormatAuto, "IAK signing key")
+	assert.EqualError(t, err, "error opening IAK signing key ssh-agent:: no key fingerprint")
+
+	t.Setenv(SSHAuthSockEnv, "")
+
+	_, err = LoadSigningKey(afero.NewMemMapFs(), "ssh-agent:SHA256:nope", KeyFormatAuto, "IAK signing key")
+	assert.EqualError(t, err,
+		"error opening IAK signing key ssh-agent:SHA256:nope: no ssh-agent: SSH_AUTH_SOCK is not set")
+}
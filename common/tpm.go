@@ -0,0 +1,248 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/spf13/afero"
+)
+
+// tpmPrimaryKeyTemplate describes the primary key used when no persistent
+// handle is given.  The TPM derives it deterministically from the owner
+// hierarchy seed, so that the same key is obtained on every invocation.
+var tpmPrimaryKeyTemplate = tpm2.TPMTPublic{
+	Type:    tpm2.TPMAlgECC,
+	NameAlg: tpm2.TPMAlgSHA256,
+	ObjectAttributes: tpm2.TPMAObject{
+		FixedTPM:            true,
+		FixedParent:         true,
+		SensitiveDataOrigin: true,
+		UserWithAuth:        true,
+		SignEncrypt:         true,
+	},
+	Parameters: tpm2.NewTPMUPublicParms(
+		tpm2.TPMAlgECC,
+		&tpm2.TPMSECCParms{
+			Scheme: tpm2.TPMTECCScheme{
+				Scheme: tpm2.TPMAlgECDSA,
+				Details: tpm2.NewTPMUAsymScheme(
+					tpm2.TPMAlgECDSA,
+					&tpm2.TPMSSigSchemeECDSA{HashAlg: tpm2.TPMAlgSHA256},
+				),
+			},
+			CurveID: tpm2.TPMECCNistP256,
+		},
+	),
+}
+
+// tpmSigner is a crypto.Signer whose ECC private key is held in a TPM 2.0.
+// The connection to the TPM stays open for the life of the process.
+type tpmSigner struct {
+	tpm    transport.TPM
+	handle *uint32
+	auth   []byte
+	pub    *ecdsa.PublicKey
+
+	// TPM commands must not be interleaved
+	mu sync.Mutex
+}
+
+// NewTPMSigner opens the ECC key designated by the supplied TPM URI (see
+// parseTPMURI), either on a TPM device or on a swtpm instance.
+func NewTPMSigner(fs afero.Fs, uri string) (crypto.Signer, error) {
+	u, err := parseTPMURI(fs, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var t transport.TPM
+
+	if u.Addr != "" {
+		conn, err := net.Dial("tcp", u.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to swtpm: %w", err)
+		}
+		t = transport.FromReadWriter(conn)
+	} else {
+		var paths []string
+		if u.Path != "" {
+			paths = append(paths, u.Path)
+		}
+
+		t, err = transport.OpenTPM(paths...)
+		if err != nil {
+			return nil, fmt.Errorf("opening TPM: %w", err)
+		}
+	}
+
+	s := &tpmSigner{tpm: t, handle: u.Handle, auth: u.Auth}
+
+	err = s.withKey(func(_ tpm2.AuthHandle, pub *tpm2.TPMTPublic) error {
+		s.pub, err = ecdsaPublicKeyFromTPM(pub)
+		return err
+	})
+	if err != nil {
+		if c, ok := t.(io.Closer); ok {
+			c.Close() // nolint: errcheck
+		}
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// withKey calls fn with the signing key loaded in the TPM.  A primary key is
+// re-created for each call and flushed afterwards: swtpm, unlike the kernel
+// resource manager, does not flush transient objects when we disconnect.
+func (o *tpmSigner) withKey(fn func(tpm2.AuthHandle, *tpm2.TPMTPublic) error) error {
+	if o.handle != nil {
+		handle := tpm2.TPMHandle(*o.handle)
+
+		rsp, err := tpm2.ReadPublic{ObjectHandle: handle}.Execute(o.tpm)
+		if err != nil {
+			return fmt.Errorf("reading TPM key %#x: %w", *o.handle, err)
+		}
+
+		pub, err := rsp.OutPublic.Contents()
+		if err != nil {
+			return fmt.Errorf("decoding TPM key %#x: %w", *o.handle, err)
+		}
+
+		return fn(tpm2.AuthHandle{
+			Handle: handle,
+			Name:   rsp.Name,
+			Auth:   tpm2.PasswordAuth(o.auth),
+		}, pub)
+	}
+
+	rsp, err := tpm2.CreatePrimary{
+		PrimaryHandle: tpm2.TPMRHOwner,
+		InPublic:      tpm2.New2B(tpmPrimaryKeyTemplate),
+	}.Execute(o.tpm)
+	if err != nil {
+		return fmt.Errorf("creating TPM primary key: %w", err)
+	}
+
+	defer tpm2.FlushContext{FlushHandle: rsp.ObjectHandle}.Execute(o.tpm) // nolint: errcheck
+
+	pub, err := rsp.OutPublic.Contents()
+	if err != nil {
+		return fmt.Errorf("decoding TPM primary key: %w", err)
+	}
+
+	return fn(tpm2.AuthHandle{
+		Handle: rsp.ObjectHandle,
+		Name:   rsp.Name,
+		Auth:   tpm2.PasswordAuth(nil),
+	}, pub)
+}
+
+func ecdsaPublicKeyFromTPM(pub *tpm2.TPMTPublic) (*ecdsa.PublicKey, error) {
+	if pub.Type != tpm2.TPMAlgECC {
+		return nil, errors.New("the TPM key is not an ECC key")
+	}
+
+	if !pub.ObjectAttributes.SignEncrypt || pub.ObjectAttributes.Restricted {
+		return nil, errors.New("the TPM key is not an unrestricted signing key")
+	}
+
+	params, err := pub.Parameters.ECCDetail()
+	if err != nil {
+		return nil, err
+	}
+
+	crv, err := params.CurveID.Curve()
+	if err != nil {
+		return nil, err
+	}
+
+	point, err := pub.Unique.ECC()
+	if err != nil {
+		return nil, err
+	}
+
+	x := new(big.Int).SetBytes(point.X.Buffer)
+	y := new(big.Int).SetBytes(point.Y.Buffer)
+
+	if !crv.IsOnCurve(x, y) {
+		return nil, errors.New("the TPM public key is not on the curve")
+	}
+
+	return &ecdsa.PublicKey{Curve: crv, X: x, Y: y}, nil
+}
+
+func tpmHashAlg(h crypto.Hash) (tpm2.TPMIAlgHash, error) {
+	switch h {
+	case crypto.SHA256:
+		return tpm2.TPMAlgSHA256, nil
+	case crypto.SHA384:
+		return tpm2.TPMAlgSHA384, nil
+	case crypto.SHA512:
+		return tpm2.TPMAlgSHA512, nil
+	}
+
+	return tpm2.TPMAlgNull, fmt.Errorf("unsupported hash %v", h)
+}
+
+func (o *tpmSigner) Public() crypto.PublicKey {
+	return o.pub
+}
+
+// Sign signs the supplied digest.  Signatures are returned in ASN.1 form, as
+// expected from a crypto.Signer.
+func (o *tpmSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hashAlg, err := tpmHashAlg(opts.HashFunc())
+	if err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var sig *tpm2.TPMSSignatureECC
+
+	err = o.withKey(func(key tpm2.AuthHandle, _ *tpm2.TPMTPublic) error {
+		rsp, err := tpm2.Sign{
+			KeyHandle: key,
+			Digest:    tpm2.TPM2BDigest{Buffer: digest},
+			InScheme: tpm2.TPMTSigScheme{
+				Scheme: tpm2.TPMAlgECDSA,
+				Details: tpm2.NewTPMUSigScheme(
+					tpm2.TPMAlgECDSA,
+					&tpm2.TPMSSchemeHash{HashAlg: hashAlg},
+				),
+			},
+			Validation: tpm2.TPMTTKHashCheck{
+				Tag:       tpm2.TPMSTHashCheck,
+				Hierarchy: tpm2.TPMRHNull,
+			},
+		}.Execute(o.tpm)
+		if err != nil {
+			return fmt.Errorf("TPM sign: %w", err)
+		}
+
+		sig, err = rsp.Signature.Signature.ECDSA()
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(struct{ R, S *big.Int }{
+		new(big.Int).SetBytes(sig.SignatureR.Buffer),
+		new(big.Int).SetBytes(sig.SignatureS.Buffer),
+	})
+}
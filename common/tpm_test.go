@@ -0,0 +1,103 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cose "github.com/veraison/go-cose"
+)
+
+// testSwtpmEnv names the environment variable with the host:port of a swtpm
+// TCP server, e.g., one started with:
+//
+//	swtpm socket --tpm2 --tpmstate dir=/tmp/swtpm \
+//	    --server type=tcp,port=2321 --ctrl type=tcp,port=2322 \
+//	    --flags not-need-init,startup-clear
+//
+// The swtpm tests are skipped if it is not set.
+const testSwtpmEnv = "EVCLI_TEST_SWTPM"
+
+func testSwtpmURI(t *testing.T) string {
+	addr := os.Getenv(testSwtpmEnv)
+	if addr == "" {
+		t.Skipf("%s not set", testSwtpmEnv)
+	}
+
+	return "tpm+swtpm://" + addr
+}
+
+func Test_LoadSigningKey_TPM_swtpm(t *testing.T) {
+	uri := testSwtpmURI(t)
+
+	sk, err := LoadSigningKey(afero.NewMemMapFs(), uri, KeyFormatAuto, "IAK signing key")
+	require.NoError(t, err)
+	assert.Equal(t, cose.AlgorithmES256, sk.Algorithm())
+
+	msg := cose.NewSign1Message()
+	msg.Payload = []byte("payload")
+	msg.Headers.Protected.SetAlgorithm(sk.Algorithm())
+	require.NoError(t, msg.Sign(rand.Reader, nil, sk))
+
+	pub, err := RawPublicKey(sk.Public)
+	require.NoError(t, err)
+
+	verifier, err := cose.NewVerifier(cose.AlgorithmES256, pub)
+	require.NoError(t, err)
+	assert.NoError(t, msg.Verify(nil, verifier))
+
+	// the primary key is derived from the owner seed, hence stable
+	again, err := LoadSigningKey(afero.NewMemMapFs(), uri, KeyFormatAuto, "IAK signing key")
+	require.NoError(t, err)
+	againPub, err := RawPublicKey(again.Public)
+	require.NoError(t, err)
+	assert.True(t, pub.(*ecdsa.PublicKey).Equal(againPub))
+}
+
+func Test_LoadSigningKey_TPM_swtpm_no_such_handle(t *testing.T) {
+	uri := testSwtpmURI(t) + "?handle=0x81fffff0"
+
+	_, err := LoadSigningKey(afero.NewMemMapFs(), uri, KeyFormatAuto, "IAK signing key")
+	assert.ErrorContains(t, err, "error opening IAK signing key "+uri+": reading TPM key 0x81fffff0")
+}
+
+func Test_NewTPMSigner_swtpm_unreachable(t *testing.T) {
+	_, err := NewTPMSigner(afero.NewMemMapFs(), "tpm+swtpm://127.0.0.1:1")
+	assert.ErrorContains(t, err, "connecting to swtpm")
+}
+
+func Test_ecdsaPublicKeyFromTPM(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	pub := tpmPrimaryKeyTemplate
+	pub.Unique = tpm2.NewTPMUPublicID(
+		tpm2.TPMAlgECC,
+		&tpm2.TPMSECCPoint{
+			X: tpm2.TPM2BECCParameter{Buffer: key.X.Bytes()},
+			Y: tpm2.TPM2BECCParameter{Buffer: key.Y.Bytes()},
+		},
+	)
+
+	actual, err := ecdsaPublicKeyFromTPM(&pub)
+	require.NoError(t, err)
+	assert.True(t, key.PublicKey.Equal(actual))
+
+	restricted := pub
+	restricted.ObjectAttributes.Restricted = true
+
+	_, err = ecdsaPublicKeyFromTPM(&restricted)
+	assert.EqualError(t, err, "the TPM key is not an unrestricted signing key")
+
+	_, err = ecdsaPublicKeyFromTPM(&tpm2.RSASRKTemplate)
+	assert.EqualError(t, err, "the TPM key is not an ECC key")
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	tpmScheme      = "tpm"
+	tpmSwtpmScheme = "tpm+swtpm"
+
+	// TPMAuthEnv names the environment variable holding the authorization
+	// value of the TPM key, used when the URI has neither an auth-value nor
+	// an auth-source attribute
+	TPMAuthEnv = "EVCLI_TPM_AUTH"
+
+	// persistent object handles are in the 0x81xxxxxx range
+	tpmPersistentHandleType = 0x81
+)
+
+// tpmURI holds the attributes of a TPM key reference
+type tpmURI struct {
+	// Path is the TPM device node or the swtpm Unix socket; empty for the
+	// default device
+	Path string
+	// Addr is the host:port of the swtpm TCP server
+	Addr string
+	// Handle is the persistent handle of the key; nil for a primary key
+	// derived from the owner hierarchy seed
+	Handle *uint32
+	// Auth is the authorization value of the key
+	Auth []byte
+}
+
+// IsTPMURI tells whether the supplied key reference designates a TPM key
+func IsTPMURI(ref string) bool {
+	return strings.HasPrefix(ref, tpmScheme+":") || strings.HasPrefix(ref, tpmSwtpmScheme+":")
+}
+
+// parseTPMURI decodes a TPM key reference, one of:
+//
+//	tpm:[<device or unix socket>][?handle=0x81000001]
+//	tpm+swtpm://<host>:<port>[?handle=0x81000001]
+//
+// Without a handle, the key is the ECC P-256 primary signing key derived from
+// the owner hierarchy seed.  The key's authorization value is taken from the
+// auth-value or auth-source (a file in the supplied file system) attributes,
+// or from the environment.
+func parseTPMURI(fs afero.Fs, s string) (*tpmURI, error) {
+	if !IsTPMURI(s) {
+		return nil, fmt.Errorf("%q is not a TPM URI", s)
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed TPM URI: %w", err)
+	}
+
+	var t tpmURI
+
+	switch u.Scheme {
+	case tpmScheme:
+		if u.Host != "" {
+			return nil, fmt.Errorf("unexpected host %q in TPM URI: use %s:// for swtpm", u.Host, tpmSwtpmScheme)
+		}
+		// tpm:/dev/tpmrm0 has a path, tpm:foo.sock is opaque
+		t.Path = u.Path
+		if t.Path == "" {
+			t.Path = u.Opaque
+		}
+	case tpmSwtpmScheme:
+		if u.Host == "" || u.Port() == "" {
+			return nil, errors.New("the swtpm address must be host:port")
+		}
+		t.Addr = u.Host
+	}
+
+	q := u.Query()
+
+	if v := q.Get("handle"); v != "" {
+		h, err := strconv.ParseUint(v, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid handle %q: %w", v, err)
+		}
+
+		if h>>24 != tpmPersistentHandleType {
+			return nil, fmt.Errorf("handle %#x is not a persistent handle", h)
+		}
+
+		handle := uint32(h)
+		t.Handle = &handle
+	}
+
+	switch {
+	case q.Has("auth-value"):
+		t.Auth = []byte(q.Get("auth-value"))
+	case q.Has("auth-source"):
+		auth, err := afero.ReadFile(fs, strings.TrimPrefix(q.Get("auth-source"), "file:"))
+		if err != nil {
+			return nil, fmt.Errorf("reading authorization value: %w", err)
+		}
+		t.Auth = []byte(strings.TrimRight(string(auth), "\r\n"))
+	default:
+		t.Auth = []byte(os.Getenv(TPMAuthEnv))
+	}
+
+	return &t, nil
+}
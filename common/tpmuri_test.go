@@ -0,0 +1,120 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseTPMURI_ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/run/auth.txt", []byte("s3cr3t\n"), 0600))
+
+	handle := uint32(0x81000001)
+
+	tvs := []struct {
+		desc     string
+		uri      string
+		env      map[string]string
+		expected tpmURI
+	}{
+		{
+			desc: "device and handle",
+			uri:  "tpm:/dev/tpmrm0?handle=0x81000001",
+			expected: tpmURI{
+				Path:   "/dev/tpmrm0",
+				Handle: &handle,
+				Auth:   []byte{},
+			},
+		},
+		{
+			desc: "default device, primary key",
+			uri:  "tpm:",
+			expected: tpmURI{
+				Auth: []byte{},
+			},
+		},
+		{
+			desc: "relative unix socket and authorization value",
+			uri:  "tpm:swtpm.sock?handle=2164260865&auth-value=1234",
+			expected: tpmURI{
+				Path:   "swtpm.sock",
+				Handle: &handle,
+				Auth:   []byte("1234"),
+			},
+		},
+		{
+			desc: "swtpm and authorization file",
+			uri:  "tpm+swtpm://localhost:2321?handle=0x81000001&auth-source=file:/run/auth.txt",
+			expected: tpmURI{
+				Addr:   "localhost:2321",
+				Handle: &handle,
+				Auth:   []byte("s3cr3t"),
+			},
+		},
+		{
+			desc: "authorization value from the environment",
+			uri:  "tpm+swtpm://127.0.0.1:2321",
+			env:  map[string]string{TPMAuthEnv: "0000"},
+			expected: tpmURI{
+				Addr: "127.0.0.1:2321",
+				Auth: []byte("0000"),
+			},
+		},
+	}
+
+	for _, tv := range tvs {
+		t.Run(tv.desc, func(t *testing.T) {
+			t.Setenv(TPMAuthEnv, "")
+			for k, v := range tv.env {
+				t.Setenv(k, v)
+			}
+
+			actual, err := parseTPMURI(fs, tv.uri)
+			require.NoError(t, err)
+			assert.Equal(t, tv.expected, *actual)
+		})
+	}
+}
+
+func Test_parseTPMURI_fail(t *testing.T) {
+	tvs := []struct {
+		uri         string
+		expectedErr string
+	}{
+		{
+			uri:         "iak.jwk",
+			expectedErr: `"iak.jwk" is not a TPM URI`,
+		},
+		{
+			uri:         "tpm://localhost:2321",
+			expectedErr: `unexpected host "localhost:2321" in TPM URI: use tpm+swtpm:// for swtpm`,
+		},
+		{
+			uri:         "tpm+swtpm://localhost",
+			expectedErr: "the swtpm address must be host:port",
+		},
+		{
+			uri:         "tpm:/dev/tpmrm0?handle=x",
+			expectedErr: `invalid handle "x"`,
+		},
+		{
+			uri:         "tpm:/dev/tpmrm0?handle=0x80000001",
+			expectedErr: "handle 0x80000001 is not a persistent handle",
+		},
+		{
+			uri:         "tpm:/dev/tpmrm0?auth-source=/nonexistent",
+			expectedErr: "reading authorization value: open /nonexistent: file does not exist",
+		},
+	}
+
+	for _, tv := range tvs {
+		_, err := parseTPMURI(afero.NewMemMapFs(), tv.uri)
+		assert.ErrorContains(t, err, tv.expectedErr, tv.uri)
+	}
+}
@@ -0,0 +1,354 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/viper"
+	cose "github.com/veraison/go-cose"
+)
+
+const (
+	vaultTransitScheme = "vault-transit"
+
+	defaultVaultAddr = "https://127.0.0.1:8200"
+	vaultTimeout     = 30 * time.Second
+)
+
+// VaultConfig holds the settings used to reach a HashiCorp Vault server
+type VaultConfig struct {
+	// Addr is the URL of the Vault server
+	Addr string
+	// Token is the Vault token used to authenticate
+	Token string
+	// Namespace is the Vault Enterprise namespace, if any
+	Namespace string
+	// CACert is the PEM file with the CA certificate(s) of the Vault server,
+	// used in addition to the system ones
+	CACert string
+}
+
+// VaultConfigFromViper reads the Vault settings from the configuration:
+// vault_addr, vault_token, vault_namespace and vault_cacert.  As environment
+// variables are looked up too, the usual VAULT_ADDR, VAULT_TOKEN, ... apply.
+// Without a token, the one saved by "vault login" in ~/.vault-token is used.
+func VaultConfigFromViper() VaultConfig {
+	cfg := VaultConfig{
+		Addr:      viper.GetString("vault_addr"),
+		Token:     viper.GetString("vault_token"),
+		Namespace: viper.GetString("vault_namespace"),
+		CACert:    viper.GetString("vault_cacert"),
+	}
+
+	if cfg.Addr == "" {
+		cfg.Addr = defaultVaultAddr
+	}
+
+	if cfg.Token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if t, err := os.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+				cfg.Token = strings.TrimSpace(string(t))
+			}
+		}
+	}
+
+	return cfg
+}
+
+// IsVaultTransitURI tells whether the supplied key reference designates a key
+// in a Vault transit secrets engine
+func IsVaultTransitURI(ref string) bool {
+	return strings.HasPrefix(ref, vaultTransitScheme+"://")
+}
+
+// vaultTransitKey describes a key of the transit secrets engine
+type vaultTransitKey struct {
+	Type          string `json:"type"`
+	LatestVersion int    `json:"latest_version"`
+	Keys          map[string]struct {
+		PublicKey string `json:"public_key"`
+	} `json:"keys"`
+}
+
+// vaultTransitSigner is a cose.Signer that signs through the transit secrets
+// engine of a Vault server.  Signatures are made with a fixed key version, the
+// one whose public key was fetched when the signer was created.
+type vaultTransitSigner struct {
+	cfg      VaultConfig
+	client   *http.Client
+	mount    string
+	name     string
+	version  int
+	alg      cose.Algorithm
+	pub      jwk.Key
+	verifier cose.Verifier
+}
+
+// NewVaultTransitSigningKey creates a SigningKey backed by the transit key
+// designated by ref, that is vault-transit://<mount>/<key name>, optionally
+// followed by ?version=<n> to pin a key version other than the latest.
+func NewVaultTransitSigningKey(ref string, cfg VaultConfig) (*SigningKey, error) {
+	mount, name, version, err := parseVaultTransitURI(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Token == "" {
+		return nil, errors.New("no Vault token: set vault_token or VAULT_TOKEN")
+	}
+
+	client, err := newVaultClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &vaultTransitSigner{cfg: cfg, client: client, mount: mount, name: name}
+
+	var key vaultTransitKey
+
+	if err = s.call(http.MethodGet, path.Join(mount, "keys", name), nil, &key); err != nil {
+		return nil, err
+	}
+
+	s.version = version
+	if s.version == 0 {
+		s.version = key.LatestVersion
+	}
+
+	kv, ok := key.Keys[strconv.Itoa(s.version)]
+	if !ok || kv.PublicKey == "" {
+		return nil, fmt.Errorf("no public key for version %d of Vault key %s", s.version, name)
+	}
+
+	if s.pub, err = vaultPublicKey(key.Type, kv.PublicKey); err != nil {
+		return nil, fmt.Errorf("decoding public key of Vault key %s: %w", name, err)
+	}
+
+	pub, err := RawPublicKey(s.pub)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.alg, err = coseAlgorithmForKey(s.pub, pub); err != nil {
+		return nil, err
+	}
+
+	if s.verifier, err = cose.NewVerifier(s.alg, pub); err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{Signer: s, Public: s.pub}, nil
+}
+
+func parseVaultTransitURI(ref string) (string, string, int, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("malformed Vault transit URI: %w", err)
+	}
+
+	if u.Scheme != vaultTransitScheme {
+		return "", "", 0, fmt.Errorf("%q is not a Vault transit URI", ref)
+	}
+
+	// the mount path may have several segments
+	mount, name := path.Split(path.Join(u.Host, u.Path))
+	mount = strings.Trim(mount, "/")
+
+	if u.Host == "" || mount == "" || name == "" {
+		return "", "", 0, errors.New("want vault-transit://<mount>/<key name>")
+	}
+
+	var version int
+
+	if v := u.Query().Get("version"); v != "" {
+		if version, err = strconv.Atoi(v); err != nil || version < 1 {
+			return "", "", 0, fmt.Errorf("invalid key version %q", v)
+		}
+	}
+
+	return mount, name, version, nil
+}
+
+func newVaultClient(cfg VaultConfig) (*http.Client, error) {
+	client := &http.Client{Timeout: vaultTimeout}
+
+	if cfg.CACert == "" {
+		return client, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	pem, err := os.ReadFile(cfg.CACert)
+	if err != nil {
+		return nil, fmt.Errorf("reading Vault CA certificate: %w", err)
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", cfg.CACert)
+	}
+
+	client.Transport = &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+	}
+
+	return client, nil
+}
+
+// vaultPublicKey decodes the public key of a transit key: PEM for ECDSA and RSA
+// keys, base64 for Ed25519 ones
+func vaultPublicKey(keyType, pub string) (jwk.Key, error) {
+	switch {
+	case strings.HasPrefix(keyType, "ecdsa-"), strings.HasPrefix(keyType, "rsa-"):
+		key, err := ParseKey([]byte(pub), KeyFormatPEM)
+		if err != nil {
+			return nil, err
+		}
+		return jwk.PublicKeyOf(key)
+	case keyType == "ed25519":
+		raw, err := base64.StdEncoding.DecodeString(pub)
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, errors.New("malformed Ed25519 public key")
+		}
+		return jwk.FromRaw(ed25519.PublicKey(raw))
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", keyType)
+}
+
+// call invokes the Vault API at /v1/<p> and decodes the "data" member of the
+// response into out
+func (o *vaultTransitSigner) call(method, p string, in, out any) error {
+	var body io.Reader
+
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(o.cfg.Addr, "/")+"/v1/"+p, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("X-Vault-Token", o.cfg.Token)
+	req.Header.Set("X-Vault-Request", "true")
+	if o.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", o.cfg.Namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to Vault failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	var rsp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+
+	// error responses may have no body
+	_ = json.NewDecoder(res.Body).Decode(&rsp)
+
+	if res.StatusCode != http.StatusOK {
+		if len(rsp.Errors) != 0 {
+			return fmt.Errorf("calling Vault %s %s: %s: %s", method, p, res.Status, strings.Join(rsp.Errors, "; "))
+		}
+		return fmt.Errorf("calling Vault %s %s: %s", method, p, res.Status)
+	}
+
+	if err = json.Unmarshal(rsp.Data, out); err != nil {
+		return fmt.Errorf("decoding Vault response: %w", err)
+	}
+
+	return nil
+}
+
+func (o *vaultTransitSigner) Algorithm() cose.Algorithm {
+	return o.alg
+}
+
+// Sign has Vault sign the supplied Sig_structure.  The signature comes back
+// as "vault:v<n>:<base64>", ASN.1 encoded for ECDSA, and is converted to the
+// form used by COSE.
+func (o *vaultTransitSigner) Sign(_ io.Reader, content []byte) ([]byte, error) {
+	req := map[string]any{
+		"input":       base64.StdEncoding.EncodeToString(content),
+		"key_version": o.version,
+	}
+
+	switch o.alg {
+	case cose.AlgorithmES256, cose.AlgorithmPS256:
+		req["hash_algorithm"] = "sha2-256"
+	case cose.AlgorithmES384, cose.AlgorithmPS384:
+		req["hash_algorithm"] = "sha2-384"
+	case cose.AlgorithmES512, cose.AlgorithmPS512:
+		req["hash_algorithm"] = "sha2-512"
+	}
+
+	switch o.alg {
+	case cose.AlgorithmPS256, cose.AlgorithmPS384, cose.AlgorithmPS512:
+		req["signature_algorithm"] = "pss"
+		// COSE wants a salt as long as the hash
+		req["salt_length"] = "hash"
+	}
+
+	var rsp struct {
+		Signature string `json:"signature"`
+	}
+
+	if err := o.call(http.MethodPost, path.Join(o.mount, "sign", o.name), req, &rsp); err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(rsp.Signature, ":")
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, fmt.Errorf("malformed Vault signature %q", rsp.Signature)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed Vault signature: %w", err)
+	}
+
+	if sig, err = normalizeSignature(o.pub, sig); err != nil {
+		return nil, fmt.Errorf("decoding Vault signature: %w", err)
+	}
+
+	if err = o.verifier.Verify(content, sig); err != nil {
+		return nil, fmt.Errorf("signature from Vault does not verify: %w", err)
+	}
+
+	return sig, nil
+}
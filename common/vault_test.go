@@ -0,0 +1,183 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cose "github.com/veraison/go-cose"
+)
+
+const testVaultToken = "s.evcli-test"
+
+// newTestVaultServer starts a stand-in for a Vault server with an ECDSA P-256
+// key named iak in the transit engine mounted at transit
+func newTestVaultServer(t *testing.T) (*httptest.Server, *ecdsa.PrivateKey) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	require.NoError(t, err)
+
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	reply := func(w http.ResponseWriter, status int, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(v)
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/v1/transit/keys/iak", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"type":           "ecdsa-p256",
+				"latest_version": 1,
+				"keys": map[string]any{
+					"1": map[string]any{"public_key": string(pubPEM)},
+				},
+			},
+		})
+	})
+
+	mux.HandleFunc("/v1/transit/sign/iak", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input         string `json:"input"`
+			KeyVersion    int    `json:"key_version"`
+			HashAlgorithm string `json:"hash_algorithm"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil ||
+			r.Method != http.MethodPost || req.KeyVersion != 1 || req.HashAlgorithm != "sha2-256" {
+			reply(w, http.StatusBadRequest, map[string]any{"errors": []string{"bad request"}})
+			return
+		}
+
+		input, err := base64.StdEncoding.DecodeString(req.Input)
+		if err != nil {
+			reply(w, http.StatusBadRequest, map[string]any{"errors": []string{"bad input"}})
+			return
+		}
+
+		digest := sha256.Sum256(input)
+
+		sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+		if err != nil {
+			reply(w, http.StatusInternalServerError, nil)
+			return
+		}
+
+		reply(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"signature": "vault:v1:" + base64.StdEncoding.EncodeToString(sig),
+			},
+		})
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != testVaultToken {
+			reply(w, http.StatusForbidden, map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, priv
+}
+
+func Test_LoadSigningKey_vault_ok(t *testing.T) {
+	srv, priv := newTestVaultServer(t)
+
+	viper.Set("vault_addr", srv.URL)
+	viper.Set("vault_token", testVaultToken)
+	t.Cleanup(viper.Reset)
+
+	sk, err := LoadSigningKey(afero.NewMemMapFs(), "vault-transit://transit/iak", KeyFormatAuto, "IAK signing key")
+	require.NoError(t, err)
+	assert.Equal(t, cose.AlgorithmES256, sk.Algorithm())
+
+	pub, err := RawPublicKey(sk.Public)
+	require.NoError(t, err)
+	assert.True(t, priv.PublicKey.Equal(pub))
+
+	msg := cose.NewSign1Message()
+	msg.Payload = []byte("payload")
+	msg.Headers.Protected.SetAlgorithm(sk.Algorithm())
+	require.NoError(t, msg.Sign(rand.Reader, nil, sk))
+
+	verifier, err := cose.NewVerifier(cose.AlgorithmES256, &priv.PublicKey)
+	require.NoError(t, err)
+	assert.NoError(t, msg.Verify(nil, verifier))
+}
+
+func Test_NewVaultTransitSigningKey_fail(t *testing.T) {
+	srv, _ := newTestVaultServer(t)
+
+	tvs := []struct {
+		ref         string
+		token       string
+		expectedErr string
+	}{
+		{
+			ref:         "vault-transit://transit/iak",
+			token:       "s.wrong",
+			expectedErr: "calling Vault GET transit/keys/iak: 403 Forbidden: permission denied",
+		},
+		{
+			ref:         "vault-transit://transit/iak",
+			expectedErr: "no Vault token: set vault_token or VAULT_TOKEN",
+		},
+		{
+			ref:         "vault-transit://transit/iak?version=2",
+			token:       testVaultToken,
+			expectedErr: "no public key for version 2 of Vault key iak",
+		},
+		{
+			ref:         "vault-transit://transit/rak",
+			token:       testVaultToken,
+			expectedErr: "calling Vault GET transit/keys/rak: 404 Not Found",
+		},
+		{
+			ref:         "vault-transit://transit",
+			token:       testVaultToken,
+			expectedErr: "want vault-transit://<mount>/<key name>",
+		},
+		{
+			ref:         "vault-transit://transit/iak?version=latest",
+			token:       testVaultToken,
+			expectedErr: `invalid key version "latest"`,
+		},
+	}
+
+	for _, tv := range tvs {
+		t.Run(tv.ref, func(t *testing.T) {
+			_, err := NewVaultTransitSigningKey(tv.ref, VaultConfig{Addr: srv.URL, Token: tv.token})
+			assert.EqualError(t, err, tv.expectedErr)
+		})
+	}
+}
+
+func Test_parseVaultTransitURI(t *testing.T) {
+	mount, name, version, err := parseVaultTransitURI("vault-transit://tenants/acme/transit/iak?version=3")
+	require.NoError(t, err)
+	assert.Equal(t, "tenants/acme/transit", mount)
+	assert.Equal(t, "iak", name)
+	assert.Equal(t, 3, version)
+}
@@ -0,0 +1,573 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/afero"
+	"github.com/veraison/ccatoken"
+	"github.com/veraison/ccatoken/encoding"
+	"github.com/veraison/ccatoken/platform"
+	"github.com/veraison/ccatoken/realm"
+	cose "github.com/veraison/go-cose"
+)
+
+// ccaCollectionTag is the CBOR tag wrapping the platform and realm tokens of a
+// CCA attestation token
+const ccaCollectionTag = 399
+
+var (
+	ccaTags = []encoding.CBORTagEntry{
+		{Type: ccatoken.CBORCollection{}, Tag: ccaCollectionTag},
+	}
+	ccaEncMode, ccaEncModeErr = encoding.InitCBOREncMode(ccaTags...)
+	ccaDecMode, ccaDecModeErr = encoding.InitCBORDecMode(ccaTags...)
+)
+
+func init() {
+	if ccaEncModeErr != nil {
+		panic(ccaEncModeErr)
+	}
+	if ccaDecModeErr != nil {
+		panic(ccaDecModeErr)
+	}
+}
+
+// ParseCertificates decodes one or more certificates from the supplied buffer,
+// which may contain a sequence of PEM "CERTIFICATE" blocks or a single DER
+// certificate.  The order of the certificates is preserved.
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	if !isPEM(data) {
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing DER certificate: %w", err)
+		}
+		return []*x509.Certificate{cert}, nil
+	}
+
+	var certs []*x509.Certificate
+
+	for rest := data; ; {
+		var block *pem.Block
+
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block type %q: want CERTIFICATE", block.Type)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificate #%d: %w", len(certs), err)
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+
+	return certs, nil
+}
+
+// ParseCRLs decodes one or more certificate revocation lists from the supplied
+// buffer, which may contain a sequence of PEM "X509 CRL" blocks or a single DER
+// CRL
+func ParseCRLs(data []byte) ([]*x509.RevocationList, error) {
+	if !isPEM(data) {
+		crl, err := x509.ParseRevocationList(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing DER CRL: %w", err)
+		}
+		return []*x509.RevocationList{crl}, nil
+	}
+
+	var crls []*x509.RevocationList
+
+	for rest := data; ; {
+		var block *pem.Block
+
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "X509 CRL" {
+			return nil, fmt.Errorf("unexpected PEM block type %q: want X509 CRL", block.Type)
+		}
+
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing CRL #%d: %w", len(crls), err)
+		}
+
+		crls = append(crls, crl)
+	}
+
+	if len(crls) == 0 {
+		return nil, errors.New("no CRLs found")
+	}
+
+	return crls, nil
+}
+
+// CheckCertificateMatchesKey makes sure that the leaf certificate of an
+// x5chain certifies the supplied (signing) key
+func CheckCertificateMatchesKey(cert *x509.Certificate, key jwk.Key) error {
+	pub, err := RawPublicKey(key)
+	if err != nil {
+		return err
+	}
+
+	k, ok := pub.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !k.Equal(cert.PublicKey) {
+		return fmt.Errorf(
+			"the public key in certificate %q does not match the signing key",
+			cert.Subject,
+		)
+	}
+
+	return nil
+}
+
+// SignWithX5Chain wraps the supplied payload in a COSE_Sign1 message signed
+// with the supplied signer, carrying the certificate chain (leaf first) in the
+// protected header next to the algorithm.  The message is signed once, after
+// all the protected headers are set.  As per RFC 9360, a single certificate is
+// encoded as a bstr and a longer chain as an array of bstr.
+func SignWithX5Chain(payload []byte, chain []*x509.Certificate, signer cose.Signer) ([]byte, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("empty certificate chain")
+	}
+
+	msg := cose.NewSign1Message()
+	msg.Payload = payload
+	msg.Headers.Protected.SetAlgorithm(signer.Algorithm())
+
+	if len(chain) == 1 {
+		msg.Headers.Protected[cose.HeaderLabelX5Chain] = chain[0].Raw
+	} else {
+		x5chain := make([]any, 0, len(chain))
+		for _, cert := range chain {
+			x5chain = append(x5chain, cert.Raw)
+		}
+		msg.Headers.Protected[cose.HeaderLabelX5Chain] = x5chain
+	}
+
+	if err := msg.Sign(rand.Reader, []byte(""), signer); err != nil {
+		return nil, fmt.Errorf("COSE Sign1 failed: %w", err)
+	}
+
+	return msg.MarshalCBOR()
+}
+
+// SignCCATokenWithX5Chain signs the claims of the supplied CCA evidence and
+// returns the CCA attestation token.  The platform token carries the supplied
+// certificate chain, which certifies the IAK (pSigner), in its protected
+// header; the realm token is signed with the RAK (rSigner) as ccatoken does.
+func SignCCATokenWithX5Chain(
+	e *ccatoken.Evidence, chain []*x509.Certificate, pSigner, rSigner cose.Signer,
+) ([]byte, error) {
+	if pSigner == nil || rSigner == nil {
+		return nil, errors.New("nil signer(s) supplied")
+	}
+
+	payload, err := platform.EncodeClaimsToCBOR(e.PlatformClaims)
+	if err != nil {
+		return nil, fmt.Errorf("CBOR encoding the platform claims: %w", err)
+	}
+
+	platformToken, err := SignWithX5Chain(payload, chain, pSigner)
+	if err != nil {
+		return nil, fmt.Errorf("signing platform claims: %w", err)
+	}
+
+	if payload, err = realm.EncodeClaimsToCBOR(e.RealmClaims); err != nil {
+		return nil, fmt.Errorf("CBOR encoding the realm claims: %w", err)
+	}
+
+	realmMsg := cose.NewSign1Message()
+	realmMsg.Payload = payload
+	realmMsg.Headers.Protected.SetAlgorithm(rSigner.Algorithm())
+
+	if err = realmMsg.Sign(rand.Reader, []byte(""), rSigner); err != nil {
+		return nil, fmt.Errorf("signing realm claims: COSE Sign1 failed: %w", err)
+	}
+
+	realmToken, err := realmMsg.MarshalCBOR()
+	if err != nil {
+		return nil, fmt.Errorf("CBOR encoding the realm token: %w", err)
+	}
+
+	return ccaEncMode.Marshal(ccatoken.CBORCollection{
+		PlatformToken: &platformToken,
+		RealmToken:    &realmToken,
+	})
+}
+
+// X5ChainFromSign1 extracts the certificate chain carried in the x5chain
+// header of a COSE_Sign1 message.  The protected header is looked up first,
+// then the unprotected one.
+func X5ChainFromSign1(sign1 []byte) ([]*x509.Certificate, error) {
+	var msg cose.Sign1Message
+
+	if err := msg.UnmarshalCBOR(sign1); err != nil {
+		return nil, fmt.Errorf("decoding COSE_Sign1: %w", err)
+	}
+
+	v, ok := msg.Headers.Protected[cose.HeaderLabelX5Chain]
+	if !ok {
+		if v, ok = msg.Headers.Unprotected[cose.HeaderLabelX5Chain]; !ok {
+			return nil, errors.New("no x5chain header found")
+		}
+	}
+
+	var ders [][]byte
+
+	switch t := v.(type) {
+	case []byte:
+		ders = [][]byte{t}
+	case []any:
+		for i, e := range t {
+			der, ok := e.([]byte)
+			if !ok {
+				return nil, fmt.Errorf("x5chain entry #%d: want bstr, got %T", i, e)
+			}
+			ders = append(ders, der)
+		}
+	default:
+		return nil, fmt.Errorf("x5chain header: want bstr or array, got %T", v)
+	}
+
+	chain := make([]*x509.Certificate, 0, len(ders))
+
+	for i, der := range ders {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("x5chain entry #%d: %w", i, err)
+		}
+		chain = append(chain, cert)
+	}
+
+	if len(chain) == 0 {
+		return nil, errors.New("empty x5chain header")
+	}
+
+	return chain, nil
+}
+
+// X5ChainFromCCAToken extracts the certificate chain carried in the platform
+// token of a CCA attestation token
+func X5ChainFromCCAToken(token []byte) ([]*x509.Certificate, error) {
+	platformToken, err := PlatformTokenFromCCAToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return X5ChainFromSign1(platformToken)
+}
+
+// PlatformTokenFromCCAToken extracts the platform token, a COSE_Sign1, from a
+// CCA attestation token
+func PlatformTokenFromCCAToken(token []byte) ([]byte, error) {
+	var collection ccatoken.CBORCollection
+
+	if err := ccaDecMode.Unmarshal(token, &collection); err != nil {
+		return nil, fmt.Errorf("decoding CCA token: %w", err)
+	}
+
+	if collection.PlatformToken == nil {
+		return nil, errors.New("missing CCA platform token")
+	}
+
+	return *collection.PlatformToken, nil
+}
+
+// X5ChainVerifier checks certificate chains extracted from attestation tokens
+// against a set of trust anchors
+type X5ChainVerifier struct {
+	// TrustAnchors are the root certificates at which a path must end
+	TrustAnchors []*x509.Certificate
+	// CRLs, if any, are used to check the revocation status of each
+	// certificate in the path, except the trust anchor
+	CRLs []*x509.RevocationList
+	// CurrentTime is the time at which the validity of the certificates is
+	// checked.  The zero value means now.
+	CurrentTime time.Time
+}
+
+// Verify builds a path from the leaf of the supplied chain (leaf first) to one
+// of the trust anchors, using the rest of the chain as intermediates, and
+// returns the public key certified by the leaf.  Besides the checks done while
+// building the path, which include the validity periods, the key usage of the
+// leaf must allow digital signatures and that of the issuers certificate
+// signing, and, if CRLs are supplied, none of the certificates must be revoked.
+// The first of the candidate paths that passes all the checks is accepted.
+func (o X5ChainVerifier) Verify(chain []*x509.Certificate) (crypto.PublicKey, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("empty certificate chain")
+	}
+
+	if len(o.TrustAnchors) == 0 {
+		return nil, errors.New("no trust anchors supplied")
+	}
+
+	roots := x509.NewCertPool()
+	for _, ta := range o.TrustAnchors {
+		roots.AddCert(ta)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	now := o.CurrentTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	leaf := chain[0]
+
+	paths, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("certificate path validation failed: %w", err)
+	}
+
+	// any of the candidate paths that passes the remaining checks will do:
+	// if none does, the failure of the first one is reported
+	var firstErr error
+
+	for _, path := range paths {
+		err := o.checkPath(path, now)
+		if err == nil {
+			return leaf.PublicKey, nil
+		}
+
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return nil, firstErr
+}
+
+func (o X5ChainVerifier) checkPath(path []*x509.Certificate, now time.Time) error {
+	if err := checkKeyUsage(path); err != nil {
+		return err
+	}
+
+	if len(o.CRLs) > 0 {
+		return o.checkRevocation(path, now)
+	}
+
+	return nil
+}
+
+func checkKeyUsage(path []*x509.Certificate) error {
+	leaf := path[0]
+
+	// an absent key usage extension means no restrictions
+	if leaf.KeyUsage != 0 && leaf.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return fmt.Errorf(
+			"certificate %q: key usage does not allow digital signatures",
+			leaf.Subject,
+		)
+	}
+
+	for _, issuer := range path[1:] {
+		if issuer.KeyUsage != 0 && issuer.KeyUsage&x509.KeyUsageCertSign == 0 {
+			return fmt.Errorf(
+				"certificate %q: key usage does not allow certificate signing",
+				issuer.Subject,
+			)
+		}
+	}
+
+	return nil
+}
+
+// checkRevocation makes sure that none of the certificates in the path, save
+// for the trust anchor, appears in a CRL issued by its own issuer.  Each CRL
+// must be issued by one of the certificates in the path: a CRL that is not
+// could be one the caller expects to be checked, so it is reported rather
+// than ignored.
+func (o X5ChainVerifier) checkRevocation(path []*x509.Certificate, now time.Time) error {
+	matched := make([]bool, len(o.CRLs))
+
+	for i := 0; i < len(path)-1; i++ {
+		cert, issuer := path[i], path[i+1]
+
+		for j, crl := range o.CRLs {
+			if crl.CheckSignatureFrom(issuer) != nil {
+				continue
+			}
+
+			matched[j] = true
+
+			if now.Before(crl.ThisUpdate) {
+				return fmt.Errorf(
+					"CRL issued by %q is not valid before %s",
+					issuer.Subject, crl.ThisUpdate.Format(time.RFC3339),
+				)
+			}
+
+			if !crl.NextUpdate.IsZero() && now.After(crl.NextUpdate) {
+				return fmt.Errorf(
+					"CRL issued by %q expired on %s",
+					issuer.Subject, crl.NextUpdate.Format(time.RFC3339),
+				)
+			}
+
+			for _, entry := range crl.RevokedCertificateEntries {
+				if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return fmt.Errorf(
+						"certificate %q (serial %s) was revoked on %s",
+						cert.Subject, cert.SerialNumber, entry.RevocationTime.Format(time.RFC3339),
+					)
+				}
+			}
+		}
+	}
+
+	for j, crl := range o.CRLs {
+		if !matched[j] {
+			return fmt.Errorf(
+				"CRL issued by %q is not signed by any issuer in the certificate path",
+				crl.Issuer,
+			)
+		}
+	}
+
+	return nil
+}
+
+// VerificationKeyFlags are the values of the check options that select where
+// the verification key comes from
+type VerificationKeyFlags struct {
+	// KeyFile is the file with the key
+	KeyFile string
+	// KeysDir is a directory of keys, from which the token's one is looked up
+	KeysDir string
+	// CoRIMFiles are CoRIMs, in which the token's key is looked up
+	CoRIMFiles []string
+	// TrustAnchorFile has the trust anchors against which the token's x5chain
+	// is validated
+	TrustAnchorFile string
+	// CRLFile has the CRLs used while validating the x5chain
+	CRLFile string
+}
+
+// Check makes sure that exactly one way of obtaining the verification key has
+// been chosen.  CRLs only make sense with a trust anchor.
+func (o VerificationKeyFlags) Check() error {
+	var set []string
+
+	for _, f := range []struct {
+		name  string
+		isSet bool
+	}{
+		{"--key", o.KeyFile != ""},
+		{"--keys-dir", o.KeysDir != ""},
+		{"--corim", len(o.CoRIMFiles) > 0},
+		{"--trust-anchor", o.TrustAnchorFile != ""},
+	} {
+		if f.isSet {
+			set = append(set, f.name)
+		}
+	}
+
+	if len(set) == 0 {
+		return errors.New("one of --key, --keys-dir, --corim or --trust-anchor must be supplied")
+	}
+
+	if len(set) > 1 {
+		return fmt.Errorf("%s and %s are mutually exclusive", set[0], set[1])
+	}
+
+	if o.CRLFile != "" && o.TrustAnchorFile == "" {
+		return errors.New("--crl can only be used with --trust-anchor")
+	}
+
+	return nil
+}
+
+// CertifiedKeyFromToken extracts the certificate chain from the supplied token
+// using the extract function, validates it against the trust anchors in
+// trustAnchorFile and, if crlFile is not empty, the CRLs therein, and returns
+// the public key certified by the leaf certificate
+func CertifiedKeyFromToken(
+	fs afero.Fs,
+	token []byte,
+	extract func([]byte) ([]*x509.Certificate, error),
+	trustAnchorFile string,
+	crlFile string,
+) (crypto.PublicKey, error) {
+	raw, err := afero.ReadFile(fs, trustAnchorFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading trust anchors from %s: %w", trustAnchorFile, err)
+	}
+
+	tas, err := ParseCertificates(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding trust anchors from %s: %w", trustAnchorFile, err)
+	}
+
+	v := X5ChainVerifier{TrustAnchors: tas}
+
+	if crlFile != "" {
+		raw, err = afero.ReadFile(fs, crlFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading CRLs from %s: %w", crlFile, err)
+		}
+
+		if v.CRLs, err = ParseCRLs(raw); err != nil {
+			return nil, fmt.Errorf("error decoding CRLs from %s: %w", crlFile, err)
+		}
+	}
+
+	chain, err := extract(token)
+	if err != nil {
+		return nil, fmt.Errorf("error extracting x5chain: %w", err)
+	}
+
+	return v.Verify(chain)
+}
+
+// LoadX5Chain reads the certificate chain (leaf first) to embed in a token from
+// the supplied file, and makes sure that the leaf certifies the signing key
+func LoadX5Chain(fs afero.Fs, fn string, key jwk.Key) ([]*x509.Certificate, error) {
+	raw, err := afero.ReadFile(fs, fn)
+	if err != nil {
+		return nil, fmt.Errorf("error loading certificate chain from %s: %w", fn, err)
+	}
+
+	chain, err := ParseCertificates(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding certificate chain from %s: %w", fn, err)
+	}
+
+	if err := CheckCertificateMatchesKey(chain[0], key); err != nil {
+		return nil, err
+	}
+
+	return chain, nil
+}
@@ -0,0 +1,390 @@
+// Copyright 2024 Contributors to the Veraison project.
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cose "github.com/veraison/go-cose"
+)
+
+var (
+	testNotBefore = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	testNotAfter  = time.Date(2034, 1, 1, 0, 0, 0, 0, time.UTC)
+	testNow       = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+type testPKI struct {
+	root, ca, leaf          *x509.Certificate
+	rootKey, caKey, leafKey *ecdsa.PrivateKey
+}
+
+func newTestCert(
+	t *testing.T, tmpl *x509.Certificate, pub *ecdsa.PublicKey,
+	parent *x509.Certificate, parentKey *ecdsa.PrivateKey,
+) *x509.Certificate {
+	if parent == nil {
+		parent = tmpl
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, parentKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return cert
+}
+
+// newTestPKI creates a root CA, an intermediate CA and a leaf certificate with
+// the supplied key usage
+func newTestPKI(t *testing.T, leafKeyUsage x509.KeyUsage) testPKI {
+	var p testPKI
+	var err error
+
+	for _, k := range []**ecdsa.PrivateKey{&p.rootKey, &p.caKey, &p.leafKey} {
+		*k, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+	}
+
+	ca := func(serial int64, cn string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: cn},
+			NotBefore:             testNotBefore,
+			NotAfter:              testNotAfter,
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		}
+	}
+
+	p.root = newTestCert(t, ca(1, "root"), &p.rootKey.PublicKey, nil, p.rootKey)
+	p.ca = newTestCert(t, ca(2, "ca"), &p.caKey.PublicKey, p.root, p.rootKey)
+	p.leaf = newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		NotBefore:    testNotBefore,
+		NotAfter:     testNotAfter,
+		KeyUsage:     leafKeyUsage,
+	}, &p.leafKey.PublicKey, p.ca, p.caKey)
+
+	return p
+}
+
+func (o testPKI) crl(t *testing.T, nextUpdate time.Time, revoked ...*x509.Certificate) *x509.RevocationList {
+	return o.crlAt(t, testNotBefore, nextUpdate, revoked...)
+}
+
+func (o testPKI) crlAt(
+	t *testing.T, thisUpdate, nextUpdate time.Time, revoked ...*x509.Certificate,
+) *x509.RevocationList {
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: thisUpdate,
+		NextUpdate: nextUpdate,
+	}
+
+	for _, cert := range revoked {
+		tmpl.RevokedCertificateEntries = append(
+			tmpl.RevokedCertificateEntries,
+			x509.RevocationListEntry{SerialNumber: cert.SerialNumber, RevocationTime: testNotBefore},
+		)
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, o.ca, o.caKey)
+	require.NoError(t, err)
+
+	crl, err := x509.ParseRevocationList(der)
+	require.NoError(t, err)
+
+	return crl
+}
+
+func Test_X5ChainVerifier_Verify_ok(t *testing.T) {
+	p := newTestPKI(t, x509.KeyUsageDigitalSignature)
+
+	v := X5ChainVerifier{
+		TrustAnchors: []*x509.Certificate{p.root},
+		CRLs:         []*x509.RevocationList{p.crl(t, testNotAfter)},
+		CurrentTime:  testNow,
+	}
+
+	pub, err := v.Verify([]*x509.Certificate{p.leaf, p.ca})
+	require.NoError(t, err)
+	assert.True(t, p.leafKey.PublicKey.Equal(pub))
+}
+
+func Test_X5ChainVerifier_Verify_fail(t *testing.T) {
+	good := newTestPKI(t, x509.KeyUsageDigitalSignature)
+	badUsage := newTestPKI(t, x509.KeyUsageKeyEncipherment)
+	other := newTestPKI(t, x509.KeyUsageDigitalSignature)
+
+	tvs := []struct {
+		desc        string
+		pki         testPKI
+		chain       []*x509.Certificate
+		anchors     []*x509.Certificate
+		crls        []*x509.RevocationList
+		now         time.Time
+		expectedErr string
+	}{
+		{
+			desc:        "untrusted root",
+			pki:         good,
+			anchors:     []*x509.Certificate{other.root},
+			now:         testNow,
+			expectedErr: "certificate path validation failed: x509: certificate signed by unknown authority",
+		},
+		{
+			desc:        "missing intermediate",
+			pki:         good,
+			chain:       []*x509.Certificate{good.leaf},
+			now:         testNow,
+			expectedErr: "certificate path validation failed: x509: certificate signed by unknown authority",
+		},
+		{
+			desc:        "expired",
+			pki:         good,
+			now:         testNotAfter.Add(time.Hour),
+			expectedErr: "certificate path validation failed: x509: certificate has expired or is not yet valid",
+		},
+		{
+			desc:        "not yet valid",
+			pki:         good,
+			now:         testNotBefore.Add(-time.Hour),
+			expectedErr: "certificate path validation failed: x509: certificate has expired or is not yet valid",
+		},
+		{
+			desc:        "leaf key usage",
+			pki:         badUsage,
+			now:         testNow,
+			expectedErr: `certificate "CN=leaf": key usage does not allow digital signatures`,
+		},
+		{
+			desc:        "revoked",
+			pki:         good,
+			crls:        []*x509.RevocationList{good.crl(t, testNotAfter, good.leaf)},
+			now:         testNow,
+			expectedErr: `certificate "CN=leaf" (serial 3) was revoked on 2024-01-01T00:00:00Z`,
+		},
+		{
+			desc:        "stale CRL",
+			pki:         good,
+			crls:        []*x509.RevocationList{good.crl(t, testNow.Add(-time.Hour))},
+			now:         testNow,
+			expectedErr: `CRL issued by "CN=ca" expired on 2024-12-31T23:00:00Z`,
+		},
+		{
+			desc:        "CRL from the future",
+			pki:         good,
+			crls:        []*x509.RevocationList{good.crlAt(t, testNow.Add(time.Hour), testNotAfter)},
+			now:         testNow,
+			expectedErr: `CRL issued by "CN=ca" is not valid before 2025-01-01T01:00:00Z`,
+		},
+	}
+
+	for _, tv := range tvs {
+		t.Run(tv.desc, func(t *testing.T) {
+			chain := tv.chain
+			if chain == nil {
+				chain = []*x509.Certificate{tv.pki.leaf, tv.pki.ca}
+			}
+
+			anchors := tv.anchors
+			if anchors == nil {
+				anchors = []*x509.Certificate{tv.pki.root}
+			}
+
+			v := X5ChainVerifier{TrustAnchors: anchors, CRLs: tv.crls, CurrentTime: tv.now}
+
+			_, err := v.Verify(chain)
+			assert.ErrorContains(t, err, tv.expectedErr)
+		})
+	}
+}
+
+func Test_X5ChainVerifier_Verify_unrelated_CRL(t *testing.T) {
+	p := newTestPKI(t, x509.KeyUsageDigitalSignature)
+	other := newTestPKI(t, x509.KeyUsageDigitalSignature)
+
+	v := X5ChainVerifier{
+		TrustAnchors: []*x509.Certificate{p.root},
+		// same issuer name and serial number, different issuer key
+		CRLs: []*x509.RevocationList{
+			p.crl(t, testNotAfter),
+			other.crl(t, testNotAfter, other.leaf),
+		},
+		CurrentTime: testNow,
+	}
+
+	_, err := v.Verify([]*x509.Certificate{p.leaf, p.ca})
+	assert.EqualError(t, err, `CRL issued by "CN=ca" is not signed by any issuer in the certificate path`)
+}
+
+func Test_X5ChainVerifier_Verify_candidate_paths(t *testing.T) {
+	p := newTestPKI(t, x509.KeyUsageDigitalSignature)
+
+	// another certificate for the intermediate CA, revoked by the root CA
+	revokedCA := newTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(4),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             testNotBefore,
+		NotAfter:              testNotAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, &p.caKey.PublicKey, p.root, p.rootKey)
+
+	rootPKI := testPKI{ca: p.root, caKey: p.rootKey}
+
+	v := X5ChainVerifier{
+		TrustAnchors: []*x509.Certificate{p.root},
+		CRLs: []*x509.RevocationList{
+			rootPKI.crl(t, testNotAfter, revokedCA),
+			p.crl(t, testNotAfter),
+		},
+		CurrentTime: testNow,
+	}
+
+	_, err := v.Verify([]*x509.Certificate{p.leaf, revokedCA})
+	assert.EqualError(t, err, `certificate "CN=ca" (serial 4) was revoked on 2024-01-01T00:00:00Z`)
+
+	// whichever path comes first, the one through the CA certificate that is
+	// not revoked is accepted
+	for _, chain := range [][]*x509.Certificate{
+		{p.leaf, revokedCA, p.ca},
+		{p.leaf, p.ca, revokedCA},
+	} {
+		pub, err := v.Verify(chain)
+		require.NoError(t, err)
+		assert.True(t, p.leafKey.PublicKey.Equal(pub))
+	}
+}
+
+// countingSigner counts the signatures made by the wrapped signer
+type countingSigner struct {
+	cose.Signer
+	count int
+}
+
+func (o *countingSigner) Sign(rand io.Reader, content []byte) ([]byte, error) {
+	o.count++
+	return o.Signer.Sign(rand, content)
+}
+
+func Test_SignWithX5Chain_X5ChainFromSign1_roundtrip(t *testing.T) {
+	p := newTestPKI(t, x509.KeyUsageDigitalSignature)
+
+	leafSigner, err := cose.NewSigner(cose.AlgorithmES256, p.leafKey)
+	require.NoError(t, err)
+
+	verifier, err := cose.NewVerifier(cose.AlgorithmES256, &p.leafKey.PublicKey)
+	require.NoError(t, err)
+
+	payload := []byte("payload")
+
+	for _, chain := range [][]*x509.Certificate{
+		{p.leaf},
+		{p.leaf, p.ca},
+	} {
+		signer := &countingSigner{Signer: leafSigner}
+
+		sign1, err := SignWithX5Chain(payload, chain, signer)
+		require.NoError(t, err)
+		assert.Equal(t, 1, signer.count)
+
+		var decoded cose.Sign1Message
+		require.NoError(t, decoded.UnmarshalCBOR(sign1))
+		assert.Equal(t, payload, decoded.Payload)
+		assert.NoError(t, decoded.Verify(nil, verifier))
+
+		alg, err := decoded.Headers.Protected.Algorithm()
+		require.NoError(t, err)
+		assert.Equal(t, cose.AlgorithmES256, alg)
+
+		actual, err := X5ChainFromSign1(sign1)
+		require.NoError(t, err)
+		assert.Equal(t, chain, actual)
+	}
+
+	_, err = SignWithX5Chain(payload, nil, leafSigner)
+	assert.EqualError(t, err, "empty certificate chain")
+
+	msg := cose.NewSign1Message()
+	msg.Payload = payload
+	msg.Headers.Protected.SetAlgorithm(cose.AlgorithmES256)
+	require.NoError(t, msg.Sign(rand.Reader, nil, leafSigner))
+
+	sign1, err := msg.MarshalCBOR()
+	require.NoError(t, err)
+
+	_, err = X5ChainFromSign1(sign1)
+	assert.EqualError(t, err, "no x5chain header found")
+}
+
+func Test_ParseCertificates(t *testing.T) {
+	p := newTestPKI(t, x509.KeyUsageDigitalSignature)
+
+	var chainPEM []byte
+	for _, cert := range []*x509.Certificate{p.leaf, p.ca} {
+		chainPEM = append(chainPEM, pem.EncodeToMemory(
+			&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw},
+		)...)
+	}
+
+	certs, err := ParseCertificates(chainPEM)
+	require.NoError(t, err)
+	assert.Equal(t, []*x509.Certificate{p.leaf, p.ca}, certs)
+
+	certs, err = ParseCertificates(p.root.Raw)
+	require.NoError(t, err)
+	assert.Equal(t, []*x509.Certificate{p.root}, certs)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(p.leafKey)
+	require.NoError(t, err)
+
+	_, err = ParseCertificates(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	assert.EqualError(t, err, `unexpected PEM block type "PRIVATE KEY": want CERTIFICATE`)
+}
+
+func Test_CheckCertificateMatchesKey(t *testing.T) {
+	p := newTestPKI(t, x509.KeyUsageDigitalSignature)
+
+	key, err := jwk.FromRaw(p.leafKey)
+	require.NoError(t, err)
+
+	assert.NoError(t, CheckCertificateMatchesKey(p.leaf, key))
+	assert.EqualError(t, CheckCertificateMatchesKey(p.ca, key),
+		`the public key in certificate "CN=ca" does not match the signing key`)
+}
+
+func Test_VerificationKeyFlags_Check(t *testing.T) {
+	assert.NoError(t, VerificationKeyFlags{KeyFile: "k.jwk"}.Check())
+	assert.NoError(t, VerificationKeyFlags{KeysDir: "keys"}.Check())
+	assert.NoError(t, VerificationKeyFlags{CoRIMFiles: []string{"a.cbor", "b.cbor"}}.Check())
+	assert.NoError(t, VerificationKeyFlags{TrustAnchorFile: "ta.pem", CRLFile: "ca.crl"}.Check())
+	assert.EqualError(t, VerificationKeyFlags{}.Check(),
+		"one of --key, --keys-dir, --corim or --trust-anchor must be supplied")
+	assert.EqualError(t, VerificationKeyFlags{KeyFile: "k.jwk", TrustAnchorFile: "ta.pem"}.Check(),
+		"--key and --trust-anchor are mutually exclusive")
+	assert.EqualError(t, VerificationKeyFlags{KeysDir: "keys", TrustAnchorFile: "ta.pem"}.Check(),
+		"--keys-dir and --trust-anchor are mutually exclusive")
+	assert.EqualError(t, VerificationKeyFlags{KeyFile: "k.jwk", CoRIMFiles: []string{"a.cbor"}}.Check(),
+		"--key and --corim are mutually exclusive")
+	assert.EqualError(t, VerificationKeyFlags{KeyFile: "k.jwk", CRLFile: "ca.crl"}.Check(),
+		"--crl can only be used with --trust-anchor")
+}